@@ -0,0 +1,3 @@
+-- 超时订单扫描: 按状态和过期时间查找待关闭的订单和支付流水
+ALTER TABLE mko_order ADD INDEX idx_status_create_time (status, create_time);
+ALTER TABLE mkb_trade_bill ADD INDEX idx_order_id (order_id);
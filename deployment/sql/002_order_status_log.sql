@@ -0,0 +1,13 @@
+-- 订单状态变更记录, 由 service/order_state_machine.go 写入
+CREATE TABLE IF NOT EXISTS `mko_order_status_log` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `order_id` bigint(20) NOT NULL COMMENT '订单id',
+  `from_status` tinyint(4) NOT NULL COMMENT '变更前状态',
+  `to_status` tinyint(4) NOT NULL COMMENT '变更后状态',
+  `actor_type` tinyint(4) NOT NULL DEFAULT '0' COMMENT '操作人类型 1-用户 2-运营人员 3-系统 4-微信支付回调',
+  `actor_id` bigint(20) NOT NULL DEFAULT '0' COMMENT '操作人id',
+  `reason` varchar(255) NOT NULL DEFAULT '' COMMENT '变更原因',
+  `create_time` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`),
+  KEY `idx_order_id` (`order_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='订单状态变更记录';
@@ -0,0 +1,5 @@
+-- 退款流水: mkb_trade_bill 中 fee_type = 2 (Outgo) 的记录, status 0-退款中 2-退款成功 4-退款失败
+ALTER TABLE mkb_trade_bill
+  ADD COLUMN `out_refund_no` varchar(64) NOT NULL DEFAULT '' COMMENT '商户退款单号' AFTER `out_trade_no`,
+  ADD COLUMN `refund_id` varchar(64) NOT NULL DEFAULT '' COMMENT '微信退款单号' AFTER `out_refund_no`,
+  ADD INDEX `idx_out_refund_no` (`out_refund_no`);
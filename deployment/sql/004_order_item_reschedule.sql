@@ -0,0 +1,18 @@
+-- 体检日期改期次数及记录, 由 service/order_service.go ModifyOrderItem 写入
+ALTER TABLE `mko_order_item`
+  ADD COLUMN `reschedule_count` int(11) NOT NULL DEFAULT '0' COMMENT '体检日期改期次数' AFTER `examine_date`;
+
+CREATE TABLE IF NOT EXISTS `mko_order_item_reschedule_log` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `order_item_id` bigint(20) NOT NULL COMMENT '订单项id',
+  `order_id` bigint(20) NOT NULL COMMENT '订单id',
+  `user_id` bigint(20) NOT NULL DEFAULT '0' COMMENT '操作的用户id',
+  `from_date` int(11) NOT NULL COMMENT '改期前的体检日期',
+  `to_date` int(11) NOT NULL COMMENT '改期后的体检日期',
+  `seq` int(11) NOT NULL COMMENT '第几次改期',
+  `chargeable` tinyint(4) NOT NULL DEFAULT '0' COMMENT '是否超出免费次数需收取服务费 0-否 1-是',
+  `create_time` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`),
+  KEY `idx_order_item_id` (`order_item_id`),
+  KEY `idx_order_id` (`order_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='体检日期改期记录';
@@ -0,0 +1,31 @@
+-- 体检机构和套餐的每日可预约名额, 0 表示不限
+ALTER TABLE `mkh_hospital`
+  ADD COLUMN `daily_capacity` int(11) NOT NULL DEFAULT '0' COMMENT '每日可预约人数, 0-不限';
+ALTER TABLE `mkp_package`
+  ADD COLUMN `daily_capacity` int(11) NOT NULL DEFAULT '0' COMMENT '每日可预约人数, 0-只受机构名额限制';
+
+-- 每日已预约人数, pkg_id 为 0 的行是机构当天的合计, 由 model/capacity_model.go 维护
+CREATE TABLE IF NOT EXISTS `mkh_capacity_usage` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `hospital_id` bigint(20) NOT NULL COMMENT '体检机构id',
+  `pkg_id` bigint(20) NOT NULL DEFAULT '0' COMMENT '套餐id, 0-机构合计',
+  `examine_date` int(11) NOT NULL COMMENT '体检日期, 当天零点的时间戳',
+  `booked` int(11) NOT NULL DEFAULT '0' COMMENT '已预约人数',
+  `update_time` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_hospital_pkg_date` (`hospital_id`, `pkg_id`, `examine_date`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='体检机构每日已预约人数';
+
+-- 按未关闭订单的现有预约初始化, 历史订单的体检日期不一定是零点, 统一成当天零点
+INSERT INTO `mkh_capacity_usage` (`hospital_id`, `pkg_id`, `examine_date`, `booked`, `update_time`)
+SELECT mp.hospital_id, moi.pkg_id, UNIX_TIMESTAMP(DATE(FROM_UNIXTIME(moi.examine_date))) AS day, COUNT(*), UNIX_TIMESTAMP(NOW())
+FROM mko_order_item AS moi
+  INNER JOIN mko_order AS mo ON moi.order_id = mo.id
+  INNER JOIN mkp_package AS mp ON moi.pkg_id = mp.id
+WHERE moi.is_deleted = 0 AND moi.examine_date > 0 AND mo.status IN (0, 2)
+GROUP BY mp.hospital_id, moi.pkg_id, day;
+
+INSERT INTO `mkh_capacity_usage` (`hospital_id`, `pkg_id`, `examine_date`, `booked`, `update_time`)
+SELECT hospital_id, 0, examine_date, SUM(booked), UNIX_TIMESTAMP(NOW())
+FROM mkh_capacity_usage
+GROUP BY hospital_id, examine_date;
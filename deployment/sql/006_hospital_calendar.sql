@@ -0,0 +1,3 @@
+-- 体检机构每周固定的休息日, 法定节假日及调休见 server/static/calendar/holidays.json
+ALTER TABLE `mkh_hospital`
+  ADD COLUMN `closed_weekdays` varchar(16) NOT NULL DEFAULT '' COMMENT '每周休息日, 逗号分隔, 0-周日 1-周一 ... 6-周六, 为空表示每天营业';
@@ -0,0 +1,36 @@
+-- 运营后台, 见 server/controller/admin_controller.go
+CREATE TABLE IF NOT EXISTS `mka_staff` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `username` varchar(32) NOT NULL COMMENT '登录名',
+  `password` varchar(64) NOT NULL COMMENT 'bcrypt 哈希',
+  `name` varchar(32) NOT NULL DEFAULT '' COMMENT '姓名',
+  `status` tinyint(4) NOT NULL DEFAULT '1' COMMENT '1-正常 2-已停用',
+  `create_time` int(11) NOT NULL DEFAULT '0',
+  `update_time` int(11) NOT NULL DEFAULT '0',
+  `is_deleted` tinyint(4) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_username` (`username`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='运营人员';
+
+-- 退款申请审核
+ALTER TABLE `mko_order`
+  ADD COLUMN `refund_status` tinyint(4) NOT NULL DEFAULT '0' COMMENT '退款申请 0-未申请 1-待审核 2-已同意 3-已拒绝',
+  ADD COLUMN `refund_audit_remark` varchar(255) NOT NULL DEFAULT '' COMMENT '退款审核意见';
+UPDATE `mko_order` SET `refund_status` = 1 WHERE `refund_reason_id` > 0 AND `status` = 2;
+UPDATE `mko_order` SET `refund_status` = 2 WHERE `refund_reason_id` > 0 AND `status` = 3;
+
+-- 客服确认预约
+ALTER TABLE `mko_order_item`
+  ADD COLUMN `appointment_status` tinyint(4) NOT NULL DEFAULT '0' COMMENT '预约状态 0-待确认 1-已确认',
+  ADD COLUMN `appointment_confirm_time` int(11) NOT NULL DEFAULT '0' COMMENT '确认预约的时间';
+
+-- 订单内部备注, 只对运营人员可见
+CREATE TABLE IF NOT EXISTS `mko_order_note` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `order_id` bigint(20) NOT NULL COMMENT '订单id',
+  `staff_id` bigint(20) NOT NULL COMMENT '运营人员id',
+  `content` varchar(1024) NOT NULL COMMENT '备注内容',
+  `create_time` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`),
+  KEY `idx_order_id` (`order_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='订单内部备注';
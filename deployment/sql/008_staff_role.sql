@@ -0,0 +1,5 @@
+-- 运营人员角色, 见 server/util/rbac
+-- 1-客服 2-财务 3-机构对接人 4-超级管理员, 已有账号默认为客服, 需要审核退款的账号手工调整
+ALTER TABLE `mka_staff`
+  ADD COLUMN `role` tinyint(4) NOT NULL DEFAULT '1' COMMENT '角色 1-客服 2-财务 3-机构对接人 4-超级管理员' AFTER `name`,
+  ADD COLUMN `hospital_id` bigint(20) NOT NULL DEFAULT '0' COMMENT '机构对接人所对接的体检机构' AFTER `role`;
@@ -0,0 +1,29 @@
+-- 审计日志, 只允许追加, 见 server/service/audit_service.go
+CREATE TABLE IF NOT EXISTS `mka_audit_log` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `actor_type` tinyint(4) NOT NULL COMMENT '操作人类型 1-用户 2-运营人员 3-系统 4-微信支付回调',
+  `actor_id` bigint(20) NOT NULL DEFAULT '0' COMMENT '用户id或运营人员id',
+  `action` varchar(32) NOT NULL COMMENT '操作, 如 examinee.update',
+  `target_table` varchar(32) NOT NULL COMMENT '被修改的表',
+  `target_id` bigint(20) NOT NULL DEFAULT '0' COMMENT '被修改记录的id',
+  `before_data` text NOT NULL COMMENT '修改前有变化的字段, json',
+  `after_data` text NOT NULL COMMENT '修改后有变化的字段, json',
+  `ip` varchar(64) NOT NULL DEFAULT '',
+  `user_agent` varchar(255) NOT NULL DEFAULT '',
+  `request` varchar(128) NOT NULL DEFAULT '' COMMENT '请求的方法和路由',
+  `create_time` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`),
+  KEY `idx_target` (`target_table`, `target_id`),
+  KEY `idx_actor` (`actor_type`, `actor_id`),
+  KEY `idx_create_time` (`create_time`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='审计日志';
+
+-- 禁止修改和删除审计日志
+DROP TRIGGER IF EXISTS `trg_audit_log_no_update`;
+DROP TRIGGER IF EXISTS `trg_audit_log_no_delete`;
+DELIMITER ;;
+CREATE TRIGGER `trg_audit_log_no_update` BEFORE UPDATE ON `mka_audit_log` FOR EACH ROW
+  SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'mka_audit_log is append-only';;
+CREATE TRIGGER `trg_audit_log_no_delete` BEFORE DELETE ON `mka_audit_log` FOR EACH ROW
+  SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'mka_audit_log is append-only';;
+DELIMITER ;
@@ -0,0 +1,46 @@
+-- 优惠券, 金额单位为分, 规则的计算见 server/util/coupon
+CREATE TABLE IF NOT EXISTS `mkc_coupon` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `name` varchar(64) NOT NULL COMMENT '优惠券名称',
+  `code` varchar(32) NOT NULL COMMENT '领取用的优惠码',
+  `type` tinyint(4) NOT NULL COMMENT '1-满减券 2-折扣券',
+  `amount` int(11) NOT NULL DEFAULT '0' COMMENT '满减券的减免金额',
+  `rate` int(11) NOT NULL DEFAULT '0' COMMENT '折扣券的减免百分比',
+  `threshold` int(11) NOT NULL DEFAULT '0' COMMENT '适用套餐满多少可用, 0 为无门槛',
+  `max_discount` int(11) NOT NULL DEFAULT '0' COMMENT '折扣券最多减免, 0 为不限',
+  `scope_type` tinyint(4) NOT NULL DEFAULT '0' COMMENT '0-全部套餐 1-指定套餐 2-指定体检机构',
+  `scope_id` bigint(20) NOT NULL DEFAULT '0' COMMENT '指定的套餐id或体检机构id',
+  `valid_start` int(11) NOT NULL COMMENT '有效期开始',
+  `valid_end` int(11) NOT NULL COMMENT '有效期结束',
+  `total` int(11) NOT NULL DEFAULT '0' COMMENT '发放总量, 0 为不限',
+  `claimed` int(11) NOT NULL DEFAULT '0' COMMENT '已领取数量',
+  `per_user_limit` int(11) NOT NULL DEFAULT '1' COMMENT '每人限领, 0 为不限',
+  `status` tinyint(4) NOT NULL DEFAULT '1' COMMENT '1-启用 2-停用',
+  `create_time` int(11) NOT NULL DEFAULT '0',
+  `update_time` int(11) NOT NULL DEFAULT '0',
+  `is_deleted` tinyint(4) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_code` (`code`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='优惠券';
+
+CREATE TABLE IF NOT EXISTS `mkc_user_coupon` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `coupon_id` bigint(20) NOT NULL,
+  `user_id` bigint(20) NOT NULL,
+  `status` tinyint(4) NOT NULL DEFAULT '0' COMMENT '0-未使用 1-已使用, 订单关闭或退款后退回未使用',
+  `order_id` bigint(20) NOT NULL DEFAULT '0' COMMENT '使用该券的订单',
+  `claim_time` int(11) NOT NULL DEFAULT '0',
+  `use_time` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`),
+  KEY `idx_user_coupon` (`user_id`, `coupon_id`),
+  KEY `idx_order_id` (`order_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='用户领取的优惠券';
+
+-- 订单使用的优惠券及优惠金额, amount 为优惠后的实付金额
+ALTER TABLE `mko_order`
+  ADD COLUMN `user_coupon_id` bigint(20) NOT NULL DEFAULT '0' COMMENT '使用的优惠券' AFTER `amount`,
+  ADD COLUMN `discount` decimal(10,2) NOT NULL DEFAULT '0.00' COMMENT '优惠金额, 单位分' AFTER `user_coupon_id`;
+
+-- 优惠金额按价格比例分摊到订单项, 退款时按分摊后的金额计算
+ALTER TABLE `mko_order_item`
+  ADD COLUMN `discount` decimal(10,2) NOT NULL DEFAULT '0.00' COMMENT '分摊的优惠金额, 单位分' AFTER `pkg_price`;
@@ -0,0 +1,40 @@
+-- 体检卡, 企业客户批量购买后把卡号发给员工, 下单时用卡号抵扣. 金额单位为分, 抵扣的计算见 server/util/card
+CREATE TABLE IF NOT EXISTS `mkc_card_batch` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `name` varchar(64) NOT NULL COMMENT '批次名称',
+  `company` varchar(64) NOT NULL DEFAULT '' COMMENT '购买的企业客户',
+  `type` tinyint(4) NOT NULL COMMENT '1-套餐卡 2-储值卡',
+  `pkg_id` bigint(20) NOT NULL DEFAULT '0' COMMENT '套餐卡对应的套餐',
+  `value` int(11) NOT NULL DEFAULT '0' COMMENT '储值卡面值',
+  `quantity` int(11) NOT NULL COMMENT '卡数量',
+  `valid_start` int(11) NOT NULL COMMENT '有效期开始',
+  `valid_end` int(11) NOT NULL COMMENT '有效期结束',
+  `status` tinyint(4) NOT NULL DEFAULT '1' COMMENT '1-正常 2-已作废',
+  `staff_id` bigint(20) NOT NULL DEFAULT '0' COMMENT '创建批次的运营人员',
+  `create_time` int(11) NOT NULL DEFAULT '0',
+  `update_time` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='体检卡批次';
+
+CREATE TABLE IF NOT EXISTS `mkc_card` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `batch_id` bigint(20) NOT NULL,
+  `code` char(16) NOT NULL COMMENT '卡号',
+  `status` tinyint(4) NOT NULL DEFAULT '0' COMMENT '0-未使用 1-已使用 2-已作废, 订单关闭或退款后退回未使用',
+  `user_id` bigint(20) NOT NULL DEFAULT '0' COMMENT '使用该卡的用户',
+  `order_id` bigint(20) NOT NULL DEFAULT '0' COMMENT '使用该卡的订单',
+  `redeem_time` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_code` (`code`),
+  KEY `idx_batch_id` (`batch_id`),
+  KEY `idx_order_id` (`order_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='体检卡';
+
+-- 订单使用的体检卡及抵扣金额, amount 为抵扣后的实付金额, 为 0 时不经过微信支付
+ALTER TABLE `mko_order`
+  ADD COLUMN `card_id` bigint(20) NOT NULL DEFAULT '0' COMMENT '使用的体检卡' AFTER `discount`,
+  ADD COLUMN `card_amount` decimal(10,2) NOT NULL DEFAULT '0.00' COMMENT '体检卡抵扣金额, 单位分' AFTER `card_id`;
+
+-- 抵扣金额分摊到订单项, 退款时只退实付部分
+ALTER TABLE `mko_order_item`
+  ADD COLUMN `card_amount` decimal(10,2) NOT NULL DEFAULT '0.00' COMMENT '分摊的体检卡抵扣金额, 单位分' AFTER `discount`;
@@ -0,0 +1,22 @@
+-- 电子发票申请, 每个订单只能开一张发票, 被驳回的申请由用户修改后重新提交
+CREATE TABLE IF NOT EXISTS `mko_invoice` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `order_id` bigint(20) NOT NULL,
+  `user_id` bigint(20) NOT NULL,
+  `title_type` tinyint(4) NOT NULL COMMENT '抬头类型 1-个人 2-企业',
+  `title` varchar(128) NOT NULL COMMENT '发票抬头',
+  `tax_no` varchar(20) NOT NULL DEFAULT '' COMMENT '纳税人识别号, 企业抬头必填',
+  `email` varchar(128) NOT NULL COMMENT '接收电子发票的邮箱',
+  `amount` decimal(10,2) NOT NULL COMMENT '开票金额, 即订单实付金额, 单位分',
+  `status` tinyint(4) NOT NULL DEFAULT '0' COMMENT '0-待开具 1-已开具 2-已驳回',
+  `pdf_url` varchar(255) NOT NULL DEFAULT '' COMMENT '电子发票 pdf',
+  `remark` varchar(255) NOT NULL DEFAULT '' COMMENT '驳回原因',
+  `staff_id` bigint(20) NOT NULL DEFAULT '0' COMMENT '处理的运营人员',
+  `issue_time` int(11) NOT NULL DEFAULT '0',
+  `create_time` int(11) NOT NULL DEFAULT '0',
+  `update_time` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_order_id` (`order_id`),
+  KEY `idx_user_id` (`user_id`),
+  KEY `idx_status` (`status`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='电子发票申请';
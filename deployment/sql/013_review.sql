@@ -0,0 +1,26 @@
+-- 套餐评价, 每个订单项评价一次, 审核通过后计入套餐评分
+CREATE TABLE IF NOT EXISTS `mkp_review` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `order_id` bigint(20) NOT NULL,
+  `order_item_id` bigint(20) NOT NULL,
+  `user_id` bigint(20) NOT NULL,
+  `pkg_id` bigint(20) NOT NULL,
+  `rating` tinyint(4) NOT NULL COMMENT '评分 1-5 星',
+  `content` varchar(1000) NOT NULL DEFAULT '' COMMENT '评价内容',
+  `images` varchar(2048) NOT NULL DEFAULT '[]' COMMENT '晒图地址, json 数组',
+  `status` tinyint(4) NOT NULL DEFAULT '0' COMMENT '0-待审核 1-已通过 2-已驳回',
+  `remark` varchar(255) NOT NULL DEFAULT '' COMMENT '驳回原因',
+  `staff_id` bigint(20) NOT NULL DEFAULT '0' COMMENT '审核的运营人员',
+  `moderate_time` int(11) NOT NULL DEFAULT '0',
+  `create_time` int(11) NOT NULL DEFAULT '0',
+  `update_time` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_order_item_id` (`order_item_id`),
+  KEY `idx_pkg_status` (`pkg_id`, `status`),
+  KEY `idx_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='套餐评价';
+
+-- 审核通过的评价数和评分之和, 平均分在查询时计算
+ALTER TABLE `mkp_package`
+  ADD COLUMN `review_count` int(11) NOT NULL DEFAULT '0' COMMENT '审核通过的评价数',
+  ADD COLUMN `rating_sum` int(11) NOT NULL DEFAULT '0' COMMENT '审核通过的评价的评分之和';
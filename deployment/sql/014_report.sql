@@ -0,0 +1,18 @@
+-- 体检报告, 运营人员按订单项上传, 文件存放在私有存储桶, 用户通过签名链接下载
+CREATE TABLE IF NOT EXISTS `mko_report` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `order_id` bigint(20) NOT NULL,
+  `order_item_id` bigint(20) NOT NULL,
+  `user_id` bigint(20) NOT NULL COMMENT '订单项所属用户, 只有该用户可以下载',
+  `file_name` varchar(255) NOT NULL COMMENT '上传时的文件名',
+  `content_type` varchar(64) NOT NULL COMMENT 'application/pdf, image/jpeg 或 image/png',
+  `size` int(11) NOT NULL DEFAULT '0' COMMENT '文件大小, 单位字节',
+  `cos_key` varchar(255) NOT NULL COMMENT '私有存储桶中的对象 key',
+  `staff_id` bigint(20) NOT NULL DEFAULT '0' COMMENT '上传的运营人员',
+  `is_deleted` tinyint(4) NOT NULL DEFAULT '0',
+  `create_time` int(11) NOT NULL DEFAULT '0',
+  `update_time` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`),
+  KEY `idx_order_item_id` (`order_item_id`),
+  KEY `idx_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='体检报告';
@@ -0,0 +1,20 @@
+-- 结构化体检结果, 每个订单项一套结果, 运营人员重新录入时整体替换
+CREATE TABLE IF NOT EXISTS `mko_exam_result` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `order_id` bigint(20) NOT NULL,
+  `order_item_id` bigint(20) NOT NULL,
+  `user_id` bigint(20) NOT NULL,
+  `item_name` varchar(64) NOT NULL COMMENT '体检项目, 对应 mkp_package_attribute.name',
+  `indicator` varchar(64) NOT NULL COMMENT '项目下的指标名称',
+  `value` varchar(64) NOT NULL COMMENT '结果值, 数值或阴性/阳性等文字',
+  `unit` varchar(32) NOT NULL DEFAULT '',
+  `ref_range` varchar(64) NOT NULL DEFAULT '' COMMENT '参考范围',
+  `abnormal` tinyint(4) NOT NULL DEFAULT '0' COMMENT '0-正常 1-偏高 2-偏低 3-异常',
+  `staff_id` bigint(20) NOT NULL DEFAULT '0' COMMENT '录入的运营人员',
+  `is_deleted` tinyint(4) NOT NULL DEFAULT '0',
+  `create_time` int(11) NOT NULL DEFAULT '0',
+  `update_time` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`),
+  KEY `idx_order_item_id` (`order_item_id`),
+  KEY `idx_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='结构化体检结果';
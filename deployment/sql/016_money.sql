@@ -0,0 +1,17 @@
+-- 金额列统一改为以分为单位的整数, 与 server/util/money 对应. 原 decimal 列存的已经是分, 小数部分均为 0
+ALTER TABLE `mkp_package`
+  MODIFY COLUMN `price_original` bigint(20) NOT NULL DEFAULT '0' COMMENT '门市价, 单位分',
+  MODIFY COLUMN `price_real` bigint(20) NOT NULL DEFAULT '0' COMMENT '实际价格, 单位分';
+
+ALTER TABLE `mko_order`
+  MODIFY COLUMN `amount` bigint(20) NOT NULL DEFAULT '0' COMMENT '实付金额, 单位分',
+  MODIFY COLUMN `discount` bigint(20) NOT NULL DEFAULT '0' COMMENT '优惠金额, 单位分',
+  MODIFY COLUMN `card_amount` bigint(20) NOT NULL DEFAULT '0' COMMENT '体检卡抵扣金额, 单位分';
+
+ALTER TABLE `mko_order_item`
+  MODIFY COLUMN `pkg_price` bigint(20) NOT NULL DEFAULT '0' COMMENT '下单时的套餐价格, 单位分',
+  MODIFY COLUMN `discount` bigint(20) NOT NULL DEFAULT '0' COMMENT '分摊的优惠金额, 单位分',
+  MODIFY COLUMN `card_amount` bigint(20) NOT NULL DEFAULT '0' COMMENT '分摊的体检卡抵扣金额, 单位分';
+
+ALTER TABLE `mko_invoice`
+  MODIFY COLUMN `amount` bigint(20) NOT NULL COMMENT '开票金额, 即订单实付金额, 单位分';
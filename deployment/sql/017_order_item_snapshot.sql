@@ -0,0 +1,20 @@
+-- 订单项保存下单时的套餐快照, 订单展示不再关联 mkp_package, 套餐改名、下架不影响历史订单
+ALTER TABLE `mko_order_item`
+  ADD COLUMN `pkg_name` varchar(128) NOT NULL DEFAULT '' COMMENT '下单时的套餐名称' AFTER `pkg_price`,
+  ADD COLUMN `pkg_avatar_url` varchar(255) NOT NULL DEFAULT '' COMMENT '下单时的套餐头像' AFTER `pkg_name`,
+  ADD COLUMN `hospital_id` bigint(20) NOT NULL DEFAULT '0' COMMENT '下单时套餐所属的体检机构' AFTER `pkg_avatar_url`,
+  ADD COLUMN `hospital_name` varchar(64) NOT NULL DEFAULT '' COMMENT '下单时的体检机构名称' AFTER `hospital_id`,
+  ADD COLUMN `pkg_items` text COMMENT '下单时的套餐项目, json 数组 [{"order_no":1,"name":"血常规"}]' AFTER `hospital_name`,
+  ADD INDEX `idx_hospital_id` (`hospital_id`);
+
+-- 历史订单按套餐当前的信息补齐, 已下架的套餐同样补齐. 项目列表为空的旧订单展示时按套餐当前的项目.
+-- 体检机构已删除时也要补齐 hospital_id, 释放名额按订单项的 hospital_id
+UPDATE `mko_order_item` AS moi
+  INNER JOIN `mkp_package` AS mp ON moi.pkg_id = mp.id
+  LEFT JOIN `mkh_hospital` AS mh ON mp.hospital_id = mh.id
+SET
+  moi.pkg_name = mp.name,
+  moi.pkg_avatar_url = mp.avatar_url,
+  moi.hospital_id = mp.hospital_id,
+  moi.hospital_name = IFNULL(mh.name, '')
+WHERE moi.pkg_name = '';
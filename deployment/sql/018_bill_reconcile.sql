@@ -0,0 +1,32 @@
+-- 微信支付对账, 每天一份报告, 重新对账时覆盖当天的报告和差异
+CREATE TABLE IF NOT EXISTS `mkb_reconcile_report` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `bill_date` char(8) NOT NULL COMMENT '对账单日期 yyyyMMdd',
+  `remote_count` int(11) NOT NULL DEFAULT '0' COMMENT '对账单交易笔数',
+  `remote_total_fee` bigint(20) NOT NULL DEFAULT '0' COMMENT '对账单订单总金额, 单位分',
+  `remote_refund_fee` bigint(20) NOT NULL DEFAULT '0' COMMENT '对账单申请退款总金额, 单位分',
+  `local_count` int(11) NOT NULL DEFAULT '0' COMMENT '参与核对的本地流水数',
+  `matched_count` int(11) NOT NULL DEFAULT '0' COMMENT '一致的交易数',
+  `diff_count` int(11) NOT NULL DEFAULT '0' COMMENT '差异数',
+  `create_time` int(11) NOT NULL DEFAULT '0',
+  `update_time` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_bill_date` (`bill_date`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='微信支付对账报告';
+
+CREATE TABLE IF NOT EXISTS `mkb_reconcile_diff` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `report_id` bigint(20) NOT NULL,
+  `kind` tinyint(4) NOT NULL COMMENT '1-本地缺失 2-微信缺失 3-金额不一致 4-状态不一致',
+  `fee_type` tinyint(4) NOT NULL COMMENT '1-收款 2-退款',
+  `out_trade_no` varchar(32) NOT NULL DEFAULT '',
+  `transaction_id` varchar(32) NOT NULL DEFAULT '',
+  `out_refund_no` varchar(32) NOT NULL DEFAULT '',
+  `local_fee` bigint(20) NOT NULL DEFAULT '0' COMMENT '本地金额, 单位分',
+  `remote_fee` bigint(20) NOT NULL DEFAULT '0' COMMENT '对账单金额, 单位分',
+  `local_status` varchar(16) NOT NULL DEFAULT '' COMMENT '本地流水状态 PROCESSING/SUCCESS/CLOSED',
+  `remote_status` varchar(16) NOT NULL DEFAULT '' COMMENT '对账单中的交易状态或退款状态',
+  `create_time` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`),
+  KEY `idx_report_id` (`report_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='微信支付对账差异';
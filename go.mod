@@ -26,6 +26,7 @@ require (
 	github.com/swaggo/swag v1.6.7
 	github.com/tencentcloud/tencentcloud-sdk-go v3.0.213+incompatible
 	github.com/tencentyun/cos-go-sdk-v5 v0.7.7
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 	golang.org/x/image v0.0.0-20200618115811-c13761719519 // indirect
 	golang.org/x/net v0.0.0-20200707034311-ab3426394381
 	gopkg.in/mgo.v2 v2.0.0-20190816093944-a6b53ec6cb22
@@ -89,11 +89,19 @@ func watch(conn *zk.Conn, path string, key interface{}) {
 	go func() {
 		for {
 			t, _, events, err := conn.GetW(path)
+			if err == zk.ErrNoNode {
+				// 节点不存在时保留默认值, 等节点创建后再读取
+				var exists bool
+				if exists, _, events, err = conn.ExistsW(path); err == nil && exists {
+					continue
+				}
+			} else if err == nil {
+				dates <- t
+			}
 			if err != nil {
 				errCh <- err
 				return
 			}
-			dates <- t
 			evt := <-events
 			if evt.Err != nil {
 				errCh <- evt.Err
@@ -118,14 +126,18 @@ func watch(conn *zk.Conn, path string, key interface{}) {
 					_ = json.Unmarshal(data, &key)
 				}
 			case err := <-errCh:
-				fmt.Printf("watchStr error %+v\n", err)
-				conn.Close()
+				// 连接是所有配置共用的, 一个节点出错不能关闭连接
+				fmt.Printf("watch %s error %+v\n", path, err)
 				return
 			}
 		}
 	}()
 
-	data, _, _ := conn.Get(path)
+	data, _, err := conn.Get(path)
+	if err == zk.ErrNoNode {
+		fmt.Printf("%s not found, use default\n", path)
+		return
+	}
 	switch key.(type) {
 	case *int:
 		t, _ := key.(*int)
@@ -17,6 +17,8 @@ type cosConfig struct {
 	SecretID  string `json:"secret_id"`
 	SecretKey string `json:"secret_key"`
 	Region    string `json:"region"`
+	// 私有读的存储桶, 存放体检报告等敏感文件, 只能通过签名链接下载
+	PrivateBucketUrl string `json:"private_bucket_url"`
 }
 
 type smsMsgTemplateConfig struct {
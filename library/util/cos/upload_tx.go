@@ -3,6 +3,7 @@ package cos
 import (
 	"context"
 	"crypto/md5"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -63,3 +64,34 @@ func Upload2Tx(file *multipart.FileHeader) (err error, path string, key string)
 	}
 	return err, CommonBucketUrl + "/" + fileKey, fileKey
 }
+
+// 上传到私有读的存储桶, 返回对象的 key, 下载时用 PresignedURL 生成临时链接
+func UploadPrivate(file *multipart.FileHeader, key string) error {
+	if C.Cos.PrivateBucketUrl == "" {
+		return errors.New("private bucket is not configured")
+	}
+	f, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cli := NewCosClient(C.Cos.PrivateBucketUrl)
+	opt := &cos.ObjectPutOptions{
+		ObjectPutHeaderOptions: &cos.ObjectPutHeaderOptions{ContentType: file.Header.Get("Content-Type")},
+	}
+	_, err = cli.Object.Put(context.Background(), key, f, opt)
+	return err
+}
+
+// 私有存储桶中对象的签名下载链接, expired 后失效
+func PresignedURL(key string, expired time.Duration) (string, error) {
+	if C.Cos.PrivateBucketUrl == "" {
+		return "", errors.New("private bucket is not configured")
+	}
+	cli := NewCosClient(C.Cos.PrivateBucketUrl)
+	u, err := cli.Object.GetPresignedURL(context.Background(), http.MethodGet, key, C.Cos.SecretID, C.Cos.SecretKey, expired, nil)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
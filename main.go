@@ -9,6 +9,11 @@ import (
 )
 
 func main() {
+	// 手动核对微信支付对账单: mk-api reconcile -date 20200601
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		os.Exit(runReconcile(os.Args[2:]))
+	}
+
 	server := router.InitRouter(
 		middleware.Secure(),
 		middleware.Options(),
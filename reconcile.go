@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"mk-api/server/model"
+	"mk-api/server/service"
+	"mk-api/server/util/reconcile"
+	wcUtil "mk-api/server/util/wechat"
+)
+
+var diffKindNames = map[int8]string{
+	reconcile.MissingLocal:   "本地缺失",
+	reconcile.MissingRemote:  "微信缺失",
+	reconcile.AmountMismatch: "金额不一致",
+	reconcile.StatusMismatch: "状态不一致",
+}
+
+var feeTypeNames = map[int8]string{reconcile.FeePay: "收款", reconcile.FeeRefund: "退款"}
+
+// runReconcile 核对某天的微信支付对账单并打印差异, 用于补跑定时任务或财务复核, 报告同样保存到数据库
+func runReconcile(args []string) int {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	date := fs.String("date", time.Now().AddDate(0, 0, -1).Format("20060102"), "对账单日期 yyyyMMdd, 默认昨天")
+	_ = fs.Parse(args)
+
+	payGateway, err := wcUtil.NewPayGateway()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "创建微信支付商户平台客户端失败: %s\n", err.Error())
+		return 1
+	}
+	report, err := service.NewReconcileService(model.NewReconcileModel(), payGateway).ReconcileDay(*date)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "对账失败: %s\n", err.Error())
+		return 1
+	}
+
+	fmt.Printf("对账单日期: %s, 对账单 %d 笔, 订单总金额 %s, 退款总金额 %s\n", report.BillDate, report.RemoteCount,
+		report.RemoteTotalFee.Yuan(), report.RemoteRefundFee.Yuan())
+	fmt.Printf("本地流水 %d 笔, 一致 %d 笔, 差异 %d 条, 报告id: %d\n", report.LocalCount, report.MatchedCount,
+		report.DiffCount, report.Id)
+	if len(report.Diffs) == 0 {
+		return 0
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "差异\t类型\t商户订单号\t商户退款单号\t本地金额\t微信金额\t本地状态\t微信状态")
+	for _, d := range report.Diffs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", diffKindNames[d.Kind], feeTypeNames[d.FeeType], d.OutTradeNo,
+			d.OutRefundNo, d.LocalFee.Yuan(), d.RemoteFee.Yuan(), d.LocalStatus, d.RemoteStatus)
+	}
+	_ = w.Flush()
+	return 0
+}
@@ -2,6 +2,7 @@ package conf
 
 import (
 	"mk-api/library/superconf"
+	"mk-api/server/util/refund"
 )
 
 const ServiceName = "mk-server"
@@ -19,6 +20,8 @@ type Config struct {
 	WeChat        WechatConfig
 	// GenerateOrderKafka kafka.Config
 	RecvOpenIds []string // 运营人员open列表
+	// 改退规则, 运营修改 zk 后实时生效
+	RefundPolicy refund.Policy
 }
 
 type MysqlConfig struct {
@@ -63,11 +66,25 @@ type WechatConfig struct {
 	PayMchID       string `json:"pay_mch_id"`     // 支付 - 商户 Id
 	PayNotifyURL   string `json:"pay_notify_url"` // 支付 - 接受微信支付结果通知的接口地址
 	PayKey         string `json:"pay_key"`        // 支付 - 商户后台设置的支付 key
+	// 支付 - 商户平台接口网关, 为空时使用微信正式环境, 本地开发可以指向假的微信支付服务
+	PayGateway         string `json:"pay_gateway"`
+	PayRefundNotifyURL string `json:"pay_refund_notify_url"` // 退款 - 接受微信退款结果通知的接口地址
+	PayCertFile        string `json:"pay_cert_file"`         // 退款 - 商户证书, 为空时使用 server/static/cert 下的证书
+	PayKeyFile         string `json:"pay_key_file"`          // 商户证书私钥, v2 退款和 v3 签名使用
+	// 支付 - 商户平台接口版本 v2 或 v3, 为空时使用 v2。 本地开发和测试可以使用 fake, 不访问微信
+	PayApiVersion  string `json:"pay_api_version"`
+	PayApiV3Key    string `json:"pay_api_v3_key"`   // v3 - APIv3 密钥, 解密通知和平台证书
+	PaySerialNo    string `json:"pay_serial_no"`    // v3 - 商户证书序列号
+	PayFakeOutcome string `json:"pay_fake_outcome"` // fake - 下单后自动支付的结果, 为空时调用 /pay/fake_pay 支付
+	// 发票开具后推送给用户的模板消息 id, 为空时不推送
+	InvoiceIssuedTmplId string `json:"invoice_issued_tmpl_id"`
+	// 体检报告上传后推送给用户的模板消息 id, 为空时不推送
+	ReportReadyTmplId string `json:"report_ready_tmpl_id"`
 }
 
 // first define your conf data structure above here , second register your configs here
 func init() {
-	cfg := Config{}
+	cfg := Config{RefundPolicy: refund.DefaultPolicy}
 
 	var allConfigs = make(map[string]interface{})
 	allConfigs["/superconf/union/mysql/read"] = &cfg.MysqlRead
@@ -78,6 +95,8 @@ func init() {
 	allConfigs["/superconf/union/mongo/log"] = &cfg.MongoLog
 	allConfigs["/superconf/third_party/wechat"] = &cfg.WeChat
 	allConfigs["/superconf/third_party/receiver_open_ids"] = &cfg.RecvOpenIds
+	// 节点不存在时使用 refund.DefaultPolicy, 创建后实时生效
+	allConfigs["/superconf/business/refund_policy"] = &cfg.RefundPolicy
 
 	sc := superconf.NewSuperConfig(&allConfigs)
 	cfg.Local = *(sc.Config)
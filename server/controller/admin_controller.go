@@ -0,0 +1,810 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"mk-api/library/ecode"
+	"mk-api/server/dto"
+	"mk-api/server/middleware"
+	"mk-api/server/model"
+	"mk-api/server/service"
+	"mk-api/server/util"
+	"mk-api/server/util/card"
+	"mk-api/server/util/consts"
+	"mk-api/server/util/rbac"
+)
+
+// AdminRegister 运营后台, 除登录外的接口都需要运营人员的 token
+func AdminRegister(router *gin.RouterGroup) {
+	var (
+		packageModel      model.PackageModel        = model.NewPackageModel()
+		orderModel        model.OrderModel          = model.NewOrderModel()
+		payModel          model.PayModel            = model.NewPayModel()
+		capacityModel     model.CapacityModel       = model.NewCapacityModel()
+		couponModel       model.CouponModel         = model.NewCouponModel()
+		cardModel         model.CardModel           = model.NewCardModel()
+		stateMachine                                = service.NewOrderStateMachine(model.NewOrderStatusModel(), capacityModel, couponModel, cardModel)
+		payGateway                                  = newPayGateway()
+		auditService      service.AuditService      = service.NewAuditService(model.NewAuditModel())
+		orderService      service.OrderService      = service.NewOrderService(orderModel, packageModel, model.NewCartModel(), payModel, capacityModel, couponModel, cardModel, stateMachine, newCalendar(), payGateway, auditService)
+		refundService     service.RefundService     = service.NewRefundService(payModel, orderModel, stateMachine, payGateway)
+		adminOrderService service.AdminOrderService = service.NewAdminOrderService(model.NewAdminOrderModel(), orderModel, payModel, orderService, refundService, auditService)
+		staffModel        model.StaffModel          = model.NewStaffModel()
+		staffService      service.StaffService      = service.NewStaffService(staffModel)
+		couponService     service.CouponService     = service.NewCouponService(couponModel, auditService)
+		cardService       service.CardService       = service.NewCardService(cardModel, packageModel, auditService)
+		invoiceService    service.InvoiceService    = service.NewInvoiceService(model.NewInvoiceModel(), auditService)
+		reviewService     service.ReviewService     = service.NewReviewService(model.NewReviewModel(), stateMachine, auditService)
+		reportService     service.ReportService     = service.NewReportService(model.NewReportModel(), model.NewExamineeModel(), packageModel, auditService)
+		reconcileService  service.ReconcileService  = service.NewReconcileService(model.NewReconcileModel(), payGateway)
+		adminController   AdminController           = NewAdminController(staffService, adminOrderService, auditService, couponService, cardService, invoiceService, reviewService, reportService, reconcileService)
+	)
+	service.StartBillReconcile(reconcileService)
+	router.POST("/login", adminController.Login)
+
+	staffRouter := router.Group("", middleware.StaffRequired(staffModel))
+	staffRouter.GET("/orders/", middleware.PermissionRequired(rbac.OrderView), adminController.ListOrder)
+	staffRouter.GET("/orders/:id", middleware.PermissionRequired(rbac.OrderView), adminController.GetOrder)
+	staffRouter.POST("/order_notes/", middleware.PermissionRequired(rbac.OrderNote), adminController.PostOrderNote)
+	staffRouter.PUT("/appointments/confirm", middleware.PermissionRequired(rbac.AppointmentConfirm), adminController.ConfirmAppointment)
+	staffRouter.PUT("/refunds/approve", middleware.PermissionRequired(rbac.RefundAudit), adminController.ApproveRefund)
+	staffRouter.PUT("/refunds/reject", middleware.PermissionRequired(rbac.RefundAudit), adminController.RejectRefund)
+	staffRouter.GET("/audit_logs/", middleware.PermissionRequired(rbac.AuditView), adminController.ListAuditLog)
+	staffRouter.POST("/coupons/", middleware.PermissionRequired(rbac.CouponManage), adminController.PostCoupon)
+	staffRouter.POST("/card_batches/", middleware.PermissionRequired(rbac.CardManage), adminController.PostCardBatch)
+	staffRouter.GET("/card_batches/", middleware.PermissionRequired(rbac.CardManage), adminController.ListCardBatch)
+	staffRouter.PUT("/card_batches/void", middleware.PermissionRequired(rbac.CardManage), adminController.VoidCardBatch)
+	staffRouter.GET("/card_batches/:id/export", middleware.PermissionRequired(rbac.CardManage), adminController.ExportCardBatch)
+	staffRouter.GET("/invoices/", middleware.PermissionRequired(rbac.InvoiceIssue), adminController.ListInvoice)
+	staffRouter.PUT("/invoices/issue", middleware.PermissionRequired(rbac.InvoiceIssue), adminController.IssueInvoice)
+	staffRouter.PUT("/invoices/reject", middleware.PermissionRequired(rbac.InvoiceIssue), adminController.RejectInvoice)
+	staffRouter.GET("/reviews/", middleware.PermissionRequired(rbac.ReviewModerate), adminController.ListReview)
+	staffRouter.PUT("/reviews/approve", middleware.PermissionRequired(rbac.ReviewModerate), adminController.ApproveReview)
+	staffRouter.PUT("/reviews/reject", middleware.PermissionRequired(rbac.ReviewModerate), adminController.RejectReview)
+	staffRouter.POST("/reports/", middleware.PermissionRequired(rbac.ReportUpload), adminController.PostReport)
+	staffRouter.GET("/reports/", middleware.PermissionRequired(rbac.OrderView), adminController.ListReport)
+	staffRouter.PUT("/reports/delete", middleware.PermissionRequired(rbac.ReportUpload), adminController.DeleteReport)
+	staffRouter.PUT("/exam_results/", middleware.PermissionRequired(rbac.ReportUpload), adminController.PutExamResult)
+	staffRouter.GET("/exam_results/", middleware.PermissionRequired(rbac.OrderView), adminController.ListExamResult)
+	staffRouter.GET("/reconcile_reports/", middleware.PermissionRequired(rbac.BillReconcile), adminController.ListReconcileReport)
+	staffRouter.GET("/reconcile_reports/:id", middleware.PermissionRequired(rbac.BillReconcile), adminController.GetReconcileReport)
+}
+
+type AdminController interface {
+	Login(ctx *gin.Context)
+	ListOrder(ctx *gin.Context)
+	GetOrder(ctx *gin.Context)
+	PostOrderNote(ctx *gin.Context)
+	ConfirmAppointment(ctx *gin.Context)
+	ApproveRefund(ctx *gin.Context)
+	RejectRefund(ctx *gin.Context)
+	ListAuditLog(ctx *gin.Context)
+	PostCoupon(ctx *gin.Context)
+	PostCardBatch(ctx *gin.Context)
+	ListCardBatch(ctx *gin.Context)
+	VoidCardBatch(ctx *gin.Context)
+	ExportCardBatch(ctx *gin.Context)
+	ListInvoice(ctx *gin.Context)
+	IssueInvoice(ctx *gin.Context)
+	RejectInvoice(ctx *gin.Context)
+	ListReview(ctx *gin.Context)
+	ApproveReview(ctx *gin.Context)
+	RejectReview(ctx *gin.Context)
+	PostReport(ctx *gin.Context)
+	ListReport(ctx *gin.Context)
+	DeleteReport(ctx *gin.Context)
+	PutExamResult(ctx *gin.Context)
+	ListExamResult(ctx *gin.Context)
+	ListReconcileReport(ctx *gin.Context)
+	GetReconcileReport(ctx *gin.Context)
+}
+
+type adminController struct {
+	staffService     service.StaffService
+	orderService     service.AdminOrderService
+	auditService     service.AuditService
+	couponService    service.CouponService
+	cardService      service.CardService
+	invoiceService   service.InvoiceService
+	reviewService    service.ReviewService
+	reportService    service.ReportService
+	reconcileService service.ReconcileService
+}
+
+// 业务错误码原样返回, 其余按服务器内部错误处理
+func responseServiceError(ctx *gin.Context, err error, msg string) {
+	if code, ok := err.(ecode.Code); ok {
+		middleware.ResponseError(ctx, code, ctx.Errors.Last())
+		return
+	}
+	util.Log.Errorf("%s, err: [%s]", msg, err.Error())
+	middleware.ResponseError(ctx, ecode.ServerErr, errors.New("服务器内部错误"))
+}
+
+// Login godoc
+// @Summary 运营人员登录
+// @Description 运营人员用户名密码登录, 返回的 token 用于请求运营后台的其他接口
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param body body dto.StaffLoginInput true "登录的请求体"
+// @Success 200 {object} middleware.Response{data=dto.StaffLoginOutput}
+// @Router /admin/login [post]
+func (c *adminController) Login(ctx *gin.Context) {
+	var input dto.StaffLoginInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	output, err := c.staffService.Login(ctx, &input)
+	if err != nil {
+		responseServiceError(ctx, err, "运营人员登录失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, output)
+}
+
+// ListOrder godoc
+// @Summary 运营后台查询订单列表
+// @Description 跨用户查询订单, 可按订单号、手机号、订单状态、退款申请状态和下单时间筛选, 机构对接人只能查到所对接机构的订单
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param token header string true "运营人员token"
+// @Param page_size query int false "每页多少条, 默认 20"
+// @Param page_no query int false "页码"
+// @Param out_trade_no query string false "订单号"
+// @Param mobile query string false "下单人手机号"
+// @Param status query int false "订单状态 -1 全部(默认值) 0-未付款，2-已付款, 3-已退款, 4-已关闭, 5-待评价"
+// @Param refund_status query int false "退款申请 -1 全部(默认值) 0-未申请 1-待审核 2-已同意 3-已拒绝"
+// @Param start_time query int false "下单时间起, 时间戳"
+// @Param end_time query int false "下单时间止, 时间戳"
+// @Success 200 {object} middleware.Response{data=dto.PaginateListOutput{list=[]dto.AdminListOrderOutputEle}}
+// @Router /admin/orders/ [get]
+func (c *adminController) ListOrder(ctx *gin.Context) {
+	var input dto.AdminListOrderInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	output, err := c.orderService.ListOrder(ctx, &input)
+	if err != nil {
+		responseServiceError(ctx, err, "运营后台查询订单列表失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, output)
+}
+
+// GetOrder godoc
+// @Summary 运营后台获取订单详情
+// @Description 订单详情, 另外包含下单用户、退款申请、支付退款流水和内部备注
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param token header string true "运营人员token"
+// @Param id path int true "订单的id, order_id"
+// @Success 200 {object} middleware.Response{data=dto.AdminOrderDetail}
+// @Router /admin/orders/{id} [get]
+func (c *adminController) GetOrder(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, errors.New("参数id有误"))
+		return
+	}
+	output, err := c.orderService.RetrieveOrder(ctx, id)
+	if err != nil {
+		responseServiceError(ctx, err, "运营后台获取订单详情失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, output)
+}
+
+// PostOrderNote godoc
+// @Summary 添加订单内部备注
+// @Description 添加订单内部备注, 仅运营人员可见
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param token header string true "运营人员token"
+// @Param body body dto.PostOrderNoteInput true "备注的请求体"
+// @Success 200 {object} middleware.Response{data=dto.ResourceID}
+// @Router /admin/order_notes/ [post]
+func (c *adminController) PostOrderNote(ctx *gin.Context) {
+	var input dto.PostOrderNoteInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	id, err := c.orderService.AddNote(ctx, &input)
+	if err != nil {
+		responseServiceError(ctx, err, "添加订单备注失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, dto.ResourceID{Id: id})
+}
+
+// ConfirmAppointment godoc
+// @Summary 确认体检预约
+// @Description 与体检机构确认预约后标记订单项为已预约, 并推送预约成功消息给用户
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param token header string true "运营人员token"
+// @Param body body dto.ConfirmAppointmentInput true "确认预约的请求体"
+// @Success 200 {object} middleware.Response{data=dto.ResourceID}
+// @Router /admin/appointments/confirm [put]
+func (c *adminController) ConfirmAppointment(ctx *gin.Context) {
+	var input dto.ConfirmAppointmentInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	if err := c.orderService.ConfirmAppointment(ctx, &input); err != nil {
+		responseServiceError(ctx, err, "确认体检预约失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, dto.ResourceID{Id: input.OrderItemId})
+}
+
+// ApproveRefund godoc
+// @Summary 同意退款申请
+// @Description 同意用户的退款申请并向微信发起退款, 不传退款金额时按改退规则计算, 仅财务可操作
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param token header string true "运营人员token"
+// @Param body body dto.ApproveRefundInput true "同意退款的请求体"
+// @Success 200 {object} middleware.Response{data=dto.ResourceID}
+// @Router /admin/refunds/approve [put]
+func (c *adminController) ApproveRefund(ctx *gin.Context) {
+	var input dto.ApproveRefundInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	if err := c.orderService.ApproveRefund(ctx, &input); err != nil {
+		responseServiceError(ctx, err, "同意退款申请失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, dto.ResourceID{Id: input.OrderId})
+}
+
+// RejectRefund godoc
+// @Summary 拒绝退款申请
+// @Description 拒绝用户的退款申请, 需填写拒绝原因, 仅财务可操作
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param token header string true "运营人员token"
+// @Param body body dto.RejectRefundInput true "拒绝退款的请求体"
+// @Success 200 {object} middleware.Response{data=dto.ResourceID}
+// @Router /admin/refunds/reject [put]
+func (c *adminController) RejectRefund(ctx *gin.Context) {
+	var input dto.RejectRefundInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	if err := c.orderService.RejectRefund(ctx, &input); err != nil {
+		responseServiceError(ctx, err, "拒绝退款申请失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, dto.ResourceID{Id: input.OrderId})
+}
+
+// ListAuditLog godoc
+// @Summary 查询审计日志
+// @Description 按操作人、操作、被修改的记录和时间查询审计日志, 仅超级管理员可查看
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param token header string true "运营人员token"
+// @Param page_size query int false "每页多少条, 默认 20"
+// @Param page_no query int false "页码"
+// @Param actor_type query int false "操作人类型 0-全部 1-用户 2-运营人员 3-系统 4-微信支付回调"
+// @Param actor_id query int false "用户id或运营人员id"
+// @Param action query string false "操作, 如 examinee.update"
+// @Param target_table query string false "被修改的表, 如 mku_examinee"
+// @Param target_id query int false "被修改记录的id"
+// @Param start_time query int false "操作时间起, 时间戳"
+// @Param end_time query int false "操作时间止, 时间戳"
+// @Success 200 {object} middleware.Response{data=dto.PaginateListOutput{list=[]dto.AuditLog}}
+// @Router /admin/audit_logs/ [get]
+func (c *adminController) ListAuditLog(ctx *gin.Context) {
+	var input dto.ListAuditLogInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	output, err := c.auditService.ListAuditLog(ctx, &input)
+	if err != nil {
+		responseServiceError(ctx, err, "查询审计日志失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, output)
+}
+
+// PostCoupon godoc
+// @Summary 创建优惠券
+// @Description 创建优惠券活动, 用户凭优惠码领取, 金额单位为分, 仅超级管理员可操作
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param token header string true "运营人员token"
+// @Param body body dto.PostCouponInput true "创建优惠券的请求体"
+// @Success 200 {object} middleware.Response{data=dto.ResourceID}
+// @Router /admin/coupons/ [post]
+func (c *adminController) PostCoupon(ctx *gin.Context) {
+	var input dto.PostCouponInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	id, err := c.couponService.CreateCoupon(ctx, &input)
+	if err != nil {
+		responseServiceError(ctx, err, "创建优惠券失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, dto.ResourceID{Id: id})
+}
+
+// PostCardBatch godoc
+// @Summary 创建体检卡批次
+// @Description 为企业客户批量生成体检卡, 套餐卡抵扣一份指定套餐, 储值卡抵扣面值金额(单位分), 仅超级管理员可操作
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param token header string true "运营人员token"
+// @Param body body dto.PostCardBatchInput true "创建体检卡批次的请求体"
+// @Success 200 {object} middleware.Response{data=dto.ResourceID}
+// @Router /admin/card_batches/ [post]
+func (c *adminController) PostCardBatch(ctx *gin.Context) {
+	var input dto.PostCardBatchInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	id, err := c.cardService.CreateCardBatch(ctx, &input)
+	if err != nil {
+		responseServiceError(ctx, err, "创建体检卡批次失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, dto.ResourceID{Id: id})
+}
+
+// ListCardBatch godoc
+// @Summary 体检卡批次列表
+// @Description 体检卡批次列表, 包含每个批次已使用的卡数量
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param token header string true "运营人员token"
+// @Param page_size query int false "每页多少条, 默认 20"
+// @Param page_no query int false "页码"
+// @Param company query string false "企业客户, 模糊匹配"
+// @Success 200 {object} middleware.Response{data=dto.PaginateListOutput{list=[]dto.CardBatch}}
+// @Router /admin/card_batches/ [get]
+func (c *adminController) ListCardBatch(ctx *gin.Context) {
+	var input dto.ListCardBatchInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	output, err := c.cardService.ListCardBatches(ctx, &input)
+	if err != nil {
+		responseServiceError(ctx, err, "查询体检卡批次失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, output)
+}
+
+// VoidCardBatch godoc
+// @Summary 作废体检卡批次
+// @Description 作废批次中尚未使用的卡, 已使用的卡不受影响
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param token header string true "运营人员token"
+// @Param body body dto.VoidCardBatchInput true "作废体检卡批次的请求体"
+// @Success 200 {object} middleware.Response
+// @Router /admin/card_batches/void [put]
+func (c *adminController) VoidCardBatch(ctx *gin.Context) {
+	var input dto.VoidCardBatchInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	if err := c.cardService.VoidCardBatch(ctx, &input); err != nil {
+		responseServiceError(ctx, err, "作废体检卡批次失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, nil)
+}
+
+// ExportCardBatch godoc
+// @Summary 导出体检卡批次
+// @Description 以 csv 文件导出批次的全部卡号及使用情况
+// @Tags admin
+// @Produce  text/csv
+// @Param token header string true "运营人员token"
+// @Param id path int true "批次id"
+// @Success 200 {file} file
+// @Router /admin/card_batches/{id}/export [get]
+func (c *adminController) ExportCardBatch(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, errors.New("参数id有误"))
+		return
+	}
+	batch, cards, err := c.cardService.ExportCardBatch(ctx, id)
+	if err != nil {
+		responseServiceError(ctx, err, "导出体检卡批次失败")
+		return
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("\xEF\xBB\xBF") // BOM, 避免 Excel 打开中文乱码
+	w := csv.NewWriter(&buf)
+	_ = w.Write([]string{"卡号", "状态", "使用订单", "使用时间"})
+	statusNames := map[int8]string{consts.CardUnused: "未使用", consts.CardRedeemed: "已使用", consts.CardVoided: "已作废"}
+	for _, item := range cards {
+		var orderId, redeemTime string
+		if item.OrderId != 0 {
+			orderId = strconv.FormatInt(item.OrderId, 10)
+			redeemTime = time.Unix(item.RedeemTime, 0).Format("2006-01-02 15:04:05")
+		}
+		_ = w.Write([]string{card.Format(item.Code), statusNames[item.Status], orderId, redeemTime})
+	}
+	w.Flush()
+	filename := fmt.Sprintf("card_batch_%d.csv", batch.Id)
+	ctx.Header("Content-Disposition", "attachment; filename="+filename)
+	ctx.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
+
+// ListInvoice godoc
+// @Summary 运营后台发票申请列表
+// @Description 发票申请列表, 按申请时间倒序
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param token header string true "运营人员token"
+// @Param page_size query int false "每页多少条, 默认 20"
+// @Param page_no query int false "页码"
+// @Param status query int false "-1 全部(默认值) 0-待开具 1-已开具 2-已驳回"
+// @Success 200 {object} middleware.Response{data=dto.PaginateListOutput{list=[]dto.Invoice}}
+// @Router /admin/invoices/ [get]
+func (c *adminController) ListInvoice(ctx *gin.Context) {
+	var input dto.ListInvoiceInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	output, err := c.invoiceService.ListInvoices(ctx, &input)
+	if err != nil {
+		responseServiceError(ctx, err, "查询发票申请失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, output)
+}
+
+// IssueInvoice godoc
+// @Summary 开具发票
+// @Description 上传电子发票 pdf 并标记发票申请为已开具, 推送通知给用户
+// @Tags admin
+// @accept multipart/form-data
+// @Produce  json
+// @Param token header string true "运营人员token"
+// @Param invoice_id formData int true "发票申请id"
+// @Param pdf formData file true "电子发票 pdf"
+// @Success 200 {object} middleware.Response
+// @Router /admin/invoices/issue [put]
+func (c *adminController) IssueInvoice(ctx *gin.Context) {
+	var input dto.IssueInvoiceInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	_, pdf, err := ctx.Request.FormFile("pdf")
+	if err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	if err = c.invoiceService.IssueInvoice(ctx, &input, pdf); err != nil {
+		responseServiceError(ctx, err, "开具发票失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, nil)
+}
+
+// RejectInvoice godoc
+// @Summary 驳回发票申请
+// @Description 驳回发票申请, 驳回原因展示给用户, 用户可修改后重新提交
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param token header string true "运营人员token"
+// @Param body body dto.RejectInvoiceInput true "驳回发票申请的请求体"
+// @Success 200 {object} middleware.Response
+// @Router /admin/invoices/reject [put]
+func (c *adminController) RejectInvoice(ctx *gin.Context) {
+	var input dto.RejectInvoiceInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	if err := c.invoiceService.RejectInvoice(ctx, &input); err != nil {
+		responseServiceError(ctx, err, "驳回发票申请失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, nil)
+}
+
+// ListReview godoc
+// @Summary 运营后台评价列表
+// @Description 套餐评价列表, 按提交时间倒序
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param token header string true "运营人员token"
+// @Param page_size query int false "每页多少条, 默认 20"
+// @Param page_no query int false "页码"
+// @Param status query int false "-1 全部(默认值) 0-待审核 1-已通过 2-已驳回"
+// @Param pkg_id query int false "套餐id"
+// @Success 200 {object} middleware.Response{data=dto.PaginateListOutput{list=[]dto.Review}}
+// @Router /admin/reviews/ [get]
+func (c *adminController) ListReview(ctx *gin.Context) {
+	var input dto.ListReviewInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	output, err := c.reviewService.ListReviews(ctx, &input)
+	if err != nil {
+		responseServiceError(ctx, err, "查询评价失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, output)
+}
+
+// ApproveReview godoc
+// @Summary 审核通过评价
+// @Description 审核通过后评价展示在套餐详情页, 评分计入套餐
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param token header string true "运营人员token"
+// @Param body body dto.ApproveReviewInput true "审核通过评价的请求体"
+// @Success 200 {object} middleware.Response
+// @Router /admin/reviews/approve [put]
+func (c *adminController) ApproveReview(ctx *gin.Context) {
+	var input dto.ApproveReviewInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	if err := c.reviewService.ApproveReview(ctx, &input); err != nil {
+		responseServiceError(ctx, err, "审核评价失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, nil)
+}
+
+// RejectReview godoc
+// @Summary 驳回评价
+// @Description 驳回评价, 驳回原因展示给用户
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param token header string true "运营人员token"
+// @Param body body dto.RejectReviewInput true "驳回评价的请求体"
+// @Success 200 {object} middleware.Response
+// @Router /admin/reviews/reject [put]
+func (c *adminController) RejectReview(ctx *gin.Context) {
+	var input dto.RejectReviewInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	if err := c.reviewService.RejectReview(ctx, &input); err != nil {
+		responseServiceError(ctx, err, "驳回评价失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, nil)
+}
+
+// PostReport godoc
+// @Summary 上传体检报告
+// @Description 为已付款的订单项上传体检报告, 支持不超过 20M 的 pdf、jpg、png, 上传后推送通知给用户. 机构对接人只能上传所对接机构的报告
+// @Tags admin
+// @accept multipart/form-data
+// @Produce  json
+// @Param token header string true "运营人员token"
+// @Param order_item_id formData int true "订单项id"
+// @Param report formData file true "体检报告"
+// @Success 200 {object} middleware.Response{data=dto.ResourceID}
+// @Router /admin/reports/ [post]
+func (c *adminController) PostReport(ctx *gin.Context) {
+	var input dto.PostReportInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	_, report, err := ctx.Request.FormFile("report")
+	if err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	id, err := c.reportService.UploadReport(ctx, &input, report)
+	if err != nil {
+		responseServiceError(ctx, err, "上传体检报告失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, dto.ResourceID{Id: id})
+}
+
+// ListReport godoc
+// @Summary 运营后台体检报告列表
+// @Description 订单项的体检报告列表
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param token header string true "运营人员token"
+// @Param order_item_id query int true "订单项id"
+// @Success 200 {object} middleware.Response{data=[]dto.Report}
+// @Router /admin/reports/ [get]
+func (c *adminController) ListReport(ctx *gin.Context) {
+	var input dto.ListReportInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	output, err := c.reportService.ListReports(ctx, &input)
+	if err != nil {
+		responseServiceError(ctx, err, "查询体检报告失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, output)
+}
+
+// DeleteReport godoc
+// @Summary 删除体检报告
+// @Description 删除传错的体检报告, 删除后用户不能再下载
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param token header string true "运营人员token"
+// @Param body body dto.DeleteReportInput true "删除体检报告的请求体"
+// @Success 200 {object} middleware.Response
+// @Router /admin/reports/delete [put]
+func (c *adminController) DeleteReport(ctx *gin.Context) {
+	var input dto.DeleteReportInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	if err := c.reportService.DeleteReport(ctx, &input); err != nil {
+		responseServiceError(ctx, err, "删除体检报告失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, nil)
+}
+
+// PutExamResult godoc
+// @Summary 录入体检结果
+// @Description 录入订单项的结构化体检结果, 整体替换已录入的结果. 项目名称必须是所购套餐中的项目
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param token header string true "运营人员token"
+// @Param body body dto.PutExamResultInput true "录入体检结果的请求体"
+// @Success 200 {object} middleware.Response
+// @Router /admin/exam_results/ [put]
+func (c *adminController) PutExamResult(ctx *gin.Context) {
+	var input dto.PutExamResultInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	if err := c.reportService.SaveExamResults(ctx, &input); err != nil {
+		responseServiceError(ctx, err, "录入体检结果失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, nil)
+}
+
+// ListExamResult godoc
+// @Summary 运营后台体检结果
+// @Description 订单项已录入的结构化体检结果
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param token header string true "运营人员token"
+// @Param order_item_id query int true "订单项id"
+// @Success 200 {object} middleware.Response{data=[]dto.ExamResult}
+// @Router /admin/exam_results/ [get]
+func (c *adminController) ListExamResult(ctx *gin.Context) {
+	var input dto.ListExamResultInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	output, err := c.reportService.ListExamResults(ctx, &input)
+	if err != nil {
+		responseServiceError(ctx, err, "查询体检结果失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, output)
+}
+
+// ListReconcileReport godoc
+// @Summary 微信支付对账报告列表
+// @Description 每天核对前一天的微信支付对账单与本地流水, 按对账单日期倒序
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param token header string true "运营人员token"
+// @Param page_size query int false "每页多少条, 默认 20"
+// @Param page_no query int false "页码"
+// @Success 200 {object} middleware.Response{data=dto.PaginateListOutput{list=[]dto.ReconcileReport}}
+// @Router /admin/reconcile_reports/ [get]
+func (c *adminController) ListReconcileReport(ctx *gin.Context) {
+	var input dto.ListReconcileReportInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	output, err := c.reconcileService.ListReports(ctx, &input)
+	if err != nil {
+		responseServiceError(ctx, err, "查询对账报告失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, output)
+}
+
+// GetReconcileReport godoc
+// @Summary 微信支付对账报告详情
+// @Description 对账报告及差异明细: 本地缺失、微信缺失、金额不一致、状态不一致
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param token header string true "运营人员token"
+// @Param id path int true "对账报告id"
+// @Success 200 {object} middleware.Response{data=dto.ReconcileReportDetail}
+// @Router /admin/reconcile_reports/{id} [get]
+func (c *adminController) GetReconcileReport(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, errors.New("参数id有误"))
+		return
+	}
+	output, err := c.reconcileService.RetrieveReport(ctx, id)
+	if err != nil {
+		responseServiceError(ctx, err, "查询对账报告失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, output)
+}
+
+func NewAdminController(staffService service.StaffService, orderService service.AdminOrderService,
+	auditService service.AuditService, couponService service.CouponService, cardService service.CardService,
+	invoiceService service.InvoiceService, reviewService service.ReviewService, reportService service.ReportService,
+	reconcileService service.ReconcileService) AdminController {
+	return &adminController{
+		staffService:     staffService,
+		orderService:     orderService,
+		auditService:     auditService,
+		couponService:    couponService,
+		cardService:      cardService,
+		invoiceService:   invoiceService,
+		reviewService:    reviewService,
+		reportService:    reportService,
+		reconcileService: reconcileService,
+	}
+}
@@ -51,7 +51,7 @@ func (c *cartController) DeleteCartEntriesByIds(ctx *gin.Context) {
 		middleware.ResponseError(ctx, ecode.RequestErr, errors.New("参数cart_ids出错"))
 		return
 	}
-	if err := c.service.RemoveCartEntries(&input); err != nil {
+	if err := c.service.RemoveCartEntries(ctx, &input); err != nil {
 		util.Log.Errorf("删除购物车条目出错, err: [%s]", err.Error())
 		middleware.ResponseError(ctx, ecode.ServerErr, errors.New("删除购物车条目出错"))
 	} else {
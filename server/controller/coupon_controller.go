@@ -0,0 +1,81 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"mk-api/library/ecode"
+	"mk-api/server/dto"
+	"mk-api/server/middleware"
+	"mk-api/server/model"
+	"mk-api/server/service"
+	"mk-api/server/util"
+)
+
+func CouponRegister(router *gin.RouterGroup) {
+	var (
+		couponService    service.CouponService = service.NewCouponService(model.NewCouponModel(), service.NewAuditService(model.NewAuditModel()))
+		couponController CouponController      = NewCouponController(couponService)
+	)
+	router.POST("/claim", couponController.ClaimCoupon)
+	router.GET("/", couponController.ListCoupon)
+}
+
+type CouponController interface {
+	ClaimCoupon(ctx *gin.Context)
+	ListCoupon(ctx *gin.Context)
+}
+
+type couponController struct {
+	service service.CouponService
+}
+
+// ClaimCoupon godoc
+// @Summary 凭优惠码领取优惠券
+// @Description 凭优惠码领取优惠券, 返回领取到的优惠券id, 下单时作为 user_coupon_id 传入
+// @Tags coupons
+// @Accept  json
+// @Produce  json
+// @Param token header string true "用户token"
+// @Param body body dto.ClaimCouponInput true "领取优惠券的请求体"
+// @Success 200 {object} middleware.Response{data=dto.ResourceID}
+// @Router /coupons/claim [post]
+func (c *couponController) ClaimCoupon(ctx *gin.Context) {
+	var input dto.ClaimCouponInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	id, err := c.service.Claim(ctx, &input)
+	if err != nil {
+		responseServiceError(ctx, err, "领取优惠券失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, dto.ResourceID{Id: id})
+}
+
+// ListCoupon godoc
+// @Summary 我的优惠券
+// @Description 用户领取的优惠券列表, 金额单位为分
+// @Tags coupons
+// @Accept  json
+// @Produce  json
+// @Param token header string true "用户token"
+// @Param status query int false "-1 全部(默认值) 0-未使用 1-已使用"
+// @Success 200 {object} middleware.Response{data=[]dto.UserCoupon}
+// @Router /coupons/ [get]
+func (c *couponController) ListCoupon(ctx *gin.Context) {
+	var input dto.ListUserCouponInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	output, err := c.service.ListUserCoupons(ctx, &input)
+	if err != nil {
+		responseServiceError(ctx, err, "查询用户的优惠券失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, output)
+}
+
+func NewCouponController(service service.CouponService) CouponController {
+	return &couponController{service: service}
+}
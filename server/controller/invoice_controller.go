@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"mk-api/library/ecode"
+	"mk-api/server/dto"
+	"mk-api/server/middleware"
+	"mk-api/server/model"
+	"mk-api/server/service"
+	"mk-api/server/util"
+)
+
+func InvoiceRegister(router *gin.RouterGroup) {
+	var (
+		invoiceService    service.InvoiceService = service.NewInvoiceService(model.NewInvoiceModel(), service.NewAuditService(model.NewAuditModel()))
+		invoiceController InvoiceController      = NewInvoiceController(invoiceService)
+	)
+	router.POST("/", invoiceController.PostInvoice)
+	router.GET("/", invoiceController.ListInvoice)
+}
+
+type InvoiceController interface {
+	PostInvoice(ctx *gin.Context)
+	ListInvoice(ctx *gin.Context)
+}
+
+type invoiceController struct {
+	service service.InvoiceService
+}
+
+// PostInvoice godoc
+// @Summary 申请电子发票
+// @Description 为已付款的订单申请电子发票, 每个订单只能开一张, 被驳回后可修改重新提交
+// @Tags invoices
+// @Accept  json
+// @Produce  json
+// @Param token header string true "用户token"
+// @Param body body dto.PostInvoiceInput true "申请发票的请求体"
+// @Success 200 {object} middleware.Response{data=dto.ResourceID}
+// @Router /invoices/ [post]
+func (c *invoiceController) PostInvoice(ctx *gin.Context) {
+	var input dto.PostInvoiceInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	id, err := c.service.ApplyInvoice(ctx, &input)
+	if err != nil {
+		responseServiceError(ctx, err, "申请发票失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, dto.ResourceID{Id: id})
+}
+
+// ListInvoice godoc
+// @Summary 我的发票
+// @Description 用户的发票申请列表, 已开具的可下载电子发票, 已驳回的附驳回原因
+// @Tags invoices
+// @Accept  json
+// @Produce  json
+// @Param token header string true "用户token"
+// @Param page_size query int false "每页多少条, 默认 20"
+// @Param page_no query int false "页码"
+// @Param status query int false "-1 全部(默认值) 0-待开具 1-已开具 2-已驳回"
+// @Success 200 {object} middleware.Response{data=dto.PaginateListOutput{list=[]dto.Invoice}}
+// @Router /invoices/ [get]
+func (c *invoiceController) ListInvoice(ctx *gin.Context) {
+	var input dto.ListInvoiceInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	input.UserId = ctx.GetInt64("userId")
+	output, err := c.service.ListInvoices(ctx, &input)
+	if err != nil {
+		responseServiceError(ctx, err, "查询发票失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, output)
+}
+
+func NewInvoiceController(service service.InvoiceService) InvoiceController {
+	return &invoiceController{service: service}
+}
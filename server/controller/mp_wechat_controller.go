@@ -29,10 +29,9 @@ const (
 	aboutAppointment = `详细请回复"人工"联系人工客服或致电客服热线：0668-2853837
 人工客服时间：周一至周日8：00-22：00
 客服电话热线：上午8：00-12：00  下午2：00-6：00`
-	aboutInvoice = `您好，如需开具电子发票，请点击“人工客服”后，发送
-购买套餐订单号、发票抬头、纳税人识别号、联系人电话、
-联系人邮箱等信息。
-电子发票在3-5个工作日内，发送到您邮箱。
+	aboutInvoice = `您好，如需开具电子发票，请在“我的订单”中进入已付款的订单，
+点击“申请发票”，填写发票抬头、纳税人识别号和接收邮箱。
+电子发票在3-5个工作日内开具，发送到您邮箱，开具后会推送消息通知您。
 `
 	aboutRefund = `改退说明
 1、退款： 如客户预约成功后选择退款，需扣除套餐实付金额的10%作为服务费。
@@ -5,11 +5,8 @@ import (
 	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"github.com/silenceper/wechat/v2/pay"
-	payConfig "github.com/silenceper/wechat/v2/pay/config"
 	"github.com/sirupsen/logrus"
 	"mk-api/library/ecode"
-	"mk-api/server/conf"
 	"mk-api/server/dto"
 	"mk-api/server/middleware"
 	"mk-api/server/model"
@@ -19,26 +16,24 @@ import (
 
 func OrderRegister(router *gin.RouterGroup) {
 	var (
-		cartModel    model.CartModel    = model.NewCartModel()
-		packageModel model.PackageModel = model.NewPackageModel()
-		orderModel   model.OrderModel   = model.NewOrderModel()
-		payModel     model.PayModel     = model.NewPayModel()
-		cfg                             = &payConfig.Config{
-			AppID:     conf.C.WeChat.AppID,
-			MchID:     conf.C.WeChat.PayMchID,
-			Key:       conf.C.WeChat.PayKey,
-			NotifyURL: conf.C.WeChat.PayNotifyURL,
-		}
-		wechatPay                            = pay.NewPay(cfg)
-		orderService    service.OrderService = service.NewOrderService(orderModel, packageModel, cartModel, payModel, wechatPay)
+		cartModel       model.CartModel      = model.NewCartModel()
+		packageModel    model.PackageModel   = model.NewPackageModel()
+		orderModel      model.OrderModel     = model.NewOrderModel()
+		payModel        model.PayModel       = model.NewPayModel()
+		capacityModel   model.CapacityModel  = model.NewCapacityModel()
+		couponModel     model.CouponModel    = model.NewCouponModel()
+		cardModel       model.CardModel      = model.NewCardModel()
+		stateMachine                         = service.NewOrderStateMachine(model.NewOrderStatusModel(), capacityModel, couponModel, cardModel)
+		orderService    service.OrderService = service.NewOrderService(orderModel, packageModel, cartModel, payModel, capacityModel, couponModel, cardModel, stateMachine, newCalendar(), newPayGateway(), service.NewAuditService(model.NewAuditModel()))
 		orderController OrderController      = NewOrderController(orderService)
 	)
-	router.POST("/orders/", orderController.PostOrder)
+	router.POST("/orders/", middleware.Idempotent(), orderController.PostOrder)
 	router.GET("/orders/", orderController.ListOrder)
 	router.GET("/orders/:id", orderController.GetOrder)
 	router.DELETE("/orders/:id", orderController.DeleteOrder)
-	router.PUT("/cancel_order/", orderController.CancelOrder)
-	router.PUT("/refund_order/", orderController.RefundOrder)
+	router.PUT("/cancel_order/", middleware.Idempotent(), orderController.CancelOrder)
+	router.PUT("/refund_order/", middleware.Idempotent(), orderController.RefundOrder)
+	router.GET("/refund_order/preview", orderController.GetRefundPreview)
 
 	router.PUT("/order_items/", orderController.PutOrderItem)
 }
@@ -50,6 +45,7 @@ type OrderController interface {
 	DeleteOrder(ctx *gin.Context)
 	CancelOrder(ctx *gin.Context)
 	RefundOrder(ctx *gin.Context)
+	GetRefundPreview(ctx *gin.Context)
 
 	PutOrderItem(ctx *gin.Context)
 }
@@ -65,6 +61,7 @@ type orderController struct {
 // @Accept  json
 // @Produce  json
 // @Param token header string true "用户token"
+// @Param Idempotency-Key header string false "幂等键, 重试时带上同一个值, 24 小时内重复请求返回第一次成功的结果"
 // @Param body body dto.RefundOrderInput true "对订单申请退款的请求体"
 // @Success 200 {object} middleware.Response{data=dto.ResourceID}
 // @Router /refund_order/ [put]
@@ -78,8 +75,8 @@ func (c *orderController) RefundOrder(ctx *gin.Context) {
 	err = c.service.RefundOrder(ctx, &input)
 
 	if err != nil {
-		if _, ok := err.(ecode.Codes); ok {
-			middleware.ResponseError(ctx, ecode.RequestErr, ctx.Errors.Last())
+		if code, ok := err.(ecode.Code); ok {
+			middleware.ResponseError(ctx, code, ctx.Errors.Last())
 			return
 		}
 
@@ -91,6 +88,36 @@ func (c *orderController) RefundOrder(ctx *gin.Context) {
 
 }
 
+// GetRefundPreview godoc
+// @Summary 申请退款前试算可退金额
+// @Description 按当前的改退规则计算订单的可退金额、退款服务费和超次改期服务费, 金额单位为分
+// @Tags orders
+// @Accept  json
+// @Produce  json
+// @Param token header string true "用户token"
+// @Param order_id query int true "订单id"
+// @Success 200 {object} middleware.Response{data=dto.RefundPreviewOutput}
+// @Router /refund_order/preview [get]
+func (c *orderController) GetRefundPreview(ctx *gin.Context) {
+	var input dto.RefundPreviewInput
+	err := util.ParseRequest(ctx, &input)
+	if err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	output, err := c.service.PreviewRefund(ctx, input.Id)
+	if err != nil {
+		if code, ok := err.(ecode.Code); ok {
+			middleware.ResponseError(ctx, code, ctx.Errors.Last())
+			return
+		}
+		util.Log.Errorf("controller failed to preview refund, input: [%v], err: [%s]", input, err.Error())
+		middleware.ResponseError(ctx, ecode.ServerErr, errors.New("internal server error"))
+		return
+	}
+	middleware.ResponseSuccess(ctx, output)
+}
+
 // CancelOrder godoc
 // @Summary 尚未支付的状态下取消订单
 // @Description 尚未支付的状态下取消订单
@@ -98,6 +125,7 @@ func (c *orderController) RefundOrder(ctx *gin.Context) {
 // @Accept  json
 // @Produce  json
 // @Param token header string true "用户token"
+// @Param Idempotency-Key header string false "幂等键, 重试时带上同一个值, 24 小时内重复请求返回第一次成功的结果"
 // @Param body body dto.CancelOrderInput true "取消订单的请求体"
 // @Success 200 {object} middleware.Response{data=dto.ResourceID}
 // @Router /cancel_order/ [put]
@@ -110,6 +138,10 @@ func (c *orderController) CancelOrder(ctx *gin.Context) {
 	}
 	err = c.service.CancelOrder(ctx, &input)
 	if err != nil {
+		if code, ok := err.(ecode.Code); ok {
+			middleware.ResponseError(ctx, code, ctx.Errors.Last())
+			return
+		}
 		util.Log.Errorf("controller failed to cancel order, input: [%v], err: [%s]", input, err.Error())
 		middleware.ResponseError(ctx, ecode.ServerErr, errors.New("internal server error"))
 		return
@@ -120,7 +152,7 @@ func (c *orderController) CancelOrder(ctx *gin.Context) {
 
 // UpdateOrderItem godoc
 // @Summary 更新orderItem的体检人信息
-// @Description 更新orderItem的体检人信息
+// @Description 更新orderItem的体检人信息, 修改体检日期会计入改期次数, 免费次数用完后返回错误码 10002, 用户确认收费后带上 accept_reschedule_fee 重试
 // @Tags orders
 // @Accept  json
 // @Produce  json
@@ -137,8 +169,8 @@ func (c *orderController) PutOrderItem(ctx *gin.Context) {
 	}
 	err = c.service.ModifyOrderItem(ctx, &input)
 	if err != nil {
-		if _, ok := err.(ecode.Codes); ok {
-			middleware.ResponseError(ctx, ecode.RequestErr, ctx.Errors.Last())
+		if code, ok := err.(ecode.Code); ok {
+			middleware.ResponseError(ctx, code, ctx.Errors.Last())
 			util.Log.Errorf("failed to update order item, input is [%v], err is [%c]", input, ctx.Errors.Last())
 			return
 		}
@@ -166,6 +198,10 @@ func (c *orderController) DeleteOrder(ctx *gin.Context) {
 		return
 	}
 	err = c.service.RemoveOrder(ctx, id)
+	if code, ok := err.(ecode.Code); ok {
+		middleware.ResponseError(ctx, code, ctx.Errors.Last())
+		return
+	}
 	if err != nil {
 		util.Log.WithFields(logrus.Fields{"order_id": id}).Errorf("移除订单失败, err: [%s]", err.Error())
 		middleware.ResponseError(ctx, ecode.ServerErr, errors.New("服务器内部错误"))
@@ -191,6 +227,10 @@ func (c *orderController) GetOrder(ctx *gin.Context) {
 		return
 	}
 	order, err := c.service.RetrieveOrder(ctx, id)
+	if code, ok := err.(ecode.Code); ok {
+		middleware.ResponseError(ctx, code, ctx.Errors.Last())
+		return
+	}
 	if err != nil {
 		util.Log.Errorf("根据id获取order失败, err: [%s]", err.Error())
 		middleware.ResponseError(ctx, ecode.ServerErr, errors.New("服务器内部错误"))
@@ -229,11 +269,12 @@ func (c *orderController) ListOrder(ctx *gin.Context) {
 
 // CreateOrder godoc
 // @Summary 创建订单
-// @Description 创建订单,返回前端调起微信支付的必须参数
+// @Description 创建订单,返回前端调起微信支付的必须参数; 使用体检卡全额抵扣时 paid 为 true, 订单已付款, 无需调起微信支付
 // @Tags orders
 // @Accept  json
 // @Produce  json
 // @Param token header string true "用户token"
+// @Param Idempotency-Key header string false "幂等键, 重试时带上同一个值, 24 小时内重复请求返回第一次成功的结果"
 // @Param body body dto.PostOrderInput true "创建订单的请求体"
 // @Success 200 {object} middleware.Response{data=dto.PostOrderOutput}
 // @Router /orders/ [post]
@@ -248,12 +289,9 @@ func (c *orderController) PostOrder(ctx *gin.Context) {
 	cfg, err := c.service.CreateOrder(ctx, &input)
 	if err != nil {
 		util.Log.Errorf("controller failed to create order, err: [%s]", err.Error())
-		switch err.(type) {
-		case ecode.Codes:
-			if ecode.Equal(err.(ecode.Codes), ecode.RequestErr) {
-				middleware.ResponseError(ctx, ecode.RequestErr, ctx.Errors.Last())
-				return
-			}
+		if code, ok := err.(ecode.Code); ok && code != ecode.ServerErr {
+			middleware.ResponseError(ctx, code, ctx.Errors.Last())
+			return
 		}
 		middleware.ResponseError(ctx, ecode.ServerErr, err)
 		return
@@ -10,20 +10,24 @@ import (
 	"mk-api/server/middleware"
 	"mk-api/server/model"
 	"mk-api/server/service"
+	"mk-api/server/static"
 	"mk-api/server/util"
+	"mk-api/server/util/calendar"
 )
 
 // packages 路由注册
 func PackageRegister(router *gin.RouterGroup) {
 	var (
-		packageModel      model.PackageModel     = model.NewPackageModel()
-		packageService    service.PackageService = service.NewPackageService(packageModel)
-		packageController PackageController      = NewPackageController(packageService)
+		packageModel        model.PackageModel          = model.NewPackageModel()
+		packageService      service.PackageService      = service.NewPackageService(packageModel)
+		availabilityService service.AvailabilityService = service.NewAvailabilityService(model.NewCapacityModel(), newCalendar())
+		packageController   PackageController           = NewPackageController(packageService, availabilityService)
 	)
 	router.GET("/pkg", packageController.ListPackage)
 	router.GET("/categories", packageController.ListCategory)
 	router.GET("/diseases", packageController.ListDisease)
 	router.GET("/pkg/:id", packageController.GetPackage)
+	router.GET("/pkg/:id/availability", packageController.ListAvailability)
 }
 
 type PackageController interface {
@@ -31,10 +35,12 @@ type PackageController interface {
 	GetPackage(ctx *gin.Context)
 	ListDisease(ctx *gin.Context)
 	ListCategory(ctx *gin.Context)
+	ListAvailability(ctx *gin.Context)
 }
 
 type packageController struct {
-	service service.PackageService
+	service             service.PackageService
+	availabilityService service.AvailabilityService
 }
 
 // DiseaseList godoc
@@ -135,8 +141,53 @@ func (c *packageController) ListPackage(ctx *gin.Context) {
 	}
 }
 
-func NewPackageController(service service.PackageService) PackageController {
+// PackageAvailability godoc
+// @Summary 获取套餐某月每天的剩余名额
+// @Description 获取套餐某月每天的剩余名额, 约满或不可预约的日期 available 为 false
+// @Tags packages
+// @Accept  json
+// @Produce  json
+// @Param token header string true "用户token"
+// @Param  id path int true "package id"
+// @Param month query string false "月份, 格式 2020-08, 不传默认当月"
+// @Success 200 {object} middleware.Response{data=[]dto.DayAvailability}
+// @Router /pkg/{id}/availability [get]
+func (c *packageController) ListAvailability(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	var input dto.ListAvailabilityInput
+	if err = util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	output, err := c.availabilityService.ListAvailability(ctx, id, &input)
+	if err != nil {
+		if code, ok := err.(ecode.Code); ok {
+			middleware.ResponseError(ctx, code, ctx.Errors.Last())
+			return
+		}
+		util.Log.Errorf("获取套餐剩余名额出错, pkg_id: [%d], err: [%s]", id, err.Error())
+		middleware.ResponseError(ctx, ecode.ServerErr, errors.New("服务器内部错误"))
+		return
+	}
+	middleware.ResponseSuccess(ctx, output)
+}
+
+// 法定节假日安排每年底更新 static/calendar/holidays.json
+func newCalendar() *calendar.Calendar {
+	c, err := calendar.Load(static.Path("calendar/holidays.json"))
+	if err != nil {
+		util.Log.Panicf("加载节假日安排失败, err: [%s]", err.Error())
+	}
+	return c
+}
+
+func NewPackageController(service service.PackageService, availabilityService service.AvailabilityService) PackageController {
 	return &packageController{
-		service: service,
+		service:             service,
+		availabilityService: availabilityService,
 	}
 }
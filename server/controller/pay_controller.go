@@ -2,48 +2,56 @@ package controller
 
 import (
 	"errors"
-	"fmt"
+	"sync"
 
 	"github.com/gin-gonic/gin"
-	payConf "github.com/silenceper/wechat/v2/pay/config"
-	"github.com/silenceper/wechat/v2/pay/notify"
 	"github.com/sirupsen/logrus"
 	"mk-api/library/ecode"
-	"mk-api/server/conf"
 	"mk-api/server/dto"
 	"mk-api/server/middleware"
 	"mk-api/server/model"
 	"mk-api/server/service"
 	"mk-api/server/util"
+	"mk-api/server/util/consts"
+	wcUtil "mk-api/server/util/wechat"
+	"mk-api/server/util/wxpay"
 )
 
 func PayRegister(router *gin.RouterGroup) {
 	var (
-		payModel   model.PayModel   = model.NewPayModel()
-		orderModel model.OrderModel = model.NewOrderModel()
-		cfg                         = &payConf.Config{
-			AppID:     conf.C.WeChat.AppID,
-			MchID:     conf.C.WeChat.PayMchID,
-			Key:       conf.C.WeChat.PayKey,
-			NotifyURL: conf.C.WeChat.PayNotifyURL,
-		}
-		ntf           *notify.Notify     = notify.NewNotify(cfg)
-		payService    service.PayService = service.NewPayService(ntf, payModel, orderModel)
-		payController PayController      = NewPayController(payService)
+		payModel      model.PayModel     = model.NewPayModel()
+		orderModel    model.OrderModel   = model.NewOrderModel()
+		stateMachine                     = service.NewOrderStateMachine(model.NewOrderStatusModel(), model.NewCapacityModel(), model.NewCouponModel(), model.NewCardModel())
+		payGateway                       = newPayGateway()
+		payService    service.PayService = service.NewPayService(payModel, orderModel, stateMachine, payGateway)
+		refundService                    = service.NewRefundService(payModel, orderModel, stateMachine, payGateway)
+		payController PayController      = NewPayController(payService, refundService, payGateway)
 	)
+	service.StartPayReconcile(payService)
+	service.StartRefundRetry(refundService)
 	router.POST("/wechat_callback", payController.WechatPayCallback)
+	router.POST("/refund_callback", payController.WechatRefundCallback)
 	router.GET("/status", middleware.MobileBoundRequired(), payController.CheckPayStatus)
-	router.POST("/scnd_pay", middleware.MobileBoundRequired(), payController.Launch2ndPay)
+	router.POST("/scnd_pay", middleware.MobileBoundRequired(), middleware.Idempotent(), payController.Launch2ndPay)
+	if _, ok := payGateway.(*wxpay.FakeGateway); ok {
+		util.Log.Warning("使用假微信支付, 仅用于本地开发和测试")
+		router.POST("/fake_pay", payController.FakePay)
+	}
 }
 
 type PayController interface {
 	WechatPayCallback(ctx *gin.Context)
+	WechatRefundCallback(ctx *gin.Context)
 	CheckPayStatus(ctx *gin.Context)
 	Launch2ndPay(ctx *gin.Context)
+	FakePay(ctx *gin.Context)
 }
 
 type payController struct {
-	service service.PayService
+	service       service.PayService
+	refundService service.RefundService
+	// 回复通知的格式与接口版本有关
+	gateway wxpay.Gateway
 }
 
 // CreateOrder godoc
@@ -53,6 +61,7 @@ type payController struct {
 // @Accept  json
 // @Produce  json
 // @Param token header string true "用户token"
+// @Param Idempotency-Key header string false "幂等键, 重试时带上同一个值, 24 小时内重复请求返回第一次成功的结果"
 // @Param body body dto.ResourceID true "创建订单的请求体"
 // @Success 200 {object} middleware.Response{data=dto.PostOrderOutput}
 // @Router /pay/scnd_pay [post]
@@ -73,23 +82,24 @@ func (c *payController) Launch2ndPay(ctx *gin.Context) {
 				middleware.ResponseError(ctx, ecode.RequestErr, ctx.Errors.Last())
 				return
 			}
-			if ecode.Equal(err.(ecode.Codes), ecode.NothingFound) {
+			if ecode.Equal(err.(ecode.Codes), consts.ResourceNotFound) {
 				util.Log.WithFields(logrus.Fields{"order_id": order.Id}).
 					Warningf("failed to launch a 2nd pay, err: [%s]", ctx.Errors.Last().Error())
-				middleware.ResponseError(ctx, ecode.NothingFound, errors.New("啥都木有"))
+				middleware.ResponseError(ctx, consts.ResourceNotFound, ctx.Errors.Last())
 				return
 			}
 		}
 		util.Log.WithFields(logrus.Fields{"order_id": order.Id}).
-			Errorf("failed to launch a 2nd pay, err: [%s]", ctx.Errors.Last().Error())
+			Errorf("failed to launch a 2nd pay, err: [%s]", err.Error())
 		middleware.ResponseError(ctx, ecode.ServerErr, errors.New("服务器错误"))
+		return
 	}
 	middleware.ResponseSuccess(ctx, cfg)
 }
 
 // CheckPayStatus godoc
 // @Summary 查询订单支付状态
-// @Description 前端轮询支付状态
+// @Description 前端轮询支付状态, 本地仍为待支付时会向微信查询支付结果
 // @Tags pay
 // @Accept  json
 // @Produce  json
@@ -107,6 +117,10 @@ func (c *payController) CheckPayStatus(ctx *gin.Context) {
 	}
 
 	status, err := c.service.CheckPayStatus(ctx, prepayId)
+	if code, ok := err.(ecode.Code); ok {
+		middleware.ResponseError(ctx, code, ctx.Errors.Last())
+		return
+	}
 	if err != nil {
 		util.Log.Errorf("查询支付状态出错，err: [%s]", err)
 		middleware.ResponseError(ctx, ecode.ServerErr, errors.New("内部服务器错误"))
@@ -118,18 +132,57 @@ func (c *payController) CheckPayStatus(ctx *gin.Context) {
 
 // 微信支付回调 Notify
 func (c *payController) WechatPayCallback(ctx *gin.Context) {
-	const AckSuccess = "<xml><return_code><![CDATA[SUCCESS]]></return_code><return_msg><![CDATA[OK]]></return_msg></xml>"
-	const AckFail = "<xml><return_code><![CDATA[FAIL]]></return_code></xml>"
-	// ctx.Writer.Header().Set("Content-Type", "application/xml; charset=utf-8")
-	if ok := c.service.WechatPayCallBack(ctx); ok {
-		_, _ = fmt.Fprint(ctx.Writer, AckSuccess)
+	c.gateway.WriteAck(ctx.Writer, c.service.WechatPayCallBack(ctx), "FAIL")
+}
+
+// 微信退款结果回调 Notify
+func (c *payController) WechatRefundCallback(ctx *gin.Context) {
+	c.gateway.WriteAck(ctx.Writer, c.refundService.WechatRefundCallBack(ctx), "FAIL")
+}
+
+// FakePay godoc
+// @Summary 模拟用户支付
+// @Description 仅在配置了假微信支付(pay_api_version 为 fake)时可用, 按指定结果支付订单, 支付成功时随后会收到支付通知
+// @Tags pay
+// @Accept  json
+// @Produce  json
+// @Param body body dto.FakePayInput true "商户订单号和支付结果"
+// @Success 200 {object} middleware.Response
+// @Router /pay/fake_pay [post]
+func (c *payController) FakePay(ctx *gin.Context) {
+	var input dto.FakePayInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
 		return
 	}
-	_, _ = fmt.Fprint(ctx.Writer, AckFail)
+	if err := c.gateway.(*wxpay.FakeGateway).Pay(input.OutTradeNo, input.Outcome); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	middleware.ResponseSuccess(ctx, nil)
+}
+
+var (
+	payGatewayOnce   sync.Once
+	sharedPayGateway wxpay.Gateway
+)
+
+// newPayGateway 所有路由共用一个客户端, 假支付的订单和 v3 的平台证书都保存在客户端中
+func newPayGateway() wxpay.Gateway {
+	payGatewayOnce.Do(func() {
+		gateway, err := wcUtil.NewPayGateway()
+		if err != nil {
+			util.Log.Panicf("创建微信支付商户平台客户端失败, err: [%s]", err.Error())
+		}
+		sharedPayGateway = gateway
+	})
+	return sharedPayGateway
 }
 
-func NewPayController(service service.PayService) PayController {
+func NewPayController(service service.PayService, refundService service.RefundService, gateway wxpay.Gateway) PayController {
 	return &payController{
-		service: service,
+		service:       service,
+		refundService: refundService,
+		gateway:       gateway,
 	}
 }
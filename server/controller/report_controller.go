@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"mk-api/library/ecode"
+	"mk-api/server/dto"
+	"mk-api/server/middleware"
+	"mk-api/server/model"
+	"mk-api/server/service"
+	"mk-api/server/util"
+)
+
+// ReportRegister 体检人的历次体检结果挂在 users 路由组下
+func ReportRegister(router *gin.RouterGroup, userRouter *gin.RouterGroup) {
+	var (
+		reportService    service.ReportService = service.NewReportService(model.NewReportModel(), model.NewExamineeModel(), model.NewPackageModel(), service.NewAuditService(model.NewAuditModel()))
+		reportController ReportController      = NewReportController(reportService)
+	)
+	router.GET("/", reportController.ListReport)
+	router.GET("/:id/url", reportController.GetReportUrl)
+	userRouter.GET("/examinees/:id/history", reportController.GetExamHistory)
+}
+
+type ReportController interface {
+	ListReport(ctx *gin.Context)
+	GetReportUrl(ctx *gin.Context)
+	GetExamHistory(ctx *gin.Context)
+}
+
+type reportController struct {
+	service service.ReportService
+}
+
+// ListReport godoc
+// @Summary 我的体检报告
+// @Description 用户自己的体检报告列表, 下载链接需通过 /reports/{id}/url 获取
+// @Tags reports
+// @Accept  json
+// @Produce  json
+// @Param token header string true "用户token"
+// @Param order_item_id query int false "订单项id, 不传返回全部报告"
+// @Success 200 {object} middleware.Response{data=[]dto.Report}
+// @Router /reports/ [get]
+func (c *reportController) ListReport(ctx *gin.Context) {
+	var input dto.ListReportInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	input.UserId = ctx.GetInt64("userId")
+	output, err := c.service.ListReports(ctx, &input)
+	if err != nil {
+		responseServiceError(ctx, err, "查询体检报告失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, output)
+}
+
+// GetReportUrl godoc
+// @Summary 获取体检报告下载链接
+// @Description 返回 10 分钟内有效的签名下载链接, 只能获取自己的报告
+// @Tags reports
+// @Accept  json
+// @Produce  json
+// @Param token header string true "用户token"
+// @Param id path int true "报告id"
+// @Success 200 {object} middleware.Response{data=dto.ReportUrlOutput}
+// @Router /reports/{id}/url [get]
+func (c *reportController) GetReportUrl(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, errors.New("参数id有误"))
+		return
+	}
+	output, err := c.service.GetReportUrl(ctx, id)
+	if err != nil {
+		responseServiceError(ctx, err, "获取报告下载链接失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, output)
+}
+
+// GetExamHistory godoc
+// @Summary 体检人的历次体检结果
+// @Description 常用体检人历次体检的结构化结果, 以及多次体检中重复出现的指标的变化趋势
+// @Tags examinees
+// @Accept  json
+// @Produce  json
+// @Param token header string true "用户token"
+// @Param id path int true "examinee id"
+// @Success 200 {object} middleware.Response{data=dto.ExamHistoryOutput}
+// @Router /users/examinees/{id}/history [get]
+func (c *reportController) GetExamHistory(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, errors.New("参数id有误"))
+		return
+	}
+	output, err := c.service.ExamHistory(ctx, id)
+	if err != nil {
+		responseServiceError(ctx, err, "查询历次体检结果失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, output)
+}
+
+func NewReportController(service service.ReportService) ReportController {
+	return &reportController{service: service}
+}
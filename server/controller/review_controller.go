@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"mk-api/library/ecode"
+	"mk-api/library/util/cos"
+	"mk-api/server/dto"
+	"mk-api/server/middleware"
+	"mk-api/server/model"
+	"mk-api/server/service"
+	"mk-api/server/util"
+)
+
+// 晒图大小上限
+const maxReviewImageSize = 5 << 20
+
+// ReviewRegister 评价需要绑定手机号, 套餐的评价列表和套餐详情一样只需要 token
+func ReviewRegister(router *gin.RouterGroup, pkgRouter *gin.RouterGroup) {
+	var (
+		stateMachine                           = service.NewOrderStateMachine(model.NewOrderStatusModel(), model.NewCapacityModel(), model.NewCouponModel(), model.NewCardModel())
+		reviewService    service.ReviewService = service.NewReviewService(model.NewReviewModel(), stateMachine, service.NewAuditService(model.NewAuditModel()))
+		reviewController ReviewController      = NewReviewController(reviewService)
+	)
+	router.POST("/", reviewController.PostReview)
+	router.GET("/", reviewController.ListReview)
+	router.POST("/images", reviewController.UploadReviewImage)
+	pkgRouter.GET("/pkg/:id/reviews", reviewController.ListPackageReview)
+}
+
+type ReviewController interface {
+	PostReview(ctx *gin.Context)
+	ListReview(ctx *gin.Context)
+	UploadReviewImage(ctx *gin.Context)
+	ListPackageReview(ctx *gin.Context)
+}
+
+type reviewController struct {
+	service service.ReviewService
+}
+
+// PostReview godoc
+// @Summary 评价套餐
+// @Description 体检日期当天起可以评价订单项, 每个订单项评价一次, 审核通过后展示在套餐详情页
+// @Tags reviews
+// @Accept  json
+// @Produce  json
+// @Param token header string true "用户token"
+// @Param body body dto.PostReviewInput true "评价的请求体"
+// @Success 200 {object} middleware.Response{data=dto.ResourceID}
+// @Router /reviews/ [post]
+func (c *reviewController) PostReview(ctx *gin.Context) {
+	var input dto.PostReviewInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	id, err := c.service.SubmitReview(ctx, &input)
+	if err != nil {
+		responseServiceError(ctx, err, "评价失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, dto.ResourceID{Id: id})
+}
+
+// ListReview godoc
+// @Summary 我的评价
+// @Description 用户自己的评价, 包括待审核和已驳回的
+// @Tags reviews
+// @Accept  json
+// @Produce  json
+// @Param token header string true "用户token"
+// @Param page_size query int false "每页多少条, 默认 20"
+// @Param page_no query int false "页码"
+// @Param status query int false "-1 全部(默认值) 0-待审核 1-已通过 2-已驳回"
+// @Success 200 {object} middleware.Response{data=dto.PaginateListOutput{list=[]dto.Review}}
+// @Router /reviews/ [get]
+func (c *reviewController) ListReview(ctx *gin.Context) {
+	var input dto.ListReviewInput
+	if err := util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	input.UserId = ctx.GetInt64("userId")
+	output, err := c.service.ListReviews(ctx, &input)
+	if err != nil {
+		responseServiceError(ctx, err, "查询评价失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, output)
+}
+
+// UploadReviewImage godoc
+// @Summary 上传晒图
+// @Description 上传评价的晒图, 返回图片地址, 提交评价时放入 images, 单张不超过 5M
+// @Tags reviews
+// @accept multipart/form-data
+// @Produce  application/json
+// @Param token header string true "用户token"
+// @Param image formData file true "晒图"
+// @Success 200 {object} middleware.Response{data=dto.UploadReviewImageOutput}
+// @Router /reviews/images [post]
+func (c *reviewController) UploadReviewImage(ctx *gin.Context) {
+	_, image, err := ctx.Request.FormFile("image")
+	if err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	if image.Size > maxReviewImageSize || !strings.HasPrefix(image.Header.Get("Content-Type"), "image/") {
+		middleware.ResponseError(ctx, ecode.RequestErr, errors.New("只能上传不超过5M的图片"))
+		return
+	}
+	err, fileUrl, _ := cos.Upload2Tx(image)
+	if err != nil {
+		util.Log.Errorf("上传晒图出错, err: [%s]", err.Error())
+		middleware.ResponseError(ctx, ecode.ServerErr, errors.New("上传晒图失败"))
+		return
+	}
+	middleware.ResponseSuccess(ctx, dto.UploadReviewImageOutput{Url: fileUrl})
+}
+
+// ListPackageReview godoc
+// @Summary 套餐的评价
+// @Description 套餐详情页的评价列表, 只包括审核通过的评价, 昵称打码
+// @Tags packages
+// @Accept  json
+// @Produce  json
+// @Param token header string true "用户token"
+// @Param id path int true "套餐的id"
+// @Param page_size query int false "每页多少条, 默认 20"
+// @Param page_no query int false "页码"
+// @Success 200 {object} middleware.Response{data=dto.PaginateListOutput{list=[]dto.Review}}
+// @Router /pkg/{id}/reviews [get]
+func (c *reviewController) ListPackageReview(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, errors.New("参数id有误"))
+		return
+	}
+	var input dto.ListReviewInput
+	if err = util.ParseRequest(ctx, &input); err != nil {
+		middleware.ResponseError(ctx, ecode.RequestErr, err)
+		return
+	}
+	input.PackageId = id
+	output, err := c.service.ListPackageReviews(ctx, &input)
+	if err != nil {
+		responseServiceError(ctx, err, "查询套餐评价失败")
+		return
+	}
+	middleware.ResponseSuccess(ctx, output)
+}
+
+func NewReviewController(service service.ReviewService) ReviewController {
+	return &reviewController{service: service}
+}
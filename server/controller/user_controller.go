@@ -22,7 +22,7 @@ func UserRegister(router, locRouter *gin.RouterGroup) {
 		addrModel      model.UserAddrModel = model.NewUserAddrModel()
 		regionModel    model.RegionModel   = model.NewRegionModel()
 		examineeModel  model.ExamineeModel = model.NewExamineeModel()
-		userService    service.UserService = service.NewUserService(userModel, addrModel, regionModel, examineeModel)
+		userService    service.UserService = service.NewUserService(userModel, addrModel, regionModel, examineeModel, service.NewAuditService(model.NewAuditModel()))
 		userController UserController      = NewUserController(userService)
 	)
 	router.GET("/profile", userController.GETUserProfile)
@@ -187,6 +187,10 @@ func (c *userController) PutExaminee(ctx *gin.Context) {
 	}
 
 	err = c.service.ModifyExaminee(ctx, id, &input)
+	if code, ok := err.(ecode.Code); ok {
+		middleware.ResponseError(ctx, code, ctx.Errors.Last())
+		return
+	}
 	if err != nil {
 		util.Log.Errorf("修改用户收件地址失败, id: [%d] 参数: [%v], err: [%s]", id, input, err.Error())
 		middleware.ResponseError(ctx, ecode.ServerErr, err)
@@ -212,7 +216,11 @@ func (c *userController) DelExaminee(ctx *gin.Context) {
 		return
 	}
 	userId := ctx.GetInt64("userId")
-	err = c.service.RemoveExaminee(id, userId)
+	err = c.service.RemoveExaminee(ctx, id, userId)
+	if code, ok := err.(ecode.Code); ok {
+		middleware.ResponseError(ctx, code, ctx.Errors.Last())
+		return
+	}
 	if err != nil {
 		util.Log.Errorf("根据id删除examinee失败, err: [%s]", err.Error())
 		middleware.ResponseError(ctx, ecode.ServerErr, errors.New("服务器内部错误"))
@@ -239,7 +247,7 @@ func (c *userController) PostExaminee(ctx *gin.Context) {
 		return
 	}
 	userId := ctx.GetInt64("userId")
-	id, err := c.service.SaveExaminee(userId, &input)
+	id, err := c.service.SaveExaminee(ctx, userId, &input)
 	if err != nil {
 		util.Log.WithFields(logrus.Fields{"user_id": userId}).Errorf("创建常用体检人出错, err: [%s]", err.Error())
 		middleware.ResponseError(ctx, ecode.ServerErr, errors.New("服务器内部错误"))
@@ -328,7 +336,7 @@ func (c *userController) PostUserAddr(ctx *gin.Context) {
 	}
 	addr.UserId = ctx.GetInt64("userId")
 
-	id, err := c.service.SaveAddr(&addr)
+	id, err := c.service.SaveAddr(ctx, &addr)
 	if err != nil {
 		util.Log.Errorf("创建用户收件地址失败, 参数: [%v], err: [%s]", addr, err.Error())
 		middleware.ResponseError(ctx, ecode.ServerErr, err)
@@ -353,7 +361,11 @@ func (c *userController) GetUserAddr(ctx *gin.Context) {
 		middleware.ResponseError(ctx, ecode.RequestErr, err)
 		return
 	}
-	addr, err := c.service.RetrieveAddr(id)
+	addr, err := c.service.RetrieveAddr(ctx, id)
+	if code, ok := err.(ecode.Code); ok {
+		middleware.ResponseError(ctx, code, ctx.Errors.Last())
+		return
+	}
 	if err != nil {
 		util.Log.Errorf("根据id获取addr失败, err: [%s]", err.Error())
 		middleware.ResponseError(ctx, ecode.ServerErr, errors.New("服务器内部错误"))
@@ -378,7 +390,11 @@ func (c *userController) DelUserAddr(ctx *gin.Context) {
 		middleware.ResponseError(ctx, ecode.RequestErr, err)
 		return
 	}
-	err = c.service.DeleteAddr(id)
+	err = c.service.DeleteAddr(ctx, id)
+	if code, ok := err.(ecode.Code); ok {
+		middleware.ResponseError(ctx, code, ctx.Errors.Last())
+		return
+	}
 	if err != nil {
 		util.Log.Errorf("根据id删除addr失败, err: [%s]", err.Error())
 		middleware.ResponseError(ctx, ecode.ServerErr, errors.New("服务器内部错误"))
@@ -413,6 +429,10 @@ func (c *userController) PutUserAddr(ctx *gin.Context) {
 		return
 	}
 	err = c.service.UpdateUserAddr(ctx, id, &addr)
+	if code, ok := err.(ecode.Code); ok {
+		middleware.ResponseError(ctx, code, ctx.Errors.Last())
+		return
+	}
 	if err != nil {
 		util.Log.Errorf("修改用户收件地址失败, id: [%d] 参数: [%v], err: [%s]", id, addr, err.Error())
 		middleware.ResponseError(ctx, ecode.ServerErr, err)
@@ -0,0 +1,87 @@
+package dao
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// 只有持有者才能释放, 避免锁过期后误删他人重新获得的锁
+var unlockScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+const lockRetryInterval = time.Millisecond * 50
+
+// Lock 基于 redis 的分布式锁, 多个实例间互斥. 加锁时写入随机的 owner token,
+// 持有者异常退出时锁在 ttl 后自动释放
+type Lock struct {
+	conn  *redis.Pool
+	key   string
+	token string
+	ttl   time.Duration
+}
+
+// NewLock ttl 应大于持有锁期间业务处理的最长耗时
+func (r *Redis) NewLock(key string, ttl time.Duration) *Lock {
+	return &Lock{conn: r.conn, key: key, ttl: ttl}
+}
+
+// Acquire 在 wait 时间内重试加锁, wait 为 0 时只尝试一次. 锁被他人持有直到超时返回 false
+func (l *Lock) Acquire(wait time.Duration) (bool, error) {
+	token, err := newLockToken()
+	if err != nil {
+		return false, err
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		ok, err := l.tryAcquire(token)
+		if err != nil || ok {
+			return ok, err
+		}
+		if time.Now().Add(lockRetryInterval).After(deadline) {
+			return false, nil
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+func (l *Lock) tryAcquire(token string) (bool, error) {
+	conn := l.conn.Get()
+	defer conn.Close()
+
+	_, err := redis.String(conn.Do("SET", l.key, token, "PX", int64(l.ttl/time.Millisecond), "NX"))
+	if err == redis.ErrNil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	l.token = token
+	return true, nil
+}
+
+// Release 释放锁, 返回锁是否仍由自己持有. 为 false 说明已过期, 期间的操作可能与他人并发
+func (l *Lock) Release() (bool, error) {
+	if l.token == "" {
+		return false, nil
+	}
+	conn := l.conn.Get()
+	defer conn.Close()
+
+	n, err := redis.Int(unlockScript.Do(conn, l.key, l.token))
+	l.token = ""
+	return n == 1, err
+}
+
+func newLockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -74,7 +74,6 @@ func NewRedisPool(conf *conf.RedisConfig) *redis.Pool {
 					c.Close()
 					fmt.Printf("NewRedisPool failed, params is [%v], err is [%s]", conf, err.Error())
 					panic("failed to create redis pool !")
-					return nil, err
 				}
 			}
 			c.Do("SELECT", conf.Db)
@@ -143,6 +142,23 @@ func (r *Redis) SetEx(key string, val interface{}, timeout time.Duration) (err e
 	return
 }
 
+// SetNxEx key 不存在时才设置, 返回是否设置成功
+func (r *Redis) SetNxEx(key string, val interface{}, timeout time.Duration) (ok bool, err error) {
+	conn := r.conn.Get()
+	defer conn.Close()
+
+	var data []byte
+	if data, err = json.Marshal(val); err != nil {
+		return
+	}
+
+	_, err = redis.String(conn.Do("SET", key, data, "EX", int64(timeout/time.Second), "NX"))
+	if err == redis.ErrNil {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 // IsExist 判断key是否存在
 func (r *Redis) Exists(key string) bool {
 	conn := r.conn.Get()
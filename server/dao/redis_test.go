@@ -65,3 +65,30 @@ func TestEncapsulatedRedis(t *testing.T) {
 	res := rd.HGet("xuemei", "lvl")
 	t.Logf("Hget Done! the val of xuemei cup is %v", res.(float64))
 }
+
+func TestLock(t *testing.T) {
+	rd := NewRedis(redisCfg)
+	key := "string.LOCK.TEST"
+
+	first := rd.NewLock(key, time.Second*5)
+	if ok, err := first.Acquire(0); err != nil || !ok {
+		t.Fatalf("first acquire: ok = %v, err = %v", ok, err)
+	}
+
+	second := rd.NewLock(key, time.Second*5)
+	if ok, err := second.Acquire(time.Millisecond * 200); err != nil || ok {
+		t.Fatalf("second acquire while held: ok = %v, err = %v", ok, err)
+	}
+	// 未持有锁时释放不能删除他人的锁
+	if held, err := second.Release(); err != nil || held {
+		t.Fatalf("second release: held = %v, err = %v", held, err)
+	}
+
+	if held, err := first.Release(); err != nil || !held {
+		t.Fatalf("first release: held = %v, err = %v", held, err)
+	}
+	if ok, err := second.Acquire(0); err != nil || !ok {
+		t.Fatalf("second acquire after release: ok = %v, err = %v", ok, err)
+	}
+	_, _ = second.Release()
+}
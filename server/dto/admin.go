@@ -0,0 +1,132 @@
+package dto
+
+import (
+	"mk-api/server/util/money"
+	"mk-api/server/util/rbac"
+)
+
+type Staff struct {
+	Id       int64  `json:"id" db:"id"`
+	Username string `json:"username" db:"username"`
+	Password string `json:"-" db:"password"`
+	Name     string `json:"name" db:"name"`
+	// 角色 1-客服 2-财务 3-机构对接人 4-超级管理员
+	Role rbac.Role `json:"role" db:"role"`
+	// 机构对接人所对接的体检机构
+	HospitalId int64 `json:"hospital_id" db:"hospital_id"`
+	// 1-正常 2-已停用
+	Status int8 `json:"status" db:"status"`
+}
+
+type StaffLoginInput struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type StaffLoginOutput struct {
+	Token string `json:"token"`
+	// token 有效期, 单位秒
+	ExpiresIn int64  `json:"expires_in"`
+	Staff     *Staff `json:"staff"`
+}
+
+type AdminListOrderInput struct {
+	// 页码, 不传默认第一页
+	PageNo int64 `json:"page_no,default=1" form:"page_no,default=1" binding:"min=1"`
+	// 每页条数, 不传默认 20
+	PageSize int64 `json:"page_size,default=20" form:"page_size,default=20" binding:"min=1,max=100"`
+	// 订单号
+	OutTradeNo string `json:"out_trade_no" form:"out_trade_no" db:"out_trade_no"`
+	// 下单人手机号
+	Mobile string `json:"mobile" form:"mobile" db:"mobile"`
+	// 订单状态 -1-全部，0-待付款，2-已付款 3-已退款 4-已关闭 5-待评价
+	Status int8 `json:"status" form:"status,default=-1" db:"status" binding:"min=-1,max=5"`
+	// 退款申请 -1-全部 0-未申请 1-待审核 2-已同意 3-已拒绝
+	RefundStatus int8 `json:"refund_status" form:"refund_status,default=-1" db:"refund_status" binding:"min=-1,max=3"`
+	// 下单时间范围, 时间戳
+	StartTime int64 `json:"start_time" form:"start_time" db:"start_time"`
+	EndTime   int64 `json:"end_time" form:"end_time" db:"end_time"`
+	// 只查询包含该机构套餐的订单, 由登录的机构对接人决定
+	HospitalId int64 `json:"-" db:"hospital_id"`
+	Offset     int64 `json:"-" db:"offset"`
+	Limit      int64 `json:"-" db:"limit"`
+}
+
+type AdminListOrderOutputEle struct {
+	OrderId      int64     `json:"order_id" db:"order_id"`
+	OutTradeNo   string    `json:"out_trade_no" db:"out_trade_no"`
+	UserId       int64     `json:"user_id" db:"user_id"`
+	Mobile       string    `json:"mobile" db:"mobile"`
+	Status       int8      `json:"status" db:"status"`
+	RefundStatus int8      `json:"refund_status" db:"refund_status"`
+	Amount       money.Fen `json:"amount" db:"amount"`
+	// 订单项数量
+	ItemCount  int64 `json:"item_count" db:"item_count"`
+	CreateTime int64 `json:"create_time" db:"create_time"`
+}
+
+// 订单中只有运营人员才能看到的信息
+type AdminOrderInfo struct {
+	UserId             int64  `json:"user_id" db:"user_id"`
+	OpenId             string `json:"open_id" db:"open_id"`
+	CancelReasonId     int64  `json:"cancel_reason_id" db:"cancel_reason_id"`
+	RefundStatus       int8   `json:"refund_status" db:"refund_status"`
+	RefundReasonId     int64  `json:"refund_reason_id" db:"refund_reason_id"`
+	RefundReasonRemark string `json:"refund_reason_remark" db:"refund_reason_remark"`
+	RefundAuditRemark  string `json:"refund_audit_remark" db:"refund_audit_remark"`
+	CreateTime         int64  `json:"create_time" db:"create_time"`
+}
+
+type AdminOrderDetail struct {
+	*RetrieveOrderOutput
+	*AdminOrderInfo
+	// 支付及退款流水
+	Bills []*TradeBill `json:"bills"`
+	// 内部备注
+	Notes []*OrderNote `json:"notes"`
+}
+
+type OrderNote struct {
+	Id        int64  `json:"id" db:"id"`
+	OrderId   int64  `json:"order_id" db:"order_id"`
+	StaffId   int64  `json:"staff_id" db:"staff_id"`
+	StaffName string `json:"staff_name" db:"staff_name"`
+	Content   string `json:"content" db:"content"`
+	// 创建时间
+	CreateTime int64 `json:"create_time" db:"create_time"`
+}
+
+type PostOrderNoteInput struct {
+	OrderId int64  `json:"order_id" db:"order_id" binding:"required"`
+	Content string `json:"content" db:"content" binding:"required,max=1024"`
+}
+
+type ConfirmAppointmentInput struct {
+	// 要确认预约的 order_item 的ID
+	OrderItemId int64 `json:"order_item_id" binding:"required"`
+	// 体检机构地址, 推送给用户
+	Address string `json:"address" binding:"required"`
+}
+
+// 确认预约推送给用户的信息
+type AppointmentInfo struct {
+	OrderId      int64  `db:"order_id"`
+	OpenId       string `db:"open_id"`
+	ExamineDate  int64  `db:"examine_date"`
+	HospitalId   int64  `db:"hospital_id"`
+	HospitalName string `db:"hospital_name"`
+}
+
+type ApproveRefundInput struct {
+	OrderId int64 `json:"order_id" binding:"required"`
+	// 退款金额, 单位分, 不传按改退规则计算
+	RefundFee money.Fen `json:"refund_fee" binding:"min=0"`
+	// 审核意见
+	Remark string `json:"remark" binding:"max=255"`
+}
+
+type RejectRefundInput struct {
+	OrderId int64 `json:"order_id" binding:"required"`
+	// 拒绝原因
+	Remark string `json:"remark" binding:"required,max=255"`
+}
@@ -0,0 +1,39 @@
+package dto
+
+type AuditLog struct {
+	Id int64 `json:"id" db:"id"`
+	// 操作人类型 1-用户 2-运营人员 3-系统 4-微信支付回调
+	ActorType int8  `json:"actor_type" db:"actor_type"`
+	ActorId   int64 `json:"actor_id" db:"actor_id"`
+	// 操作, 如 examinee.update
+	Action      string `json:"action" db:"action"`
+	TargetTable string `json:"target_table" db:"target_table"`
+	TargetId    int64  `json:"target_id" db:"target_id"`
+	// 修改前后有变化的字段, json, 新建时 before 为空, 删除时 after 为空
+	Before    string `json:"before" db:"before_data"`
+	After     string `json:"after" db:"after_data"`
+	Ip        string `json:"ip" db:"ip"`
+	UserAgent string `json:"user_agent" db:"user_agent"`
+	// 请求的方法和路由, 如 PUT /users/examinees/:id
+	Request    string `json:"request" db:"request"`
+	CreateTime int64  `json:"create_time" db:"create_time"`
+}
+
+type ListAuditLogInput struct {
+	// 页码, 不传默认第一页
+	PageNo int64 `json:"page_no,default=1" form:"page_no,default=1" binding:"min=1"`
+	// 每页条数, 不传默认 20
+	PageSize int64 `json:"page_size,default=20" form:"page_size,default=20" binding:"min=1,max=100"`
+	// 操作人类型 0-全部 1-用户 2-运营人员 3-系统 4-微信支付回调
+	ActorType int8  `json:"actor_type" form:"actor_type" db:"actor_type" binding:"min=0,max=4"`
+	ActorId   int64 `json:"actor_id" form:"actor_id" db:"actor_id"`
+	// 操作, 如 examinee.update
+	Action      string `json:"action" form:"action" db:"action"`
+	TargetTable string `json:"target_table" form:"target_table" db:"target_table"`
+	TargetId    int64  `json:"target_id" form:"target_id" db:"target_id"`
+	// 操作时间范围, 时间戳
+	StartTime int64 `json:"start_time" form:"start_time" db:"start_time"`
+	EndTime   int64 `json:"end_time" form:"end_time" db:"end_time"`
+	Offset    int64 `json:"-" db:"offset"`
+	Limit     int64 `json:"-" db:"limit"`
+}
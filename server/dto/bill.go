@@ -1,21 +1,27 @@
 package dto
 
+import "mk-api/server/util/money"
+
 type TradeBill struct {
-	Id            int64  `json:"id" db:"id"`
-	TransactionId string `json:"transaction_id" db:"transaction_id"`
-	OrderId       int64  `json:"order_id" db:"order_id"`
-	OutTradeNo    string `json:"out_trade_no" db:"out_trade_no"`
-	PrepayId      string `json:"prepay_id" db:"prepay_id"`
-	NonceStr      string `json:"nonce_str" db:"nonce_str"`
-	TotalFee      int64  `json:"total_fee" db:"total_fee"`
-	FeeType       int8   `json:"fee_type" db:"fee_type"`
-	Status        int8   `json:"status" db:"status"`
-	TransType     int8   `json:"trans_type" db:"trans_type"`
-	TimeStart     int64  `json:"time_start" db:"time_start"`
-	TimeExpire    int64  `json:"time_expire" db:"time_expire"`
-	TimeEnd       int64  `json:"time_end" db:"time_end"`
-	CreateTime    int64  `json:"create_time" db:"create_time"`
-	UpdateTime    int64  `json:"update_time" db:"update_time"`
+	Id            int64     `json:"id" db:"id"`
+	TransactionId string    `json:"transaction_id" db:"transaction_id"`
+	OrderId       int64     `json:"order_id" db:"order_id"`
+	OutTradeNo    string    `json:"out_trade_no" db:"out_trade_no"`
+	PrepayId      string    `json:"prepay_id" db:"prepay_id"`
+	NonceStr      string    `json:"nonce_str" db:"nonce_str"`
+	TotalFee      money.Fen `json:"total_fee" db:"total_fee"`
+	FeeType       int8      `json:"fee_type" db:"fee_type"`
+	Status        int8      `json:"status" db:"status"`
+	TransType     int8      `json:"trans_type" db:"trans_type"`
+	TimeStart     int64     `json:"time_start" db:"time_start"`
+	TimeExpire    int64     `json:"time_expire" db:"time_expire"`
+	TimeEnd       int64     `json:"time_end" db:"time_end"`
+	CreateTime    int64     `json:"create_time" db:"create_time"`
+	UpdateTime    int64     `json:"update_time" db:"update_time"`
+	// 退款流水才有, 商户退款单号
+	OutRefundNo string `json:"out_refund_no" db:"out_refund_no"`
+	// 退款流水才有, 微信退款单号
+	RefundId string `json:"refund_id" db:"refund_id"`
 }
 
 type UpdateBillInput struct {
@@ -26,6 +32,22 @@ type UpdateBillInput struct {
 	Status        int8   `json:"status" db:"status"`
 }
 
+// PaidTrade 支付成功的交易, 来自微信支付回调或主动查询
+type PaidTrade struct {
+	OutTradeNo    string    `json:"out_trade_no"`
+	TransactionId string    `json:"transaction_id"`
+	TotalFee      money.Fen `json:"total_fee"`
+	// 支付完成时间戳
+	TimeEnd int64 `json:"time_end"`
+}
+
+// FakePayInput 使用假微信支付时模拟用户支付
+type FakePayInput struct {
+	OutTradeNo string `json:"out_trade_no" binding:"required"`
+	// 支付结果 success-成功 fail-失败 late_notify-成功但通知很久之后才到 no_notify-成功但通知丢失
+	Outcome string `json:"outcome" binding:"required,oneof=success fail late_notify no_notify"`
+}
+
 type CheckPayStatusOutput struct {
 	// 支付状态 0-待支付 2-支付成功 4-订单已关闭
 	Status int8 `json:"status"`
@@ -37,3 +59,14 @@ type OrderPayStatus struct {
 	Status     int8   `json:"status" db:"status"`
 	TimeExpire int64  `json:"time_expire" db:"time_expire"`
 }
+
+type LaunchRefundInput struct {
+	OrderId int64 `json:"order_id"`
+	// 退款金额, 单位分, 0 表示全额退款
+	RefundFee money.Fen `json:"refund_fee"`
+	// 退款原因, 会出现在用户收到的退款消息中
+	Reason string `json:"reason"`
+	// 操作人
+	ActorType int8  `json:"actor_type"`
+	ActorId   int64 `json:"actor_id"`
+}
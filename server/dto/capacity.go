@@ -0,0 +1,52 @@
+package dto
+
+// 套餐所属机构及两者的每日名额, 0 表示不限
+type PackageCapacity struct {
+	PackageId        int64 `json:"pkg_id" db:"pkg_id"`
+	HospitalId       int64 `json:"hospital_id" db:"hospital_id"`
+	HospitalCapacity int64 `json:"hospital_capacity" db:"hospital_capacity"`
+	PackageCapacity  int64 `json:"pkg_capacity" db:"pkg_capacity"`
+	// 机构每周的休息日, 如 "0,6"
+	ClosedWeekdays string `json:"closed_weekdays" db:"closed_weekdays"`
+}
+
+// 某天某套餐要占用或释放的名额
+type CapacitySlot struct {
+	Capacity *PackageCapacity
+	// 体检日期, 当天零点的时间戳
+	ExamineDate int64
+	Count       int64
+}
+
+// 某天的已预约人数, PackageId 为 0 的是机构合计
+type CapacityUsage struct {
+	PackageId   int64 `json:"pkg_id" db:"pkg_id"`
+	ExamineDate int64 `json:"examine_date" db:"examine_date"`
+	Booked      int64 `json:"booked" db:"booked"`
+}
+
+// 订单占用的名额
+type OrderCapacityUsage struct {
+	HospitalId  int64 `db:"hospital_id"`
+	PackageId   int64 `db:"pkg_id"`
+	ExamineDate int64 `db:"examine_date"`
+	Count       int64 `db:"count"`
+}
+
+type ListAvailabilityInput struct {
+	// 月份, 格式 2020-08, 不传默认当月
+	Month string `json:"month" form:"month"`
+}
+
+type DayAvailability struct {
+	// 日期, 当天零点的时间戳
+	Date int64 `json:"date"`
+	// 机构当天是否营业
+	Open bool `json:"open"`
+	// 不营业的原因, 如 "国庆节"、"机构休息日"
+	Remark string `json:"remark"`
+	// 剩余名额, -1 表示不限
+	Remaining int64 `json:"remaining"`
+	// 是否可以预约
+	Available bool `json:"available"`
+}
@@ -0,0 +1,75 @@
+package dto
+
+import (
+	"mk-api/server/util/card"
+	"mk-api/server/util/money"
+)
+
+type CardBatch struct {
+	Id   int64  `json:"id" db:"id"`
+	Name string `json:"name" db:"name"`
+	// 购买的企业客户
+	Company string `json:"company" db:"company"`
+	card.Rule
+	Quantity int64 `json:"quantity" db:"quantity"`
+	// 已使用的卡数量
+	Redeemed int64 `json:"redeemed" db:"redeemed"`
+	// 有效期, 时间戳
+	ValidStart int64 `json:"valid_start" db:"valid_start"`
+	ValidEnd   int64 `json:"valid_end" db:"valid_end"`
+	// 1-正常 2-已作废
+	Status int8 `json:"status" db:"status"`
+	// 创建批次的运营人员
+	StaffId    int64 `json:"staff_id" db:"staff_id"`
+	CreateTime int64 `json:"create_time" db:"create_time"`
+}
+
+type Card struct {
+	Id      int64  `json:"id" db:"id"`
+	BatchId int64  `json:"batch_id" db:"batch_id"`
+	Code    string `json:"code" db:"code"`
+	// 0-未使用 1-已使用 2-已作废
+	Status     int8  `json:"status" db:"status"`
+	UserId     int64 `json:"user_id" db:"user_id"`
+	OrderId    int64 `json:"order_id" db:"order_id"`
+	RedeemTime int64 `json:"redeem_time" db:"redeem_time"`
+}
+
+// 下单时按卡号查出的体检卡及其批次的抵扣规则
+type RedeemableCard struct {
+	Id     int64 `db:"id"`
+	Status int8  `db:"status"`
+	card.Rule
+	ValidStart  int64 `db:"valid_start"`
+	ValidEnd    int64 `db:"valid_end"`
+	BatchStatus int8  `db:"batch_status"`
+}
+
+type PostCardBatchInput struct {
+	Name    string `json:"name" binding:"required,max=64"`
+	Company string `json:"company" binding:"max=64"`
+	// 1-套餐卡 2-储值卡
+	Type int8 `json:"type" binding:"required,oneof=1 2"`
+	// 套餐卡对应的套餐id
+	PackageId int64 `json:"pkg_id" binding:"min=0"`
+	// 储值卡面值, 单位分
+	Value money.Fen `json:"value" binding:"min=0"`
+	// 卡数量, 单批最多 10000 张
+	Quantity int64 `json:"quantity" binding:"required,min=1,max=10000"`
+	// 有效期, 时间戳
+	ValidStart int64 `json:"valid_start" binding:"required"`
+	ValidEnd   int64 `json:"valid_end" binding:"required,gtfield=ValidStart"`
+}
+
+type ListCardBatchInput struct {
+	// 页码, 不传默认第一页
+	PageNo int64 `json:"page_no,default=1" form:"page_no,default=1" binding:"min=1"`
+	// 每页条数, 不传默认 20
+	PageSize int64 `json:"page_size,default=20" form:"page_size,default=20" binding:"min=1,max=100"`
+	// 购买的企业客户, 模糊匹配
+	Company string `json:"company" form:"company"`
+}
+
+type VoidCardBatchInput struct {
+	BatchId int64 `json:"batch_id" binding:"required"`
+}
@@ -1,5 +1,7 @@
 package dto
 
+import "mk-api/server/util/money"
+
 type GetCartOutputElem struct {
 	// 购物车条目id
 	Id int64 `json:"id" db:"id"`
@@ -18,7 +20,7 @@ type GetCartOutputElem struct {
 	// 套餐数量
 	PackageCount int64 `json:"pkg_count" db:"pkg_count"`
 	// 套餐单价
-	PackagePrice float64 `json:"pkg_price" db:"pkg_price"`
+	PackagePrice money.Fen `json:"pkg_price" db:"pkg_price"`
 	// 更新时间
 	UpdateTime int64 `json:"update_time" db:"update_time"`
 }
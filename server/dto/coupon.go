@@ -0,0 +1,78 @@
+package dto
+
+import (
+	"mk-api/server/util/coupon"
+	"mk-api/server/util/money"
+)
+
+type Coupon struct {
+	Id   int64  `json:"id" db:"id"`
+	Name string `json:"name" db:"name"`
+	// 领取用的优惠码
+	Code string `json:"code" db:"code"`
+	coupon.Rule
+	// 有效期, 时间戳
+	ValidStart int64 `json:"valid_start" db:"valid_start"`
+	ValidEnd   int64 `json:"valid_end" db:"valid_end"`
+	// 发放总量, 0 表示不限
+	Total   int64 `json:"total" db:"total"`
+	Claimed int64 `json:"claimed" db:"claimed"`
+	// 每人限领, 0 表示不限
+	PerUserLimit int64 `json:"per_user_limit" db:"per_user_limit"`
+	// 1-启用 2-停用
+	Status     int8  `json:"status" db:"status"`
+	CreateTime int64 `json:"create_time" db:"create_time"`
+}
+
+type UserCoupon struct {
+	Id       int64 `json:"id" db:"id"`
+	CouponId int64 `json:"coupon_id" db:"coupon_id"`
+	UserId   int64 `json:"-" db:"user_id"`
+	// 0-未使用 1-已使用
+	Status int8 `json:"status" db:"status"`
+	// 使用该券的订单
+	OrderId   int64  `json:"order_id" db:"order_id"`
+	ClaimTime int64  `json:"claim_time" db:"claim_time"`
+	UseTime   int64  `json:"use_time" db:"use_time"`
+	Name      string `json:"name" db:"name"`
+	coupon.Rule
+	ValidStart int64 `json:"valid_start" db:"valid_start"`
+	ValidEnd   int64 `json:"valid_end" db:"valid_end"`
+	// 优惠券本身的状态 1-启用 2-停用
+	CouponStatus int8 `json:"coupon_status" db:"coupon_status"`
+}
+
+type ClaimCouponInput struct {
+	// 优惠码
+	Code string `json:"code" binding:"required,max=32"`
+}
+
+type ListUserCouponInput struct {
+	// -1-全部 0-未使用 1-已使用
+	Status int8 `json:"status" form:"status,default=-1" binding:"min=-1,max=1"`
+}
+
+type PostCouponInput struct {
+	Name string `json:"name" binding:"required,max=64"`
+	Code string `json:"code" binding:"required,max=32"`
+	// 1-满减券 2-折扣券
+	Type int8 `json:"type" binding:"required,oneof=1 2"`
+	// 满减券的减免金额, 单位分
+	Amount money.Fen `json:"amount" binding:"min=0"`
+	// 折扣券的减免百分比
+	Rate int64 `json:"rate" binding:"min=0,max=99"`
+	// 适用套餐满多少可用, 单位分, 0 为无门槛
+	Threshold money.Fen `json:"threshold" binding:"min=0"`
+	// 折扣券最多减免, 单位分, 0 为不限
+	MaxDiscount money.Fen `json:"max_discount" binding:"min=0"`
+	// 0-全部套餐 1-指定套餐 2-指定体检机构
+	ScopeType int8  `json:"scope_type" binding:"min=0,max=2"`
+	ScopeId   int64 `json:"scope_id" binding:"min=0"`
+	// 有效期, 时间戳
+	ValidStart int64 `json:"valid_start" binding:"required"`
+	ValidEnd   int64 `json:"valid_end" binding:"required,gtfield=ValidStart"`
+	// 发放总量, 0 为不限
+	Total int64 `json:"total" binding:"min=0"`
+	// 每人限领, 0 为不限
+	PerUserLimit int64 `json:"per_user_limit" binding:"min=0"`
+}
@@ -0,0 +1,70 @@
+package dto
+
+import "mk-api/server/util/money"
+
+type Invoice struct {
+	Id         int64  `json:"id" db:"id"`
+	OrderId    int64  `json:"order_id" db:"order_id"`
+	OutTradeNo string `json:"out_trade_no" db:"out_trade_no"`
+	UserId     int64  `json:"user_id" db:"user_id"`
+	// 推送开票通知用
+	OpenId string `json:"-" db:"open_id"`
+	// 抬头类型 1-个人 2-企业
+	TitleType int8   `json:"title_type" db:"title_type"`
+	Title     string `json:"title" db:"title"`
+	// 纳税人识别号
+	TaxNo string `json:"tax_no" db:"tax_no"`
+	Email string `json:"email" db:"email"`
+	// 开票金额, 即订单实付金额, 单位分
+	Amount money.Fen `json:"amount" db:"amount"`
+	// 0-待开具 1-已开具 2-已驳回
+	Status int8 `json:"status" db:"status"`
+	// 电子发票 pdf 的地址, 已开具时有值
+	PdfUrl string `json:"pdf_url" db:"pdf_url"`
+	// 驳回原因
+	Remark     string `json:"remark" db:"remark"`
+	StaffId    int64  `json:"staff_id" db:"staff_id"`
+	IssueTime  int64  `json:"issue_time" db:"issue_time"`
+	CreateTime int64  `json:"create_time" db:"create_time"`
+	UpdateTime int64  `json:"update_time" db:"update_time"`
+}
+
+// 申请发票时校验的订单信息
+type InvoicableOrder struct {
+	Id           int64     `db:"id"`
+	Status       int8      `db:"status"`
+	RefundStatus int8      `db:"refund_status"`
+	Amount       money.Fen `db:"amount"`
+}
+
+type PostInvoiceInput struct {
+	OrderId int64 `json:"order_id" binding:"required"`
+	// 抬头类型 1-个人 2-企业
+	TitleType int8   `json:"title_type" binding:"required,oneof=1 2"`
+	Title     string `json:"title" binding:"required,max=128"`
+	// 纳税人识别号, 企业抬头必填
+	TaxNo string `json:"tax_no" binding:"omitempty,checkTaxNo"`
+	// 接收电子发票的邮箱
+	Email string `json:"email" binding:"required,email,max=128"`
+}
+
+type ListInvoiceInput struct {
+	// 页码, 不传默认第一页
+	PageNo int64 `json:"page_no,default=1" form:"page_no,default=1" binding:"min=1"`
+	// 每页条数, 不传默认 20
+	PageSize int64 `json:"page_size,default=20" form:"page_size,default=20" binding:"min=1,max=100"`
+	// -1-全部 0-待开具 1-已开具 2-已驳回
+	Status int8 `json:"status" form:"status,default=-1" binding:"min=-1,max=2"`
+	// 用户查询自己的发票时由 token 填充
+	UserId int64 `json:"-"`
+}
+
+type IssueInvoiceInput struct {
+	InvoiceId int64 `form:"invoice_id" binding:"required"`
+}
+
+type RejectInvoiceInput struct {
+	InvoiceId int64 `json:"invoice_id" binding:"required"`
+	// 驳回原因, 展示给用户
+	Remark string `json:"remark" binding:"required,max=255"`
+}
@@ -1,11 +1,16 @@
 package dto
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"strconv"
 	"strings"
 
 	"mk-api/server/util"
 	"mk-api/server/util/consts"
+	"mk-api/server/util/money"
+	"mk-api/server/util/refund"
 )
 
 type PostOrderInput struct {
@@ -14,6 +19,10 @@ type PostOrderInput struct {
 	// 预约人手机号
 	SubscriberMobile  string `json:"subscriber_mobile" binding:"required,checkMobile"`
 	SubscriberComment string `json:"subscriber_comment"`
+	// 使用的优惠券, 即用户领取的优惠券 id, 不使用不传
+	UserCouponId int64 `json:"user_coupon_id"`
+	// 体检卡卡号, 不使用不传. 抵扣后实付金额为 0 时订单直接变为已付款, 不再调起微信支付
+	CardCode string `json:"card_code" binding:"max=32"`
 }
 
 type CartItem struct {
@@ -43,6 +52,9 @@ type Examinee struct {
 }
 
 type PostOrderOutput struct {
+	OrderId int64 `json:"order_id"`
+	// 是否已用体检卡全额抵扣, 为 true 时无需调起微信支付, 以下参数为空
+	Paid      bool   `json:"paid"`
 	Timestamp string `json:"timestamp"`
 	NonceStr  string `json:"nonceStr"`
 	PrePayID  string `json:"prePayId"`
@@ -58,22 +70,45 @@ type OrderItem struct {
 	// 套餐id
 	PackageId int64 `json:"pkg_id" db:"pkg_id"`
 	// 套餐价格
-	PackagePrice float64 `json:"pkg_price" db:"pkg_price"`
-	CreateTime   int64   `json:"create_time" db:"create_time"`
-	UpdateTime   int64   `json:"update_time" db:"update_time"`
+	PackagePrice money.Fen `json:"pkg_price" db:"pkg_price"`
+	// 分摊的优惠金额
+	Discount money.Fen `json:"discount" db:"discount"`
+	// 分摊的体检卡抵扣金额
+	CardAmount money.Fen `json:"card_amount" db:"card_amount"`
+	CreateTime int64     `json:"create_time" db:"create_time"`
+	UpdateTime int64     `json:"update_time" db:"update_time"`
+	PackageSnapshot
 	*Examinee
 }
 
+// 下单时的套餐快照, 订单展示以快照为准, 不受套餐后续改名、下架的影响
+type PackageSnapshot struct {
+	PackageName      string `json:"pkg_name" db:"pkg_name"`
+	PackageAvatarUrl string `json:"pkg_avatar_url" db:"pkg_avatar_url"`
+	// 套餐所属体检机构, 也用于判断优惠券适用范围
+	HospitalId   int64  `json:"hospital_id" db:"hospital_id"`
+	HospitalName string `json:"hospital_name" db:"hospital_name"`
+	// 套餐项目
+	PkgItems PkgItemList `json:"pkg_items" db:"pkg_items"`
+}
+
 type Order struct {
-	Id         int64   `json:"id" db:"id"`
-	OutTradeNo string  `json:"out_trade_no" db:"out_trade_no"`
-	UserId     int64   `json:"user_id" db:"user_id"`
-	Mobile     string  `json:"mobile" db:"mobile"`
-	OpenId     string  `json:"open_id" db:"open_id"`
-	Amount     float64 `json:"amount" db:"amount"`
-	Remark     string  `json:"remark" db:"remark"`
-	CreateTime int64   `json:"create_time" db:"create_time"`
-	UpdateTime int64   `json:"update_time" db:"update_time"`
+	Id         int64  `json:"id" db:"id"`
+	OutTradeNo string `json:"out_trade_no" db:"out_trade_no"`
+	UserId     int64  `json:"user_id" db:"user_id"`
+	Mobile     string `json:"mobile" db:"mobile"`
+	OpenId     string `json:"open_id" db:"open_id"`
+	// 优惠后的实付金额
+	Amount money.Fen `json:"amount" db:"amount"`
+	// 使用的优惠券及优惠金额
+	UserCouponId int64     `json:"user_coupon_id" db:"user_coupon_id"`
+	Discount     money.Fen `json:"discount" db:"discount"`
+	// 使用的体检卡及抵扣金额
+	CardId     int64     `json:"card_id" db:"card_id"`
+	CardAmount money.Fen `json:"card_amount" db:"card_amount"`
+	Remark     string    `json:"remark" db:"remark"`
+	CreateTime int64     `json:"create_time" db:"create_time"`
+	UpdateTime int64     `json:"update_time" db:"update_time"`
 }
 
 type ListOrderInput struct {
@@ -81,7 +116,7 @@ type ListOrderInput struct {
 	PageNo int64 `json:"page_no,default=1" form:"page_no,default=1" binding:"min=1"`
 	// 每页条数, 不传默认 10
 	PageSize int64 `json:"page_size,default=10" form:"page_size,default=10" binding:"min=1,max=100"`
-	// 订单筛选 -1-全部，0-待付款，2-待预约(指已经付款) 3-已退款 4-已关闭 5-待评价(该功能暂时disable)
+	// 订单筛选 -1-全部，0-待付款，2-待预约(指已经付款) 3-已退款 4-已关闭 5-待评价
 	Status int8 `json:"status" db:"status" form:"status,default=-1" binding:"min=-1,max=5"`
 }
 
@@ -109,7 +144,7 @@ type ListOrderOutputEle struct {
 	// 订单状态 0-待付款，2-待预约(指已经付款) 3-已退款 4-已关闭 5-待评价
 	Status int8 `json:"status" db:"status"`
 	// 订单总价
-	Amount float64 `json:"amount" db:"amount"`
+	Amount money.Fen `json:"amount" db:"amount"`
 	// 订单中的套餐列表
 	AggregatedOrderItems []*AggregatedOrderItem `json:"aggregated_order_items"`
 }
@@ -123,10 +158,12 @@ type AggregatedOrderItem struct {
 	PackageName string `json:"pkg_name" db:"pkg_name"`
 	// 套餐头像
 	PackageAvatarUrl string `json:"pkg_avatar_url" db:"pkg_avatar_url"`
+	// 体检机构名称
+	HospitalName string `json:"hospital_name" db:"hospital_name"`
 	// 套餐数量
 	PackageCount int64 `json:"pkg_count" db:"pkg_count"`
 	// 套餐单价
-	PackagePrice float64 `json:"pkg_price" db:"pkg_price"`
+	PackagePrice money.Fen `json:"pkg_price" db:"pkg_price"`
 	// 创建时间
 	CreateTime int64 `json:"create_time" db:"create_time"`
 }
@@ -138,14 +175,20 @@ type RetrieveOrderOutput struct {
 	OutTradeNo string `json:"out_trade_no" db:"out_trade_no"`
 	// 订单状态 0-待付款，2-待预约(指已经付款) 3-已退款 4-已关闭 5-待评价
 	Status int8 `json:"status" db:"status"`
-	// 订单总价
-	Amount float64 `json:"amount" db:"amount"`
+	// 订单实付金额, 已减去优惠金额
+	Amount money.Fen `json:"amount" db:"amount"`
+	// 优惠券的优惠金额
+	Discount money.Fen `json:"discount" db:"discount"`
+	// 体检卡的抵扣金额
+	CardAmount money.Fen `json:"card_amount" db:"card_amount"`
 	// 下单人/预约人手机号
 	Mobile string `json:"mobile" db:"mobile"`
 	// 订单备注
 	Remark string `json:"remark" db:"remark"`
 	// 聚合后包括套餐项目的的order item
 	AggregatedOrderItemsWithPkgItem []*AggregatedOrderItemWithPkgItem `json:"aggregated_order_items_with_pkg_item"`
+	// 订单状态变更记录, 按时间先后排序
+	Timeline []*OrderStatusLog `json:"timeline"`
 }
 
 type AggregatedOrderItemWithPkgItem struct {
@@ -164,20 +207,61 @@ type PkgItemName struct {
 	Name string `json:"name" db:"name"`
 }
 
+// PkgItemList 以 json 数组存入数据库
+type PkgItemList []*PkgItemName
+
+func (l PkgItemList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal(l)
+	return string(b), err
+}
+
+func (l *PkgItemList) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return l.unmarshal(v)
+	case string:
+		return l.unmarshal([]byte(v))
+	case nil:
+		*l = nil
+		return nil
+	}
+	return errors.New("unsupported type for PkgItemList")
+}
+
+// 补齐快照之前的旧订单项为空串
+func (l *PkgItemList) unmarshal(b []byte) error {
+	if len(b) == 0 {
+		*l = nil
+		return nil
+	}
+	return json.Unmarshal(b, l)
+}
+
 type ExamineeInOrderItem struct {
 	// order item(订单项)的id
 	OrderItemId int64 `json:"order_item_id" db:"order_item_id"`
 	Examinee
+	// 已改期次数
+	RescheduleCount int64 `json:"reschedule_count" db:"reschedule_count"`
+	// 剩余免费改期次数
+	FreeReschedulesLeft int64 `json:"free_reschedules_left"`
+	// 预约状态 0-待确认 1-已确认
+	AppointmentStatus int8 `json:"appointment_status" db:"appointment_status"`
 }
 
 type OItemWithPkgBrief struct {
-	OrderItemId      int64   `json:"order_item_id" db:"order_item_id"`
-	PackageId        int64   `json:"pkg_id" db:"pkg_id"`
-	PackagePrice     float64 `json:"pkg_price" db:"pkg_price"`
-	PackageName      string  `json:"pkg_name" db:"pkg_name"`
-	PackageAvatarUrl string  `json:"pkg_avatar_url" db:"pkg_avatar_url"`
-	OrderId          int64   `json:"order_id" db:"order_id"`
-	CreateTime       int64   `json:"create_time" db:"create_time"`
+	OrderItemId  int64     `json:"order_item_id" db:"order_item_id"`
+	PackageId    int64     `json:"pkg_id" db:"pkg_id"`
+	PackagePrice money.Fen `json:"pkg_price" db:"pkg_price"`
+	PackageSnapshot
+	OrderId         int64 `json:"order_id" db:"order_id"`
+	CreateTime      int64 `json:"create_time" db:"create_time"`
+	RescheduleCount int64 `json:"reschedule_count" db:"reschedule_count"`
+	// 预约状态 0-待确认 1-已确认
+	AppointmentStatus int8 `json:"appointment_status" db:"appointment_status"`
 	Examinee
 }
 
@@ -188,10 +272,13 @@ type PutOrderItemInput struct {
 	PackageId int64 `json:"pkg_id" db:"pkg_id" binding:"required"`
 	// 体检人信息
 	Examinee
+	// 免费改期次数用完后, 用户确认接受改期服务费时传 true
+	AcceptRescheduleFee bool `json:"accept_reschedule_fee"`
 }
 
 type CancelOrderInput struct {
-	Id int64 `json:"order_id" db:"id"  binding:"required"`
+	Id     int64 `json:"order_id" db:"id"  binding:"required"`
+	UserId int64 `json:"-" db:"user_id"`
 	// 取消原因id, 1-支付时出故障，支付不了， 2-付款时 余额限制了 3-买多了/不想买了 4-信息写错，重新下单 5-朋友/网上评价不好 6-计划有变，时间按排不上，7-其他
 	CancelReasonId int64 `json:"cancel_reason_id" binding:"required,min=1,max=7" db:"cancel_reason_id"`
 	// 取消的问题描述
@@ -199,16 +286,112 @@ type CancelOrderInput struct {
 }
 
 type RefundOrderInput struct {
-	Id int64 `json:"order_id" db:"id"  binding:"required"`
+	Id     int64 `json:"order_id" db:"id"  binding:"required"`
+	UserId int64 `json:"-" db:"user_id"`
 	// 退款原因id 3-买多了/不想买了 4-信息写错，重新下单 5-朋友/网上评价不好 6-计划有变，时间按排不上，7-其他
 	RefundReasonId int64 `json:"refund_reason_id" binding:"required,min=1,max=7" db:"refund_reason_id"`
 	// 退款具体原因描述
 	RefundReasonRemark string `json:"refund_reason_remark" db:"refund_reason_remark"`
 }
 
+// 订单项当前的体检人、体检日期及改期次数
+type OrderItemSchedule struct {
+	OrderItemId     int64 `json:"order_item_id" db:"order_item_id"`
+	OrderId         int64 `json:"order_id" db:"order_id"`
+	PackageId       int64 `json:"pkg_id" db:"pkg_id"`
+	ExamineDate     int64 `json:"examine_date" db:"examine_date"`
+	RescheduleCount int64 `json:"reschedule_count" db:"reschedule_count"`
+	OrderStatus     int8  `json:"order_status" db:"order_status"`
+	// 修改前的体检人信息, 记录审计日志用
+	ExamineeName   string `json:"examinee_name" db:"examinee_name"`
+	ExamineeMobile string `json:"examinee_mobile" db:"examinee_mobile"`
+	IdCardNo       string `json:"id_card_no" db:"id_card_no"`
+	Gender         int8   `json:"gender" db:"gender"`
+	IsMarried      int8   `json:"is_married" db:"is_married"`
+}
+
+// 体检日期改期记录
+type OrderItemRescheduleLog struct {
+	OrderItemId int64 `json:"order_item_id" db:"order_item_id"`
+	OrderId     int64 `json:"order_id" db:"order_id"`
+	UserId      int64 `json:"user_id" db:"user_id"`
+	FromDate    int64 `json:"from_date" db:"from_date"`
+	ToDate      int64 `json:"to_date" db:"to_date"`
+	// 第几次改期, 从1开始
+	Seq int64 `json:"seq" db:"seq"`
+	// 是否超出免费次数 0-否 1-是
+	Chargeable int8  `json:"chargeable" db:"chargeable"`
+	CreateTime int64 `json:"create_time" db:"create_time"`
+}
+
+type RefundPreviewInput struct {
+	Id int64 `json:"order_id" form:"order_id" binding:"required"`
+}
+
+// 退款试算用到的订单项
+type RefundableOrderItem struct {
+	OrderItemId  int64     `json:"order_item_id" db:"order_item_id"`
+	PackagePrice money.Fen `json:"pkg_price" db:"pkg_price"`
+	// 分摊的优惠金额, 退款按套餐价格减去优惠金额计算
+	Discount money.Fen `json:"discount" db:"discount"`
+	// 分摊的体检卡抵扣金额, 不退给用户, 订单退款后体检卡退回未使用
+	CardAmount  money.Fen `json:"card_amount" db:"card_amount"`
+	ExamineDate int64     `json:"examine_date" db:"examine_date"`
+	// 已改期次数
+	RescheduleCount int64 `json:"reschedule_count" db:"reschedule_count"`
+	// 预约状态 0-待确认 1-已确认
+	AppointmentStatus int8 `json:"appointment_status" db:"appointment_status"`
+}
+
+// 按当前改退规则试算的退款金额和费用明细, 金额单位为分
+type RefundPreviewOutput struct {
+	OrderId int64 `json:"order_id"`
+	// 付款时间
+	PaidAt int64 `json:"paid_at"`
+	*refund.Result
+}
+
 type OInfo4PaidNotify struct {
-	Id         int64   `json:"order_id" db:"id"`
-	OpenId     string  `json:"open_id" db:"open_id"`
-	OutTradeNo string  `json:"out_trade_no" db:"out_trade_no"`
-	Amount     float64 `json:"amount" db:"amount"`
+	Id         int64     `json:"order_id" db:"id"`
+	OpenId     string    `json:"open_id" db:"open_id"`
+	OutTradeNo string    `json:"out_trade_no" db:"out_trade_no"`
+	Amount     money.Fen `json:"amount" db:"amount"`
+}
+
+// 超时未支付、待关闭的订单
+type ExpiredOrder struct {
+	OrderId    int64  `json:"order_id" db:"order_id"`
+	OutTradeNo string `json:"out_trade_no" db:"out_trade_no"`
+	OpenId     string `json:"open_id" db:"open_id"`
+	// 没有生成支付流水的订单为0
+	BillId int64 `json:"bill_id" db:"bill_id"`
+}
+
+// 订单状态变更请求
+type OrderTransition struct {
+	OrderId int64 `json:"order_id" db:"order_id"`
+	// 变更前状态, 由状态机填充
+	From int8 `json:"from_status" db:"from_status"`
+	To   int8 `json:"to_status" db:"to_status"`
+	// 操作人类型 1-用户 2-运营人员 3-系统 4-微信支付回调
+	ActorType int8 `json:"actor_type" db:"actor_type"`
+	// 操作人id, 用户为user_id, 系统和微信为0
+	ActorId    int64  `json:"actor_id" db:"actor_id"`
+	Reason     string `json:"reason" db:"reason"`
+	CreateTime int64  `json:"create_time" db:"create_time"`
+}
+
+type OrderStatusLog struct {
+	// 变更前状态
+	From int8 `json:"from_status" db:"from_status"`
+	// 变更后状态
+	To int8 `json:"to_status" db:"to_status"`
+	// 操作人类型 1-用户 2-运营人员 3-系统 4-微信支付回调
+	ActorType int8 `json:"actor_type" db:"actor_type"`
+	// 操作人id
+	ActorId int64 `json:"actor_id" db:"actor_id"`
+	// 变更原因
+	Reason string `json:"reason" db:"reason"`
+	// 变更时间
+	CreateTime int64 `json:"create_time" db:"create_time"`
 }
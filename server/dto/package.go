@@ -6,6 +6,7 @@ import (
 
 	"mk-api/server/util"
 	"mk-api/server/util/consts"
+	"mk-api/server/util/money"
 )
 
 type ListPackageInput struct {
@@ -20,15 +21,15 @@ type ListPackageInput struct {
 	// 套餐分类 id, 0-不限
 	CategoryId int64 `json:"category_id" form:"category_id" binding:"min=0" db:"category_id"`
 	// 价格区间左值,0 表示不限 单位分
-	MinPrice int64 `json:"min_price" form:"min_price" binding:"min=0,max=3000000" db:"min_price"`
+	MinPrice money.Fen `json:"min_price" form:"min_price" binding:"min=0,max=3000000" db:"min_price"`
 	// 价格区间右值 0 表示不限 单位分
-	MaxPrice int64 `json:"max_price" form:"max_price" binding:"min=0,max=100000000" db:"max_price"`
+	MaxPrice money.Fen `json:"max_price" form:"max_price" binding:"min=0,max=100000000" db:"max_price"`
 	// 适用人群 0-不限 1-男士 2-女未婚 3-女已婚
 	Target int8 `json:"target" form:"target" binding:"oneof=0 1 2 3" db:"target"`
 	// 检测目标高发疾病id
 	DiseaseId int64 `json:"disease_id,default=0" form:"disease_id,default=0" db:"disease_id"`
-	// 优先排序 0-默认排序，1-低价优先 2 高价优先
-	OrderBy int8 `json:"order_by" form:"order_by" binding:"oneof=0 1 2"`
+	// 优先排序 0-默认排序，1-低价优先 2 高价优先 3-好评优先
+	OrderBy int8 `json:"order_by" form:"order_by" binding:"oneof=0 1 2 3"`
 	// 按套餐名字搜索
 	Name string `json:"name" form:"name" db:"name"`
 }
@@ -48,10 +49,10 @@ func (input *ListPackageInput) GetListKey() string {
 		keys = append(keys, strconv.FormatInt(input.CategoryId, 10))
 	}
 	if input.MinPrice != 0 {
-		keys = append(keys, strconv.FormatInt(input.MinPrice, 10))
+		keys = append(keys, strconv.FormatInt(int64(input.MinPrice), 10))
 	}
 	if input.MaxPrice != 0 {
-		keys = append(keys, strconv.FormatInt(input.MaxPrice, 10))
+		keys = append(keys, strconv.FormatInt(int64(input.MaxPrice), 10))
 	}
 	if input.Target != 0 {
 		keys = append(keys, strconv.Itoa(int(input.Target)))
@@ -83,9 +84,13 @@ type ListPackageOutputEle struct {
 	// 已经预约的单数, 这个暂时需要前端用hidden隐藏起来
 	Sold int64 `json:"sold" db:"sold"`
 	// 门市价, 原价, 单位分
-	PriceOriginal float64 `json:"price_original" db:"price_original"`
+	PriceOriginal money.Fen `json:"price_original" db:"price_original"`
 	// 真实价格， 现价格， 单位分
-	PriceReal float64 `json:"price_real" db:"price_real"`
+	PriceReal money.Fen `json:"price_real" db:"price_real"`
+	// 审核通过的评价数
+	ReviewCount int64 `json:"review_count" db:"review_count"`
+	// 平均评分, 保留一位小数, 没有评价时为 0
+	Rating float64 `json:"rating" db:"rating"`
 }
 
 type GetPackageOutPut struct {
@@ -109,11 +114,15 @@ type PackageBasicInfo struct {
 	// 套餐URL
 	AvatarUrl string `json:"avatar_url" db:"avatar_url"`
 	// 原价/门市价
-	PriceOriginal float64 `json:"price_original" db:"price_original"`
+	PriceOriginal money.Fen `json:"price_original" db:"price_original"`
 	// 实际价格
-	PriceReal float64 `json:"price_real" db:"price_real"`
+	PriceReal money.Fen `json:"price_real" db:"price_real"`
 	// 已经预约的数量
 	Sold int64 `json:"sold" db:"sold"`
+	// 审核通过的评价数
+	ReviewCount int64 `json:"review_count" db:"review_count"`
+	// 平均评分, 保留一位小数, 没有评价时为 0
+	Rating float64 `json:"rating" db:"rating"`
 	// 套餐简介
 	Brief string `json:"brief" db:"brief"`
 	// 套餐详细介绍
@@ -164,8 +173,9 @@ type PackageNotice = PackageAttribute
 type PackageProcedure = PackageAttribute
 
 type PkgTargetNPrice struct {
-	Price  float64 `db:"price_real"`
-	Target int8    `db:"target"`
+	Price      money.Fen `db:"price_real"`
+	Target     int8      `db:"target"`
+	HospitalId int64     `db:"hospital_id"`
 }
 
 type Category struct {
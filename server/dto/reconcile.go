@@ -0,0 +1,53 @@
+package dto
+
+import "mk-api/server/util/money"
+
+// 微信支付对账报告, 每天一份
+type ReconcileReport struct {
+	Id int64 `json:"id" db:"id"`
+	// 对账单日期 yyyyMMdd
+	BillDate string `json:"bill_date" db:"bill_date"`
+	// 对账单交易笔数, 退款单独计一笔
+	RemoteCount int `json:"remote_count" db:"remote_count"`
+	// 对账单订单总金额和申请退款总金额, 单位分
+	RemoteTotalFee  money.Fen `json:"remote_total_fee" db:"remote_total_fee"`
+	RemoteRefundFee money.Fen `json:"remote_refund_fee" db:"remote_refund_fee"`
+	// 参与核对的本地流水数
+	LocalCount   int   `json:"local_count" db:"local_count"`
+	MatchedCount int   `json:"matched_count" db:"matched_count"`
+	DiffCount    int   `json:"diff_count" db:"diff_count"`
+	CreateTime   int64 `json:"create_time" db:"create_time"`
+	UpdateTime   int64 `json:"update_time" db:"update_time"`
+}
+
+type ReconcileDiff struct {
+	Id       int64 `json:"id" db:"id"`
+	ReportId int64 `json:"report_id" db:"report_id"`
+	// 1-本地缺失 2-微信缺失 3-金额不一致 4-状态不一致
+	Kind int8 `json:"kind" db:"kind"`
+	// 1-收款 2-退款
+	FeeType       int8   `json:"fee_type" db:"fee_type"`
+	OutTradeNo    string `json:"out_trade_no" db:"out_trade_no"`
+	TransactionId string `json:"transaction_id" db:"transaction_id"`
+	OutRefundNo   string `json:"out_refund_no" db:"out_refund_no"`
+	// 本地和对账单中的金额, 单位分
+	LocalFee  money.Fen `json:"local_fee" db:"local_fee"`
+	RemoteFee money.Fen `json:"remote_fee" db:"remote_fee"`
+	// 本地流水状态 PROCESSING/SUCCESS/CLOSED, 本地缺失时为空
+	LocalStatus string `json:"local_status" db:"local_status"`
+	// 对账单中的交易状态或退款状态, 微信缺失时为空
+	RemoteStatus string `json:"remote_status" db:"remote_status"`
+	CreateTime   int64  `json:"create_time" db:"create_time"`
+}
+
+type ReconcileReportDetail struct {
+	*ReconcileReport
+	Diffs []*ReconcileDiff `json:"diffs"`
+}
+
+type ListReconcileReportInput struct {
+	// 页码, 不传默认第一页
+	PageNo int64 `json:"page_no,default=1" form:"page_no,default=1" binding:"min=1"`
+	// 每页条数, 不传默认 20
+	PageSize int64 `json:"page_size,default=20" form:"page_size,default=20" binding:"min=1,max=100"`
+}
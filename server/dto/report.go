@@ -0,0 +1,119 @@
+package dto
+
+import "mk-api/server/util/exam"
+
+type Report struct {
+	Id          int64 `json:"id" db:"id"`
+	OrderId     int64 `json:"order_id" db:"order_id"`
+	OrderItemId int64 `json:"order_item_id" db:"order_item_id"`
+	UserId      int64 `json:"user_id" db:"user_id"`
+	// 上传时的文件名
+	FileName string `json:"file_name" db:"file_name"`
+	// application/pdf, image/jpeg 或 image/png
+	ContentType string `json:"content_type" db:"content_type"`
+	// 文件大小, 单位字节
+	Size int64 `json:"size" db:"size"`
+	// 私有存储桶中的对象 key, 不对外暴露, 下载链接通过 GET /reports/{id}/url 获取
+	CosKey     string `json:"-" db:"cos_key"`
+	StaffId    int64  `json:"staff_id" db:"staff_id"`
+	CreateTime int64  `json:"create_time" db:"create_time"`
+	UpdateTime int64  `json:"update_time" db:"update_time"`
+}
+
+// 上传报告时校验和推送通知用的订单项信息
+type ReportTarget struct {
+	OrderItemId  int64  `db:"order_item_id"`
+	OrderId      int64  `db:"order_id"`
+	UserId       int64  `db:"user_id"`
+	OpenId       string `db:"open_id"`
+	ExamineeName string `db:"examinee_name"`
+	PackageName  string `db:"pkg_name"`
+	PackageId    int64  `db:"pkg_id"`
+	HospitalId   int64  `db:"hospital_id"`
+	// 下单时的套餐项目快照
+	PkgItems    PkgItemList `db:"pkg_items"`
+	OrderStatus int8        `db:"order_status"`
+	ExamineDate int64       `db:"examine_date"`
+}
+
+type PostReportInput struct {
+	OrderItemId int64 `json:"order_item_id" form:"order_item_id" binding:"required"`
+}
+
+type ListReportInput struct {
+	// 订单项 id, 用户查询时不传返回自己的全部报告, 运营后台查询时必传
+	OrderItemId int64 `json:"order_item_id" form:"order_item_id" binding:"min=0"`
+	// 用户查询时取自 token, 运营后台查询时为 0
+	UserId int64 `json:"-"`
+}
+
+type DeleteReportInput struct {
+	ReportId int64 `json:"report_id" binding:"required"`
+}
+
+type ReportUrlOutput struct {
+	// 签名下载链接, 过期后需要重新获取
+	Url string `json:"url"`
+	// 链接的过期时间戳
+	ExpireTime int64 `json:"expire_time"`
+}
+
+type ExamResult struct {
+	Id      int64 `json:"id" db:"id"`
+	OrderId int64 `json:"order_id" db:"order_id"`
+	UserId  int64 `json:"user_id" db:"user_id"`
+	exam.Record
+	StaffId    int64 `json:"staff_id" db:"staff_id"`
+	CreateTime int64 `json:"create_time" db:"create_time"`
+	UpdateTime int64 `json:"update_time" db:"update_time"`
+}
+
+type ExamResultInput struct {
+	// 体检项目, 必须是订单项所购套餐中的项目
+	ItemName string `json:"item_name" binding:"required,max=64"`
+	// 项目下的指标名称, 同一项目下不能重复
+	Indicator string `json:"indicator" binding:"required,max=64"`
+	Value     string `json:"value" binding:"required,max=64"`
+	Unit      string `json:"unit" binding:"max=32"`
+	// 参考范围, 如 3.5-9.5
+	RefRange string `json:"ref_range" binding:"max=64"`
+	// 0-正常 1-偏高 2-偏低 3-异常
+	Abnormal int8 `json:"abnormal" binding:"oneof=0 1 2 3"`
+}
+
+type PutExamResultInput struct {
+	OrderItemId int64 `json:"order_item_id" binding:"required"`
+	// 订单项的全部结果, 整体替换已录入的结果
+	Results []*ExamResultInput `json:"results" binding:"required,min=1,max=500,dive"`
+}
+
+type ListExamResultInput struct {
+	OrderItemId int64 `json:"order_item_id" form:"order_item_id" binding:"required"`
+}
+
+// 体检人历次体检的结果, 每行一个指标
+type ExamHistoryRow struct {
+	OrderId      int64  `db:"order_id"`
+	PackageName  string `db:"pkg_name"`
+	HospitalName string `db:"hospital_name"`
+	exam.Record
+}
+
+// 一次体检及其结果
+type ExamVisit struct {
+	OrderId     int64 `json:"order_id"`
+	OrderItemId int64 `json:"order_item_id"`
+	// 体检日期
+	ExamineDate  int64          `json:"examine_date"`
+	PackageName  string         `json:"pkg_name"`
+	HospitalName string         `json:"hospital_name"`
+	Results      []*exam.Record `json:"results"`
+}
+
+type ExamHistoryOutput struct {
+	ExamineeName string `json:"examinee_name"`
+	// 历次体检, 按体检日期倒序
+	Visits []*ExamVisit `json:"visits"`
+	// 在两次及以上体检中出现的指标的变化趋势
+	Trends []*exam.Series `json:"trends"`
+}
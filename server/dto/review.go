@@ -0,0 +1,99 @@
+package dto
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+)
+
+type Review struct {
+	Id          int64 `json:"id" db:"id"`
+	OrderId     int64 `json:"order_id" db:"order_id"`
+	OrderItemId int64 `json:"order_item_id" db:"order_item_id"`
+	UserId      int64 `json:"user_id" db:"user_id"`
+	// 评价人昵称, 对外展示时打码
+	UserName  string `json:"user_name" db:"user_name"`
+	AvatarUrl string `json:"avatar_url" db:"avatar_url"`
+	PackageId int64  `json:"pkg_id" db:"pkg_id"`
+	// 评分 1-5 星
+	Rating  int8   `json:"rating" db:"rating"`
+	Content string `json:"content" db:"content"`
+	// 晒图地址
+	Images ImageList `json:"images" db:"images"`
+	// 0-待审核 1-已通过 2-已驳回
+	Status int8 `json:"status" db:"status"`
+	// 驳回原因
+	Remark       string `json:"remark" db:"remark"`
+	StaffId      int64  `json:"staff_id" db:"staff_id"`
+	ModerateTime int64  `json:"moderate_time" db:"moderate_time"`
+	CreateTime   int64  `json:"create_time" db:"create_time"`
+}
+
+// ImageList 以 json 数组存入数据库
+type ImageList []string
+
+func (l ImageList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal(l)
+	return string(b), err
+}
+
+func (l *ImageList) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, l)
+	case string:
+		return json.Unmarshal([]byte(v), l)
+	case nil:
+		*l = nil
+		return nil
+	}
+	return errors.New("unsupported type for ImageList")
+}
+
+// 提交评价时校验的订单项信息
+type ReviewableItem struct {
+	OrderItemId  int64 `db:"order_item_id"`
+	OrderId      int64 `db:"order_id"`
+	PackageId    int64 `db:"pkg_id"`
+	ExamineDate  int64 `db:"examine_date"`
+	OrderStatus  int8  `db:"order_status"`
+	RefundStatus int8  `db:"refund_status"`
+}
+
+type PostReviewInput struct {
+	OrderItemId int64 `json:"order_item_id" binding:"required"`
+	// 评分 1-5 星
+	Rating  int8   `json:"rating" binding:"required,min=1,max=5"`
+	Content string `json:"content" binding:"max=1000"`
+	// 晒图地址, 先通过 POST /reviews/images 上传, 最多 9 张
+	Images []string `json:"images" binding:"max=9,dive,url"`
+}
+
+type ListReviewInput struct {
+	// 页码, 不传默认第一页
+	PageNo int64 `json:"page_no,default=1" form:"page_no,default=1" binding:"min=1"`
+	// 每页条数, 不传默认 20
+	PageSize int64 `json:"page_size,default=20" form:"page_size,default=20" binding:"min=1,max=100"`
+	// -1-全部 0-待审核 1-已通过 2-已驳回, 套餐详情页只展示已通过的评价
+	Status    int8  `json:"status" form:"status,default=-1" binding:"min=-1,max=2"`
+	PackageId int64 `json:"pkg_id" form:"pkg_id"`
+	// 用户查询自己的评价时由 token 填充
+	UserId int64 `json:"-"`
+}
+
+type ApproveReviewInput struct {
+	ReviewId int64 `json:"review_id" binding:"required"`
+}
+
+type RejectReviewInput struct {
+	ReviewId int64 `json:"review_id" binding:"required"`
+	// 驳回原因, 展示给用户
+	Remark string `json:"remark" binding:"required,max=255"`
+}
+
+type UploadReviewImageOutput struct {
+	Url string `json:"url"`
+}
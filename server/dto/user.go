@@ -20,7 +20,7 @@ type CreateUserAddrInput struct {
 
 type GetUserAddrOutput struct {
 	Id             int64  `json:"id" db:"id" comment:"地址id"`
-	UserId         int64  `json:"id" db:"user_id" comment:"用户id"`
+	UserId         int64  `json:"user_id" db:"user_id" comment:"用户id"`
 	ProvinceId     int64  `json:"province_id" db:"province_id" binding:"required" comment:"省id"`
 	CityId         int64  `json:"city_id" db:"city_id" binding:"required" comment:"城市id"`
 	CountyId       int64  `json:"county_id" db:"county_id" binding:"required" comment:"区id"`
@@ -1,13 +1,18 @@
 package middleware
 
 import (
+	"database/sql"
 	"errors"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gomodule/redigo/redis"
+	"github.com/sirupsen/logrus"
 	"mk-api/library/ecode"
 	. "mk-api/server/dao"
+	"mk-api/server/model"
 	"mk-api/server/util"
+	"mk-api/server/util/consts"
+	"mk-api/server/util/rbac"
 )
 
 // TokenAuthMiddleware 检查request header 的token， 必须是注册(绑定手机)并且登录的用户 request才能往下进行
@@ -71,3 +76,62 @@ func TokenRequired() gin.HandlerFunc {
 		ctx.Next()
 	}
 }
+
+// StaffRequired 运营后台的接口, 检查 request header 的 token 是否为运营人员登录后获得的 token.
+// 每次请求都按 token 中的 staff_id 重新查询账号, 账号停用后 token 立即失效, 角色以数据库为准
+func StaffRequired(staffModel model.StaffModel) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		token := ctx.GetHeader("token")
+		if token == "" {
+			ResponseError(ctx, ecode.Unauthorized, errors.New("缺少请求token"))
+			ctx.Abort()
+			return
+		}
+
+		cli := Rdb.TokenRdbP.Get()
+		defer cli.Close()
+
+		staffTokenKey := "hash.staff_token." + token
+		staffId, _ := redis.Int64(cli.Do("HGET", staffTokenKey, "staff_id"))
+		if staffId == 0 {
+			ResponseError(ctx, ecode.Unauthorized, errors.New("登录已过期， 请重新登录"))
+			ctx.Abort()
+			return
+		}
+		staff, err := staffModel.FindStaffById(staffId)
+		if err != nil && err != sql.ErrNoRows {
+			util.Log.WithFields(logrus.Fields{"staff_id": staffId}).Errorf("查询运营人员出错, err: [%s]", err.Error())
+			ResponseError(ctx, ecode.ServerErr, errors.New("服务器错误"))
+			ctx.Abort()
+			return
+		}
+		if err == sql.ErrNoRows || staff.Status != consts.StaffActive || !staff.Role.Valid() ||
+			(staff.Role.HospitalScoped() && staff.HospitalId == 0) {
+			_, _ = cli.Do("DEL", staffTokenKey)
+			ResponseError(ctx, ecode.Unauthorized, errors.New("该账号已停用或角色已变更， 请重新登录"))
+			ctx.Abort()
+			return
+		}
+		ctx.Set("staffId", staff.Id)
+		ctx.Set("staffName", staff.Name)
+		ctx.Set("staffRole", staff.Role)
+		// 机构对接人只能处理所对接机构的订单, 其他角色不限
+		if staff.Role.HospitalScoped() {
+			ctx.Set("staffHospitalId", staff.HospitalId)
+		}
+		ctx.Next()
+	}
+}
+
+// PermissionRequired 运营人员的角色需要拥有权限 p, 需放在 StaffRequired 之后
+func PermissionRequired(p rbac.Permission) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		role, _ := ctx.Get("staffRole")
+		if r, ok := role.(rbac.Role); !ok || !r.Can(p) {
+			ResponseError(ctx, ecode.AccessDenied, errors.New("没有操作权限"))
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/gin-gonic/gin"
+	"mk-api/library/ecode"
+	. "mk-api/server/dao"
+	"mk-api/server/util"
+	"mk-api/server/util/consts"
+)
+
+// 幂等键对应的记录, Response 为空表示第一次请求还在处理中
+type idempotentRecord struct {
+	// 请求体的 md5, 同一个幂等键不能用于不同的请求
+	BodyHash string `json:"body_hash"`
+	Response string `json:"response"`
+}
+
+// Idempotent 请求头 Idempotency-Key 相同的重复请求直接返回第一次成功的响应, 第一次请求还在处理中时拒绝.
+// 第一次请求失败时删除记录, 客户端可以用同一个幂等键重试. 不带该请求头的请求照常处理.
+// 按用户区分幂等键, 需放在 MobileBoundRequired 之后
+func Idempotent() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key := ctx.GetHeader("Idempotency-Key")
+		if key == "" {
+			ctx.Next()
+			return
+		}
+		if len(key) > 64 {
+			ResponseError(ctx, ecode.RequestErr, errors.New("Idempotency-Key 不能超过64个字符"))
+			return
+		}
+		body, err := ctx.GetRawData()
+		if err != nil {
+			ResponseError(ctx, ecode.RequestErr, err)
+			return
+		}
+		ctx.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+		bodyHash := util.MD5V(body)
+		cacheKey := fmt.Sprintf("%s.%d.%s.%s", consts.CacheIdempotency, ctx.GetInt64("userId"), ctx.FullPath(), key)
+
+		ok, err := Rdb.ApiCache.SetNxEx(cacheKey, idempotentRecord{BodyHash: bodyHash}, consts.IdempotencyLockExpire)
+		if err != nil {
+			// redis 不可用时不影响下单和支付
+			util.Log.Errorf("设置幂等键出错, key: [%s], err: [%s]", cacheKey, err.Error())
+			ctx.Next()
+			return
+		}
+		if ok {
+			ctx.Next()
+			response := ctx.GetString("response")
+			if ctx.IsAborted() || response == "" {
+				_ = Rdb.ApiCache.Delete(cacheKey)
+				return
+			}
+			record := idempotentRecord{BodyHash: bodyHash, Response: response}
+			if err = Rdb.ApiCache.SetEx(cacheKey, record, consts.IdempotencyWindow); err != nil {
+				util.Log.Errorf("保存幂等请求的响应出错, key: [%s], err: [%s]", cacheKey, err.Error())
+			}
+			return
+		}
+
+		var record idempotentRecord
+		data, err := Rdb.ApiCache.Get(cacheKey)
+		if err == nil {
+			err = json.Unmarshal(data, &record)
+		}
+		switch {
+		case err == nil && record.BodyHash != bodyHash:
+			ResponseError(ctx, ecode.RequestErr, errors.New("Idempotency-Key 已用于其他请求"))
+		case err != nil || record.Response == "":
+			ResponseError(ctx, ecode.Conflict, errors.New("请求正在处理中, 请勿重复提交"))
+		default:
+			ctx.Data(200, "application/json; charset=utf-8", []byte(record.Response))
+			ctx.Set("response", record.Response)
+			ctx.Abort()
+		}
+	}
+}
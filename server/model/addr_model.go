@@ -28,9 +28,9 @@ type UserAddrModel interface {
 	FindUserAddrByUserId(userID int64) ([]UserAddr, error)
 	Save(addr *UserAddr) (id int64, err error)
 	CancelOriginDefaultAddr(userId int64) (err error)
-	FindUserAddrByAddrId(id int64) (addr *dto.GetUserAddrOutput, err error)
-	DeleteUserAddrByAddrId(id int64) (err error)
-	UpdateUserAddr(id int64, addr *dto.UpdateUserAddrInput) (err error)
+	FindUserAddrByIdNUserId(id int64, userId int64) (addr *dto.GetUserAddrOutput, err error)
+	DeleteUserAddrByIdNUserId(id int64, userId int64) (err error)
+	UpdateUserAddr(id int64, userId int64, addr *dto.UpdateUserAddrInput) (err error)
 }
 
 type addrDatabase struct {
@@ -112,7 +112,7 @@ func (db *addrDatabase) FindUserAddrByUserId(userId int64) (addrs []UserAddr, er
 	return
 }
 
-func (db *addrDatabase) FindUserAddrByAddrId(id int64) (addr *dto.GetUserAddrOutput, err error) {
+func (db *addrDatabase) FindUserAddrByIdNUserId(id int64, userId int64) (addr *dto.GetUserAddrOutput, err error) {
 	addr = new(dto.GetUserAddrOutput)
 	cmd := `SELECT 
 				id, 
@@ -127,18 +127,19 @@ func (db *addrDatabase) FindUserAddrByAddrId(id int64) (addr *dto.GetUserAddrOut
 				mku_user_address
 			WHERE 
 				id = ? 
+				AND user_id = ?
 				AND is_deleted = 0`
-	err = db.connection.Get(addr, cmd, id)
+	err = db.connection.Get(addr, cmd, id, userId)
 	return
 }
 
-func (db *addrDatabase) DeleteUserAddrByAddrId(id int64) (err error) {
-	cmd := `UPDATE mku_user_address SET is_deleted = 1 WHERE id = ? AND is_deleted = 0`
-	_, err = db.connection.Exec(cmd, id)
+func (db *addrDatabase) DeleteUserAddrByIdNUserId(id int64, userId int64) (err error) {
+	cmd := `UPDATE mku_user_address SET is_deleted = 1 WHERE id = ? AND user_id = ? AND is_deleted = 0`
+	_, err = db.connection.Exec(cmd, id, userId)
 	return
 }
 
-func (db *addrDatabase) UpdateUserAddr(id int64, addr *dto.UpdateUserAddrInput) (err error) {
+func (db *addrDatabase) UpdateUserAddr(id int64, userId int64, addr *dto.UpdateUserAddrInput) (err error) {
 	cmd := `UPDATE 
 				mku_user_address
 			SET 
@@ -150,12 +151,14 @@ func (db *addrDatabase) UpdateUserAddr(id int64, addr *dto.UpdateUserAddrInput)
 				is_default = :is_default
 			WHERE 
 				id = :id
+				AND user_id = :user_id
 				AND is_deleted = 0`
 
 	_, err = db.connection.NamedExec(cmd, struct {
-		Id int64 `json:"id" db:"id"`
+		Id     int64 `json:"id" db:"id"`
+		UserId int64 `json:"user_id" db:"user_id"`
 		dto.UpdateUserAddrInput
-	}{Id: id, UpdateUserAddrInput: *addr})
+	}{Id: id, UserId: userId, UpdateUserAddrInput: *addr})
 	return
 }
 
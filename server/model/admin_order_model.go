@@ -0,0 +1,253 @@
+package model
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"mk-api/server/dao"
+	"mk-api/server/dto"
+)
+
+// AdminOrderModel 运营后台跨用户查询和处理订单
+type AdminOrderModel interface {
+	ListOrder(input *dto.AdminListOrderInput) ([]*dto.AdminListOrderOutputEle, error)
+	FindAdminOrderInfo(orderId int64) (*dto.AdminOrderInfo, error)
+	// 订单中是否有该机构的套餐
+	IsOrderInHospital(orderId int64, hospitalId int64) (bool, error)
+	ListBillsByOrderId(orderId int64) ([]*dto.TradeBill, error)
+	ListOrderNotes(orderId int64) ([]*dto.OrderNote, error)
+	SaveOrderNote(note *dto.OrderNote) (int64, error)
+	FindAppointmentInfo(orderItemId int64) (*dto.AppointmentInfo, error)
+	ConfirmAppointment(orderItemId int64, confirmTime int64) (ok bool, err error)
+	AuditRefund(orderId int64, from int8, to int8, remark string) (ok bool, err error)
+}
+
+type adminOrderDatabase struct {
+	connection *sqlx.DB
+}
+
+func (db *adminOrderDatabase) ListOrder(input *dto.AdminListOrderInput) ([]*dto.AdminListOrderOutputEle, error) {
+	output := make([]*dto.AdminListOrderOutputEle, 0, input.PageSize+1)
+	cmd := `
+			SELECT
+				mo.id AS order_id,
+				mo.out_trade_no,
+				mo.user_id,
+				mo.mobile,
+				mo.status,
+				mo.refund_status,
+				mo.amount,
+				(SELECT COUNT(*) FROM mko_order_item AS moi WHERE moi.order_id = mo.id AND moi.is_deleted = 0) AS item_count,
+				mo.create_time
+			FROM
+				mko_order AS mo
+			WHERE
+				1 = 1
+				%s
+			ORDER BY mo.id DESC
+			LIMIT :offset, :limit
+`
+	whereStmt := ""
+	if input.OutTradeNo != "" {
+		whereStmt += " AND mo.out_trade_no = :out_trade_no"
+	}
+	if input.Mobile != "" {
+		whereStmt += " AND mo.mobile = :mobile"
+	}
+	if input.Status != -1 {
+		whereStmt += " AND mo.status = :status"
+	}
+	if input.RefundStatus != -1 {
+		whereStmt += " AND mo.refund_status = :refund_status"
+	}
+	if input.StartTime != 0 {
+		whereStmt += " AND mo.create_time >= :start_time"
+	}
+	if input.EndTime != 0 {
+		whereStmt += " AND mo.create_time < :end_time"
+	}
+	if input.HospitalId != 0 {
+		whereStmt += ` AND EXISTS (
+					SELECT 1 FROM mko_order_item AS moi
+					WHERE moi.order_id = mo.id AND moi.is_deleted = 0 AND moi.hospital_id = :hospital_id)`
+	}
+	cmd = fmt.Sprintf(cmd, whereStmt)
+
+	input.Offset = (input.PageNo - 1) * input.PageSize
+	input.Limit = input.PageSize + 1
+	rows, err := db.connection.NamedQuery(cmd, input)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ele dto.AdminListOrderOutputEle
+		if err = rows.StructScan(&ele); err != nil {
+			return nil, err
+		}
+		output = append(output, &ele)
+	}
+	return output, rows.Err()
+}
+
+func (db *adminOrderDatabase) FindAdminOrderInfo(orderId int64) (*dto.AdminOrderInfo, error) {
+	var output dto.AdminOrderInfo
+	const cmd = `
+			SELECT
+				user_id,
+				open_id,
+				cancel_reason_id,
+				refund_status,
+				refund_reason_id,
+				refund_reason_remark,
+				refund_audit_remark,
+				create_time
+			FROM
+				mko_order
+			WHERE
+				id = ?
+`
+	err := db.connection.Get(&output, cmd, orderId)
+	return &output, err
+}
+
+func (db *adminOrderDatabase) IsOrderInHospital(orderId int64, hospitalId int64) (bool, error) {
+	var count int64
+	const cmd = `
+			SELECT
+				COUNT(*)
+			FROM
+				mko_order_item AS moi
+			WHERE
+				moi.order_id = ?
+				AND moi.is_deleted = 0
+				AND moi.hospital_id = ?
+`
+	err := db.connection.Get(&count, cmd, orderId, hospitalId)
+	return count > 0, err
+}
+
+func (db *adminOrderDatabase) ListBillsByOrderId(orderId int64) ([]*dto.TradeBill, error) {
+	output := make([]*dto.TradeBill, 0, 2)
+	cmd := `SELECT ` + billColumns + `
+			FROM mkb_trade_bill
+			WHERE order_id = ? AND is_deleted = 0
+			ORDER BY id`
+	err := db.connection.Select(&output, cmd, orderId)
+	return output, err
+}
+
+func (db *adminOrderDatabase) ListOrderNotes(orderId int64) ([]*dto.OrderNote, error) {
+	output := make([]*dto.OrderNote, 0, 4)
+	const cmd = `
+			SELECT
+				mon.id,
+				mon.order_id,
+				mon.staff_id,
+				IFNULL(ms.name, '') AS staff_name,
+				mon.content,
+				mon.create_time
+			FROM
+				mko_order_note AS mon
+				LEFT JOIN mka_staff AS ms
+					ON mon.staff_id = ms.id
+			WHERE
+				mon.order_id = ?
+			ORDER BY mon.id
+`
+	err := db.connection.Select(&output, cmd, orderId)
+	return output, err
+}
+
+func (db *adminOrderDatabase) SaveOrderNote(note *dto.OrderNote) (int64, error) {
+	const cmd = `
+			INSERT INTO mko_order_note (
+				order_id,
+				staff_id,
+				content,
+				create_time
+			) VALUES (
+				:order_id,
+				:staff_id,
+				:content,
+				:create_time
+			)
+`
+	rs, err := db.connection.NamedExec(cmd, note)
+	if err != nil {
+		return 0, err
+	}
+	return rs.LastInsertId()
+}
+
+func (db *adminOrderDatabase) FindAppointmentInfo(orderItemId int64) (*dto.AppointmentInfo, error) {
+	var output dto.AppointmentInfo
+	const cmd = `
+			SELECT
+				mo.id AS order_id,
+				mo.open_id,
+				moi.examine_date,
+				moi.hospital_id,
+				moi.hospital_name
+			FROM
+				mko_order_item AS moi
+				INNER JOIN mko_order AS mo
+					ON moi.order_id = mo.id
+			WHERE
+				moi.id = ?
+				AND moi.is_deleted = 0
+`
+	err := db.connection.Get(&output, cmd, orderItemId)
+	return &output, err
+}
+
+// 只有已付款订单中已选体检日期的订单项才能确认预约, ok 为 false 表示不满足条件或已确认过
+func (db *adminOrderDatabase) ConfirmAppointment(orderItemId int64, confirmTime int64) (ok bool, err error) {
+	const cmd = `
+			UPDATE mko_order_item AS moi
+				INNER JOIN mko_order AS mo
+					ON moi.order_id = mo.id
+			SET
+				moi.appointment_status = 1,
+				moi.appointment_confirm_time = ?,
+				moi.update_time = UNIX_TIMESTAMP(NOW())
+			WHERE
+				moi.id = ?
+				AND moi.examine_date > 0
+				AND moi.appointment_status = 0
+				AND moi.is_deleted = 0
+				AND mo.status = 2
+`
+	rs, err := db.connection.Exec(cmd, confirmTime, orderItemId)
+	if err != nil {
+		return false, err
+	}
+	rows, err := rs.RowsAffected()
+	return rows == 1, err
+}
+
+// 以 from 为条件修改退款申请的审核状态, ok 为 false 表示申请不存在或已被审核。
+// 同意退款(to 为 2)时订单还必须是已付款, 已变为待评价的订单不能再退款
+func (db *adminOrderDatabase) AuditRefund(orderId int64, from int8, to int8, remark string) (ok bool, err error) {
+	const cmd = `
+			UPDATE mko_order SET
+				refund_status = ?,
+				refund_audit_remark = ?,
+				update_time = UNIX_TIMESTAMP(NOW())
+			WHERE
+				id = ?
+				AND refund_status = ?
+				AND (? != 2 OR status = 2)
+				AND is_deleted = 0
+`
+	rs, err := db.connection.Exec(cmd, to, remark, orderId, from, to)
+	if err != nil {
+		return false, err
+	}
+	rows, err := rs.RowsAffected()
+	return rows == 1, err
+}
+
+func NewAdminOrderModel() AdminOrderModel {
+	return &adminOrderDatabase{connection: dao.Db}
+}
@@ -0,0 +1,120 @@
+package model
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"mk-api/server/dao"
+	"mk-api/server/dto"
+)
+
+// AuditModel 审计日志只追加, 不提供修改和删除
+type AuditModel interface {
+	SaveAuditLog(log *dto.AuditLog) error
+	ListAuditLog(input *dto.ListAuditLogInput) ([]*dto.AuditLog, error)
+}
+
+type auditDatabase struct {
+	connection *sqlx.DB
+}
+
+func (db *auditDatabase) SaveAuditLog(log *dto.AuditLog) error {
+	const cmd = `
+			INSERT INTO mka_audit_log (
+				actor_type,
+				actor_id,
+				action,
+				target_table,
+				target_id,
+				before_data,
+				after_data,
+				ip,
+				user_agent,
+				request,
+				create_time
+			) VALUES (
+				:actor_type,
+				:actor_id,
+				:action,
+				:target_table,
+				:target_id,
+				:before_data,
+				:after_data,
+				:ip,
+				:user_agent,
+				:request,
+				:create_time
+			)
+`
+	_, err := db.connection.NamedExec(cmd, log)
+	return err
+}
+
+func (db *auditDatabase) ListAuditLog(input *dto.ListAuditLogInput) ([]*dto.AuditLog, error) {
+	output := make([]*dto.AuditLog, 0, input.PageSize+1)
+	cmd := `
+			SELECT
+				id,
+				actor_type,
+				actor_id,
+				action,
+				target_table,
+				target_id,
+				before_data,
+				after_data,
+				ip,
+				user_agent,
+				request,
+				create_time
+			FROM
+				mka_audit_log
+			WHERE
+				1 = 1
+				%s
+			ORDER BY id DESC
+			LIMIT :offset, :limit
+`
+	whereStmt := ""
+	if input.ActorType != 0 {
+		whereStmt += " AND actor_type = :actor_type"
+	}
+	if input.ActorId != 0 {
+		whereStmt += " AND actor_id = :actor_id"
+	}
+	if input.Action != "" {
+		whereStmt += " AND action = :action"
+	}
+	if input.TargetTable != "" {
+		whereStmt += " AND target_table = :target_table"
+	}
+	if input.TargetId != 0 {
+		whereStmt += " AND target_id = :target_id"
+	}
+	if input.StartTime != 0 {
+		whereStmt += " AND create_time >= :start_time"
+	}
+	if input.EndTime != 0 {
+		whereStmt += " AND create_time < :end_time"
+	}
+	cmd = fmt.Sprintf(cmd, whereStmt)
+
+	input.Offset = (input.PageNo - 1) * input.PageSize
+	input.Limit = input.PageSize + 1
+	rows, err := db.connection.NamedQuery(cmd, input)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ele dto.AuditLog
+		if err = rows.StructScan(&ele); err != nil {
+			return nil, err
+		}
+		output = append(output, &ele)
+	}
+	return output, rows.Err()
+}
+
+func NewAuditModel() AuditModel {
+	return &auditDatabase{connection: dao.Db}
+}
@@ -0,0 +1,179 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+	"mk-api/server/dao"
+	"mk-api/server/dto"
+	"mk-api/server/util/xtime"
+)
+
+// ErrCapacityFull 所选日期名额已满, 由 Reserve 返回的 TxFunc 抛出以回滚整个事务
+var ErrCapacityFull = errors.New("capacity full")
+
+type CapacityModel interface {
+	FindPackageCapacity(pkgId int64) (*dto.PackageCapacity, error)
+	ListCapacityUsage(hospitalId int64, pkgId int64, from int64, to int64) ([]*dto.CapacityUsage, error)
+	Reserve(slots []*dto.CapacitySlot) TxFunc
+	Release(slots []*dto.CapacitySlot) TxFunc
+	ReleaseByOrderId(orderId int64) TxFunc
+}
+
+type capacityDatabase struct {
+	connection *sqlx.DB
+}
+
+func (db *capacityDatabase) FindPackageCapacity(pkgId int64) (*dto.PackageCapacity, error) {
+	var output dto.PackageCapacity
+	const cmd = `
+			SELECT
+				mp.id AS pkg_id,
+				mp.hospital_id,
+				mh.daily_capacity AS hospital_capacity,
+				mp.daily_capacity AS pkg_capacity,
+				mh.closed_weekdays
+			FROM
+				mkp_package AS mp
+				INNER JOIN mkh_hospital AS mh
+					ON mp.hospital_id = mh.id AND mh.is_deleted = 0
+			WHERE
+				mp.id = ?
+				AND mp.is_deleted = 0
+`
+	err := db.connection.Get(&output, cmd, pkgId)
+	return &output, err
+}
+
+// 查询机构合计及该套餐在 [from, to) 之间每天的已预约人数
+func (db *capacityDatabase) ListCapacityUsage(hospitalId int64, pkgId int64, from int64, to int64) ([]*dto.CapacityUsage, error) {
+	output := make([]*dto.CapacityUsage, 0, 62)
+	const cmd = `
+			SELECT
+				pkg_id,
+				examine_date,
+				booked
+			FROM
+				mkh_capacity_usage
+			WHERE
+				hospital_id = ?
+				AND pkg_id IN (0, ?)
+				AND examine_date >= ?
+				AND examine_date < ?
+`
+	err := db.connection.Select(&output, cmd, hospitalId, pkgId, from, to)
+	return output, err
+}
+
+// 占用名额, 机构合计和套餐各占一份, 任一超出名额时返回 ErrCapacityFull
+func (db *capacityDatabase) Reserve(slots []*dto.CapacitySlot) TxFunc {
+	return func(tx *sqlx.Tx) error {
+		for _, slot := range slots {
+			c := slot.Capacity
+			if err := reserve(tx, c.HospitalId, 0, slot.ExamineDate, slot.Count, c.HospitalCapacity); err != nil {
+				return err
+			}
+			if err := reserve(tx, c.HospitalId, c.PackageId, slot.ExamineDate, slot.Count, c.PackageCapacity); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func reserve(tx *sqlx.Tx, hospitalId, pkgId, examineDate, count, capacity int64) error {
+	const cmd1 = `
+			INSERT INTO mkh_capacity_usage (hospital_id, pkg_id, examine_date, booked, update_time)
+			VALUES (?, ?, ?, 0, UNIX_TIMESTAMP(NOW()))
+			ON DUPLICATE KEY UPDATE booked = booked
+`
+	if _, err := tx.Exec(cmd1, hospitalId, pkgId, examineDate); err != nil {
+		return err
+	}
+
+	// 以 booked 为条件原子地加上预约人数, 多实例并发时不会超卖
+	const cmd2 = `
+			UPDATE mkh_capacity_usage SET
+				booked = booked + ?,
+				update_time = UNIX_TIMESTAMP(NOW())
+			WHERE
+				hospital_id = ?
+				AND pkg_id = ?
+				AND examine_date = ?
+				AND (? = 0 OR booked + ? <= ?)
+`
+	rs, err := tx.Exec(cmd2, count, hospitalId, pkgId, examineDate, capacity, count, capacity)
+	if err != nil {
+		return err
+	}
+	rows, err := rs.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows != 1 {
+		return ErrCapacityFull
+	}
+	return nil
+}
+
+func (db *capacityDatabase) Release(slots []*dto.CapacitySlot) TxFunc {
+	return func(tx *sqlx.Tx) error {
+		for _, slot := range slots {
+			if err := release(tx, slot.Capacity.HospitalId, slot.Capacity.PackageId, slot.ExamineDate, slot.Count); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// 释放订单所有已选日期的订单项占用的名额, 随订单关闭或退款一起提交, 见 OrderStateMachine
+func (db *capacityDatabase) ReleaseByOrderId(orderId int64) TxFunc {
+	return func(tx *sqlx.Tx) error {
+		usages := make([]*dto.OrderCapacityUsage, 0, 4)
+		const cmd = `
+			SELECT
+				moi.hospital_id,
+				moi.pkg_id,
+				moi.examine_date,
+				COUNT(*) AS count
+			FROM
+				mko_order_item AS moi
+			WHERE
+				moi.order_id = ?
+				AND moi.examine_date > 0
+				AND moi.is_deleted = 0
+			GROUP BY
+				moi.hospital_id, moi.pkg_id, moi.examine_date
+`
+		if err := tx.Select(&usages, cmd, orderId); err != nil {
+			return err
+		}
+		for _, u := range usages {
+			if err := release(tx, u.HospitalId, u.PackageId, u.ExamineDate, u.Count); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// 名额按当天零点记录, 历史订单项的体检日期不一定是零点, 释放时统一成当天零点
+func release(tx *sqlx.Tx, hospitalId, pkgId, examineDate, count int64) error {
+	examineDate = xtime.DayStartAt(examineDate)
+	const cmd = `
+			UPDATE mkh_capacity_usage SET
+				booked = GREATEST(CAST(booked AS SIGNED) - ?, 0),
+				update_time = UNIX_TIMESTAMP(NOW())
+			WHERE
+				hospital_id = ?
+				AND pkg_id IN (0, ?)
+				AND examine_date = ?
+`
+	_, err := tx.Exec(cmd, count, hospitalId, pkgId, examineDate)
+	return err
+}
+
+func NewCapacityModel() CapacityModel {
+	return &capacityDatabase{connection: dao.Db}
+}
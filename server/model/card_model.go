@@ -0,0 +1,269 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+	"mk-api/server/dao"
+	"mk-api/server/dto"
+	"mk-api/server/util"
+)
+
+// ErrCardUsed 体检卡已被使用或已作废, 由 Redeem 返回的 TxFunc 抛出以回滚整个事务
+var ErrCardUsed = errors.New("card used")
+
+type CardModel interface {
+	// 创建批次并写入该批次的所有卡号
+	SaveCardBatch(batch *dto.CardBatch, codes []string) (int64, error)
+	FindCardBatchById(id int64) (*dto.CardBatch, error)
+	ListCardBatches(input *dto.ListCardBatchInput) ([]*dto.CardBatch, error)
+	ListCardsByBatchId(batchId int64) ([]*dto.Card, error)
+	// 作废批次及其中未使用的卡, 已使用的卡不受影响
+	VoidCardBatch(batchId int64, updateTime int64) (bool, error)
+	FindRedeemableCard(code string) (*dto.RedeemableCard, error)
+	// 下单时使用体检卡, 与 out_trade_no 对应的订单在同一事务中提交
+	Redeem(cardId int64, userId int64, outTradeNo string, redeemTime int64) TxFunc
+	// 订单关闭或退款时退回体检卡, 所在批次已作废的退回为已作废, 见 OrderStateMachine
+	ReleaseByOrderId(orderId int64) TxFunc
+}
+
+type cardDatabase struct {
+	connection *sqlx.DB
+}
+
+func (db *cardDatabase) SaveCardBatch(batch *dto.CardBatch, codes []string) (id int64, err error) {
+	tx, err := db.connection.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	const cmd1 = `
+			INSERT INTO mkc_card_batch (
+				name,
+				company,
+				type,
+				pkg_id,
+				value,
+				quantity,
+				valid_start,
+				valid_end,
+				status,
+				staff_id,
+				create_time,
+				update_time
+			) VALUES (
+				:name,
+				:company,
+				:type,
+				:pkg_id,
+				:value,
+				:quantity,
+				:valid_start,
+				:valid_end,
+				:status,
+				:staff_id,
+				:create_time,
+				:create_time
+			)
+`
+	rs, err := tx.NamedExec(cmd1, batch)
+	if err != nil {
+		return 0, err
+	}
+	if id, err = rs.LastInsertId(); err != nil {
+		return 0, err
+	}
+
+	cards := make([]*dto.Card, 0, len(codes))
+	for _, code := range codes {
+		cards = append(cards, &dto.Card{BatchId: id, Code: code})
+	}
+	// 分批插入, 避免单条语句的占位符过多
+	const cmd2 = `INSERT INTO mkc_card (batch_id, code) VALUES (:batch_id, :code)`
+	for start := 0; start < len(cards); start += 1000 {
+		end := start + 1000
+		if end > len(cards) {
+			end = len(cards)
+		}
+		if _, err = tx.NamedExec(cmd2, cards[start:end]); err != nil {
+			return 0, err
+		}
+	}
+	return id, nil
+}
+
+const cardBatchColumns = `
+				mcb.id,
+				mcb.name,
+				mcb.company,
+				mcb.type,
+				mcb.pkg_id,
+				mcb.value,
+				mcb.quantity,
+				(SELECT COUNT(*) FROM mkc_card AS mc WHERE mc.batch_id = mcb.id AND mc.status = 1) AS redeemed,
+				mcb.valid_start,
+				mcb.valid_end,
+				mcb.status,
+				mcb.staff_id,
+				mcb.create_time`
+
+func (db *cardDatabase) FindCardBatchById(id int64) (*dto.CardBatch, error) {
+	var output dto.CardBatch
+	cmd := `SELECT ` + cardBatchColumns + ` FROM mkc_card_batch AS mcb WHERE mcb.id = ?`
+	err := db.connection.Get(&output, cmd, id)
+	return &output, err
+}
+
+func (db *cardDatabase) ListCardBatches(input *dto.ListCardBatchInput) ([]*dto.CardBatch, error) {
+	output := make([]*dto.CardBatch, 0, input.PageSize+1)
+	cmd := `SELECT ` + cardBatchColumns + `
+			FROM
+				mkc_card_batch AS mcb
+			WHERE
+				(? = '' OR mcb.company LIKE CONCAT('%', ?, '%'))
+			ORDER BY mcb.id DESC
+			LIMIT ?, ?
+`
+	err := db.connection.Select(&output, cmd, input.Company, input.Company,
+		(input.PageNo-1)*input.PageSize, input.PageSize+1)
+	return output, err
+}
+
+func (db *cardDatabase) ListCardsByBatchId(batchId int64) ([]*dto.Card, error) {
+	output := make([]*dto.Card, 0, 64)
+	const cmd = `
+			SELECT
+				id,
+				batch_id,
+				code,
+				status,
+				user_id,
+				order_id,
+				redeem_time
+			FROM
+				mkc_card
+			WHERE
+				batch_id = ?
+			ORDER BY id
+`
+	err := db.connection.Select(&output, cmd, batchId)
+	return output, err
+}
+
+func (db *cardDatabase) VoidCardBatch(batchId int64, updateTime int64) (ok bool, err error) {
+	tx, err := db.connection.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err != nil || !ok {
+			_ = tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	const cmd1 = `UPDATE mkc_card_batch SET status = 2, update_time = ? WHERE id = ? AND status = 1`
+	rs, err := tx.Exec(cmd1, updateTime, batchId)
+	if err != nil {
+		return false, err
+	}
+	if rows, err := rs.RowsAffected(); err != nil || rows != 1 {
+		return false, err
+	}
+	const cmd2 = `UPDATE mkc_card SET status = 2 WHERE batch_id = ? AND status = 0`
+	if _, err = tx.Exec(cmd2, batchId); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (db *cardDatabase) FindRedeemableCard(code string) (*dto.RedeemableCard, error) {
+	var output dto.RedeemableCard
+	const cmd = `
+			SELECT
+				mc.id,
+				mc.status,
+				mcb.type,
+				mcb.pkg_id,
+				mcb.value,
+				mcb.valid_start,
+				mcb.valid_end,
+				mcb.status AS batch_status
+			FROM
+				mkc_card AS mc
+				INNER JOIN mkc_card_batch AS mcb
+					ON mc.batch_id = mcb.id
+			WHERE
+				mc.code = ?
+`
+	err := db.connection.Get(&output, cmd, code)
+	return &output, err
+}
+
+func (db *cardDatabase) Redeem(cardId int64, userId int64, outTradeNo string, redeemTime int64) TxFunc {
+	return func(tx *sqlx.Tx) error {
+		const cmd = `
+			UPDATE mkc_card AS mc
+				INNER JOIN mko_order AS mo
+					ON mo.out_trade_no = ?
+			SET
+				mc.status = 1,
+				mc.user_id = ?,
+				mc.order_id = mo.id,
+				mc.redeem_time = ?
+			WHERE
+				mc.id = ?
+				AND mc.status = 0
+`
+		rs, err := tx.Exec(cmd, outTradeNo, userId, redeemTime, cardId)
+		if err != nil {
+			return err
+		}
+		rows, err := rs.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows != 1 {
+			return ErrCardUsed
+		}
+		return nil
+	}
+}
+
+func (db *cardDatabase) ReleaseByOrderId(orderId int64) TxFunc {
+	return func(tx *sqlx.Tx) error {
+		const cmd = `
+			UPDATE mkc_card AS mc
+				INNER JOIN mkc_card_batch AS mcb
+					ON mc.batch_id = mcb.id
+			SET
+				mc.status = IF(mcb.status = 2, 2, 0),
+				mc.user_id = 0,
+				mc.order_id = 0,
+				mc.redeem_time = 0
+			WHERE
+				mc.order_id = ?
+				AND mc.status = 1
+`
+		rs, err := tx.Exec(cmd, orderId)
+		if err != nil {
+			return err
+		}
+		if rows, _ := rs.RowsAffected(); rows > 0 {
+			util.Log.Infof("订单 [%d] 关闭或退款, 退回体检卡", orderId)
+		}
+		return nil
+	}
+}
+
+func NewCardModel() CardModel {
+	return &cardDatabase{connection: dao.Db}
+}
@@ -11,15 +11,15 @@ type CartModel interface {
 	IncrementPkgCount(id int64, pkgCount int64) (err error)
 	FindCartItemId(userId int64, pkgId int64) (id int64)
 	CreateCart(userId int64, pkgId int64, pkgCount int64) (err error)
-	RemoveCartEntries(ids []int64) error
+	RemoveCartEntries(userId int64, ids []int64) error
 }
 
 type cartDatabase struct {
 	connection *sqlx.DB
 }
 
-func (db *cartDatabase) RemoveCartEntries(ids []int64) error {
-	cmd, args, err := sqlx.In(`UPDATE mko_cart SET is_deleted = 1 WHERE id IN (?)`, ids)
+func (db *cartDatabase) RemoveCartEntries(userId int64, ids []int64) error {
+	cmd, args, err := sqlx.In(`UPDATE mko_cart SET is_deleted = 1 WHERE user_id = ? AND id IN (?)`, userId, ids)
 	cmd = db.connection.Rebind(cmd)
 	_, err = db.connection.Exec(cmd, args...)
 	return err
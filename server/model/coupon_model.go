@@ -0,0 +1,251 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+	"mk-api/server/dao"
+	"mk-api/server/dto"
+	"mk-api/server/util"
+)
+
+var (
+	// ErrCouponSoldOut 优惠券已经领完
+	ErrCouponSoldOut = errors.New("coupon sold out")
+	// ErrCouponLimitExceeded 超出每人限领数量
+	ErrCouponLimitExceeded = errors.New("coupon per user limit exceeded")
+	// ErrCouponUsed 优惠券已被使用, 由 Use 返回的 TxFunc 抛出以回滚整个事务
+	ErrCouponUsed = errors.New("coupon used")
+)
+
+type CouponModel interface {
+	FindCouponByCode(code string) (*dto.Coupon, error)
+	SaveCoupon(c *dto.Coupon) (int64, error)
+	// 领取优惠券, 并发领取时以锁住的优惠券行保证不超发、不超出每人限领
+	Claim(couponId int64, userId int64, claimTime int64) (int64, error)
+	ListUserCoupons(userId int64, status int8) ([]*dto.UserCoupon, error)
+	FindUserCoupon(id int64, userId int64) (*dto.UserCoupon, error)
+	// 下单时使用优惠券, 与 out_trade_no 对应的订单在同一事务中提交
+	Use(userCouponId int64, outTradeNo string, useTime int64) TxFunc
+	// 订单关闭或退款时退回优惠券, 见 OrderStateMachine
+	ReleaseByOrderId(orderId int64) TxFunc
+}
+
+type couponDatabase struct {
+	connection *sqlx.DB
+}
+
+const couponColumns = `
+				id,
+				name,
+				code,
+				type,
+				amount,
+				rate,
+				threshold,
+				max_discount,
+				scope_type,
+				scope_id,
+				valid_start,
+				valid_end,
+				total,
+				claimed,
+				per_user_limit,
+				status,
+				create_time`
+
+func (db *couponDatabase) FindCouponByCode(code string) (*dto.Coupon, error) {
+	var output dto.Coupon
+	cmd := `SELECT ` + couponColumns + ` FROM mkc_coupon WHERE code = ? AND is_deleted = 0`
+	err := db.connection.Get(&output, cmd, code)
+	return &output, err
+}
+
+func (db *couponDatabase) SaveCoupon(c *dto.Coupon) (int64, error) {
+	const cmd = `
+			INSERT INTO mkc_coupon (
+				name,
+				code,
+				type,
+				amount,
+				rate,
+				threshold,
+				max_discount,
+				scope_type,
+				scope_id,
+				valid_start,
+				valid_end,
+				total,
+				per_user_limit,
+				status,
+				create_time,
+				update_time
+			) VALUES (
+				:name,
+				:code,
+				:type,
+				:amount,
+				:rate,
+				:threshold,
+				:max_discount,
+				:scope_type,
+				:scope_id,
+				:valid_start,
+				:valid_end,
+				:total,
+				:per_user_limit,
+				:status,
+				:create_time,
+				:create_time
+			)
+`
+	rs, err := db.connection.NamedExec(cmd, c)
+	if err != nil {
+		return 0, err
+	}
+	return rs.LastInsertId()
+}
+
+func (db *couponDatabase) Claim(couponId int64, userId int64, claimTime int64) (id int64, err error) {
+	tx, err := db.connection.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	var c dto.Coupon
+	const cmd1 = `SELECT id, total, claimed, per_user_limit FROM mkc_coupon WHERE id = ? FOR UPDATE`
+	if err = tx.Get(&c, cmd1, couponId); err != nil {
+		return 0, err
+	}
+	if c.Total > 0 && c.Claimed >= c.Total {
+		return 0, ErrCouponSoldOut
+	}
+	if c.PerUserLimit > 0 {
+		var count int64
+		const cmd2 = `SELECT COUNT(*) FROM mkc_user_coupon WHERE user_id = ? AND coupon_id = ?`
+		if err = tx.Get(&count, cmd2, userId, couponId); err != nil {
+			return 0, err
+		}
+		if count >= c.PerUserLimit {
+			return 0, ErrCouponLimitExceeded
+		}
+	}
+
+	const cmd3 = `INSERT INTO mkc_user_coupon (coupon_id, user_id, claim_time) VALUES (?, ?, ?)`
+	rs, err := tx.Exec(cmd3, couponId, userId, claimTime)
+	if err != nil {
+		return 0, err
+	}
+	if id, err = rs.LastInsertId(); err != nil {
+		return 0, err
+	}
+	const cmd4 = `UPDATE mkc_coupon SET claimed = claimed + 1, update_time = UNIX_TIMESTAMP(NOW()) WHERE id = ?`
+	_, err = tx.Exec(cmd4, couponId)
+	return id, err
+}
+
+const userCouponColumns = `
+				muc.id,
+				muc.coupon_id,
+				muc.user_id,
+				muc.status,
+				muc.order_id,
+				muc.claim_time,
+				muc.use_time,
+				mc.name,
+				mc.type,
+				mc.amount,
+				mc.rate,
+				mc.threshold,
+				mc.max_discount,
+				mc.scope_type,
+				mc.scope_id,
+				mc.valid_start,
+				mc.valid_end,
+				mc.status AS coupon_status`
+
+func (db *couponDatabase) ListUserCoupons(userId int64, status int8) ([]*dto.UserCoupon, error) {
+	output := make([]*dto.UserCoupon, 0, 8)
+	cmd := `SELECT ` + userCouponColumns + `
+			FROM
+				mkc_user_coupon AS muc
+				INNER JOIN mkc_coupon AS mc
+					ON muc.coupon_id = mc.id
+			WHERE
+				muc.user_id = ?
+				AND (? = -1 OR muc.status = ?)
+			ORDER BY muc.id DESC
+			LIMIT 200
+`
+	err := db.connection.Select(&output, cmd, userId, status, status)
+	return output, err
+}
+
+func (db *couponDatabase) FindUserCoupon(id int64, userId int64) (*dto.UserCoupon, error) {
+	var output dto.UserCoupon
+	cmd := `SELECT ` + userCouponColumns + `
+			FROM
+				mkc_user_coupon AS muc
+				INNER JOIN mkc_coupon AS mc
+					ON muc.coupon_id = mc.id
+			WHERE
+				muc.id = ?
+				AND muc.user_id = ?
+`
+	err := db.connection.Get(&output, cmd, id, userId)
+	return &output, err
+}
+
+func (db *couponDatabase) Use(userCouponId int64, outTradeNo string, useTime int64) TxFunc {
+	return func(tx *sqlx.Tx) error {
+		const cmd = `
+			UPDATE mkc_user_coupon AS muc
+				INNER JOIN mko_order AS mo
+					ON mo.out_trade_no = ?
+			SET
+				muc.status = 1,
+				muc.order_id = mo.id,
+				muc.use_time = ?
+			WHERE
+				muc.id = ?
+				AND muc.status = 0
+`
+		rs, err := tx.Exec(cmd, outTradeNo, useTime, userCouponId)
+		if err != nil {
+			return err
+		}
+		rows, err := rs.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows != 1 {
+			return ErrCouponUsed
+		}
+		return nil
+	}
+}
+
+func (db *couponDatabase) ReleaseByOrderId(orderId int64) TxFunc {
+	return func(tx *sqlx.Tx) error {
+		const cmd = `UPDATE mkc_user_coupon SET status = 0, order_id = 0, use_time = 0 WHERE order_id = ? AND status = 1`
+		rs, err := tx.Exec(cmd, orderId)
+		if err != nil {
+			return err
+		}
+		if rows, _ := rs.RowsAffected(); rows > 0 {
+			util.Log.Infof("订单 [%d] 关闭或退款, 退回优惠券", orderId)
+		}
+		return nil
+	}
+}
+
+func NewCouponModel() CouponModel {
+	return &couponDatabase{connection: dao.Db}
+}
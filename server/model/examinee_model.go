@@ -8,6 +8,7 @@ import (
 
 type ExamineeModel interface {
 	FindExamineesByUserId(userId int64) ([]*dto.ListExamineeOutputEle, error)
+	FindExamineeByIdNUserId(id int64, userId int64) (*dto.ExamineeBean, error)
 	SaveExaminee(examinee *dto.ExamineeBean) (id int64, err error)
 	DeleteExamineeByIdNUserId(id int64, userId int64) error
 	UpdateExaminee(bean *dto.ExamineeBean) error
@@ -61,6 +62,29 @@ func (db *examineeDatabase) FindExamineesByUserId(userId int64) ([]*dto.ListExam
 	return output, err
 }
 
+func (db *examineeDatabase) FindExamineeByIdNUserId(id int64, userId int64) (*dto.ExamineeBean, error) {
+	var output dto.ExamineeBean
+	const cmd = `SELECT
+					id
+					,user_id
+					,examinee_name
+					,relation
+					,id_card_no
+					,is_married
+					,gender
+					,examinee_mobile
+					,create_time
+					,update_time
+				FROM mku_examinee
+				WHERE
+					id = ?
+					AND user_id = ?
+					AND is_deleted = 0
+`
+	err := db.connection.Get(&output, cmd, id, userId)
+	return &output, err
+}
+
 func (db *examineeDatabase) SaveExaminee(examinee *dto.ExamineeBean) (id int64, err error) {
 	const cmd = `
 			INSERT INTO mku_examinee (
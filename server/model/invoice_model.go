@@ -0,0 +1,211 @@
+package model
+
+import (
+	"github.com/jmoiron/sqlx"
+	"mk-api/server/dao"
+	"mk-api/server/dto"
+)
+
+type InvoiceModel interface {
+	FindInvoicableOrder(orderId int64, userId int64) (*dto.InvoicableOrder, error)
+	FindInvoiceById(id int64) (*dto.Invoice, error)
+	FindInvoiceByOrderId(orderId int64) (*dto.Invoice, error)
+	// 订单已有发票申请时因 uk_order_id 插入失败, 保证每个订单只开一张发票
+	SaveInvoice(invoice *dto.Invoice) (int64, error)
+	// 重新提交被驳回的申请, 申请不是已驳回状态时返回 false
+	ResubmitInvoice(invoice *dto.Invoice) (bool, error)
+	ListInvoices(input *dto.ListInvoiceInput) ([]*dto.Invoice, error)
+	// 开具和驳回都只处理待开具的申请, 并发处理时只有一个成功
+	IssueInvoice(id int64, pdfUrl string, staffId int64, issueTime int64) (bool, error)
+	RejectInvoice(id int64, remark string, staffId int64, updateTime int64) (bool, error)
+}
+
+type invoiceDatabase struct {
+	connection *sqlx.DB
+}
+
+func (db *invoiceDatabase) FindInvoicableOrder(orderId int64, userId int64) (*dto.InvoicableOrder, error) {
+	var output dto.InvoicableOrder
+	const cmd = `
+			SELECT
+				id,
+				status,
+				refund_status,
+				amount
+			FROM
+				mko_order
+			WHERE
+				id = ?
+				AND user_id = ?
+				AND is_deleted = 0
+`
+	err := db.connection.Get(&output, cmd, orderId, userId)
+	return &output, err
+}
+
+const invoiceColumns = `
+				mi.id,
+				mi.order_id,
+				mo.out_trade_no,
+				mi.user_id,
+				mo.open_id,
+				mi.title_type,
+				mi.title,
+				mi.tax_no,
+				mi.email,
+				mi.amount,
+				mi.status,
+				mi.pdf_url,
+				mi.remark,
+				mi.staff_id,
+				mi.issue_time,
+				mi.create_time,
+				mi.update_time`
+
+func (db *invoiceDatabase) FindInvoiceById(id int64) (*dto.Invoice, error) {
+	var output dto.Invoice
+	cmd := `SELECT ` + invoiceColumns + `
+			FROM
+				mko_invoice AS mi
+				INNER JOIN mko_order AS mo
+					ON mi.order_id = mo.id
+			WHERE
+				mi.id = ?
+`
+	err := db.connection.Get(&output, cmd, id)
+	return &output, err
+}
+
+func (db *invoiceDatabase) FindInvoiceByOrderId(orderId int64) (*dto.Invoice, error) {
+	var output dto.Invoice
+	cmd := `SELECT ` + invoiceColumns + `
+			FROM
+				mko_invoice AS mi
+				INNER JOIN mko_order AS mo
+					ON mi.order_id = mo.id
+			WHERE
+				mi.order_id = ?
+`
+	err := db.connection.Get(&output, cmd, orderId)
+	return &output, err
+}
+
+func (db *invoiceDatabase) SaveInvoice(invoice *dto.Invoice) (int64, error) {
+	const cmd = `
+			INSERT INTO mko_invoice (
+				order_id,
+				user_id,
+				title_type,
+				title,
+				tax_no,
+				email,
+				amount,
+				status,
+				create_time,
+				update_time
+			) VALUES (
+				:order_id,
+				:user_id,
+				:title_type,
+				:title,
+				:tax_no,
+				:email,
+				:amount,
+				:status,
+				:create_time,
+				:update_time
+			)
+`
+	rs, err := db.connection.NamedExec(cmd, invoice)
+	if err != nil {
+		return 0, err
+	}
+	return rs.LastInsertId()
+}
+
+func (db *invoiceDatabase) ResubmitInvoice(invoice *dto.Invoice) (bool, error) {
+	const cmd = `
+			UPDATE mko_invoice
+			SET
+				title_type = :title_type,
+				title = :title,
+				tax_no = :tax_no,
+				email = :email,
+				amount = :amount,
+				status = 0,
+				remark = '',
+				update_time = :update_time
+			WHERE
+				order_id = :order_id
+				AND status = 2
+`
+	rs, err := db.connection.NamedExec(cmd, invoice)
+	if err != nil {
+		return false, err
+	}
+	rows, err := rs.RowsAffected()
+	return rows == 1, err
+}
+
+func (db *invoiceDatabase) ListInvoices(input *dto.ListInvoiceInput) ([]*dto.Invoice, error) {
+	output := make([]*dto.Invoice, 0, input.PageSize+1)
+	cmd := `SELECT ` + invoiceColumns + `
+			FROM
+				mko_invoice AS mi
+				INNER JOIN mko_order AS mo
+					ON mi.order_id = mo.id
+			WHERE
+				(? = -1 OR mi.status = ?)
+				AND (? = 0 OR mi.user_id = ?)
+			ORDER BY mi.id DESC
+			LIMIT ?, ?
+`
+	err := db.connection.Select(&output, cmd, input.Status, input.Status, input.UserId, input.UserId,
+		(input.PageNo-1)*input.PageSize, input.PageSize+1)
+	return output, err
+}
+
+func (db *invoiceDatabase) IssueInvoice(id int64, pdfUrl string, staffId int64, issueTime int64) (bool, error) {
+	const cmd = `
+			UPDATE mko_invoice
+			SET
+				status = 1,
+				pdf_url = ?,
+				staff_id = ?,
+				issue_time = ?,
+				update_time = ?
+			WHERE
+				id = ?
+				AND status = 0
+`
+	rs, err := db.connection.Exec(cmd, pdfUrl, staffId, issueTime, issueTime, id)
+	if err != nil {
+		return false, err
+	}
+	rows, err := rs.RowsAffected()
+	return rows == 1, err
+}
+
+func (db *invoiceDatabase) RejectInvoice(id int64, remark string, staffId int64, updateTime int64) (bool, error) {
+	const cmd = `
+			UPDATE mko_invoice
+			SET
+				status = 2,
+				remark = ?,
+				staff_id = ?,
+				update_time = ?
+			WHERE
+				id = ?
+				AND status = 0
+`
+	rs, err := db.connection.Exec(cmd, remark, staffId, updateTime, id)
+	if err != nil {
+		return false, err
+	}
+	rows, err := rs.RowsAffected()
+	return rows == 1, err
+}
+
+func NewInvoiceModel() InvoiceModel {
+	return &invoiceDatabase{connection: dao.Db}
+}
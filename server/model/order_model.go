@@ -11,24 +11,77 @@ import (
 )
 
 type OrderModel interface {
-	SaveOrder(order *dto.Order, items []*dto.OrderItem) (id int64, err error)
-	UpdateOrderStatus(outTradeNo string, status int8) (err error)
+	SaveOrder(order *dto.Order, items []*dto.OrderItem, extras ...TxFunc) (id int64, err error)
 	ListOrder(input *dto.ListOrderInput, userId int64) ([]*dto.ListOrderOutputEle, error)
+	// 用户侧的查询和修改都带上 user_id, 其他用户的订单按不存在处理
+	FindOrderDetailByIdNUserId(id int64, userId int64, pkgModel PackageModel) (*dto.RetrieveOrderOutput, error)
+	// 后台查看订单详情, 不校验订单归属
 	FindOrderDetailById(id int64, pkgModel PackageModel) (*dto.RetrieveOrderOutput, error)
-	DeleteOrderByIdNUserId(userId int64, id int64) error
-	FindOrderPayStatusById(orderId int64) (*dto.OrderPayStatus, error)
-	UpdateOrderItem(input *dto.PutOrderItemInput) error
-	CancelOrder(input *dto.CancelOrderInput) error
+	DeleteOrderByIdNUserId(userId int64, id int64) (ok bool, err error)
+	IsOrderOwnedBy(orderId int64, userId int64) (bool, error)
+	FindOrderPayStatusByIdNUserId(orderId int64, userId int64) (*dto.OrderPayStatus, error)
+	FindOrderItemScheduleByIdNUserId(orderItemId int64, userId int64) (*dto.OrderItemSchedule, error)
+	UpdateOrderItem(input *dto.PutOrderItemInput, schedule *dto.OrderItemSchedule, log *dto.OrderItemRescheduleLog, extras ...TxFunc) (ok bool, err error)
+	SaveCancelReason(input *dto.CancelOrderInput) TxFunc
 	RefundOrder(input *dto.RefundOrderInput) (int64, error)
 	FindOutTradeNoByOrderId(orderId int64) (string, error)
-	FindRefundReasonIdByOrderId(orderId int64) int64
 	FindOrderInfo2NotifyClientByOutTradeNo(outTradeNo string) *dto.OInfo4PaidNotify
+	FindExpiredOrders(now int64, expireIn int64, afterId int64, limit int) ([]*dto.ExpiredOrder, error)
+	FindRefundableOrderItems(orderId int64) ([]*dto.RefundableOrderItem, error)
 }
 
 type orderDatabase struct {
 	connection *sqlx.DB
 }
 
+// 查找超时未支付的订单, 有支付流水的以流水的time_expire为准, 没有的(统一下单失败)以下单时间加expireIn为准
+func (db *orderDatabase) FindExpiredOrders(now int64, expireIn int64, afterId int64, limit int) ([]*dto.ExpiredOrder, error) {
+	output := make([]*dto.ExpiredOrder, 0, limit)
+	const cmd = `
+			SELECT
+				mo.id AS order_id,
+				mo.out_trade_no,
+				mo.open_id,
+				IFNULL(mb.id, 0) AS bill_id
+			FROM
+				mko_order AS mo
+				LEFT JOIN mkb_trade_bill AS mb
+					ON mb.order_id = mo.id
+					AND mb.fee_type = 1
+					AND mb.is_deleted = 0
+			WHERE
+				mo.id > ?
+				AND mo.status = 0
+				AND IFNULL(mb.time_expire, mo.create_time + ?) < ?
+			ORDER BY mo.id
+			LIMIT ?
+`
+	err := db.connection.Select(&output, cmd, afterId, expireIn, now, limit)
+	return output, err
+}
+
+func (db *orderDatabase) FindRefundableOrderItems(orderId int64) ([]*dto.RefundableOrderItem, error) {
+	output := make([]*dto.RefundableOrderItem, 0)
+	const cmd = `
+			SELECT
+				id AS order_item_id,
+				pkg_price,
+				discount,
+				card_amount,
+				examine_date,
+				reschedule_count,
+				appointment_status
+			FROM
+				mko_order_item
+			WHERE
+				order_id = ?
+				AND is_deleted = 0
+			ORDER BY id
+`
+	err := db.connection.Select(&output, cmd, orderId)
+	return output, err
+}
+
 func (db *orderDatabase) FindOrderInfo2NotifyClientByOutTradeNo(outTradeNo string) *dto.OInfo4PaidNotify {
 	var output dto.OInfo4PaidNotify
 	const cmd = `SELECT id, open_id, out_trade_no, amount FROM mko_order WHERE out_trade_no = ? AND is_deleted = 0`
@@ -36,13 +89,6 @@ func (db *orderDatabase) FindOrderInfo2NotifyClientByOutTradeNo(outTradeNo strin
 	return &output
 }
 
-func (db *orderDatabase) FindRefundReasonIdByOrderId(orderId int64) int64 {
-	var refundReasonId int64
-	const cmd = `SELECT refund_reason_id FROM mko_order WHERE id = ? AND is_deleted = 0`
-	_ = db.connection.Get(&refundReasonId, cmd, orderId)
-	return refundReasonId
-}
-
 func (db *orderDatabase) FindOutTradeNoByOrderId(orderId int64) (string, error) {
 	var outTradeNo string
 	const cmd = `SELECT out_trade_no FROM mko_order WHERE id = ? AND is_deleted = 0`
@@ -50,12 +96,14 @@ func (db *orderDatabase) FindOutTradeNoByOrderId(orderId int64) (string, error)
 	return outTradeNo, err
 }
 
+// 提交退款申请, 被拒绝后可以再次申请, 返回 0 表示订单未付款或已有申请
 func (db *orderDatabase) RefundOrder(input *dto.RefundOrderInput) (int64, error) {
 	const cmd = `
 			UPDATE mko_order SET 
 				refund_reason_id = :refund_reason_id,
-				refund_reason_remark = :refund_reason_remark
-			WHERE id = :id AND is_deleted = 0 AND status = 2
+				refund_reason_remark = :refund_reason_remark,
+				refund_status = 1
+			WHERE id = :id AND user_id = :user_id AND is_deleted = 0 AND status = 2 AND refund_status IN (0, 3)
 `
 	rs, err := db.connection.NamedExec(cmd, input)
 	if err != nil {
@@ -71,42 +119,143 @@ func (db *orderDatabase) RefundOrder(input *dto.RefundOrderInput) (int64, error)
 
 }
 
-func (db *orderDatabase) CancelOrder(input *dto.CancelOrderInput) error {
-	cmd := `
+// 取消原因随订单关闭一起提交, 见 OrderStateMachine
+func (db *orderDatabase) SaveCancelReason(input *dto.CancelOrderInput) TxFunc {
+	return func(tx *sqlx.Tx) error {
+		cmd := `
 			UPDATE mko_order SET
-			status = 4,
 			cancel_reason_id = :cancel_reason_id,
 			%s
 			update_time = UNIX_TIMESTAMP(NOW())
 			WHERE 
-			id = :id AND status = 0 AND is_deleted = 0
+			id = :id AND user_id = :user_id AND is_deleted = 0
 `
-	remarkStmt := ""
-	if input.Remark != "" {
-		remarkStmt = "remark = :remark, "
+		remarkStmt := ""
+		if input.Remark != "" {
+			remarkStmt = "remark = :remark, "
+		}
+		cmd = fmt.Sprintf(cmd, remarkStmt)
+		_, err := tx.NamedExec(cmd, input)
+		return err
 	}
-	cmd = fmt.Sprintf(cmd, remarkStmt)
-	_, err := db.connection.NamedExec(cmd, input)
-	return err
 }
 
-func (db *orderDatabase) UpdateOrderItem(input *dto.PutOrderItemInput) error {
+func (db *orderDatabase) FindOrderItemScheduleByIdNUserId(orderItemId int64, userId int64) (*dto.OrderItemSchedule, error) {
+	var output dto.OrderItemSchedule
 	const cmd = `
+			SELECT
+				moi.id AS order_item_id,
+				moi.order_id,
+				moi.pkg_id,
+				moi.examine_date,
+				moi.reschedule_count,
+				mo.status AS order_status,
+				moi.examinee_name,
+				moi.examinee_mobile,
+				moi.id_card_no,
+				moi.gender,
+				moi.is_married
+			FROM
+				mko_order_item AS moi
+				INNER JOIN mko_order AS mo
+					ON moi.order_id = mo.id
+			WHERE
+				moi.id = ?
+				AND moi.user_id = ?
+				AND moi.is_deleted = 0
+`
+	err := db.connection.Get(&output, cmd, orderItemId, userId)
+	return &output, err
+}
+
+// 修改体检人信息, 以 schedule 中的体检日期和改期次数为条件更新, ok 为 false 表示订单项已经被修改过。
+// log 不为空时表示改期, 改期次数加一并写入改期记录。 体检日期变化后需要客服重新确认预约
+func (db *orderDatabase) UpdateOrderItem(input *dto.PutOrderItemInput, schedule *dto.OrderItemSchedule,
+	log *dto.OrderItemRescheduleLog, extras ...TxFunc) (ok bool, err error) {
+	tx, err := db.connection.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		} else if err != nil || !ok {
+			_ = tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	increment := 0
+	if log != nil {
+		increment = 1
+	}
+	const cmd1 = `
 				UPDATE mko_order_item SET 
-					examinee_name = :examinee_name,
-					examinee_mobile = :examinee_mobile,
-					id_card_no = :id_card_no,
-					gender = :gender,
-					is_married = :is_married,
-					examine_date = :examine_date,
+					examinee_name = ?,
+					examinee_mobile = ?,
+					id_card_no = ?,
+					gender = ?,
+					is_married = ?,
+					examine_date = ?,
+					reschedule_count = reschedule_count + ?,
+					appointment_status = IF(?, 0, appointment_status),
 					update_time = UNIX_TIMESTAMP(NOW())
-				WHERE id = :id AND is_deleted = 0
+				WHERE
+					id = ?
+					AND examine_date = ?
+					AND reschedule_count = ?
+					AND is_deleted = 0
+`
+	rs, err := tx.Exec(cmd1, input.ExamineeName, input.ExamineeMobile, input.IdCardNo, input.Gender, input.IsMarried,
+		input.ExamineDate, increment, input.ExamineDate != schedule.ExamineDate,
+		schedule.OrderItemId, schedule.ExamineDate, schedule.RescheduleCount)
+	if err != nil {
+		return false, err
+	}
+	rows, err := rs.RowsAffected()
+	if err != nil || rows != 1 {
+		return false, err
+	}
+
+	for _, extra := range extras {
+		if err = extra(tx); err != nil {
+			return false, err
+		}
+	}
+
+	if log == nil {
+		return true, nil
+	}
+	const cmd2 = `
+			INSERT INTO mko_order_item_reschedule_log (
+				order_item_id,
+				order_id,
+				user_id,
+				from_date,
+				to_date,
+				seq,
+				chargeable,
+				create_time
+			) VALUES (
+				:order_item_id,
+				:order_id,
+				:user_id,
+				:from_date,
+				:to_date,
+				:seq,
+				:chargeable,
+				:create_time
+			)
 `
-	_, err := db.connection.NamedExec(cmd, input)
-	return err
+	if _, err = tx.NamedExec(cmd2, log); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
-func (db *orderDatabase) FindOrderPayStatusById(orderId int64) (*dto.OrderPayStatus, error) {
+func (db *orderDatabase) FindOrderPayStatusByIdNUserId(orderId int64, userId int64) (*dto.OrderPayStatus, error) {
 	var output dto.OrderPayStatus
 	const cmd = `SELECT 
 					mo.status,
@@ -118,28 +267,52 @@ func (db *orderDatabase) FindOrderPayStatusById(orderId int64) (*dto.OrderPaySta
 					INNER JOIN mkb_trade_bill AS mb ON mo.id = mb.order_id 
 				WHERE 
 					mo.id = ?
+					AND mo.user_id = ?
 					AND mo.is_deleted = 0
+					AND mb.fee_type = 1
 					AND mb.is_deleted = 0
 `
-	err := db.connection.Get(&output, cmd, orderId)
+	err := db.connection.Get(&output, cmd, orderId, userId)
 	return &output, err
 }
 
-func (db *orderDatabase) DeleteOrderByIdNUserId(userId int64, id int64) error {
-	const cmd = `UPDATE mko_order SET is_deleted = 1 WHERE id = ? AND user_id = ?`
-	_, err := db.connection.Exec(cmd, id, userId)
-	return err
+func (db *orderDatabase) DeleteOrderByIdNUserId(userId int64, id int64) (bool, error) {
+	const cmd = `UPDATE mko_order SET is_deleted = 1 WHERE id = ? AND user_id = ? AND is_deleted = 0`
+	rs, err := db.connection.Exec(cmd, id, userId)
+	if err != nil {
+		return false, err
+	}
+	rows, err := rs.RowsAffected()
+	return rows == 1, err
+}
+
+func (db *orderDatabase) IsOrderOwnedBy(orderId int64, userId int64) (bool, error) {
+	var count int64
+	const cmd = `SELECT COUNT(*) FROM mko_order WHERE id = ? AND user_id = ? AND is_deleted = 0`
+	err := db.connection.Get(&count, cmd, orderId, userId)
+	return count > 0, err
+}
+
+func (db *orderDatabase) FindOrderDetailByIdNUserId(id int64, userId int64, pkgModel PackageModel) (*dto.RetrieveOrderOutput, error) {
+	return db.findOrderDetail(pkgModel, " AND mo.user_id = ?", id, userId)
 }
 
 func (db *orderDatabase) FindOrderDetailById(id int64, pkgModel PackageModel) (*dto.RetrieveOrderOutput, error) {
+	return db.findOrderDetail(pkgModel, "", id)
+}
+
+// whereStmt 为订单表头的附加条件, args 依次为订单id和附加条件的参数
+func (db *orderDatabase) findOrderDetail(pkgModel PackageModel, whereStmt string, args ...interface{}) (*dto.RetrieveOrderOutput, error) {
 	output := dto.RetrieveOrderOutput{}
 	// step 1 获取订单表头信息
-	const cmd1 = `
+	cmd1 := `
 			SELECT
 				mo.id AS order_id,
 				mo.out_trade_no,
 				mo.status,
 				mo.amount,
+				mo.discount,
+				mo.card_amount,
 				mo.mobile,
 				mo.remark
 			FROM 
@@ -147,8 +320,10 @@ func (db *orderDatabase) FindOrderDetailById(id int64, pkgModel PackageModel) (*
 			WHERE 
 				mo.id = ? 
 				AND mo.is_deleted = 0
+				%s
 `
-	if err := db.connection.Get(&output, cmd1, id); err != nil {
+	cmd1 = fmt.Sprintf(cmd1, whereStmt)
+	if err := db.connection.Get(&output, cmd1, args...); err != nil {
 		return nil, err
 	}
 	output.AggregatedOrderItemsWithPkgItem = make([]*dto.AggregatedOrderItemWithPkgItem, 0, 4)
@@ -166,19 +341,21 @@ func (db *orderDatabase) FindOrderDetailById(id int64, pkgModel PackageModel) (*
 				moi.is_married,
 				moi.gender,
 				moi.examine_date,
-				mp.name AS pkg_name,
-				mp.avatar_url AS pkg_avatar_url,
+				moi.reschedule_count,
+				moi.appointment_status,
+				moi.pkg_name,
+				moi.pkg_avatar_url,
+				moi.hospital_id,
+				moi.hospital_name,
+				moi.pkg_items,
 				moi.create_time
 			FROM
 				mko_order_item AS moi
-				INNER JOIN
-					mkp_package AS mp
-					ON moi.pkg_id = mp.id AND mp.is_deleted = 0
 			WHERE 
 				moi.order_id = ?
 				AND moi.is_deleted = 0
 `
-	if err := db.connection.Select(&orderItems, cmd2, id); err != nil {
+	if err := db.connection.Select(&orderItems, cmd2, output.OrderId); err != nil {
 		return nil, err
 	}
 	if orderItems == nil {
@@ -188,9 +365,13 @@ func (db *orderDatabase) FindOrderDetailById(id int64, pkgModel PackageModel) (*
 	dic := make(map[int64]*dto.AggregatedOrderItemWithPkgItem)
 	for _, item := range orderItems {
 		if _, ok := dic[item.PackageId]; !ok {
-			pkgItems, err := pkgModel.FindPkgItemNameByPkgId(item.PackageId)
-			if err != nil {
-				util.Log.Errorf("查询套餐项目名称失败, err: [%s]", err.Error())
+			pkgItems := []*dto.PkgItemName(item.PkgItems)
+			// 补齐快照之前的旧订单没有项目快照, 按套餐当前的项目展示
+			if len(pkgItems) == 0 {
+				var err error
+				if pkgItems, err = pkgModel.FindPkgItemNameByPkgId(item.PackageId); err != nil {
+					util.Log.Errorf("查询套餐项目名称失败, err: [%s]", err.Error())
+				}
 			}
 			dic[item.PackageId] = &dto.AggregatedOrderItemWithPkgItem{
 				PkgItems: pkgItems,
@@ -204,12 +385,15 @@ func (db *orderDatabase) FindOrderDetailById(id int64, pkgModel PackageModel) (*
 						IsMarried:      item.IsMarried,
 						ExamineDate:    item.ExamineDate,
 					},
+					RescheduleCount:   item.RescheduleCount,
+					AppointmentStatus: item.AppointmentStatus,
 				}},
 				AggregatedOrderItem: dto.AggregatedOrderItem{
 					PackageId:        item.PackageId,
 					OrderId:          item.OrderId,
 					PackageName:      item.PackageName,
 					PackageAvatarUrl: item.PackageAvatarUrl,
+					HospitalName:     item.HospitalName,
 					PackageCount:     1,
 					PackagePrice:     item.PackagePrice,
 					CreateTime:       item.CreateTime,
@@ -227,6 +411,8 @@ func (db *orderDatabase) FindOrderDetailById(id int64, pkgModel PackageModel) (*
 					IsMarried:      item.IsMarried,
 					ExamineDate:    item.ExamineDate,
 				},
+				RescheduleCount:   item.RescheduleCount,
+				AppointmentStatus: item.AppointmentStatus,
 			})
 		}
 	}
@@ -304,20 +490,18 @@ func (db *orderDatabase) ListOrder(input *dto.ListOrderInput, userId int64) ([]*
 			moi.pkg_id,
 			moi.pkg_price,
 			moi.order_id,
-			mp.name AS pkg_name,
-			mp.avatar_url AS pkg_avatar_url,
+			moi.pkg_name,
+			moi.pkg_avatar_url,
+			moi.hospital_name,
 			moi.create_time,
 			COUNT(*) AS pkg_count
 		FROM
 			mko_order_item AS moi
-				INNER JOIN
-					mkp_package AS mp
-					ON moi.pkg_id = mp.id 
 		WHERE
 				moi.order_id IN (?)
 		  AND moi.is_deleted = 0
 		GROUP BY
-			moi.pkg_id, moi.pkg_price, moi.create_time, mp.name, moi.order_id
+			moi.pkg_id, moi.pkg_price, moi.create_time, moi.pkg_name, moi.pkg_avatar_url, moi.hospital_name, moi.order_id
 	`
 	cmd2, args, err := sqlx.In(cmd2, orderIds)
 	if err != nil {
@@ -343,30 +527,8 @@ func (db *orderDatabase) ListOrder(input *dto.ListOrderInput, userId int64) ([]*
 	return output, nil
 }
 
-func (db *orderDatabase) UpdateOrderStatus(outTradeNo string, status int8) (err error) {
-	const cmd = `
-			UPDATE mko_order SET 
-				status = :status
-			WHERE 
-				out_trade_no = :out_trade_no
-				AND is_deleted = 0
-			`
-	rs, err := db.connection.NamedExec(cmd, map[string]interface{}{
-		"status":       status,
-		"out_trade_no": outTradeNo,
-	})
-	if err != nil {
-		return err
-	}
-	if rows, err := rs.RowsAffected(); err != nil {
-		return err
-	} else if rows != 1 {
-		return errors.New("rows effected is not equal to 1")
-	}
-	return
-}
-
-func (db *orderDatabase) SaveOrder(order *dto.Order, items []*dto.OrderItem) (id int64, err error) {
+// 保存订单及订单项, extras 在同一个事务中执行, 如占用体检名额
+func (db *orderDatabase) SaveOrder(order *dto.Order, items []*dto.OrderItem, extras ...TxFunc) (id int64, err error) {
 	tx, err := db.connection.Beginx()
 	if err != nil {
 		util.Log.Errorf("begin trans failed, err: %v", err)
@@ -393,6 +555,10 @@ func (db *orderDatabase) SaveOrder(order *dto.Order, items []*dto.OrderItem) (id
 					mobile,
 					open_id,
 					amount,
+					user_coupon_id,
+					discount,
+					card_id,
+					card_amount,
                     remark,
 					create_time,
 					update_time
@@ -402,6 +568,10 @@ func (db *orderDatabase) SaveOrder(order *dto.Order, items []*dto.OrderItem) (id
 					:mobile,
 					:open_id,
 					:amount,
+					:user_coupon_id,
+					:discount,
+					:card_id,
+					:card_amount,
 				  	:remark,
 					:create_time,
 					:update_time
@@ -424,6 +594,13 @@ func (db *orderDatabase) SaveOrder(order *dto.Order, items []*dto.OrderItem) (id
 						order_id,
 						pkg_id,
 						pkg_price,
+						pkg_name,
+						pkg_avatar_url,
+						hospital_id,
+						hospital_name,
+						pkg_items,
+						discount,
+						card_amount,
 						examinee_name,
 						examinee_mobile,
 						id_card_no,
@@ -437,6 +614,13 @@ func (db *orderDatabase) SaveOrder(order *dto.Order, items []*dto.OrderItem) (id
 						:order_id,
 						:pkg_id,
 						:pkg_price,
+						:pkg_name,
+						:pkg_avatar_url,
+						:hospital_id,
+						:hospital_name,
+						:pkg_items,
+						:discount,
+						:card_amount,
 						:examinee_name,
 						:examinee_mobile,
 						:id_card_no,
@@ -458,6 +642,12 @@ func (db *orderDatabase) SaveOrder(order *dto.Order, items []*dto.OrderItem) (id
 		return 0, errors.New("exec cmd2 failed")
 	}
 
+	for _, extra := range extras {
+		if err = extra(tx); err != nil {
+			return 0, err
+		}
+	}
+
 	return id, err
 }
 
@@ -0,0 +1,122 @@
+package model
+
+import (
+	"github.com/jmoiron/sqlx"
+	"mk-api/server/dao"
+	"mk-api/server/dto"
+)
+
+// TxFunc 在订单状态变更的同一个事务中执行的额外操作
+type TxFunc func(tx *sqlx.Tx) error
+
+type OrderStatusModel interface {
+	FindOrderStatusById(orderId int64) (status int8, err error)
+	FindOrderIdByOutTradeNo(outTradeNo string) (orderId int64, err error)
+	TransitOrderStatus(t *dto.OrderTransition, extras ...TxFunc) (ok bool, err error)
+	ListOrderStatusLog(orderId int64) ([]*dto.OrderStatusLog, error)
+}
+
+type orderStatusDatabase struct {
+	connection *sqlx.DB
+}
+
+func (db *orderStatusDatabase) FindOrderStatusById(orderId int64) (status int8, err error) {
+	const cmd = `SELECT status FROM mko_order WHERE id = ?`
+	err = db.connection.Get(&status, cmd, orderId)
+	return
+}
+
+func (db *orderStatusDatabase) FindOrderIdByOutTradeNo(outTradeNo string) (orderId int64, err error) {
+	const cmd = `SELECT id FROM mko_order WHERE out_trade_no = ?`
+	err = db.connection.Get(&orderId, cmd, outTradeNo)
+	return
+}
+
+// 以 t.From 为条件更新订单状态并写入变更记录, ok 为 false 表示订单状态已经被修改过
+func (db *orderStatusDatabase) TransitOrderStatus(t *dto.OrderTransition, extras ...TxFunc) (ok bool, err error) {
+	tx, err := db.connection.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		} else if err != nil || !ok {
+			_ = tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	const cmd1 = `
+			UPDATE mko_order SET
+				status = :to_status,
+				update_time = :create_time
+			WHERE
+				id = :order_id
+				AND status = :from_status
+`
+	rs, err := tx.NamedExec(cmd1, t)
+	if err != nil {
+		return false, err
+	}
+	rows, err := rs.RowsAffected()
+	if err != nil || rows != 1 {
+		return false, err
+	}
+
+	for _, extra := range extras {
+		if err = extra(tx); err != nil {
+			return false, err
+		}
+	}
+
+	const cmd2 = `
+			INSERT INTO mko_order_status_log (
+				order_id,
+				from_status,
+				to_status,
+				actor_type,
+				actor_id,
+				reason,
+				create_time
+			) VALUES (
+				:order_id,
+				:from_status,
+				:to_status,
+				:actor_type,
+				:actor_id,
+				:reason,
+				:create_time
+			)
+`
+	if _, err = tx.NamedExec(cmd2, t); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (db *orderStatusDatabase) ListOrderStatusLog(orderId int64) ([]*dto.OrderStatusLog, error) {
+	output := make([]*dto.OrderStatusLog, 0, 4)
+	const cmd = `
+			SELECT
+				from_status,
+				to_status,
+				actor_type,
+				actor_id,
+				reason,
+				create_time
+			FROM
+				mko_order_status_log
+			WHERE
+				order_id = ?
+			ORDER BY id
+`
+	err := db.connection.Select(&output, cmd, orderId)
+	return output, err
+}
+
+func NewOrderStatusModel() OrderStatusModel {
+	return &orderStatusDatabase{connection: dao.Db}
+}
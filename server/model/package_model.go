@@ -16,6 +16,8 @@ type PackageModel interface {
 	FindPackageAttr(pkgId int64) (attrs []dto.PackageAttribute, err error)
 	FindPackagePriceNTargetById(id int64) (output *dto.PkgTargetNPrice, err error)
 	FindPkgItemNameByPkgId(pkgId int64) ([]*dto.PkgItemName, error)
+	// 下单时保存到订单项上的套餐快照
+	FindPackageSnapshot(id int64) (*dto.PackageSnapshot, error)
 	ListDisease() ([]*dto.Disease, error)
 	ListCategory() ([]*dto.Category, error)
 }
@@ -54,9 +56,33 @@ func (db *packageDatabase) FindPkgItemNameByPkgId(pkgId int64) ([]*dto.PkgItemNa
 	return names, err
 }
 
+func (db *packageDatabase) FindPackageSnapshot(id int64) (*dto.PackageSnapshot, error) {
+	var snapshot dto.PackageSnapshot
+	const cmd = `
+			SELECT
+				mp.name AS pkg_name,
+				mp.avatar_url AS pkg_avatar_url,
+				mp.hospital_id,
+				mh.name AS hospital_name
+			FROM
+				mkp_package AS mp
+				INNER JOIN mkh_hospital AS mh
+					ON mp.hospital_id = mh.id
+			WHERE
+				mp.id = ?
+				AND mp.is_deleted = 0
+`
+	if err := db.connection.Get(&snapshot, cmd, id); err != nil {
+		return nil, err
+	}
+	items, err := db.FindPkgItemNameByPkgId(id)
+	snapshot.PkgItems = items
+	return &snapshot, err
+}
+
 func (db *packageDatabase) FindPackagePriceNTargetById(id int64) (output *dto.PkgTargetNPrice, err error) {
 	output = &dto.PkgTargetNPrice{}
-	cmd := `SELECT price_real, target FROM mkp_package WHERE id = ? AND is_deleted = 0`
+	cmd := `SELECT price_real, target, hospital_id FROM mkp_package WHERE id = ? AND is_deleted = 0`
 	err = db.connection.Get(output, cmd, id)
 	return
 }
@@ -96,6 +122,8 @@ func (db *packageDatabase) FindPackageBasicInfo(id int64) (*dto.PackageBasicInfo
 				mp.price_real,
 				mp.brief,
        			mp.sold,
+				mp.review_count,
+				IF(mp.review_count = 0, 0, ROUND(mp.rating_sum / mp.review_count, 1)) AS rating,
 				mp.comment,
 				mp.tips
 			FROM 
@@ -146,6 +174,8 @@ func (db *packageDatabase) ListPackage(input *dto.ListPackageInput) ([]dto.ListP
 		orderByStmt = " ORDER BY mp.price_real "
 	case 2:
 		orderByStmt = " ORDER BY mp.price_real DESC "
+	case 3:
+		orderByStmt = " ORDER BY rating DESC, mp.review_count DESC "
 	}
 
 	cmd := ` SELECT 
@@ -157,6 +187,8 @@ func (db *packageDatabase) ListPackage(input *dto.ListPackageInput) ([]dto.ListP
 				mp.price_original,
 				mp.price_real,
 				mp.sold,
+				mp.review_count,
+				IF(mp.review_count = 0, 0, ROUND(mp.rating_sum / mp.review_count, 1)) AS rating,
 				mh.level
 			FROM 
 				mkp_package AS mp
@@ -3,60 +3,86 @@ package model
 import (
 	"errors"
 	"strconv"
-	"time"
 
 	"github.com/jmoiron/sqlx"
-	"github.com/silenceper/wechat/v2/pay/notify"
 	"mk-api/server/dao"
 	"mk-api/server/dto"
 	"mk-api/server/util"
 )
 
+// 流水状态, 退款流水中 Closed 表示退款失败
 const (
-	Closed  = 4
-	Success = 2
+	Processing = 0
+	Closed     = 4
+	Success    = 2
 )
 
+// ErrRefundInFlight 订单已有处理中或已成功的退款, 由 SaveRefundBill 返回
+var ErrRefundInFlight = errors.New("refund in flight")
+
 type PayModel interface {
-	FindBillByOutTradeNo(result *notify.PaidResult) (*dto.TradeBill, error)
-	SuccessPaidResult2Bill(result *notify.PaidResult) (err error)
+	FindBillByOutTradeNo(outTradeNo string) (*dto.TradeBill, error)
+	// 支付成功回写流水, 需与订单状态变更在同一事务中提交
+	SuccessPaid2Bill(trade *dto.PaidTrade) TxFunc
+	// 查询支付超过 startBefore 仍未收到结果且尚未超时的流水, 按id分批
+	FindPendingBills(startBefore int64, now int64, afterId int64, limit int) ([]*dto.TradeBill, error)
 	SaveTradeBill(bill *dto.TradeBill) (id int64, err error)
-	ExpireBill(billId int64) (err error)
-	CheckPayStatusByPrepayId(prepayId string) (status int8, err error)
+	CloseBillByOrderId(orderId int64) TxFunc
+	// 只能查询自己订单的支付状态
+	CheckPayStatusByPrepayIdNUserId(prepayId string, userId int64) (*dto.TradeBill, error)
+
+	FindPaidBillByOrderId(orderId int64) (*dto.TradeBill, error)
+	FindRefundBillByOrderId(orderId int64) (*dto.TradeBill, error)
+	FindRefundBillByOutRefundNo(outRefundNo string) (*dto.TradeBill, error)
+	// 查询发起超过 startBefore 仍未被微信受理(没有微信退款单号)的处理中退款流水, 按id分批
+	FindPendingRefundBills(startBefore int64, afterId int64, limit int) ([]*dto.TradeBill, error)
+	// 锁住订单后检查并插入, 一个订单同时只能有一笔处理中或已成功的退款, 已有时返回 ErrRefundInFlight
+	SaveRefundBill(bill *dto.TradeBill) (id int64, err error)
+	UpdateRefundId(billId int64, refundId string) error
+	FailRefundBill(billId int64) error
+	SuccessRefund2Bill(outRefundNo string, refundId string, timeEnd int64) TxFunc
 }
 
 type payDatabase struct {
 	connection *sqlx.DB
 }
 
-func (db *payDatabase) CheckPayStatusByPrepayId(prepayId string) (status int8, err error) {
-	const cmd = `SELECT status FROM mkb_trade_bill WHERE prepay_id = ? AND is_deleted = 0`
-	err = db.connection.Get(&status, cmd, prepayId)
-	return
+func (db *payDatabase) CheckPayStatusByPrepayIdNUserId(prepayId string, userId int64) (*dto.TradeBill, error) {
+	var bill dto.TradeBill
+	const cmd = `
+			SELECT
+				mb.status,
+				mb.out_trade_no
+			FROM
+				mkb_trade_bill AS mb
+				INNER JOIN mko_order AS mo
+					ON mb.order_id = mo.id
+			WHERE
+				mb.prepay_id = ?
+				AND mb.fee_type = 1
+				AND mb.is_deleted = 0
+				AND mo.user_id = ?
+`
+	err := db.connection.Get(&bill, cmd, prepayId, userId)
+	return &bill, err
 }
 
-func (db *payDatabase) ExpireBill(billId int64) (err error) {
-	const cmd = `
+// 关闭订单未支付的收款流水, 随订单关闭一起提交
+func (db *payDatabase) CloseBillByOrderId(orderId int64) TxFunc {
+	return func(tx *sqlx.Tx) error {
+		const cmd = `
 			UPDATE mkb_trade_bill SET 
-				status = :status
+				status = ?,
+				update_time = UNIX_TIMESTAMP(NOW())
 			WHERE 
-				id = :billId
-				AND time_expire < unix_timestamp(now())
+				order_id = ?
+				AND fee_type = 1
+				AND status = 0
 				AND is_deleted = 0
 `
-	rs, err := db.connection.NamedExec(cmd, map[string]interface{}{
-		"status": Closed,
-		"billId": billId,
-	})
-	if err != nil {
-		return err
-	}
-	if rows, err := rs.RowsAffected(); err != nil {
+		_, err := tx.Exec(cmd, Closed, orderId)
 		return err
-	} else if rows != 1 {
-		return errors.New("rows effected is not equal to 1, but " + strconv.Itoa(int(rows)))
 	}
-	return
 }
 
 func (db *payDatabase) SaveTradeBill(bill *dto.TradeBill) (id int64, err error) {
@@ -97,31 +123,65 @@ func (db *payDatabase) SaveTradeBill(bill *dto.TradeBill) (id int64, err error)
 	return
 }
 
-func (db *payDatabase) SuccessPaidResult2Bill(result *notify.PaidResult) (err error) {
-	const cmd = `
-			UPDATE mkb_trade_bill SET 
-				status = :status,
-				transaction_id = :transaction_id,
-				time_end = :time_end
-			WHERE 
-				out_trade_no = :out_trade_no
+// 支付成功, 随订单变为已支付一起提交
+func (db *payDatabase) SuccessPaid2Bill(trade *dto.PaidTrade) TxFunc {
+	return func(tx *sqlx.Tx) error {
+		const cmd = `
+			UPDATE mkb_trade_bill SET
+				status = ?,
+				transaction_id = ?,
+				time_end = ?,
+				update_time = UNIX_TIMESTAMP(NOW())
+			WHERE
+				out_trade_no = ?
+				AND fee_type = 1
+				AND status != ?
 				AND is_deleted = 0
 `
-	timeEnd, _ := time.Parse("20060102150405", *result.TimeEnd)
-	rs, err := db.connection.NamedExec(cmd, map[string]interface{}{
-		"status":         Success,
-		"transaction_id": *result.TransactionID,
-		"time_end":       timeEnd.Unix(),
-		"out_trade_no":   *result.OutTradeNo,
-	})
-	if err != nil {
-		return err
+		rs, err := tx.Exec(cmd, Success, trade.TransactionId, trade.TimeEnd, trade.OutTradeNo, Success)
+		if err != nil {
+			return err
+		}
+		if rows, err := rs.RowsAffected(); err != nil {
+			return err
+		} else if rows != 1 {
+			return errors.New("rows effected is not equal to 1, but " + strconv.Itoa(int(rows)))
+		}
+		return nil
 	}
-	_, err = rs.RowsAffected()
-	return err
 }
 
-func (db *payDatabase) FindBillByOutTradeNo(result *notify.PaidResult) (*dto.TradeBill, error) {
+func (db *payDatabase) FindPendingBills(startBefore int64, now int64, afterId int64, limit int) ([]*dto.TradeBill, error) {
+	output := make([]*dto.TradeBill, 0, limit)
+	const cmd = `
+			SELECT
+				mb.id,
+				mb.order_id,
+				mb.out_trade_no,
+				mb.total_fee,
+				mb.status,
+				mb.time_start,
+				mb.time_expire
+			FROM
+				mkb_trade_bill AS mb
+				INNER JOIN mko_order AS mo
+					ON mb.order_id = mo.id
+			WHERE
+				mb.id > ?
+				AND mb.fee_type = 1
+				AND mb.status = 0
+				AND mb.is_deleted = 0
+				AND mb.time_start < ?
+				AND mb.time_expire >= ?
+				AND mo.status = 0
+			ORDER BY mb.id
+			LIMIT ?
+`
+	err := db.connection.Select(&output, cmd, afterId, startBefore, now, limit)
+	return output, err
+}
+
+func (db *payDatabase) FindBillByOutTradeNo(outTradeNo string) (*dto.TradeBill, error) {
 	var bill dto.TradeBill
 	const cmd = `
 			SELECT
@@ -135,15 +195,187 @@ func (db *payDatabase) FindBillByOutTradeNo(result *notify.PaidResult) (*dto.Tra
 				mkb_trade_bill
 			WHERE
 				out_trade_no = ?
+				AND fee_type = 1
 				AND is_deleted = 0
 `
-	no, err := strconv.ParseInt(*result.OutTradeNo, 10, 64)
+	err := db.connection.Get(&bill, cmd, outTradeNo)
+
+	return &bill, err
+}
+
+const billColumns = `
+				id,
+				order_id,
+				transaction_id,
+				out_trade_no,
+				out_refund_no,
+				refund_id,
+				total_fee,
+				fee_type,
+				status,
+				trans_type,
+				time_start,
+				time_end,
+				create_time,
+				update_time
+`
+
+func (db *payDatabase) FindPaidBillByOrderId(orderId int64) (*dto.TradeBill, error) {
+	var bill dto.TradeBill
+	cmd := `SELECT ` + billColumns + `
+			FROM mkb_trade_bill
+			WHERE order_id = ? AND fee_type = 1 AND status = 2 AND is_deleted = 0`
+	err := db.connection.Get(&bill, cmd, orderId)
+	return &bill, err
+}
+
+// 查找订单处理中或已成功的退款流水, 失败的退款不算
+func (db *payDatabase) FindRefundBillByOrderId(orderId int64) (*dto.TradeBill, error) {
+	var bill dto.TradeBill
+	cmd := `SELECT ` + billColumns + `
+			FROM mkb_trade_bill
+			WHERE order_id = ? AND fee_type = 2 AND status IN (0, 2) AND is_deleted = 0
+			LIMIT 1`
+	err := db.connection.Get(&bill, cmd, orderId)
+	return &bill, err
+}
+
+func (db *payDatabase) FindRefundBillByOutRefundNo(outRefundNo string) (*dto.TradeBill, error) {
+	var bill dto.TradeBill
+	cmd := `SELECT ` + billColumns + `
+			FROM mkb_trade_bill
+			WHERE out_refund_no = ? AND fee_type = 2 AND is_deleted = 0`
+	err := db.connection.Get(&bill, cmd, outRefundNo)
+	return &bill, err
+}
+
+func (db *payDatabase) FindPendingRefundBills(startBefore int64, afterId int64, limit int) ([]*dto.TradeBill, error) {
+	output := make([]*dto.TradeBill, 0, limit)
+	cmd := `SELECT ` + billColumns + `
+			FROM mkb_trade_bill
+			WHERE
+				id > ?
+				AND fee_type = 2
+				AND status = 0
+				AND refund_id = ''
+				AND is_deleted = 0
+				AND time_start < ?
+			ORDER BY id
+			LIMIT ?`
+	err := db.connection.Select(&output, cmd, afterId, startBefore, limit)
+	return output, err
+}
+
+func (db *payDatabase) SaveRefundBill(bill *dto.TradeBill) (id int64, err error) {
+	tx, err := db.connection.Beginx()
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	err = db.connection.Get(&bill, cmd, no)
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
 
-	return &bill, err
+	// 同一订单的退款串行执行, 检查和插入之间不会有其他退款流水插入
+	var orderId int64
+	if err = tx.Get(&orderId, `SELECT id FROM mko_order WHERE id = ? FOR UPDATE`, bill.OrderId); err != nil {
+		return 0, err
+	}
+	var inFlight int
+	const cmd1 = `
+			SELECT COUNT(*)
+			FROM mkb_trade_bill
+			WHERE order_id = ? AND fee_type = 2 AND status IN (0, 2) AND is_deleted = 0
+`
+	if err = tx.Get(&inFlight, cmd1, bill.OrderId); err != nil {
+		return 0, err
+	}
+	if inFlight > 0 {
+		return 0, ErrRefundInFlight
+	}
+
+	const cmd = `INSERT INTO mkb_trade_bill (
+					order_id
+					,transaction_id
+					,out_trade_no
+					,out_refund_no
+					,total_fee
+					,fee_type
+					,status
+					,trans_type
+					,time_start
+					,create_time
+					,update_time
+				) VALUES (
+					:order_id
+					,:transaction_id
+					,:out_trade_no
+					,:out_refund_no
+					,:total_fee
+					,:fee_type
+					,:status
+					,:trans_type
+					,:time_start
+					,:create_time
+					,:update_time
+				)
+`
+	rs, err := tx.NamedExec(cmd, bill)
+	if err != nil {
+		return 0, err
+	}
+	return rs.LastInsertId()
+}
+
+func (db *payDatabase) UpdateRefundId(billId int64, refundId string) error {
+	const cmd = `UPDATE mkb_trade_bill SET refund_id = ?, update_time = UNIX_TIMESTAMP(NOW()) WHERE id = ? AND fee_type = 2`
+	_, err := db.connection.Exec(cmd, refundId, billId)
+	return err
+}
+
+func (db *payDatabase) FailRefundBill(billId int64) error {
+	const cmd = `
+			UPDATE mkb_trade_bill SET
+				status = ?,
+				update_time = UNIX_TIMESTAMP(NOW())
+			WHERE
+				id = ?
+				AND fee_type = 2
+				AND status = 0
+`
+	_, err := db.connection.Exec(cmd, Closed, billId)
+	return err
+}
+
+// 退款成功, 随订单变为已退款一起提交
+func (db *payDatabase) SuccessRefund2Bill(outRefundNo string, refundId string, timeEnd int64) TxFunc {
+	return func(tx *sqlx.Tx) error {
+		const cmd = `
+			UPDATE mkb_trade_bill SET
+				status = ?,
+				refund_id = ?,
+				time_end = ?,
+				update_time = UNIX_TIMESTAMP(NOW())
+			WHERE
+				out_refund_no = ?
+				AND fee_type = 2
+				AND status = 0
+				AND is_deleted = 0
+`
+		rs, err := tx.Exec(cmd, Success, refundId, timeEnd, outRefundNo)
+		if err != nil {
+			return err
+		}
+		if rows, err := rs.RowsAffected(); err != nil {
+			return err
+		} else if rows != 1 {
+			return errors.New("rows effected is not equal to 1, but " + strconv.Itoa(int(rows)))
+		}
+		return nil
+	}
 }
 
 func NewPayModel() PayModel {
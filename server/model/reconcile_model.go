@@ -0,0 +1,222 @@
+package model
+
+import (
+	"github.com/jmoiron/sqlx"
+	"mk-api/server/dao"
+	"mk-api/server/dto"
+)
+
+type ReconcileModel interface {
+	// 查询参与对账的流水: [start, end) 内支付成功的收款和发起的退款, 以及 outTradeNos 对应订单的全部流水
+	ListBillsForReconcile(start int64, end int64, outTradeNos []string) ([]*dto.TradeBill, error)
+	// 保存报告并替换原有的差异, 同一天重复对账时覆盖
+	SaveReport(report *dto.ReconcileReport, diffs []*dto.ReconcileDiff) (int64, error)
+	ListReports(input *dto.ListReconcileReportInput) ([]*dto.ReconcileReport, error)
+	FindReportById(id int64) (*dto.ReconcileReport, error)
+	ListDiffsByReportId(reportId int64) ([]*dto.ReconcileDiff, error)
+}
+
+type reconcileDatabase struct {
+	connection *sqlx.DB
+}
+
+func (db *reconcileDatabase) ListBillsForReconcile(start int64, end int64, outTradeNos []string) ([]*dto.TradeBill, error) {
+	output := make([]*dto.TradeBill, 0)
+	cmd := `
+			SELECT
+				id,
+				order_id,
+				transaction_id,
+				out_trade_no,
+				out_refund_no,
+				refund_id,
+				total_fee,
+				fee_type,
+				status,
+				time_end,
+				create_time
+			FROM
+				mkb_trade_bill
+			WHERE
+				is_deleted = 0
+				AND (
+					(fee_type = 1 AND status = 2 AND time_end >= ? AND time_end < ?)
+					OR (fee_type = 2 AND create_time >= ? AND create_time < ?)
+`
+	args := []interface{}{start, end, start, end}
+	if len(outTradeNos) > 0 {
+		cmd += `
+					OR out_trade_no IN (?)
+`
+		args = append(args, outTradeNos)
+	}
+	cmd += `
+				)
+			ORDER BY id
+`
+	cmd, args, err := sqlx.In(cmd, args...)
+	if err != nil {
+		return nil, err
+	}
+	err = db.connection.Select(&output, db.connection.Rebind(cmd), args...)
+	return output, err
+}
+
+func (db *reconcileDatabase) SaveReport(report *dto.ReconcileReport, diffs []*dto.ReconcileDiff) (id int64, err error) {
+	tx, err := db.connection.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	// 已有当天的报告时更新, LAST_INSERT_ID(id) 使 LastInsertId 返回已有报告的id
+	const cmd1 = `
+			INSERT INTO mkb_reconcile_report (
+				bill_date,
+				remote_count,
+				remote_total_fee,
+				remote_refund_fee,
+				local_count,
+				matched_count,
+				diff_count,
+				create_time,
+				update_time
+			) VALUES (
+				:bill_date,
+				:remote_count,
+				:remote_total_fee,
+				:remote_refund_fee,
+				:local_count,
+				:matched_count,
+				:diff_count,
+				:create_time,
+				:update_time
+			) ON DUPLICATE KEY UPDATE
+				id = LAST_INSERT_ID(id),
+				remote_count = VALUES(remote_count),
+				remote_total_fee = VALUES(remote_total_fee),
+				remote_refund_fee = VALUES(remote_refund_fee),
+				local_count = VALUES(local_count),
+				matched_count = VALUES(matched_count),
+				diff_count = VALUES(diff_count),
+				update_time = VALUES(update_time)
+`
+	rs, err := tx.NamedExec(cmd1, report)
+	if err != nil {
+		return 0, err
+	}
+	if id, err = rs.LastInsertId(); err != nil {
+		return 0, err
+	}
+
+	if _, err = tx.Exec(`DELETE FROM mkb_reconcile_diff WHERE report_id = ?`, id); err != nil {
+		return 0, err
+	}
+	const cmd2 = `
+			INSERT INTO mkb_reconcile_diff (
+				report_id,
+				kind,
+				fee_type,
+				out_trade_no,
+				transaction_id,
+				out_refund_no,
+				local_fee,
+				remote_fee,
+				local_status,
+				remote_status,
+				create_time
+			) VALUES (
+				:report_id,
+				:kind,
+				:fee_type,
+				:out_trade_no,
+				:transaction_id,
+				:out_refund_no,
+				:local_fee,
+				:remote_fee,
+				:local_status,
+				:remote_status,
+				:create_time
+			)
+`
+	for _, diff := range diffs {
+		diff.ReportId = id
+		if _, err = tx.NamedExec(cmd2, diff); err != nil {
+			return 0, err
+		}
+	}
+	return id, nil
+}
+
+const reconcileReportColumns = `
+				id,
+				bill_date,
+				remote_count,
+				remote_total_fee,
+				remote_refund_fee,
+				local_count,
+				matched_count,
+				diff_count,
+				create_time,
+				update_time
+`
+
+func (db *reconcileDatabase) ListReports(input *dto.ListReconcileReportInput) ([]*dto.ReconcileReport, error) {
+	output := make([]*dto.ReconcileReport, 0, input.PageSize+1)
+	cmd := `SELECT ` + reconcileReportColumns + `
+			FROM
+				mkb_reconcile_report
+			ORDER BY bill_date DESC
+			LIMIT ?, ?
+`
+	err := db.connection.Select(&output, cmd, (input.PageNo-1)*input.PageSize, input.PageSize+1)
+	return output, err
+}
+
+func (db *reconcileDatabase) FindReportById(id int64) (*dto.ReconcileReport, error) {
+	var output dto.ReconcileReport
+	cmd := `SELECT ` + reconcileReportColumns + `
+			FROM
+				mkb_reconcile_report
+			WHERE
+				id = ?
+`
+	err := db.connection.Get(&output, cmd, id)
+	return &output, err
+}
+
+func (db *reconcileDatabase) ListDiffsByReportId(reportId int64) ([]*dto.ReconcileDiff, error) {
+	output := make([]*dto.ReconcileDiff, 0)
+	const cmd = `
+			SELECT
+				id,
+				report_id,
+				kind,
+				fee_type,
+				out_trade_no,
+				transaction_id,
+				out_refund_no,
+				local_fee,
+				remote_fee,
+				local_status,
+				remote_status,
+				create_time
+			FROM
+				mkb_reconcile_diff
+			WHERE
+				report_id = ?
+			ORDER BY kind, id
+`
+	err := db.connection.Select(&output, cmd, reportId)
+	return output, err
+}
+
+func NewReconcileModel() ReconcileModel {
+	return &reconcileDatabase{connection: dao.Db}
+}
@@ -0,0 +1,265 @@
+package model
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"mk-api/server/dao"
+	"mk-api/server/dto"
+)
+
+type ReportModel interface {
+	FindReportTarget(orderItemId int64) (*dto.ReportTarget, error)
+	SaveReport(report *dto.Report) (int64, error)
+	// input.UserId 不为 0 时只返回该用户的报告
+	ListReports(input *dto.ListReportInput) ([]*dto.Report, error)
+	FindReportById(id int64) (*dto.Report, error)
+	// 按 user_id 查询, 用户只能获取自己的报告
+	FindUserReport(id int64, userId int64) (*dto.Report, error)
+	DeleteReport(id int64, updateTime int64) (bool, error)
+	// 在同一事务中删除订单项已录入的结果并写入新的结果
+	ReplaceExamResults(orderItemId int64, results []*dto.ExamResult) error
+	ListExamResults(orderItemId int64) ([]*dto.ExamResult, error)
+	// 用户为同一身份证号的体检人下的已付款订单项的结果, 按体检日期倒序
+	FindExamHistory(userId int64, idCardNo string) ([]*dto.ExamHistoryRow, error)
+}
+
+type reportDatabase struct {
+	connection *sqlx.DB
+}
+
+func (db *reportDatabase) FindReportTarget(orderItemId int64) (*dto.ReportTarget, error) {
+	var output dto.ReportTarget
+	const cmd = `
+			SELECT
+				moi.id AS order_item_id,
+				moi.order_id,
+				moi.user_id,
+				mo.open_id,
+				moi.examinee_name,
+				moi.pkg_name,
+				moi.pkg_id,
+				moi.hospital_id,
+				moi.pkg_items,
+				mo.status AS order_status,
+				moi.examine_date
+			FROM
+				mko_order_item AS moi
+				INNER JOIN mko_order AS mo
+					ON moi.order_id = mo.id
+			WHERE
+				moi.id = ?
+				AND moi.is_deleted = 0
+`
+	err := db.connection.Get(&output, cmd, orderItemId)
+	return &output, err
+}
+
+func (db *reportDatabase) SaveReport(report *dto.Report) (int64, error) {
+	const cmd = `
+			INSERT INTO mko_report (
+				order_id,
+				order_item_id,
+				user_id,
+				file_name,
+				content_type,
+				size,
+				cos_key,
+				staff_id,
+				create_time,
+				update_time
+			) VALUES (
+				:order_id,
+				:order_item_id,
+				:user_id,
+				:file_name,
+				:content_type,
+				:size,
+				:cos_key,
+				:staff_id,
+				:create_time,
+				:update_time
+			)
+`
+	rs, err := db.connection.NamedExec(cmd, report)
+	if err != nil {
+		return 0, err
+	}
+	return rs.LastInsertId()
+}
+
+const reportColumns = `
+				id,
+				order_id,
+				order_item_id,
+				user_id,
+				file_name,
+				content_type,
+				size,
+				cos_key,
+				staff_id,
+				create_time,
+				update_time`
+
+func (db *reportDatabase) ListReports(input *dto.ListReportInput) ([]*dto.Report, error) {
+	output := make([]*dto.Report, 0, 4)
+	cmd := `SELECT ` + reportColumns + `
+			FROM
+				mko_report
+			WHERE
+				(? = 0 OR order_item_id = ?)
+				AND (? = 0 OR user_id = ?)
+				AND is_deleted = 0
+			ORDER BY id DESC
+			LIMIT 200
+`
+	err := db.connection.Select(&output, cmd, input.OrderItemId, input.OrderItemId, input.UserId, input.UserId)
+	return output, err
+}
+
+func (db *reportDatabase) FindReportById(id int64) (*dto.Report, error) {
+	var output dto.Report
+	cmd := `SELECT ` + reportColumns + ` FROM mko_report WHERE id = ? AND is_deleted = 0`
+	err := db.connection.Get(&output, cmd, id)
+	return &output, err
+}
+
+func (db *reportDatabase) FindUserReport(id int64, userId int64) (*dto.Report, error) {
+	var output dto.Report
+	cmd := `SELECT ` + reportColumns + ` FROM mko_report WHERE id = ? AND user_id = ? AND is_deleted = 0`
+	err := db.connection.Get(&output, cmd, id, userId)
+	return &output, err
+}
+
+func (db *reportDatabase) DeleteReport(id int64, updateTime int64) (bool, error) {
+	const cmd = `UPDATE mko_report SET is_deleted = 1, update_time = ? WHERE id = ? AND is_deleted = 0`
+	rs, err := db.connection.Exec(cmd, updateTime, id)
+	if err != nil {
+		return false, err
+	}
+	rows, err := rs.RowsAffected()
+	return rows == 1, err
+}
+
+func (db *reportDatabase) ReplaceExamResults(orderItemId int64, results []*dto.ExamResult) (err error) {
+	tx, err := db.connection.Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	const cmd1 = `UPDATE mko_exam_result SET is_deleted = 1, update_time = ? WHERE order_item_id = ? AND is_deleted = 0`
+	if _, err = tx.Exec(cmd1, time.Now().Unix(), orderItemId); err != nil {
+		return err
+	}
+	const cmd2 = `
+			INSERT INTO mko_exam_result (
+				order_id,
+				order_item_id,
+				user_id,
+				item_name,
+				indicator,
+				value,
+				unit,
+				ref_range,
+				abnormal,
+				staff_id,
+				create_time,
+				update_time
+			) VALUES (
+				:order_id,
+				:order_item_id,
+				:user_id,
+				:item_name,
+				:indicator,
+				:value,
+				:unit,
+				:ref_range,
+				:abnormal,
+				:staff_id,
+				:create_time,
+				:update_time
+			)
+`
+	for _, r := range results {
+		if _, err = tx.NamedExec(cmd2, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *reportDatabase) ListExamResults(orderItemId int64) ([]*dto.ExamResult, error) {
+	output := make([]*dto.ExamResult, 0, 32)
+	const cmd = `
+			SELECT
+				mer.id,
+				mer.order_id,
+				mer.order_item_id,
+				mer.user_id,
+				moi.examine_date,
+				mer.item_name,
+				mer.indicator,
+				mer.value,
+				mer.unit,
+				mer.ref_range,
+				mer.abnormal,
+				mer.staff_id,
+				mer.create_time,
+				mer.update_time
+			FROM
+				mko_exam_result AS mer
+				INNER JOIN mko_order_item AS moi
+					ON mer.order_item_id = moi.id
+			WHERE
+				mer.order_item_id = ?
+				AND mer.is_deleted = 0
+			ORDER BY mer.id
+`
+	err := db.connection.Select(&output, cmd, orderItemId)
+	return output, err
+}
+
+func (db *reportDatabase) FindExamHistory(userId int64, idCardNo string) ([]*dto.ExamHistoryRow, error) {
+	output := make([]*dto.ExamHistoryRow, 0, 64)
+	const cmd = `
+			SELECT
+				moi.order_id,
+				moi.pkg_name,
+				moi.hospital_name,
+				mer.order_item_id,
+				moi.examine_date,
+				mer.item_name,
+				mer.indicator,
+				mer.value,
+				mer.unit,
+				mer.ref_range,
+				mer.abnormal
+			FROM
+				mko_exam_result AS mer
+				INNER JOIN mko_order_item AS moi
+					ON mer.order_item_id = moi.id
+					AND moi.is_deleted = 0
+				INNER JOIN mko_order AS mo
+					ON moi.order_id = mo.id
+			WHERE
+				moi.user_id = ?
+				AND moi.id_card_no = ?
+				AND mo.status IN (2, 5)
+				AND mer.is_deleted = 0
+			ORDER BY moi.examine_date DESC, mer.order_item_id DESC, mer.id
+			LIMIT 5000
+`
+	err := db.connection.Select(&output, cmd, userId, idCardNo)
+	return output, err
+}
+
+func NewReportModel() ReportModel {
+	return &reportDatabase{connection: dao.Db}
+}
@@ -0,0 +1,253 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+	"mk-api/server/dao"
+	"mk-api/server/dto"
+)
+
+// ErrRefundApplied 订单已申请退款, 由 RequireNoRefund 返回的 TxFunc 抛出以回滚整个事务
+var ErrRefundApplied = errors.New("refund applied")
+
+type ReviewModel interface {
+	// 查找所有订单项的体检日期都已过去的已付款订单, 不包括正在退款的
+	FindReviewableOrders(before int64, limit int) ([]int64, error)
+	// 与订单变为待评价在同一事务中检查, 查询之后用户申请了退款的订单不能变为待评价
+	RequireNoRefund(orderId int64) TxFunc
+	FindReviewableItem(orderItemId int64, userId int64) (*dto.ReviewableItem, error)
+	FindReviewById(id int64) (*dto.Review, error)
+	ExistsReview(orderItemId int64) (bool, error)
+	// 每个订单项只能评价一次, 重复时因 uk_order_item_id 插入失败
+	SaveReview(review *dto.Review) (int64, error)
+	ListReviews(input *dto.ListReviewInput) ([]*dto.Review, error)
+	// 审核通过时在同一事务中把评分计入套餐, 只处理待审核的评价
+	ApproveReview(id int64, staffId int64, moderateTime int64) (bool, error)
+	RejectReview(id int64, remark string, staffId int64, moderateTime int64) (bool, error)
+}
+
+type reviewDatabase struct {
+	connection *sqlx.DB
+}
+
+func (db *reviewDatabase) FindReviewableOrders(before int64, limit int) ([]int64, error) {
+	output := make([]int64, 0, limit)
+	const cmd = `
+			SELECT
+				mo.id
+			FROM
+				mko_order AS mo
+			WHERE
+				mo.status = 2
+				AND mo.refund_status NOT IN (1, 2)
+				AND mo.is_deleted = 0
+				AND NOT EXISTS (
+					SELECT 1 FROM mko_order_item AS moi
+					WHERE
+						moi.order_id = mo.id
+						AND moi.is_deleted = 0
+						AND (moi.examine_date = 0 OR moi.examine_date >= ?)
+				)
+			ORDER BY mo.id
+			LIMIT ?
+`
+	err := db.connection.Select(&output, cmd, before, limit)
+	return output, err
+}
+
+func (db *reviewDatabase) RequireNoRefund(orderId int64) TxFunc {
+	return func(tx *sqlx.Tx) error {
+		// 订单行已被同一事务中的状态变更锁住, 申请退款的 UPDATE 要等本事务结束, 之后订单已不是已付款, 申请会失败
+		var refundStatus int8
+		const cmd = `SELECT refund_status FROM mko_order WHERE id = ? FOR UPDATE`
+		if err := tx.Get(&refundStatus, cmd, orderId); err != nil {
+			return err
+		}
+		if refundStatus == 1 || refundStatus == 2 {
+			return ErrRefundApplied
+		}
+		return nil
+	}
+}
+
+func (db *reviewDatabase) FindReviewableItem(orderItemId int64, userId int64) (*dto.ReviewableItem, error) {
+	var output dto.ReviewableItem
+	const cmd = `
+			SELECT
+				moi.id AS order_item_id,
+				moi.order_id,
+				moi.pkg_id,
+				moi.examine_date,
+				mo.status AS order_status,
+				mo.refund_status
+			FROM
+				mko_order_item AS moi
+				INNER JOIN mko_order AS mo
+					ON moi.order_id = mo.id
+			WHERE
+				moi.id = ?
+				AND moi.user_id = ?
+				AND moi.is_deleted = 0
+`
+	err := db.connection.Get(&output, cmd, orderItemId, userId)
+	return &output, err
+}
+
+const reviewColumns = `
+				mr.id,
+				mr.order_id,
+				mr.order_item_id,
+				mr.user_id,
+				IFNULL(mup.user_name, '') AS user_name,
+				IFNULL(mup.avatar_url, '') AS avatar_url,
+				mr.pkg_id,
+				mr.rating,
+				mr.content,
+				mr.images,
+				mr.status,
+				mr.remark,
+				mr.staff_id,
+				mr.moderate_time,
+				mr.create_time`
+
+const reviewFrom = `
+			FROM
+				mkp_review AS mr
+				LEFT JOIN mku_user_profile AS mup
+					ON mr.user_id = mup.user_id
+					AND mup.is_deleted = 0`
+
+func (db *reviewDatabase) FindReviewById(id int64) (*dto.Review, error) {
+	var output dto.Review
+	cmd := `SELECT ` + reviewColumns + reviewFrom + ` WHERE mr.id = ?`
+	err := db.connection.Get(&output, cmd, id)
+	return &output, err
+}
+
+func (db *reviewDatabase) ExistsReview(orderItemId int64) (bool, error) {
+	var count int64
+	const cmd = `SELECT COUNT(*) FROM mkp_review WHERE order_item_id = ?`
+	err := db.connection.Get(&count, cmd, orderItemId)
+	return count > 0, err
+}
+
+func (db *reviewDatabase) SaveReview(review *dto.Review) (int64, error) {
+	const cmd = `
+			INSERT INTO mkp_review (
+				order_id,
+				order_item_id,
+				user_id,
+				pkg_id,
+				rating,
+				content,
+				images,
+				status,
+				create_time,
+				update_time
+			) VALUES (
+				:order_id,
+				:order_item_id,
+				:user_id,
+				:pkg_id,
+				:rating,
+				:content,
+				:images,
+				:status,
+				:create_time,
+				:create_time
+			)
+`
+	rs, err := db.connection.NamedExec(cmd, review)
+	if err != nil {
+		return 0, err
+	}
+	return rs.LastInsertId()
+}
+
+func (db *reviewDatabase) ListReviews(input *dto.ListReviewInput) ([]*dto.Review, error) {
+	output := make([]*dto.Review, 0, input.PageSize+1)
+	cmd := `SELECT ` + reviewColumns + reviewFrom + `
+			WHERE
+				(? = -1 OR mr.status = ?)
+				AND (? = 0 OR mr.pkg_id = ?)
+				AND (? = 0 OR mr.user_id = ?)
+			ORDER BY mr.id DESC
+			LIMIT ?, ?
+`
+	err := db.connection.Select(&output, cmd, input.Status, input.Status, input.PackageId, input.PackageId,
+		input.UserId, input.UserId, (input.PageNo-1)*input.PageSize, input.PageSize+1)
+	return output, err
+}
+
+func (db *reviewDatabase) ApproveReview(id int64, staffId int64, moderateTime int64) (ok bool, err error) {
+	tx, err := db.connection.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err != nil || !ok {
+			_ = tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	const cmd1 = `
+			UPDATE mkp_review
+			SET
+				status = 1,
+				staff_id = ?,
+				moderate_time = ?,
+				update_time = ?
+			WHERE
+				id = ?
+				AND status = 0
+`
+	rs, err := tx.Exec(cmd1, staffId, moderateTime, moderateTime, id)
+	if err != nil {
+		return false, err
+	}
+	if rows, err := rs.RowsAffected(); err != nil || rows != 1 {
+		return false, err
+	}
+	const cmd2 = `
+			UPDATE mkp_package AS mp
+				INNER JOIN mkp_review AS mr
+					ON mr.pkg_id = mp.id
+			SET
+				mp.review_count = mp.review_count + 1,
+				mp.rating_sum = mp.rating_sum + mr.rating
+			WHERE
+				mr.id = ?
+`
+	if _, err = tx.Exec(cmd2, id); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (db *reviewDatabase) RejectReview(id int64, remark string, staffId int64, moderateTime int64) (bool, error) {
+	const cmd = `
+			UPDATE mkp_review
+			SET
+				status = 2,
+				remark = ?,
+				staff_id = ?,
+				moderate_time = ?,
+				update_time = ?
+			WHERE
+				id = ?
+				AND status = 0
+`
+	rs, err := db.connection.Exec(cmd, remark, staffId, moderateTime, moderateTime, id)
+	if err != nil {
+		return false, err
+	}
+	rows, err := rs.RowsAffected()
+	return rows == 1, err
+}
+
+func NewReviewModel() ReviewModel {
+	return &reviewDatabase{connection: dao.Db}
+}
@@ -0,0 +1,34 @@
+package model
+
+import (
+	"github.com/jmoiron/sqlx"
+	"mk-api/server/dao"
+	"mk-api/server/dto"
+)
+
+type StaffModel interface {
+	FindStaffByUsername(username string) (*dto.Staff, error)
+	FindStaffById(id int64) (*dto.Staff, error)
+}
+
+type staffDatabase struct {
+	connection *sqlx.DB
+}
+
+func (db *staffDatabase) FindStaffByUsername(username string) (*dto.Staff, error) {
+	var staff dto.Staff
+	const cmd = `SELECT id, username, password, name, role, hospital_id, status FROM mka_staff WHERE username = ? AND is_deleted = 0`
+	err := db.connection.Get(&staff, cmd, username)
+	return &staff, err
+}
+
+func (db *staffDatabase) FindStaffById(id int64) (*dto.Staff, error) {
+	var staff dto.Staff
+	const cmd = `SELECT id, username, password, name, role, hospital_id, status FROM mka_staff WHERE id = ? AND is_deleted = 0`
+	err := db.connection.Get(&staff, cmd, id)
+	return &staff, err
+}
+
+func NewStaffModel() StaffModel {
+	return &staffDatabase{connection: dao.Db}
+}
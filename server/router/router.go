@@ -98,6 +98,46 @@ func InitRouter(middlewares ...gin.HandlerFunc) *gin.Engine {
 		controller.PayRegister(payRegisterRouteGroup)
 	}
 
+	// review_register, 套餐的评价列表挂在 package 路由组下
+	reviewRegisterRouteGroup := router.Group("/reviews")
+	reviewRegisterRouteGroup.Use(
+		middleware.MobileBoundRequired(),
+	)
+
+	{
+		controller.ReviewRegister(reviewRegisterRouteGroup, pkgRegisterRouteGroup)
+	}
+
+	// report_register
+	reportRegisterRouteGroup := router.Group("/reports")
+	reportRegisterRouteGroup.Use(
+		middleware.MobileBoundRequired(),
+	)
+
+	{
+		controller.ReportRegister(reportRegisterRouteGroup, userRouteGroup)
+	}
+
+	// invoice_register
+	invoiceRegisterRouteGroup := router.Group("/invoices")
+	invoiceRegisterRouteGroup.Use(
+		middleware.MobileBoundRequired(),
+	)
+
+	{
+		controller.InvoiceRegister(invoiceRegisterRouteGroup)
+	}
+
+	// coupon_register
+	couponRegisterRouteGroup := router.Group("/coupons")
+	couponRegisterRouteGroup.Use(
+		middleware.MobileBoundRequired(),
+	)
+
+	{
+		controller.CouponRegister(couponRegisterRouteGroup)
+	}
+
 	// region_register,
 	regionRegisterRouteGroup := router.Group("/regions")
 	regionRegisterRouteGroup.Use(
@@ -108,6 +148,13 @@ func InitRouter(middlewares ...gin.HandlerFunc) *gin.Engine {
 		controller.RegionRegister(regionRegisterRouteGroup)
 	}
 
+	// admin_register, 运营后台, 鉴权在子路由中处理, 登录接口不需要token
+	adminRouteGroup := router.Group("/admin")
+
+	{
+		controller.AdminRegister(adminRouteGroup)
+	}
+
 	return router
 }
 
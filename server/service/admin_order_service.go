@@ -0,0 +1,233 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"mk-api/library/ecode"
+	"mk-api/server/dto"
+	"mk-api/server/model"
+	"mk-api/server/util"
+	"mk-api/server/util/consts"
+	wcUtil "mk-api/server/util/wechat"
+)
+
+// AdminOrderService 运营后台处理订单, 操作人为登录的运营人员
+type AdminOrderService interface {
+	ListOrder(ctx *gin.Context, input *dto.AdminListOrderInput) (*dto.PaginateListOutput, error)
+	RetrieveOrder(ctx *gin.Context, id int64) (*dto.AdminOrderDetail, error)
+	// 确认体检预约, 推送预约成功消息给用户
+	ConfirmAppointment(ctx *gin.Context, input *dto.ConfirmAppointmentInput) error
+	// 同意退款申请并向微信发起退款
+	ApproveRefund(ctx *gin.Context, input *dto.ApproveRefundInput) error
+	RejectRefund(ctx *gin.Context, input *dto.RejectRefundInput) error
+	AddNote(ctx *gin.Context, input *dto.PostOrderNoteInput) (int64, error)
+}
+
+type adminOrderService struct {
+	adminOrderModel model.AdminOrderModel
+	orderModel      model.OrderModel
+	payModel        model.PayModel
+	orderService    OrderService
+	refundService   RefundService
+	auditService    AuditService
+}
+
+func (service *adminOrderService) ListOrder(ctx *gin.Context, input *dto.AdminListOrderInput) (*dto.PaginateListOutput, error) {
+	var output dto.PaginateListOutput
+	input.HospitalId = ctx.GetInt64("staffHospitalId")
+	list, err := service.adminOrderModel.ListOrder(input)
+	if err != nil {
+		util.Log.Errorf("运营后台查询订单列表出错, input: [%v], err: [%s]", input, err.Error())
+		return &output, err
+	}
+	if len(list) == int(input.PageSize)+1 {
+		output.HasNext = 1
+		list = list[:len(list)-1]
+	}
+	output.PageSize = int64(len(list))
+	output.PageNo = input.PageNo
+	output.List = list
+	return &output, nil
+}
+
+func (service *adminOrderService) RetrieveOrder(ctx *gin.Context, id int64) (*dto.AdminOrderDetail, error) {
+	logger := util.Log.WithFields(logrus.Fields{"order_id": id})
+	info, err := service.adminOrderModel.FindAdminOrderInfo(id)
+	if err == sql.ErrNoRows {
+		_ = ctx.Error(errors.New("订单不存在"))
+		return nil, ecode.RequestErr
+	} else if err != nil {
+		logger.Errorf("查询订单信息出错, err: [%s]", err.Error())
+		return nil, err
+	}
+	if err = service.checkHospitalScope(ctx, id); err != nil {
+		return nil, err
+	}
+	order, err := service.orderService.FindOrderDetail(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	output := &dto.AdminOrderDetail{RetrieveOrderOutput: order, AdminOrderInfo: info}
+	if output.Bills, err = service.adminOrderModel.ListBillsByOrderId(id); err != nil {
+		logger.Errorf("查询订单流水出错, err: [%s]", err.Error())
+		return nil, err
+	}
+	if output.Notes, err = service.adminOrderModel.ListOrderNotes(id); err != nil {
+		logger.Errorf("查询订单备注出错, err: [%s]", err.Error())
+		return nil, err
+	}
+	return output, nil
+}
+
+func (service *adminOrderService) ConfirmAppointment(ctx *gin.Context, input *dto.ConfirmAppointmentInput) error {
+	logger := util.Log.WithFields(logrus.Fields{"order_item_id": input.OrderItemId})
+	info, err := service.adminOrderModel.FindAppointmentInfo(input.OrderItemId)
+	if err == sql.ErrNoRows {
+		_ = ctx.Error(errors.New("订单项不存在"))
+		return ecode.RequestErr
+	} else if err != nil {
+		logger.Errorf("查询预约信息出错, err: [%s]", err.Error())
+		return err
+	}
+	if hospitalId := ctx.GetInt64("staffHospitalId"); hospitalId != 0 && hospitalId != info.HospitalId {
+		_ = ctx.Error(errors.New("只能确认所对接机构的预约"))
+		return ecode.AccessDenied
+	}
+
+	ok, err := service.adminOrderModel.ConfirmAppointment(input.OrderItemId, time.Now().Unix())
+	if err != nil {
+		logger.Errorf("确认预约出错, err: [%s]", err.Error())
+		return err
+	}
+	if !ok {
+		_ = ctx.Error(errors.New("只有已付款且已选择体检日期的订单项才能确认预约, 或该预约已经确认过"))
+		return consts.OrderStatusIllegal
+	}
+	service.auditService.Record(ctx, consts.AuditAppointmentConfirm, "mko_order_item", input.OrderItemId,
+		gin.H{"appointment_status": consts.AppointmentPending}, gin.H{"appointment_status": consts.AppointmentConfirmed})
+
+	examDate := time.Unix(info.ExamineDate, 0).Format("2006-01-02")
+	go wcUtil.AppointmentMadeNotifyClient(info.OpenId, examDate, info.HospitalName, input.Address, info.OrderId)
+	return nil
+}
+
+func (service *adminOrderService) ApproveRefund(ctx *gin.Context, input *dto.ApproveRefundInput) error {
+	logger := util.Log.WithFields(logrus.Fields{"order_id": input.OrderId})
+	refundFee := input.RefundFee
+	if refundFee == 0 {
+		preview, err := service.orderService.CalcRefund(ctx, input.OrderId)
+		if err != nil {
+			return err
+		}
+		if preview.RefundAmount == 0 {
+			_ = ctx.Error(errors.New("按改退规则该订单没有可退金额, 如需退款请指定退款金额"))
+			return ecode.RequestErr
+		}
+		refundFee = preview.RefundAmount
+	}
+
+	ok, err := service.adminOrderModel.AuditRefund(input.OrderId, consts.RefundAuditing, consts.RefundApproved, input.Remark)
+	if err != nil {
+		logger.Errorf("更新退款申请为已同意出错, err: [%s]", err.Error())
+		return err
+	}
+	if !ok {
+		_ = ctx.Error(errors.New("该订单没有待审核的退款申请"))
+		return consts.OrderStatusIllegal
+	}
+
+	err = service.refundService.LaunchRefund(ctx, &dto.LaunchRefundInput{
+		OrderId:   input.OrderId,
+		RefundFee: refundFee,
+		Reason:    "体检套餐退款",
+		ActorType: consts.ActorStaff,
+		ActorId:   ctx.GetInt64("staffId"),
+	})
+	if err != nil {
+		// 微信明确拒绝或没有生成退款流水时退款申请退回待审核, 以便重新处理。
+		// 退款流水仍在处理中说明微信可能已经受理, 保持已同意, 等待退款通知
+		if _, e := service.payModel.FindRefundBillByOrderId(input.OrderId); e == sql.ErrNoRows {
+			if _, e = service.adminOrderModel.AuditRefund(input.OrderId, consts.RefundApproved, consts.RefundAuditing, ""); e != nil {
+				logger.Errorf("退款申请退回待审核出错, err: [%s]", e.Error())
+			}
+		} else if e != nil {
+			logger.Errorf("查询订单退款流水出错, 退款申请保持已同意, err: [%s]", e.Error())
+		}
+		return err
+	}
+	service.auditService.Record(ctx, consts.AuditRefundApprove, "mko_order", input.OrderId,
+		gin.H{"refund_status": consts.RefundAuditing, "refund_audit_remark": ""},
+		// 退款金额记在退款流水上, 不是 mko_order 的字段
+		gin.H{"refund_status": consts.RefundApproved, "refund_audit_remark": input.Remark, "approved_refund_fee": refundFee})
+	return nil
+}
+
+func (service *adminOrderService) RejectRefund(ctx *gin.Context, input *dto.RejectRefundInput) error {
+	ok, err := service.adminOrderModel.AuditRefund(input.OrderId, consts.RefundAuditing, consts.RefundRejected, input.Remark)
+	if err != nil {
+		util.Log.WithFields(logrus.Fields{"order_id": input.OrderId}).Errorf("更新退款申请为已拒绝出错, err: [%s]", err.Error())
+		return err
+	}
+	if !ok {
+		_ = ctx.Error(errors.New("该订单没有待审核的退款申请"))
+		return consts.OrderStatusIllegal
+	}
+	service.auditService.Record(ctx, consts.AuditRefundReject, "mko_order", input.OrderId,
+		gin.H{"refund_status": consts.RefundAuditing, "refund_audit_remark": ""},
+		gin.H{"refund_status": consts.RefundRejected, "refund_audit_remark": input.Remark})
+	return nil
+}
+
+func (service *adminOrderService) AddNote(ctx *gin.Context, input *dto.PostOrderNoteInput) (int64, error) {
+	if err := service.checkHospitalScope(ctx, input.OrderId); err != nil {
+		return 0, err
+	}
+	note := &dto.OrderNote{
+		OrderId:    input.OrderId,
+		StaffId:    ctx.GetInt64("staffId"),
+		Content:    input.Content,
+		CreateTime: time.Now().Unix(),
+	}
+	id, err := service.adminOrderModel.SaveOrderNote(note)
+	if err != nil {
+		util.Log.WithFields(logrus.Fields{"order_id": input.OrderId}).Errorf("保存订单备注出错, err: [%s]", err.Error())
+		return 0, err
+	}
+	note.Id = id
+	service.auditService.Record(ctx, consts.AuditOrderNoteCreate, "mko_order_note", id, nil, note)
+	return id, nil
+}
+
+// 机构对接人只能处理包含所对接机构套餐的订单
+func (service *adminOrderService) checkHospitalScope(ctx *gin.Context, orderId int64) error {
+	hospitalId := ctx.GetInt64("staffHospitalId")
+	if hospitalId == 0 {
+		return nil
+	}
+	ok, err := service.adminOrderModel.IsOrderInHospital(orderId, hospitalId)
+	if err != nil {
+		util.Log.WithFields(logrus.Fields{"order_id": orderId, "hospital_id": hospitalId}).
+			Errorf("查询订单所属机构出错, err: [%s]", err.Error())
+		return err
+	}
+	if !ok {
+		_ = ctx.Error(errors.New("只能处理所对接机构的订单"))
+		return ecode.AccessDenied
+	}
+	return nil
+}
+
+func NewAdminOrderService(adminOrderModel model.AdminOrderModel, orderModel model.OrderModel, payModel model.PayModel, orderService OrderService, refundService RefundService, auditService AuditService) AdminOrderService {
+	return &adminOrderService{
+		adminOrderModel: adminOrderModel,
+		orderModel:      orderModel,
+		payModel:        payModel,
+		orderService:    orderService,
+		refundService:   refundService,
+		auditService:    auditService,
+	}
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"mk-api/server/dto"
+	"mk-api/server/model"
+	"mk-api/server/util"
+	"mk-api/server/util/audit"
+	"mk-api/server/util/consts"
+)
+
+type AuditService interface {
+	// Record 记录一次修改, 操作人和请求信息取自 ctx. 新建时 before 为 nil, 删除时 after 为 nil.
+	// 记录失败只打日志, 不影响业务
+	Record(ctx *gin.Context, action string, table string, targetId int64, before, after interface{})
+	ListAuditLog(ctx *gin.Context, input *dto.ListAuditLogInput) (*dto.PaginateListOutput, error)
+}
+
+type auditService struct {
+	auditModel model.AuditModel
+}
+
+func (service *auditService) Record(ctx *gin.Context, action string, table string, targetId int64, before, after interface{}) {
+	logger := util.Log.WithFields(logrus.Fields{"action": action, "target_table": table, "target_id": targetId})
+	b, a, err := audit.Diff(before, after)
+	if err != nil {
+		logger.Errorf("审计日志对比修改前后的记录出错, err: [%s]", err.Error())
+		return
+	}
+	log := &dto.AuditLog{
+		ActorType:   consts.ActorSystem,
+		Action:      action,
+		TargetTable: table,
+		TargetId:    targetId,
+		Before:      b,
+		After:       a,
+		CreateTime:  time.Now().Unix(),
+	}
+	if ctx != nil {
+		if staffId := ctx.GetInt64("staffId"); staffId != 0 {
+			log.ActorType, log.ActorId = consts.ActorStaff, staffId
+		} else if userId := ctx.GetInt64("userId"); userId != 0 {
+			log.ActorType, log.ActorId = consts.ActorUser, userId
+		}
+		if ctx.Request != nil {
+			log.Ip = ctx.ClientIP()
+			log.UserAgent = truncate(ctx.Request.UserAgent(), 255)
+			log.Request = truncate(ctx.Request.Method+" "+ctx.FullPath(), 128)
+		}
+	}
+	if err = service.auditModel.SaveAuditLog(log); err != nil {
+		logger.Errorf("保存审计日志出错, before: [%s], after: [%s], err: [%s]", b, a, err.Error())
+	}
+}
+
+func (service *auditService) ListAuditLog(ctx *gin.Context, input *dto.ListAuditLogInput) (*dto.PaginateListOutput, error) {
+	var output dto.PaginateListOutput
+	list, err := service.auditModel.ListAuditLog(input)
+	if err != nil {
+		util.Log.Errorf("查询审计日志出错, input: [%v], err: [%s]", input, err.Error())
+		return &output, err
+	}
+	if len(list) == int(input.PageSize)+1 {
+		output.HasNext = 1
+		list = list[:len(list)-1]
+	}
+	output.PageSize = int64(len(list))
+	output.PageNo = input.PageNo
+	output.List = list
+	return &output, nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+func NewAuditService(auditModel model.AuditModel) AuditService {
+	return &auditService{auditModel: auditModel}
+}
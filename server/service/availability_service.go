@@ -0,0 +1,99 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"mk-api/library/ecode"
+	"mk-api/server/dto"
+	"mk-api/server/model"
+	"mk-api/server/util"
+	"mk-api/server/util/calendar"
+	"mk-api/server/util/xtime"
+)
+
+type AvailabilityService interface {
+	// 套餐某月每天的营业情况和剩余名额, 供前端日期选择器置灰休息或约满的日期
+	ListAvailability(ctx *gin.Context, pkgId int64, input *dto.ListAvailabilityInput) ([]*dto.DayAvailability, error)
+}
+
+type availabilityService struct {
+	capacityModel model.CapacityModel
+	calendar      *calendar.Calendar
+}
+
+func (service *availabilityService) ListAvailability(ctx *gin.Context, pkgId int64, input *dto.ListAvailabilityInput) ([]*dto.DayAvailability, error) {
+	monthStart := time.Now()
+	if input.Month != "" {
+		var err error
+		if monthStart, err = time.ParseInLocation("2006-01", input.Month, time.Local); err != nil {
+			_ = ctx.Error(errors.New("月份格式应为 2006-01"))
+			return nil, ecode.RequestErr
+		}
+	}
+	monthStart = time.Date(monthStart.Year(), monthStart.Month(), 1, 0, 0, 0, 0, time.Local)
+	monthEnd := monthStart.AddDate(0, 1, 0)
+
+	capacity, err := service.capacityModel.FindPackageCapacity(pkgId)
+	if err == sql.ErrNoRows {
+		_ = ctx.Error(errors.New("套餐不存在"))
+		return nil, ecode.RequestErr
+	} else if err != nil {
+		util.Log.WithFields(logrus.Fields{"pkg_id": pkgId}).Errorf("查询套餐名额出错, err: [%s]", err.Error())
+		return nil, err
+	}
+	usages, err := service.capacityModel.ListCapacityUsage(capacity.HospitalId, pkgId, monthStart.Unix(), monthEnd.Unix())
+	if err != nil {
+		util.Log.WithFields(logrus.Fields{"pkg_id": pkgId}).Errorf("查询已预约人数出错, err: [%s]", err.Error())
+		return nil, err
+	}
+	hospitalBooked := make(map[int64]int64)
+	pkgBooked := make(map[int64]int64)
+	for _, u := range usages {
+		if u.PackageId == 0 {
+			hospitalBooked[u.ExamineDate] = u.Booked
+		} else {
+			pkgBooked[u.ExamineDate] = u.Booked
+		}
+	}
+
+	tomorrow := xtime.TomorrowStartAt()
+	closed := calendar.ParseWeekdays(capacity.ClosedWeekdays)
+	output := make([]*dto.DayAvailability, 0, 31)
+	for day := monthStart; day.Before(monthEnd); day = day.AddDate(0, 0, 1) {
+		date := day.Unix()
+		open, remark := service.calendar.IsOpen(date, closed)
+		remaining := remainingCapacity(capacity.HospitalCapacity, hospitalBooked[date], -1)
+		remaining = remainingCapacity(capacity.PackageCapacity, pkgBooked[date], remaining)
+		output = append(output, &dto.DayAvailability{
+			Date:      date,
+			Open:      open,
+			Remark:    remark,
+			Remaining: remaining,
+			Available: date >= tomorrow && open && remaining != 0,
+		})
+	}
+	return output, nil
+}
+
+// 在 current 的基础上按 capacity 收紧剩余名额, capacity 为 0 表示不限, 返回 -1 表示不限
+func remainingCapacity(capacity int64, booked int64, current int64) int64 {
+	if capacity == 0 {
+		return current
+	}
+	remaining := capacity - booked
+	if remaining < 0 {
+		remaining = 0
+	}
+	if current == -1 || remaining < current {
+		return remaining
+	}
+	return current
+}
+
+func NewAvailabilityService(capacityModel model.CapacityModel, calendar *calendar.Calendar) AvailabilityService {
+	return &availabilityService{capacityModel: capacityModel, calendar: calendar}
+}
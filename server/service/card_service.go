@@ -0,0 +1,149 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"mk-api/library/ecode"
+	"mk-api/server/dto"
+	"mk-api/server/model"
+	"mk-api/server/util"
+	"mk-api/server/util/card"
+	"mk-api/server/util/consts"
+)
+
+// CardService 运营后台管理企业客户购买的体检卡, 用户下单时的抵扣见 orderService.redeemCard
+type CardService interface {
+	// 创建批次并生成卡号
+	CreateCardBatch(ctx *gin.Context, input *dto.PostCardBatchInput) (int64, error)
+	ListCardBatches(ctx *gin.Context, input *dto.ListCardBatchInput) (*dto.PaginateListOutput, error)
+	// 作废批次中尚未使用的卡
+	VoidCardBatch(ctx *gin.Context, input *dto.VoidCardBatchInput) error
+	// 导出批次的全部卡号, 交给企业客户分发
+	ExportCardBatch(ctx *gin.Context, batchId int64) (*dto.CardBatch, []*dto.Card, error)
+}
+
+type cardService struct {
+	cardModel    model.CardModel
+	packageModel model.PackageModel
+	auditService AuditService
+}
+
+func (service *cardService) CreateCardBatch(ctx *gin.Context, input *dto.PostCardBatchInput) (int64, error) {
+	switch input.Type {
+	case card.TypePackage:
+		if input.PackageId == 0 {
+			_ = ctx.Error(errors.New("套餐卡需指定套餐"))
+			return 0, ecode.RequestErr
+		}
+		if _, err := service.packageModel.FindPackagePriceNTargetById(input.PackageId); err == sql.ErrNoRows {
+			_ = ctx.Error(errors.New("套餐不存在"))
+			return 0, ecode.RequestErr
+		} else if err != nil {
+			util.Log.WithFields(logrus.Fields{"pkg_id": input.PackageId}).Errorf("查询套餐出错, err: [%s]", err.Error())
+			return 0, err
+		}
+	case card.TypeValue:
+		if input.Value == 0 {
+			_ = ctx.Error(errors.New("储值卡需填写面值"))
+			return 0, ecode.RequestErr
+		}
+	}
+
+	codes := make([]string, 0, input.Quantity)
+	seen := make(map[string]bool, input.Quantity)
+	for int64(len(codes)) < input.Quantity {
+		code, err := card.GenerateCode()
+		if err != nil {
+			util.Log.Errorf("生成体检卡卡号出错, err: [%s]", err.Error())
+			return 0, err
+		}
+		if !seen[code] {
+			seen[code] = true
+			codes = append(codes, code)
+		}
+	}
+	batch := &dto.CardBatch{
+		Name:    input.Name,
+		Company: input.Company,
+		Rule: card.Rule{
+			Type:      input.Type,
+			PackageId: input.PackageId,
+			Value:     input.Value,
+		},
+		Quantity:   input.Quantity,
+		ValidStart: input.ValidStart,
+		ValidEnd:   input.ValidEnd,
+		Status:     consts.CardBatchActive,
+		StaffId:    ctx.GetInt64("staffId"),
+		CreateTime: time.Now().Unix(),
+	}
+	// 卡号与已有卡号重复的概率可以忽略, 重复时整批回滚, 重新提交即可
+	id, err := service.cardModel.SaveCardBatch(batch, codes)
+	if err != nil {
+		util.Log.Errorf("创建体检卡批次出错, input: [%v], err: [%s]", input, err.Error())
+		return 0, err
+	}
+	batch.Id = id
+	service.auditService.Record(ctx, consts.AuditCardBatchCreate, "mkc_card_batch", id, nil, batch)
+	return id, nil
+}
+
+func (service *cardService) ListCardBatches(ctx *gin.Context, input *dto.ListCardBatchInput) (*dto.PaginateListOutput, error) {
+	var output dto.PaginateListOutput
+	list, err := service.cardModel.ListCardBatches(input)
+	if err != nil {
+		util.Log.Errorf("查询体检卡批次出错, input: [%v], err: [%s]", input, err.Error())
+		return &output, err
+	}
+	if len(list) == int(input.PageSize)+1 {
+		output.HasNext = 1
+		list = list[:len(list)-1]
+	}
+	output.PageSize = int64(len(list))
+	output.PageNo = input.PageNo
+	output.List = list
+	return &output, nil
+}
+
+func (service *cardService) VoidCardBatch(ctx *gin.Context, input *dto.VoidCardBatchInput) error {
+	ok, err := service.cardModel.VoidCardBatch(input.BatchId, time.Now().Unix())
+	if err != nil {
+		util.Log.WithFields(logrus.Fields{"batch_id": input.BatchId}).Errorf("作废体检卡批次出错, err: [%s]", err.Error())
+		return err
+	}
+	if !ok {
+		_ = ctx.Error(errors.New("批次不存在或已经作废"))
+		return ecode.RequestErr
+	}
+	service.auditService.Record(ctx, consts.AuditCardBatchVoid, "mkc_card_batch", input.BatchId,
+		gin.H{"status": consts.CardBatchActive}, gin.H{"status": consts.CardBatchVoided})
+	return nil
+}
+
+func (service *cardService) ExportCardBatch(ctx *gin.Context, batchId int64) (*dto.CardBatch, []*dto.Card, error) {
+	logger := util.Log.WithFields(logrus.Fields{"batch_id": batchId})
+	batch, err := service.cardModel.FindCardBatchById(batchId)
+	if err == sql.ErrNoRows {
+		_ = ctx.Error(errors.New("批次不存在"))
+		return nil, nil, ecode.RequestErr
+	} else if err != nil {
+		logger.Errorf("查询体检卡批次出错, err: [%s]", err.Error())
+		return nil, nil, err
+	}
+	cards, err := service.cardModel.ListCardsByBatchId(batchId)
+	if err != nil {
+		logger.Errorf("查询批次的体检卡出错, err: [%s]", err.Error())
+		return nil, nil, err
+	}
+	// 卡号等同于现金, 导出操作需留痕
+	service.auditService.Record(ctx, consts.AuditCardBatchExport, "mkc_card_batch", batchId, nil, gin.H{"quantity": len(cards)})
+	return batch, cards, nil
+}
+
+func NewCardService(cardModel model.CardModel, packageModel model.PackageModel, auditService AuditService) CardService {
+	return &cardService{cardModel: cardModel, packageModel: packageModel, auditService: auditService}
+}
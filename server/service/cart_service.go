@@ -13,7 +13,7 @@ import (
 type CartService interface {
 	RetrieveCart(ctx *gin.Context) ([]dto.GetCartOutputElem, error)
 	CreateCart(ctx *gin.Context, pkgId int64, pkgCount int64) (err error)
-	RemoveCartEntries(input *dto.DeleteCartEntriesInput) (err error)
+	RemoveCartEntries(ctx *gin.Context, input *dto.DeleteCartEntriesInput) (err error)
 }
 
 type cartService struct {
@@ -21,8 +21,8 @@ type cartService struct {
 	packageModel model.PackageModel
 }
 
-func (service *cartService) RemoveCartEntries(input *dto.DeleteCartEntriesInput) (err error) {
-	return service.cartModel.RemoveCartEntries(input.CartIds)
+func (service *cartService) RemoveCartEntries(ctx *gin.Context, input *dto.DeleteCartEntriesInput) (err error) {
+	return service.cartModel.RemoveCartEntries(ctx.GetInt64("userId"), input.CartIds)
 }
 
 func (service *cartService) CreateCart(ctx *gin.Context, pkgId int64, pkgCount int64) (err error) {
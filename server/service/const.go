@@ -14,9 +14,10 @@ const (
 
 const (
 	Income FeeType = 1
-	// Outgo          = 2
+	Outgo  FeeType = 2
 )
 
 const (
-	Earned TransType = 1
+	Earned   TransType = 1
+	Refunded TransType = 2
 )
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"mk-api/library/ecode"
+	"mk-api/server/dto"
+	"mk-api/server/model"
+	"mk-api/server/util"
+	"mk-api/server/util/consts"
+	"mk-api/server/util/coupon"
+)
+
+type CouponService interface {
+	// 用户凭优惠码领取优惠券
+	Claim(ctx *gin.Context, input *dto.ClaimCouponInput) (int64, error)
+	ListUserCoupons(ctx *gin.Context, input *dto.ListUserCouponInput) ([]*dto.UserCoupon, error)
+	// 运营人员创建优惠券
+	CreateCoupon(ctx *gin.Context, input *dto.PostCouponInput) (int64, error)
+}
+
+type couponService struct {
+	couponModel  model.CouponModel
+	auditService AuditService
+}
+
+func (service *couponService) Claim(ctx *gin.Context, input *dto.ClaimCouponInput) (int64, error) {
+	userId := ctx.GetInt64("userId")
+	logger := util.Log.WithFields(logrus.Fields{"user_id": userId, "code": input.Code})
+	c, err := service.couponModel.FindCouponByCode(input.Code)
+	if err == sql.ErrNoRows {
+		_ = ctx.Error(errors.New("优惠码无效"))
+		return 0, ecode.RequestErr
+	} else if err != nil {
+		logger.Errorf("查询优惠券出错, err: [%s]", err.Error())
+		return 0, err
+	}
+	now := time.Now().Unix()
+	if c.Status != consts.CouponActive || now >= c.ValidEnd {
+		_ = ctx.Error(errors.New("优惠券已过期或已停止发放"))
+		return 0, ecode.RequestErr
+	}
+
+	id, err := service.couponModel.Claim(c.Id, userId, now)
+	switch err {
+	case nil:
+		return id, nil
+	case model.ErrCouponSoldOut:
+		_ = ctx.Error(errors.New("优惠券已经领完了"))
+		return 0, ecode.RequestErr
+	case model.ErrCouponLimitExceeded:
+		_ = ctx.Error(errors.New("您已经领取过该优惠券"))
+		return 0, ecode.RequestErr
+	}
+	logger.Errorf("领取优惠券出错, err: [%s]", err.Error())
+	return 0, err
+}
+
+func (service *couponService) ListUserCoupons(ctx *gin.Context, input *dto.ListUserCouponInput) ([]*dto.UserCoupon, error) {
+	userId := ctx.GetInt64("userId")
+	output, err := service.couponModel.ListUserCoupons(userId, input.Status)
+	if err != nil {
+		util.Log.WithFields(logrus.Fields{"user_id": userId}).Errorf("查询用户的优惠券出错, err: [%s]", err.Error())
+	}
+	return output, err
+}
+
+func (service *couponService) CreateCoupon(ctx *gin.Context, input *dto.PostCouponInput) (int64, error) {
+	if input.Type == coupon.TypeFixed && input.Amount == 0 || input.Type == coupon.TypePercent && input.Rate == 0 {
+		_ = ctx.Error(errors.New("满减券需填写减免金额, 折扣券需填写减免比例"))
+		return 0, ecode.RequestErr
+	}
+	if input.ScopeType != coupon.ScopeAll && input.ScopeId == 0 {
+		_ = ctx.Error(errors.New("请指定适用的套餐或体检机构"))
+		return 0, ecode.RequestErr
+	}
+	c := &dto.Coupon{
+		Name: input.Name,
+		Code: input.Code,
+		Rule: coupon.Rule{
+			Type:        input.Type,
+			Amount:      input.Amount,
+			Rate:        input.Rate,
+			Threshold:   input.Threshold,
+			MaxDiscount: input.MaxDiscount,
+			ScopeType:   input.ScopeType,
+			ScopeId:     input.ScopeId,
+		},
+		ValidStart:   input.ValidStart,
+		ValidEnd:     input.ValidEnd,
+		Total:        input.Total,
+		PerUserLimit: input.PerUserLimit,
+		Status:       consts.CouponActive,
+		CreateTime:   time.Now().Unix(),
+	}
+	id, err := service.couponModel.SaveCoupon(c)
+	if err != nil {
+		if _, e := service.couponModel.FindCouponByCode(input.Code); e == nil {
+			_ = ctx.Error(errors.New("优惠码已存在"))
+			return 0, ecode.RequestErr
+		}
+		util.Log.Errorf("创建优惠券出错, input: [%v], err: [%s]", input, err.Error())
+		return 0, err
+	}
+	c.Id = id
+	service.auditService.Record(ctx, consts.AuditCouponCreate, "mkc_coupon", id, nil, c)
+	return id, nil
+}
+
+func NewCouponService(couponModel model.CouponModel, auditService AuditService) CouponService {
+	return &couponService{couponModel: couponModel, auditService: auditService}
+}
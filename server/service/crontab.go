@@ -4,8 +4,18 @@ import (
 	"time"
 
 	"mk-api/server/model"
+	"mk-api/server/util/consts"
 )
 
+// 全局超时订单扫描, 其他模块通过 RegisterOrderExpireHook 挂载订单关闭后的处理
+var orderExpirer = NewOrderExpireService(model.NewOrderModel(), model.NewPayModel(),
+	NewOrderStateMachine(model.NewOrderStatusModel(), model.NewCapacityModel(), model.NewCouponModel(), model.NewCardModel()))
+
+// 体检日期已过的订单变为待评价
+var reviewSweeper = NewReviewService(model.NewReviewModel(),
+	NewOrderStateMachine(model.NewOrderStatusModel(), model.NewCapacityModel(), model.NewCouponModel(), model.NewCardModel()),
+	NewAuditService(model.NewAuditModel()))
+
 // func startTimer(f func()) {
 // 	go func() {
 // 		for {
@@ -20,18 +30,55 @@ import (
 // 	}()
 // }
 
-func startTimer(f func()) {
+// 每天 hour 点执行一次 f
+func startTimer(hour int, f func()) {
 	ticker := time.NewTicker(time.Hour * 1)
 	go func() {
 		for range ticker.C {
-			if time.Now().Format("15") == "00" {
+			if time.Now().Hour() == hour {
 				f()
 			}
 		}
 	}()
 }
 
+// 每隔 d 执行一次 f, 上一次执行完之前不会开始下一次
+func startTicker(d time.Duration, f func()) {
+	ticker := time.NewTicker(d)
+	go func() {
+		for range ticker.C {
+			f()
+		}
+	}()
+}
+
+func RegisterOrderExpireHook(name string, hook OrderExpireHook) {
+	orderExpirer.RegisterHook(name, hook)
+}
+
+// StartPayReconcile 微信支付对账依赖支付配置, 在注册支付路由时启动
+func StartPayReconcile(payService PayService) {
+	orderExpirer.RegisterGuard("wechat_pay_query", payService.GuardExpiredOrder)
+	startTicker(consts.PayReconcileInterval, payService.SweepPendingPayments)
+}
+
+// StartRefundRetry 重新发起结果未知的退款, 依赖支付配置, 在注册支付路由时启动
+func StartRefundRetry(refundService RefundService) {
+	startTicker(consts.RefundRetryInterval, refundService.SweepPendingRefunds)
+}
+
+// StartBillReconcile 每天核对前一天的微信支付对账单, 依赖支付配置, 在注册运营后台路由时启动
+func StartBillReconcile(reconcileService ReconcileService) {
+	startTimer(consts.BillReconcileHour, reconcileService.ReconcileYesterday)
+}
+
 func init() {
 	// 每天增加套餐销售量
-	startTimer(model.IncreasePkgSalesVolume)
+	startTimer(0, model.IncreasePkgSalesVolume)
+
+	// 关闭超时未支付的订单
+	startTicker(consts.OrderSweepInterval, orderExpirer.SweepExpiredOrders)
+
+	// 体检日期已过的订单变为待评价
+	startTicker(consts.ReviewSweepInterval, reviewSweeper.SweepReviewableOrders)
 }
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"mime/multipart"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"mk-api/library/ecode"
+	"mk-api/library/util/cos"
+	"mk-api/server/dto"
+	"mk-api/server/model"
+	"mk-api/server/util"
+	"mk-api/server/util/consts"
+	wcUtil "mk-api/server/util/wechat"
+)
+
+type InvoiceService interface {
+	// 用户为已付款的订单申请发票, 被驳回的申请可以修改后重新提交
+	ApplyInvoice(ctx *gin.Context, input *dto.PostInvoiceInput) (int64, error)
+	// 用户查询自己的发票申请时 input.UserId 取自 token, 运营后台查询全部
+	ListInvoices(ctx *gin.Context, input *dto.ListInvoiceInput) (*dto.PaginateListOutput, error)
+	// 运营人员上传电子发票 pdf 并标记为已开具, 推送通知给用户
+	IssueInvoice(ctx *gin.Context, input *dto.IssueInvoiceInput, pdf *multipart.FileHeader) error
+	RejectInvoice(ctx *gin.Context, input *dto.RejectInvoiceInput) error
+}
+
+type invoiceService struct {
+	invoiceModel model.InvoiceModel
+	auditService AuditService
+}
+
+func (service *invoiceService) ApplyInvoice(ctx *gin.Context, input *dto.PostInvoiceInput) (int64, error) {
+	userId := ctx.GetInt64("userId")
+	logger := util.Log.WithFields(logrus.Fields{"user_id": userId, "order_id": input.OrderId})
+	if input.TitleType == consts.InvoiceTitleCompany && input.TaxNo == "" {
+		_ = ctx.Error(errors.New("企业抬头需填写纳税人识别号"))
+		return 0, ecode.RequestErr
+	}
+	if input.TitleType == consts.InvoiceTitlePersonal {
+		input.TaxNo = ""
+	}
+
+	order, err := service.invoiceModel.FindInvoicableOrder(input.OrderId, userId)
+	if err == sql.ErrNoRows {
+		return 0, resourceNotFound(ctx, "订单")
+	} else if err != nil {
+		logger.Errorf("查询订单出错, err: [%s]", err.Error())
+		return 0, err
+	}
+	if order.Status != consts.Success && order.Status != consts.ToReview {
+		_ = ctx.Error(errors.New("只有已付款的订单才能申请发票"))
+		return 0, ecode.RequestErr
+	}
+	if order.RefundStatus == consts.RefundAuditing || order.RefundStatus == consts.RefundApproved {
+		_ = ctx.Error(errors.New("订单正在退款, 无法申请发票"))
+		return 0, ecode.RequestErr
+	}
+	if order.Amount == 0 {
+		_ = ctx.Error(errors.New("订单由体检卡全额抵扣, 请向购卡单位索取发票"))
+		return 0, ecode.RequestErr
+	}
+
+	now := time.Now().Unix()
+	invoice := &dto.Invoice{
+		OrderId:    input.OrderId,
+		UserId:     userId,
+		TitleType:  input.TitleType,
+		Title:      input.Title,
+		TaxNo:      input.TaxNo,
+		Email:      input.Email,
+		Amount:     order.Amount,
+		Status:     consts.InvoicePending,
+		CreateTime: now,
+		UpdateTime: now,
+	}
+	existing, err := service.invoiceModel.FindInvoiceByOrderId(input.OrderId)
+	if err == nil {
+		if existing.Status != consts.InvoiceRejected {
+			_ = ctx.Error(errors.New("该订单已经申请过发票"))
+			return 0, ecode.RequestErr
+		}
+		ok, err := service.invoiceModel.ResubmitInvoice(invoice)
+		if err != nil {
+			logger.Errorf("重新提交发票申请出错, err: [%s]", err.Error())
+			return 0, err
+		}
+		if !ok {
+			_ = ctx.Error(errors.New("该订单已经申请过发票"))
+			return 0, ecode.RequestErr
+		}
+		service.auditService.Record(ctx, consts.AuditInvoiceApply, "mko_invoice", existing.Id, existing, invoice)
+		return existing.Id, nil
+	} else if err != sql.ErrNoRows {
+		logger.Errorf("查询订单的发票申请出错, err: [%s]", err.Error())
+		return 0, err
+	}
+
+	id, err := service.invoiceModel.SaveInvoice(invoice)
+	if err != nil {
+		// 并发提交时由 uk_order_id 拦下重复的申请
+		if _, e := service.invoiceModel.FindInvoiceByOrderId(input.OrderId); e == nil {
+			_ = ctx.Error(errors.New("该订单已经申请过发票"))
+			return 0, ecode.RequestErr
+		}
+		logger.Errorf("保存发票申请出错, err: [%s]", err.Error())
+		return 0, err
+	}
+	invoice.Id = id
+	service.auditService.Record(ctx, consts.AuditInvoiceApply, "mko_invoice", id, nil, invoice)
+	return id, nil
+}
+
+func (service *invoiceService) ListInvoices(ctx *gin.Context, input *dto.ListInvoiceInput) (*dto.PaginateListOutput, error) {
+	var output dto.PaginateListOutput
+	list, err := service.invoiceModel.ListInvoices(input)
+	if err != nil {
+		util.Log.Errorf("查询发票申请出错, input: [%v], err: [%s]", input, err.Error())
+		return &output, err
+	}
+	if len(list) == int(input.PageSize)+1 {
+		output.HasNext = 1
+		list = list[:len(list)-1]
+	}
+	output.PageSize = int64(len(list))
+	output.PageNo = input.PageNo
+	output.List = list
+	return &output, nil
+}
+
+func (service *invoiceService) IssueInvoice(ctx *gin.Context, input *dto.IssueInvoiceInput, pdf *multipart.FileHeader) error {
+	logger := util.Log.WithFields(logrus.Fields{"invoice_id": input.InvoiceId})
+	invoice, err := service.pendingInvoice(ctx, input.InvoiceId)
+	if err != nil {
+		return err
+	}
+
+	err, pdfUrl, _ := cos.Upload2Tx(pdf)
+	if err != nil {
+		logger.Errorf("上传电子发票出错, err: [%s]", err.Error())
+		return err
+	}
+	ok, err := service.invoiceModel.IssueInvoice(input.InvoiceId, pdfUrl, ctx.GetInt64("staffId"), time.Now().Unix())
+	if err != nil {
+		logger.Errorf("更新发票申请为已开具出错, err: [%s]", err.Error())
+		return err
+	}
+	if !ok {
+		_ = ctx.Error(errors.New("该发票申请已被处理"))
+		return ecode.RequestErr
+	}
+	service.auditService.Record(ctx, consts.AuditInvoiceIssue, "mko_invoice", input.InvoiceId,
+		gin.H{"status": consts.InvoicePending, "pdf_url": ""}, gin.H{"status": consts.InvoiceIssued, "pdf_url": pdfUrl})
+
+	go wcUtil.InvoiceIssuedNotifyClient(invoice.OpenId, invoice.OutTradeNo, invoice.Title, invoice.Amount, invoice.Email)
+	return nil
+}
+
+func (service *invoiceService) RejectInvoice(ctx *gin.Context, input *dto.RejectInvoiceInput) error {
+	if _, err := service.pendingInvoice(ctx, input.InvoiceId); err != nil {
+		return err
+	}
+	ok, err := service.invoiceModel.RejectInvoice(input.InvoiceId, input.Remark, ctx.GetInt64("staffId"), time.Now().Unix())
+	if err != nil {
+		util.Log.WithFields(logrus.Fields{"invoice_id": input.InvoiceId}).Errorf("更新发票申请为已驳回出错, err: [%s]", err.Error())
+		return err
+	}
+	if !ok {
+		_ = ctx.Error(errors.New("该发票申请已被处理"))
+		return ecode.RequestErr
+	}
+	service.auditService.Record(ctx, consts.AuditInvoiceReject, "mko_invoice", input.InvoiceId,
+		gin.H{"status": consts.InvoicePending, "remark": ""}, gin.H{"status": consts.InvoiceRejected, "remark": input.Remark})
+	return nil
+}
+
+// 查询待开具的发票申请, 不存在或已被处理时返回 ecode.RequestErr
+func (service *invoiceService) pendingInvoice(ctx *gin.Context, id int64) (*dto.Invoice, error) {
+	invoice, err := service.invoiceModel.FindInvoiceById(id)
+	if err == sql.ErrNoRows {
+		_ = ctx.Error(errors.New("发票申请不存在"))
+		return nil, ecode.RequestErr
+	} else if err != nil {
+		util.Log.WithFields(logrus.Fields{"invoice_id": id}).Errorf("查询发票申请出错, err: [%s]", err.Error())
+		return nil, err
+	}
+	if invoice.Status != consts.InvoicePending {
+		_ = ctx.Error(errors.New("该发票申请已被处理"))
+		return nil, ecode.RequestErr
+	}
+	return invoice, nil
+}
+
+func NewInvoiceService(invoiceModel model.InvoiceModel, auditService AuditService) InvoiceService {
+	return &invoiceService{invoiceModel: invoiceModel, auditService: auditService}
+}
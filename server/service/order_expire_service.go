@@ -0,0 +1,144 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"mk-api/library/ecode"
+	"mk-api/server/dto"
+	"mk-api/server/model"
+	"mk-api/server/util"
+	"mk-api/server/util/consts"
+)
+
+// OrderExpireHook 订单因超时被关闭后调用, 用于挂载其他清理逻辑
+type OrderExpireHook func(order *dto.ExpiredOrder) error
+
+// OrderExpireGuard 关闭超时订单前调用, 返回 false 时本轮不关闭该订单, 用于确认订单确实未支付
+type OrderExpireGuard func(order *dto.ExpiredOrder) (bool, error)
+
+// OrderExpireService 定时扫描超时未支付的订单并关闭。
+// 状态保存在数据库中, 重启或重新部署都不会丢失; 关闭操作是条件更新, 多个实例同时扫描也只会有一个实例关闭成功并执行hook
+type OrderExpireService interface {
+	SweepExpiredOrders()
+	RegisterHook(name string, hook OrderExpireHook)
+	RegisterGuard(name string, guard OrderExpireGuard)
+}
+
+type orderExpireService struct {
+	orderModel   model.OrderModel
+	payModel     model.PayModel
+	stateMachine OrderStateMachine
+
+	mu         sync.RWMutex
+	hookNames  []string
+	hooks      map[string]OrderExpireHook
+	guardNames []string
+	guards     map[string]OrderExpireGuard
+}
+
+func (service *orderExpireService) RegisterHook(name string, hook OrderExpireHook) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	if _, ok := service.hooks[name]; !ok {
+		service.hookNames = append(service.hookNames, name)
+	}
+	service.hooks[name] = hook
+}
+
+func (service *orderExpireService) RegisterGuard(name string, guard OrderExpireGuard) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	if _, ok := service.guards[name]; !ok {
+		service.guardNames = append(service.guardNames, name)
+	}
+	service.guards[name] = guard
+}
+
+func (service *orderExpireService) SweepExpiredOrders() {
+	var (
+		total   int
+		afterId int64
+	)
+	for {
+		orders, err := service.orderModel.FindExpiredOrders(time.Now().Unix(), int64(consts.OrderExpireIn/time.Second), afterId, consts.OrderSweepBatch)
+		if err != nil {
+			util.Log.Errorf("查询超时未支付订单出错, err: [%s]", err.Error())
+			return
+		}
+		for _, order := range orders {
+			afterId = order.OrderId
+			if service.closeExpiredOrder(order) {
+				total++
+			}
+		}
+		if len(orders) < consts.OrderSweepBatch {
+			break
+		}
+	}
+	if total > 0 {
+		util.Log.Infof("close expired orders done, %d orders closed.", total)
+	}
+}
+
+func (service *orderExpireService) closeExpiredOrder(order *dto.ExpiredOrder) bool {
+	logger := util.Log.WithFields(logrus.Fields{
+		"order_id":     order.OrderId,
+		"out_trade_no": order.OutTradeNo,
+	})
+	if !service.closable(order, logger) {
+		return false
+	}
+	err := service.stateMachine.Transit(&dto.OrderTransition{
+		OrderId:   order.OrderId,
+		To:        consts.Closed,
+		ActorType: consts.ActorSystem,
+		Reason:    "超时未支付",
+	}, service.payModel.CloseBillByOrderId(order.OrderId))
+	if ecode.EqualError(consts.OrderStatusIllegal, err) {
+		logger.Debug("订单已被其他实例关闭或已支付")
+		return false
+	}
+	if err != nil {
+		logger.Errorf("关闭超时订单出错, err: [%s]", err.Error())
+		return false
+	}
+	logger.Info("超时未支付, 订单已关闭")
+
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+	for _, name := range service.hookNames {
+		if err = service.hooks[name](order); err != nil {
+			logger.Warningf("order expire hook [%s] failed, err: [%s]", name, err.Error())
+		}
+	}
+	return true
+}
+
+func (service *orderExpireService) closable(order *dto.ExpiredOrder, logger *logrus.Entry) bool {
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+	for _, name := range service.guardNames {
+		ok, err := service.guards[name](order)
+		if err != nil {
+			logger.Warningf("order expire guard [%s] failed, err: [%s]", name, err.Error())
+			return false
+		}
+		if !ok {
+			logger.Infof("order expire guard [%s] kept the order open", name)
+			return false
+		}
+	}
+	return true
+}
+
+func NewOrderExpireService(orderModel model.OrderModel, payModel model.PayModel, stateMachine OrderStateMachine) OrderExpireService {
+	return &orderExpireService{
+		orderModel:   orderModel,
+		payModel:     payModel,
+		stateMachine: stateMachine,
+		hooks:        make(map[string]OrderExpireHook),
+		guards:       make(map[string]OrderExpireGuard),
+	}
+}
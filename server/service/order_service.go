@@ -1,16 +1,14 @@
 package service
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
-	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/silenceper/wechat/v2/pay"
-	wo "github.com/silenceper/wechat/v2/pay/order"
 	"github.com/sirupsen/logrus"
 	"mk-api/library/ecode"
 	"mk-api/server/conf"
@@ -18,44 +16,71 @@ import (
 	"mk-api/server/dto"
 	"mk-api/server/model"
 	"mk-api/server/util"
+	"mk-api/server/util/calendar"
+	"mk-api/server/util/card"
 	"mk-api/server/util/consts"
+	"mk-api/server/util/coupon"
+	"mk-api/server/util/money"
+	"mk-api/server/util/refund"
 	"mk-api/server/util/token"
 	wxUtil "mk-api/server/util/wechat"
+	"mk-api/server/util/wxpay"
 	"mk-api/server/util/xtime"
 	"mk-api/server/validator/id_card"
 )
 
 type OrderService interface {
-	CreateOrder(ctx *gin.Context, input *dto.PostOrderInput) (*wo.Config, error)
+	// 创建订单, 返回调起微信支付的参数, 体检卡全额抵扣的订单直接变为已付款
+	CreateOrder(ctx *gin.Context, input *dto.PostOrderInput) (*dto.PostOrderOutput, error)
 	ListOrder(ctx *gin.Context, input *dto.ListOrderInput) (*dto.PaginateListOutput, error)
 	RetrieveOrder(ctx *gin.Context, id int64) (*dto.RetrieveOrderOutput, error)
+	// 同 RetrieveOrder, 但不校验订单归属, 供后台查看订单详情
+	FindOrderDetail(ctx *gin.Context, id int64) (*dto.RetrieveOrderOutput, error)
 	RemoveOrder(ctx *gin.Context, id int64) error
 	ModifyOrderItem(ctx *gin.Context, input *dto.PutOrderItemInput) error
 	CancelOrder(ctx *gin.Context, input *dto.CancelOrderInput) error
 	RefundOrder(ctx *gin.Context, input *dto.RefundOrderInput) error
+	// 按当前改退规则试算退款金额, 供用户申请退款前确认
+	PreviewRefund(ctx *gin.Context, orderId int64) (*dto.RefundPreviewOutput, error)
+	// 同 PreviewRefund, 但不校验订单归属, 供后台审核退款时使用
+	CalcRefund(ctx *gin.Context, orderId int64) (*dto.RefundPreviewOutput, error)
 }
 
 type orderService struct {
-	orderModel   model.OrderModel
-	cartModel    model.CartModel
-	packageModel model.PackageModel
-	payModel     model.PayModel
-	wechatPay    *pay.Pay
+	orderModel    model.OrderModel
+	cartModel     model.CartModel
+	packageModel  model.PackageModel
+	payModel      model.PayModel
+	capacityModel model.CapacityModel
+	couponModel   model.CouponModel
+	cardModel     model.CardModel
+	stateMachine  OrderStateMachine
+	calendar      *calendar.Calendar
+	gateway       wxpay.Gateway
+	auditService  AuditService
 }
 
 func (service *orderService) RefundOrder(ctx *gin.Context, input *dto.RefundOrderInput) error {
-	// 检查该订单是否已经申请过退款
-	refundReasonId := service.orderModel.FindRefundReasonIdByOrderId(input.Id)
-	if refundReasonId != 0 {
-		_ = ctx.Error(errors.New("您已经发起过退款申请，工作人员将会及时审核，请耐心等待"))
-		return ecode.RequestErr
+	input.UserId = ctx.GetInt64("userId")
+	owned, err := service.orderModel.IsOrderOwnedBy(input.Id, input.UserId)
+	if err != nil {
+		util.Log.Errorf("查询订单归属出错, order_id: [%d], err: [%s]", input.Id, err.Error())
+		return err
 	}
-
-	_, err := service.orderModel.RefundOrder(input)
+	if !owned {
+		return resourceNotFound(ctx, "订单")
+	}
+	rows, err := service.orderModel.RefundOrder(input)
 	if err != nil {
 		util.Log.Error(err.Error())
 		return err
 	}
+	// 订单未付款或已经申请过退款
+	if rows == 0 {
+		_ = ctx.Error(errors.New("您已经发起过退款申请，工作人员将会及时审核，请耐心等待"))
+		return ecode.RequestErr
+	}
+	service.auditService.Record(ctx, consts.AuditOrderRefundApply, "mko_order", input.Id, nil, input)
 
 	// 异步通知客服处理退款订单
 	go func() {
@@ -66,12 +91,140 @@ func (service *orderService) RefundOrder(ctx *gin.Context, input *dto.RefundOrde
 	return err
 }
 
+func (service *orderService) PreviewRefund(ctx *gin.Context, orderId int64) (*dto.RefundPreviewOutput, error) {
+	owned, err := service.orderModel.IsOrderOwnedBy(orderId, ctx.GetInt64("userId"))
+	if err != nil {
+		util.Log.Errorf("查询订单归属出错, order_id: [%d], err: [%s]", orderId, err.Error())
+		return nil, err
+	}
+	if !owned {
+		return nil, resourceNotFound(ctx, "订单")
+	}
+	return service.CalcRefund(ctx, orderId)
+}
+
+func (service *orderService) CalcRefund(ctx *gin.Context, orderId int64) (*dto.RefundPreviewOutput, error) {
+	logger := util.Log.WithFields(logrus.Fields{"order_id": orderId})
+	paidBill, err := service.payModel.FindPaidBillByOrderId(orderId)
+	if err == sql.ErrNoRows {
+		_ = ctx.Error(errors.New("只有已付款的订单才能申请退款"))
+		return nil, ecode.RequestErr
+	} else if err != nil {
+		logger.Errorf("查询订单支付流水出错, err: [%s]", err.Error())
+		return nil, err
+	}
+
+	orderItems, err := service.orderModel.FindRefundableOrderItems(orderId)
+	if err != nil {
+		logger.Errorf("查询订单项出错, err: [%s]", err.Error())
+		return nil, err
+	}
+	items := make([]*refund.Item, 0, len(orderItems))
+	for _, item := range orderItems {
+		items = append(items, &refund.Item{
+			OrderItemId:          item.OrderItemId,
+			Price:                item.PackagePrice - item.Discount - item.CardAmount,
+			ExamineDate:          item.ExamineDate,
+			RescheduleCount:      item.RescheduleCount,
+			AppointmentConfirmed: item.AppointmentStatus == consts.AppointmentConfirmed,
+		})
+	}
+
+	policy := conf.C.RefundPolicy
+	return &dto.RefundPreviewOutput{
+		OrderId: orderId,
+		PaidAt:  paidBill.TimeEnd,
+		Result:  policy.Calc(items, paidBill.TimeEnd, time.Now().Unix()),
+	}, nil
+}
+
 func (service *orderService) CancelOrder(ctx *gin.Context, input *dto.CancelOrderInput) error {
-	return service.orderModel.CancelOrder(input)
+	input.UserId = ctx.GetInt64("userId")
+	owned, err := service.orderModel.IsOrderOwnedBy(input.Id, input.UserId)
+	if err != nil {
+		util.Log.Errorf("查询订单归属出错, order_id: [%d], err: [%s]", input.Id, err.Error())
+		return err
+	}
+	if !owned {
+		return resourceNotFound(ctx, "订单")
+	}
+	// 先关闭微信订单, 否则本地关闭后用户仍能完成支付
+	paid, err := service.closeWechatOrder(input.Id)
+	if err != nil {
+		util.Log.WithFields(logrus.Fields{"order_id": input.Id}).Errorf("关闭微信订单出错, err: [%s]", err.Error())
+		return err
+	}
+	if paid {
+		_ = ctx.Error(errors.New("订单已支付, 不能取消"))
+		return consts.OrderStatusIllegal
+	}
+	err = service.stateMachine.Transit(&dto.OrderTransition{
+		OrderId:   input.Id,
+		To:        consts.Closed,
+		ActorType: consts.ActorUser,
+		ActorId:   input.UserId,
+		Reason:    "用户取消订单",
+	}, service.orderModel.SaveCancelReason(input), service.payModel.CloseBillByOrderId(input.Id))
+	if ecode.EqualError(consts.OrderStatusIllegal, err) {
+		_ = ctx.Error(errors.New("只有待付款的订单才能取消"))
+	}
+	if err == nil {
+		service.auditService.Record(ctx, consts.AuditOrderCancel, "mko_order", input.Id,
+			gin.H{"status": consts.Pending, "cancel_reason_id": 0},
+			gin.H{"status": consts.Closed, "cancel_reason_id": input.CancelReasonId})
+	}
+	return err
+}
+
+// closeWechatOrder 与关闭超时订单相同, 先查询再关闭, 返回 true 表示用户已经支付, 本地的支付结果由回调或对账确认
+func (service *orderService) closeWechatOrder(orderId int64) (bool, error) {
+	outTradeNo, err := service.orderModel.FindOutTradeNoByOrderId(orderId)
+	if err != nil {
+		return false, err
+	}
+	bill, err := service.payModel.FindBillByOutTradeNo(outTradeNo)
+	if err == sql.ErrNoRows {
+		// 统一下单失败, 微信侧没有订单
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	// 流水不是待支付时由状态机拒绝取消
+	if bill.Status != model.Processing {
+		return false, nil
+	}
+
+	ret, err := service.gateway.QueryOrder(outTradeNo)
+	switch {
+	case wxpay.IsErrCode(err, wxpay.ErrCodeOrderNotExist):
+		return false, nil
+	case err != nil:
+		return false, err
+	case ret.Paid():
+		return true, nil
+	}
+	err = service.gateway.CloseOrder(outTradeNo)
+	switch {
+	case wxpay.IsErrCode(err, wxpay.ErrCodeOrderPaid):
+		// 查询之后用户完成了支付
+		return true, nil
+	case wxpay.IsErrCode(err, wxpay.ErrCodeOrderClosed), wxpay.IsErrCode(err, wxpay.ErrCodeOrderNotExist):
+		return false, nil
+	}
+	return false, err
 }
 
 func (service *orderService) ModifyOrderItem(ctx *gin.Context, input *dto.PutOrderItemInput) error {
-	var err error
+	schedule, err := service.orderModel.FindOrderItemScheduleByIdNUserId(input.Id, ctx.GetInt64("userId"))
+	if err == sql.ErrNoRows {
+		return resourceNotFound(ctx, "订单项")
+	} else if err != nil {
+		util.Log.Errorf("failed to get order item schedule, order_item_id: [%d], err: [%s]", input.Id, err.Error())
+		return err
+	}
+	// 套餐以订单项实际所属的为准, 不信任请求中传入的 pkg_id
+	input.PackageId = schedule.PackageId
+
 	// 此处只取target， 因为价格不可变
 	priceNTargetInfo, err := service.packageModel.FindPackagePriceNTargetById(input.PackageId)
 	if err != nil {
@@ -103,29 +256,141 @@ func (service *orderService) ModifyOrderItem(ctx *gin.Context, input *dto.PutOrd
 		return ecode.RequestErr
 	}
 
-	err = service.orderModel.UpdateOrderItem(input)
-	if err != nil {
+	input.ExamineDate = xtime.DayStartAt(input.ExamineDate)
+
+	if schedule.OrderStatus != consts.Pending && schedule.OrderStatus != consts.Success {
+		_ = ctx.Error(errors.New("订单已关闭或已退款, 无法修改"))
+		return consts.OrderStatusIllegal
+	}
+
+	var (
+		log    *dto.OrderItemRescheduleLog
+		extras []model.TxFunc
+	)
+	// 历史订单项的体检日期不一定是零点, 按天比较, 选同一天不算改期
+	fromDate := schedule.ExamineDate
+	if fromDate != 0 {
+		fromDate = xtime.DayStartAt(fromDate)
+	}
+	if input.ExamineDate != fromDate {
+		capacity, err := service.capacityModel.FindPackageCapacity(schedule.PackageId)
+		if err != nil {
+			util.Log.Errorf("failed to get pkg capacity, pkg_id: [%d], err: [%s]", schedule.PackageId, err.Error())
+			return err
+		}
+		if err = service.checkOpen(ctx, capacity, input.ExamineDate); err != nil {
+			return err
+		}
+		// 先释放原日期的名额再占用新日期的名额
+		if schedule.ExamineDate != 0 {
+			extras = append(extras, service.capacityModel.Release([]*dto.CapacitySlot{{
+				Capacity: capacity, ExamineDate: schedule.ExamineDate, Count: 1,
+			}}))
+		}
+		extras = append(extras, service.capacityModel.Reserve([]*dto.CapacitySlot{{
+			Capacity: capacity, ExamineDate: input.ExamineDate, Count: 1,
+		}}))
+	}
+
+	// 已有体检日期的再修改才算改期, 超出免费次数的需用户确认收取服务费, 服务费在退款时扣除
+	if input.ExamineDate != fromDate && fromDate != 0 {
+		policy := conf.C.RefundPolicy
+		seq := schedule.RescheduleCount + 1
+		chargeable := policy.ChargeableReschedules(seq) > 0
+		if chargeable && !input.AcceptRescheduleFee {
+			_ = ctx.Error(fmt.Errorf("每位体检人可免费改期%d次, 已用完, 再次改期将在退款时扣除套餐实付金额的%d%%作为服务费",
+				policy.FreeReschedules, policy.RescheduleFeeRate))
+			return consts.RescheduleChargeable
+		}
+
+		log = &dto.OrderItemRescheduleLog{
+			OrderItemId: schedule.OrderItemId,
+			OrderId:     schedule.OrderId,
+			UserId:      ctx.GetInt64("userId"),
+			FromDate:    schedule.ExamineDate,
+			ToDate:      input.ExamineDate,
+			Seq:         seq,
+			CreateTime:  time.Now().Unix(),
+		}
+		if chargeable {
+			log.Chargeable = 1
+		}
+	}
+
+	ok, err := service.orderModel.UpdateOrderItem(input, schedule, log, extras...)
+	if err == model.ErrCapacityFull {
+		_ = ctx.Error(errors.New("所选体检日期已约满, 请选择其他日期"))
+		return consts.CapacityFull
+	} else if err != nil {
 		util.Log.Errorf("failed to update order item, input is [%#v], err: [%s]", input, err.Error())
+		return err
 	}
-	return err
+	if !ok {
+		_ = ctx.Error(errors.New("体检日期已被修改, 请刷新后重试"))
+		return ecode.RequestErr
+	}
+	service.auditService.Record(ctx, consts.AuditOrderItemUpdate, "mko_order_item", input.Id, &dto.Examinee{
+		ExamineeName:   schedule.ExamineeName,
+		ExamineeMobile: schedule.ExamineeMobile,
+		IdCardNo:       schedule.IdCardNo,
+		Gender:         schedule.Gender,
+		IsMarried:      schedule.IsMarried,
+		ExamineDate:    schedule.ExamineDate,
+	}, &input.Examinee)
+	return nil
 }
 
 func (service *orderService) RemoveOrder(ctx *gin.Context, id int64) error {
 	userId := ctx.GetInt64("userId")
-	err := service.orderModel.DeleteOrderByIdNUserId(userId, id)
+	ok, err := service.orderModel.DeleteOrderByIdNUserId(userId, id)
 	if err != nil {
 		util.Log.WithFields(logrus.Fields{
 			"user_id":  userId,
 			"order_id": id,
 		}).Errorf("删除订单失败， err: [%s]", err)
+		return err
 	}
-	return err
+	if !ok {
+		return resourceNotFound(ctx, "订单")
+	}
+	service.auditService.Record(ctx, consts.AuditOrderDelete, "mko_order", id, gin.H{"is_deleted": 0}, gin.H{"is_deleted": 1})
+	return nil
 }
 
 func (service *orderService) RetrieveOrder(ctx *gin.Context, id int64) (*dto.RetrieveOrderOutput, error) {
+	output, err := service.orderModel.FindOrderDetailByIdNUserId(id, ctx.GetInt64("userId"), service.packageModel)
+	if err == sql.ErrNoRows {
+		return nil, resourceNotFound(ctx, "订单")
+	} else if err != nil {
+		util.Log.Errorf("获取订单详情出错, err: [%s]", err)
+		return output, err
+	}
+	return service.completeOrderDetail(output)
+}
+
+func (service *orderService) FindOrderDetail(ctx *gin.Context, id int64) (*dto.RetrieveOrderOutput, error) {
 	output, err := service.orderModel.FindOrderDetailById(id, service.packageModel)
 	if err != nil {
-		util.Log.Errorf("获取订单详情出错, err: [%]", err)
+		util.Log.Errorf("获取订单详情出错, err: [%s]", err)
+		return nil, err
+	}
+	return service.completeOrderDetail(output)
+}
+
+// 补充剩余免费改期次数和状态变更记录
+func (service *orderService) completeOrderDetail(output *dto.RetrieveOrderOutput) (*dto.RetrieveOrderOutput, error) {
+	policy := conf.C.RefundPolicy
+	for _, agg := range output.AggregatedOrderItemsWithPkgItem {
+		for _, examinee := range agg.Examinees {
+			if examinee.RescheduleCount < policy.FreeReschedules {
+				examinee.FreeReschedulesLeft = policy.FreeReschedules - examinee.RescheduleCount
+			}
+		}
+	}
+	var err error
+	output.Timeline, err = service.stateMachine.Timeline(output.OrderId)
+	if err != nil {
+		util.Log.Errorf("获取订单状态变更记录出错, err: [%s]", err)
 	}
 	return output, err
 }
@@ -169,14 +434,14 @@ func (service *orderService) ListOrder(ctx *gin.Context, input *dto.ListOrderInp
 	return &output, err
 }
 
-func (service *orderService) CreateOrder(ctx *gin.Context, input *dto.PostOrderInput) (*wo.Config, error) {
+func (service *orderService) CreateOrder(ctx *gin.Context, input *dto.PostOrderInput) (*dto.PostOrderOutput, error) {
 	var err error
 
 	userId := ctx.GetInt64("userId")
 	orderItems := make([]*dto.OrderItem, 0, 4)
 	cartIds := make([]int64, 0, 8)
 
-	var amount float64
+	var amount money.Fen
 	for _, cItem := range input.CartItems {
 		cartIds = append(cartIds, cItem.CartId)
 
@@ -188,6 +453,11 @@ func (service *orderService) CreateOrder(ctx *gin.Context, input *dto.PostOrderI
 			util.Log.WithFields(logrus.Fields{"userId": userId}).Errorf("套餐不存在: [%v]", input)
 			return nil, ecode.ServerErr
 		}
+		snapshot, err := service.packageModel.FindPackageSnapshot(cItem.PackageId)
+		if err != nil {
+			util.Log.WithFields(logrus.Fields{"userId": userId}).Errorf("查询套餐快照出错, pkg_id: [%d], err: [%s]", cItem.PackageId, err)
+			return nil, err
+		}
 		// 检查套餐数量和体检人数量
 		diff := cItem.PackageCount - len(cItem.Examinees)
 		if diff < 0 {
@@ -221,15 +491,17 @@ func (service *orderService) CreateOrder(ctx *gin.Context, input *dto.PostOrderI
 				_ = ctx.Error(errors.New("体检日期必须是以秒为单位的时间戳"))
 				return nil, ecode.RequestErr
 			}
+			cItem.Examinees[i].ExamineDate = xtime.DayStartAt(cItem.Examinees[i].ExamineDate)
 
 			orderItem := &dto.OrderItem{
-				UserId:       userId,
-				OrderId:      0,
-				PackageId:    cItem.PackageId,
-				PackagePrice: priceNTargetInfo.Price,
-				CreateTime:   time.Now().Unix(),
-				UpdateTime:   time.Now().Unix(),
-				Examinee:     cItem.Examinees[i],
+				UserId:          userId,
+				OrderId:         0,
+				PackageId:       cItem.PackageId,
+				PackagePrice:    priceNTargetInfo.Price,
+				CreateTime:      time.Now().Unix(),
+				UpdateTime:      time.Now().Unix(),
+				PackageSnapshot: *snapshot,
+				Examinee:        cItem.Examinees[i],
 			}
 			orderItems = append(orderItems, orderItem)
 			amount += priceNTargetInfo.Price
@@ -237,13 +509,14 @@ func (service *orderService) CreateOrder(ctx *gin.Context, input *dto.PostOrderI
 
 		for i := 0; i < diff; i++ {
 			orderItem := &dto.OrderItem{
-				UserId:       userId,
-				OrderId:      0,
-				PackageId:    cItem.PackageId,
-				PackagePrice: priceNTargetInfo.Price,
-				CreateTime:   time.Now().Unix(),
-				UpdateTime:   time.Now().Unix(),
-				Examinee:     &dto.Examinee{},
+				UserId:          userId,
+				OrderId:         0,
+				PackageId:       cItem.PackageId,
+				PackagePrice:    priceNTargetInfo.Price,
+				CreateTime:      time.Now().Unix(),
+				UpdateTime:      time.Now().Unix(),
+				PackageSnapshot: *snapshot,
+				Examinee:        &dto.Examinee{},
 			}
 			orderItems = append(orderItems, orderItem)
 			amount += priceNTargetInfo.Price
@@ -270,66 +543,251 @@ func (service *orderService) CreateOrder(ctx *gin.Context, input *dto.PostOrderI
 		UpdateTime: time.Now().Unix(),
 	}
 
-	order.Id, err = service.orderModel.SaveOrder(&order, orderItems)
-	if err != nil {
+	extras := make([]model.TxFunc, 0, 2)
+	if input.UserCouponId != 0 {
+		if err = service.applyCoupon(ctx, &order, orderItems, input.UserCouponId); err != nil {
+			return nil, err
+		}
+		extras = append(extras, service.couponModel.Use(input.UserCouponId, order.OutTradeNo, order.CreateTime))
+	}
+	if input.CardCode != "" {
+		if err = service.redeemCard(ctx, &order, orderItems, input.CardCode); err != nil {
+			return nil, err
+		}
+		extras = append(extras, service.cardModel.Redeem(order.CardId, userId, order.OutTradeNo, order.CreateTime))
+	}
+
+	slots, err := service.bookingSlots(ctx, orderItems)
+	if _, ok := err.(ecode.Codes); ok {
+		return nil, err
+	} else if err != nil {
+		util.Log.WithFields(logrus.Fields{"userId": userId}).Errorf("查询套餐名额出错, err: [%s]", err.Error())
+		return nil, err
+	}
+	extras = append(extras, service.capacityModel.Reserve(slots))
+	order.Id, err = service.orderModel.SaveOrder(&order, orderItems, extras...)
+	if err == model.ErrCapacityFull {
+		_ = ctx.Error(errors.New("所选体检日期已约满, 请选择其他日期"))
+		return nil, consts.CapacityFull
+	} else if err == model.ErrCouponUsed {
+		_ = ctx.Error(errors.New("优惠券已被使用"))
+		return nil, ecode.RequestErr
+	} else if err == model.ErrCardUsed {
+		_ = ctx.Error(errors.New("体检卡已被使用"))
+		return nil, ecode.RequestErr
+	} else if err != nil {
 		errStr := fmt.Sprintf("failed to create order, input: [%v], err: [%s]", input, err.Error())
 		util.Log.WithFields(logrus.Fields{"userId": userId}).Errorf(errStr)
 		return nil, errors.New(errStr)
 	}
+	service.auditService.Record(ctx, consts.AuditOrderCreate, "mko_order", order.Id, nil, gin.H{"order": &order, "items": orderItems})
 
-	cfg, err := service.makeWechatOrderNPrepay(ctx, &order)
-	if err != nil {
-		errStr := fmt.Sprintf("failed to make wechat order and prepay, input: [%v], err: [%s]", input, err.Error())
-		util.Log.WithFields(logrus.Fields{"userId": userId}).Errorf(errStr)
-		return nil, errors.New(errStr)
+	output := &dto.PostOrderOutput{OrderId: order.Id}
+	if order.Amount == 0 {
+		if err = service.payByCard(ctx, &order); err != nil {
+			return nil, err
+		}
+		output.Paid = true
+	} else {
+		cfg, err := service.makeWechatOrderNPrepay(ctx, &order)
+		if err != nil {
+			errStr := fmt.Sprintf("failed to make wechat order and prepay, input: [%v], err: [%s]", input, err.Error())
+			util.Log.WithFields(logrus.Fields{"userId": userId}).Errorf(errStr)
+			return nil, errors.New(errStr)
+		}
+		output.Timestamp, output.NonceStr, output.PrePayID = cfg.Timestamp, cfg.NonceStr, cfg.PrePayID
+		output.SignType, output.Package, output.PaySign = cfg.SignType, cfg.Package, cfg.PaySign
 	}
 
 	// 最后生成预付单后才删除购物车
-	if err = service.cartModel.RemoveCartEntries(cartIds); err != nil {
+	if err = service.cartModel.RemoveCartEntries(userId, cartIds); err != nil {
 		util.Log.Errorf("更新购物车条目出错, err: [%s]", err.Error())
 	}
-	return cfg, nil
+	return output, nil
 }
 
-func (service *orderService) makeWechatOrderNPrepay(ctx *gin.Context, order *dto.Order) (*wo.Config, error) {
+// 校验体检卡, 抵扣金额按优惠后的应付金额分摊到订单项上, 订单金额改为抵扣后的实付金额
+func (service *orderService) redeemCard(ctx *gin.Context, order *dto.Order, items []*dto.OrderItem, code string) error {
+	c, err := service.cardModel.FindRedeemableCard(card.Normalize(code))
+	if err == sql.ErrNoRows {
+		_ = ctx.Error(errors.New("体检卡卡号无效"))
+		return ecode.RequestErr
+	} else if err != nil {
+		util.Log.WithFields(logrus.Fields{"card_code": code}).Errorf("查询体检卡出错, err: [%s]", err.Error())
+		return err
+	}
+	now := time.Now().Unix()
+	if c.Status != consts.CardUnused || c.BatchStatus != consts.CardBatchActive {
+		_ = ctx.Error(errors.New("体检卡已被使用或已作废"))
+		return ecode.RequestErr
+	}
+	if now < c.ValidStart || now >= c.ValidEnd {
+		_ = ctx.Error(errors.New("体检卡不在有效期内"))
+		return ecode.RequestErr
+	}
+
+	cardItems := make([]*card.Item, 0, len(items))
+	for _, item := range items {
+		cardItems = append(cardItems, &card.Item{
+			PackageId: item.PackageId,
+			Payable:   item.PackagePrice - item.Discount,
+		})
+	}
+	total, offsets, err := c.Offset(cardItems)
+	if err != nil {
+		_ = ctx.Error(err)
+		return ecode.RequestErr
+	}
+	for i, item := range items {
+		item.CardAmount = offsets[i]
+	}
+	order.CardId = c.Id
+	order.CardAmount = total
+	order.Amount -= total
+	return nil
+}
+
+// 体检卡全额抵扣的订单不经过微信支付, 直接变为已付款
+func (service *orderService) payByCard(ctx *gin.Context, order *dto.Order) error {
+	err := service.stateMachine.Transit(&dto.OrderTransition{
+		OrderId:   order.Id,
+		To:        consts.Success,
+		ActorType: consts.ActorUser,
+		ActorId:   order.UserId,
+		Reason:    "体检卡全额抵扣",
+	})
+	if err != nil {
+		util.Log.WithFields(logrus.Fields{"order_id": order.Id}).Errorf("体检卡全额抵扣, 修改订单状态出错, err: [%s]", err.Error())
+		return err
+	}
+	go func() {
+		now := time.Now().Unix()
+		wxUtil.OrderPaidNotifyStaff(conf.C.RecvOpenIds, order.OutTradeNo, 0, now)
+		wxUtil.OrderPaidNotifyClient(order.OpenId, order.OutTradeNo, 0, order.Id, now)
+	}()
+	return nil
+}
+
+// 校验用户的优惠券, 优惠金额按价格比例分摊到订单项上, 订单金额改为优惠后的实付金额
+func (service *orderService) applyCoupon(ctx *gin.Context, order *dto.Order, items []*dto.OrderItem, userCouponId int64) error {
+	uc, err := service.couponModel.FindUserCoupon(userCouponId, order.UserId)
+	if err == sql.ErrNoRows {
+		return resourceNotFound(ctx, "优惠券")
+	} else if err != nil {
+		util.Log.WithFields(logrus.Fields{"user_coupon_id": userCouponId}).Errorf("查询优惠券出错, err: [%s]", err.Error())
+		return err
+	}
+	now := time.Now().Unix()
+	if uc.Status != consts.CouponUnused {
+		_ = ctx.Error(errors.New("优惠券已被使用"))
+		return ecode.RequestErr
+	}
+	if uc.CouponStatus != consts.CouponActive || now < uc.ValidStart || now >= uc.ValidEnd {
+		_ = ctx.Error(errors.New("优惠券不在有效期内"))
+		return ecode.RequestErr
+	}
+
+	couponItems := make([]*coupon.Item, 0, len(items))
+	for _, item := range items {
+		couponItems = append(couponItems, &coupon.Item{
+			PackageId:  item.PackageId,
+			HospitalId: item.HospitalId,
+			Price:      item.PackagePrice,
+		})
+	}
+	total, discounts, err := uc.Apply(couponItems)
+	if err != nil {
+		_ = ctx.Error(err)
+		return ecode.RequestErr
+	}
+	for i, item := range items {
+		item.Discount = discounts[i]
+	}
+	order.UserCouponId = userCouponId
+	order.Discount = total
+	order.Amount -= total
+	return nil
+}
+
+// 检查体检日期机构是否营业, 并按套餐和体检日期汇总订单项要占用的名额, 尚未选择体检日期的不占用
+func (service *orderService) bookingSlots(ctx *gin.Context, items []*dto.OrderItem) ([]*dto.CapacitySlot, error) {
+	capacities := make(map[int64]*dto.PackageCapacity)
+	slots := make(map[[2]int64]*dto.CapacitySlot)
+	output := make([]*dto.CapacitySlot, 0, len(items))
+	for _, item := range items {
+		if item.ExamineDate == 0 {
+			continue
+		}
+		capacity, ok := capacities[item.PackageId]
+		if !ok {
+			var err error
+			if capacity, err = service.capacityModel.FindPackageCapacity(item.PackageId); err != nil {
+				return nil, err
+			}
+			capacities[item.PackageId] = capacity
+		}
+		if err := service.checkOpen(ctx, capacity, item.ExamineDate); err != nil {
+			return nil, err
+		}
+		key := [2]int64{item.PackageId, item.ExamineDate}
+		if slot, ok := slots[key]; ok {
+			slot.Count++
+			continue
+		}
+		slots[key] = &dto.CapacitySlot{Capacity: capacity, ExamineDate: item.ExamineDate, Count: 1}
+		output = append(output, slots[key])
+	}
+	return output, nil
+}
+
+// 体检日期遇法定节假日或机构休息日时返回 ecode.RequestErr
+func (service *orderService) checkOpen(ctx *gin.Context, capacity *dto.PackageCapacity, examineDate int64) error {
+	open, remark := service.calendar.IsOpen(examineDate, calendar.ParseWeekdays(capacity.ClosedWeekdays))
+	if !open {
+		_ = ctx.Error(fmt.Errorf("%s 体检机构不营业(%s), 请选择其他日期", time.Unix(examineDate, 0).Format("2006-01-02"), remark))
+		return ecode.RequestErr
+	}
+	return nil
+}
+
+func (service *orderService) makeWechatOrderNPrepay(ctx *gin.Context, order *dto.Order) (*wxpay.JSAPIParams, error) {
 	var err error
 
 	util.Log.WithFields(logrus.Fields{
 		"user_id": ctx.GetInt64("userId"),
 	}).Infof("用户的IP: [%s]", ctx.ClientIP())
 
-	// 微信统一下单
-	params := &wo.Params{
-		TotalFee:   strconv.Itoa(int(order.Amount)),
-		CreateIP:   ctx.ClientIP(),
-		Body:       "迈康-体检套餐",
-		OutTradeNo: order.OutTradeNo,
-		OpenID:     ctx.GetString("openId"),
-		TradeType:  "JSAPI",
-		SignType:   "MD5",
-		Detail:     "预约体检套餐",
-		Attach:     "迈康体检",
-		GoodsTag:   "",
-		NotifyURL:  conf.C.WeChat.PayNotifyURL,
-	}
-	wechatOrder := service.wechatPay.GetOrder() // 获取微信订单对象
-
-	cfg, err := wechatOrder.BridgeConfig(params) // 下单+获取prepayId+获取返回给前端的cfg
+	// 微信下单
+	prepayId, err := service.gateway.Prepay(&wxpay.PrepayParams{
+		OutTradeNo:  order.OutTradeNo,
+		TotalFee:    order.Amount,
+		Description: "迈康-体检套餐",
+		OpenID:      ctx.GetString("openId"),
+		ClientIP:    ctx.ClientIP(),
+		NotifyURL:   conf.C.WeChat.PayNotifyURL,
+		Attach:      "迈康体检",
+	})
 	if err != nil {
 		util.Log.Errorf("调用微信统一下单出错, err: [%s]", err)
 		return nil, err
 	}
+	// 返回给前端调起支付的参数
+	cfg, err := service.gateway.JSAPIParams(prepayId)
+	if err != nil {
+		util.Log.Errorf("生成调起支付参数出错, err: [%s]", err)
+		return nil, err
+	}
 
-	// 创建 mkb_trade_bill 条目
+	// 创建 mkb_trade_bill 条目, 超时未支付的订单由 orderExpirer 定时关闭
 	now := time.Now().Unix()
-	timeExpire := now + 3600*2
+	timeExpire := now + int64(consts.OrderExpireIn/time.Second)
 
 	bill := &dto.TradeBill{
 		OrderId:    order.Id,
 		OutTradeNo: order.OutTradeNo,
 		PrepayId:   cfg.PrePayID,
 		NonceStr:   cfg.NonceStr,
-		TotalFee:   int64(order.Amount),
+		TotalFee:   order.Amount,
 		FeeType:    Income,
 		Status:     0,
 		TransType:  Earned,
@@ -353,21 +811,23 @@ func (service *orderService) makeWechatOrderNPrepay(ctx *gin.Context, order *dto
 		"bill_id":  billId,
 	}).Infof("生成支付流水成功!")
 
-	time.AfterFunc(consts.OrderExpireIn, func() {
-		_ = service.payModel.ExpireBill(billId)
-		_ = service.orderModel.UpdateOrderStatus(params.OutTradeNo, consts.Closed)
-	})
-
-	return &cfg, nil
+	return cfg, nil
 }
 
-func NewOrderService(orderModel model.OrderModel, packageModel model.PackageModel,
-	cartModel model.CartModel, payModel model.PayModel, wechatPay *pay.Pay) OrderService {
+func NewOrderService(orderModel model.OrderModel, packageModel model.PackageModel, cartModel model.CartModel,
+	payModel model.PayModel, capacityModel model.CapacityModel, couponModel model.CouponModel, cardModel model.CardModel, stateMachine OrderStateMachine,
+	calendar *calendar.Calendar, gateway wxpay.Gateway, auditService AuditService) OrderService {
 	return &orderService{
-		orderModel:   orderModel,
-		packageModel: packageModel,
-		cartModel:    cartModel,
-		wechatPay:    wechatPay,
-		payModel:     payModel,
+		orderModel:    orderModel,
+		packageModel:  packageModel,
+		cartModel:     cartModel,
+		gateway:       gateway,
+		payModel:      payModel,
+		capacityModel: capacityModel,
+		couponModel:   couponModel,
+		cardModel:     cardModel,
+		stateMachine:  stateMachine,
+		calendar:      calendar,
+		auditService:  auditService,
 	}
 }
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"mk-api/server/dto"
+	"mk-api/server/model"
+	"mk-api/server/util"
+	"mk-api/server/util/consts"
+)
+
+// 合法的订单状态流转, 其余的流转一律拒绝
+//
+//	待付款 --支付成功--> 已付款 --退款成功--> 已退款
+//	   |                  |
+//	   +--取消/超时--> 已关闭  +--体检完成--> 待评价
+var orderTransitions = map[int8][]int8{
+	consts.Pending: {consts.Success, consts.Closed},
+	consts.Success: {consts.Refunded, consts.ToReview},
+}
+
+// 进入这些状态时释放订单占用的体检名额, 退回使用的优惠券和体检卡
+var releaseResourcesOn = map[int8]bool{
+	consts.Closed:   true,
+	consts.Refunded: true,
+}
+
+func CanTransit(from, to int8) bool {
+	for _, s := range orderTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// OrderStateMachine 订单状态的唯一修改入口, 每次变更都会在 mko_order_status_log 留下记录
+type OrderStateMachine interface {
+	// 非法流转或并发修改时返回 consts.OrderStatusIllegal
+	Transit(t *dto.OrderTransition, extras ...model.TxFunc) error
+	TransitByOutTradeNo(outTradeNo string, t *dto.OrderTransition, extras ...model.TxFunc) error
+	Timeline(orderId int64) ([]*dto.OrderStatusLog, error)
+}
+
+type orderStateMachine struct {
+	statusModel   model.OrderStatusModel
+	capacityModel model.CapacityModel
+	couponModel   model.CouponModel
+	cardModel     model.CardModel
+}
+
+func (sm *orderStateMachine) Transit(t *dto.OrderTransition, extras ...model.TxFunc) error {
+	logger := util.Log.WithFields(logrus.Fields{"order_id": t.OrderId})
+
+	from, err := sm.statusModel.FindOrderStatusById(t.OrderId)
+	if err != nil {
+		logger.Errorf("查询订单状态出错, err: [%s]", err.Error())
+		return err
+	}
+	if !CanTransit(from, t.To) {
+		logger.Warningf("非法的订单状态变更: [%d] -> [%d]", from, t.To)
+		return consts.OrderStatusIllegal
+	}
+
+	t.From = from
+	t.CreateTime = time.Now().Unix()
+	if releaseResourcesOn[t.To] {
+		extras = append(extras, sm.capacityModel.ReleaseByOrderId(t.OrderId), sm.couponModel.ReleaseByOrderId(t.OrderId),
+			sm.cardModel.ReleaseByOrderId(t.OrderId))
+	}
+	ok, err := sm.statusModel.TransitOrderStatus(t, extras...)
+	if err != nil {
+		logger.Errorf("修改订单状态出错, err: [%s]", err.Error())
+		return err
+	}
+	if !ok {
+		logger.Warningf("订单状态已被修改, 放弃变更: [%d] -> [%d]", from, t.To)
+		return consts.OrderStatusIllegal
+	}
+	logger.Infof("订单状态变更: [%d] -> [%d], actor: [%d-%d], reason: [%s]", from, t.To, t.ActorType, t.ActorId, t.Reason)
+	return nil
+}
+
+func (sm *orderStateMachine) TransitByOutTradeNo(outTradeNo string, t *dto.OrderTransition, extras ...model.TxFunc) error {
+	orderId, err := sm.statusModel.FindOrderIdByOutTradeNo(outTradeNo)
+	if err != nil {
+		util.Log.WithFields(logrus.Fields{"out_trade_no": outTradeNo}).
+			Errorf("根据out_trade_no查询订单出错, err: [%s]", err.Error())
+		return err
+	}
+	t.OrderId = orderId
+	return sm.Transit(t, extras...)
+}
+
+func (sm *orderStateMachine) Timeline(orderId int64) ([]*dto.OrderStatusLog, error) {
+	return sm.statusModel.ListOrderStatusLog(orderId)
+}
+
+func NewOrderStateMachine(statusModel model.OrderStatusModel, capacityModel model.CapacityModel,
+	couponModel model.CouponModel, cardModel model.CardModel) OrderStateMachine {
+	return &orderStateMachine{statusModel: statusModel, capacityModel: capacityModel, couponModel: couponModel, cardModel: cardModel}
+}
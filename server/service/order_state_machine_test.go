@@ -0,0 +1,29 @@
+package service
+
+import (
+	"testing"
+
+	"mk-api/server/util/consts"
+)
+
+func TestCanTransit(t *testing.T) {
+	cases := []struct {
+		from, to int8
+		want     bool
+	}{
+		{consts.Pending, consts.Success, true},
+		{consts.Pending, consts.Closed, true},
+		{consts.Success, consts.Refunded, true},
+		{consts.Success, consts.ToReview, true},
+		{consts.Pending, consts.Refunded, false},
+		{consts.Success, consts.Closed, false},
+		{consts.Closed, consts.Success, false},
+		{consts.Refunded, consts.Success, false},
+		{consts.Success, consts.Success, false},
+	}
+	for _, c := range cases {
+		if got := CanTransit(c.from, c.to); got != c.want {
+			t.Errorf("CanTransit(%d, %d) = %v, want %v", c.from, c.to, got, c.want)
+		}
+	}
+}
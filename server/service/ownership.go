@@ -0,0 +1,15 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"mk-api/server/util/consts"
+)
+
+// 用户侧按 id 访问的资源都以 user_id 为条件查询, 查不到时统一返回 ResourceNotFound,
+// 不区分资源不存在和属于其他用户, 避免通过返回值枚举他人的订单和地址
+func resourceNotFound(ctx *gin.Context, name string) error {
+	_ = ctx.Error(fmt.Errorf("%s不存在", name))
+	return consts.ResourceNotFound
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"database/sql"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"mk-api/server/dto"
+	"mk-api/server/model"
+	"mk-api/server/util/consts"
+)
+
+// 资源都属于 owner, 用另一个用户访问时应返回 ResourceNotFound 且不触发任何修改
+const (
+	owner    int64 = 1
+	stranger int64 = 2
+)
+
+type fakeOrderModel struct {
+	model.OrderModel
+	mutated bool
+}
+
+func (m *fakeOrderModel) IsOrderOwnedBy(orderId int64, userId int64) (bool, error) {
+	return userId == owner, nil
+}
+
+func (m *fakeOrderModel) FindOrderDetailByIdNUserId(id int64, userId int64, pkgModel model.PackageModel) (*dto.RetrieveOrderOutput, error) {
+	if userId != owner {
+		return nil, sql.ErrNoRows
+	}
+	return &dto.RetrieveOrderOutput{}, nil
+}
+
+func (m *fakeOrderModel) FindOrderItemScheduleByIdNUserId(orderItemId int64, userId int64) (*dto.OrderItemSchedule, error) {
+	if userId != owner {
+		return nil, sql.ErrNoRows
+	}
+	return &dto.OrderItemSchedule{OrderItemId: orderItemId}, nil
+}
+
+func (m *fakeOrderModel) FindOrderPayStatusByIdNUserId(orderId int64, userId int64) (*dto.OrderPayStatus, error) {
+	if userId != owner {
+		return nil, sql.ErrNoRows
+	}
+	return &dto.OrderPayStatus{}, nil
+}
+
+func (m *fakeOrderModel) DeleteOrderByIdNUserId(userId int64, id int64) (bool, error) {
+	return userId == owner, nil
+}
+
+func (m *fakeOrderModel) RefundOrder(input *dto.RefundOrderInput) (int64, error) {
+	m.mutated = true
+	return 1, nil
+}
+
+func (m *fakeOrderModel) SaveCancelReason(input *dto.CancelOrderInput) model.TxFunc {
+	m.mutated = true
+	return nil
+}
+
+func (m *fakeOrderModel) UpdateOrderItem(input *dto.PutOrderItemInput, schedule *dto.OrderItemSchedule,
+	log *dto.OrderItemRescheduleLog, extras ...model.TxFunc) (bool, error) {
+	m.mutated = true
+	return true, nil
+}
+
+type fakeStateMachine struct {
+	OrderStateMachine
+	mutated bool
+}
+
+func (m *fakeStateMachine) Transit(t *dto.OrderTransition, extras ...model.TxFunc) error {
+	m.mutated = true
+	return nil
+}
+
+type fakePayModel struct {
+	model.PayModel
+}
+
+func (m *fakePayModel) CheckPayStatusByPrepayIdNUserId(prepayId string, userId int64) (*dto.TradeBill, error) {
+	if userId != owner {
+		return nil, sql.ErrNoRows
+	}
+	return &dto.TradeBill{Status: consts.Success}, nil
+}
+
+func (m *fakePayModel) CloseBillByOrderId(orderId int64) model.TxFunc {
+	return nil
+}
+
+type fakeAddrModel struct {
+	model.UserAddrModel
+	mutated bool
+}
+
+func (m *fakeAddrModel) FindUserAddrByIdNUserId(id int64, userId int64) (*dto.GetUserAddrOutput, error) {
+	if userId != owner {
+		return nil, sql.ErrNoRows
+	}
+	return &dto.GetUserAddrOutput{Id: id, UserId: userId}, nil
+}
+
+func (m *fakeAddrModel) DeleteUserAddrByIdNUserId(id int64, userId int64) error {
+	m.mutated = true
+	return nil
+}
+
+func (m *fakeAddrModel) UpdateUserAddr(id int64, userId int64, addr *dto.UpdateUserAddrInput) error {
+	m.mutated = true
+	return nil
+}
+
+func (m *fakeAddrModel) CancelOriginDefaultAddr(userId int64) error {
+	m.mutated = true
+	return nil
+}
+
+type fakeExamineeModel struct {
+	model.ExamineeModel
+	mutated bool
+}
+
+func (m *fakeExamineeModel) FindExamineeByIdNUserId(id int64, userId int64) (*dto.ExamineeBean, error) {
+	if userId != owner {
+		return nil, sql.ErrNoRows
+	}
+	return &dto.ExamineeBean{Id: id, UserId: userId}, nil
+}
+
+func (m *fakeExamineeModel) UpdateExaminee(bean *dto.ExamineeBean) error {
+	m.mutated = true
+	return nil
+}
+
+func (m *fakeExamineeModel) DeleteExamineeByIdNUserId(id int64, userId int64) error {
+	m.mutated = true
+	return nil
+}
+
+func strangerContext() *gin.Context {
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Set("userId", stranger)
+	return ctx
+}
+
+func assertNotFound(t *testing.T, name string, err error) {
+	t.Helper()
+	if err != consts.ResourceNotFound {
+		t.Errorf("%s: err = %v, want ResourceNotFound", name, err)
+	}
+}
+
+func TestOrderOwnership(t *testing.T) {
+	orderModel := &fakeOrderModel{}
+	stateMachine := &fakeStateMachine{}
+	service := &orderService{orderModel: orderModel, payModel: &fakePayModel{}, stateMachine: stateMachine}
+
+	_, err := service.RetrieveOrder(strangerContext(), 1)
+	assertNotFound(t, "RetrieveOrder", err)
+	assertNotFound(t, "RemoveOrder", service.RemoveOrder(strangerContext(), 1))
+	assertNotFound(t, "CancelOrder", service.CancelOrder(strangerContext(), &dto.CancelOrderInput{Id: 1}))
+	assertNotFound(t, "RefundOrder", service.RefundOrder(strangerContext(), &dto.RefundOrderInput{Id: 1}))
+	_, err = service.PreviewRefund(strangerContext(), 1)
+	assertNotFound(t, "PreviewRefund", err)
+	assertNotFound(t, "ModifyOrderItem", service.ModifyOrderItem(strangerContext(), &dto.PutOrderItemInput{Id: 1, PackageId: 1}))
+
+	if orderModel.mutated || stateMachine.mutated {
+		t.Error("order of another user was modified")
+	}
+}
+
+func TestPayOwnership(t *testing.T) {
+	service := &payService{orderModel: &fakeOrderModel{}, payModel: &fakePayModel{}}
+
+	_, err := service.Launch2ndPay(strangerContext(), 1)
+	assertNotFound(t, "Launch2ndPay", err)
+	_, err = service.CheckPayStatus(strangerContext(), "prepay_id")
+	assertNotFound(t, "CheckPayStatus", err)
+}
+
+func TestAddrOwnership(t *testing.T) {
+	addrModel := &fakeAddrModel{}
+	service := &userService{addrModel: addrModel}
+
+	_, err := service.RetrieveAddr(strangerContext(), 1)
+	assertNotFound(t, "RetrieveAddr", err)
+	assertNotFound(t, "DeleteAddr", service.DeleteAddr(strangerContext(), 1))
+	assertNotFound(t, "UpdateUserAddr", service.UpdateUserAddr(strangerContext(), 1, &dto.UpdateUserAddrInput{IsDefault: 1}))
+
+	if addrModel.mutated {
+		t.Error("address of another user was modified")
+	}
+}
+
+func TestExamineeOwnership(t *testing.T) {
+	examineeModel := &fakeExamineeModel{}
+	service := &userService{examineeModel: examineeModel}
+
+	input := &dto.PostExamineeInput{IdCardNo: "11010519491231002X"}
+	assertNotFound(t, "ModifyExaminee", service.ModifyExaminee(strangerContext(), 1, input))
+	assertNotFound(t, "RemoveExaminee", service.RemoveExaminee(strangerContext(), 1, stranger))
+
+	if examineeModel.mutated {
+		t.Error("examinee of another user was modified")
+	}
+}
@@ -1,44 +1,53 @@
 package service
 
 import (
-	"encoding/xml"
+	"database/sql"
 	"errors"
+	"fmt"
 	"io/ioutil"
-	"sync"
 	"time"
 
-	wo "github.com/silenceper/wechat/v2/pay/order"
 	"mk-api/library/ecode"
 	"mk-api/server/conf"
+	. "mk-api/server/dao"
+	"mk-api/server/dto"
 
 	"github.com/gin-gonic/gin"
-	"github.com/silenceper/wechat/v2/pay/notify"
 	"github.com/sirupsen/logrus"
 	"mk-api/server/model"
 	"mk-api/server/util"
 	"mk-api/server/util/consts"
 	wcUtil "mk-api/server/util/wechat"
+	"mk-api/server/util/wxpay"
 )
 
 type PayService interface {
 	WechatPayCallBack(ctx *gin.Context) bool
 	CheckPayStatus(ctx *gin.Context, prepayId string) (status int8, err error)
-	Launch2ndPay(ctx *gin.Context, orderId int64) (*wo.Config, error)
+	Launch2ndPay(ctx *gin.Context, orderId int64) (*wxpay.JSAPIParams, error)
+	// 主动向微信查询支付结果, 已支付的按支付成功处理, 返回是否已支付
+	SyncPayResult(outTradeNo string) (bool, error)
+	// 支付后长时间未收到回调的订单主动查询, 防止回调丢失
+	SweepPendingPayments()
+	// 作为 OrderExpireGuard 在关闭超时订单前调用
+	GuardExpiredOrder(order *dto.ExpiredOrder) (bool, error)
 }
 
 type payService struct {
-	payModel   model.PayModel
-	orderModel model.OrderModel
-	notify     *notify.Notify
+	payModel     model.PayModel
+	orderModel   model.OrderModel
+	stateMachine OrderStateMachine
+	gateway      wxpay.Gateway
 }
 
-func (service *payService) Launch2ndPay(ctx *gin.Context, orderId int64) (cfg *wo.Config, err error) {
-	payStatus, err := service.orderModel.FindOrderPayStatusById(orderId)
-	if err != nil {
-		_ = ctx.Error(err)
+func (service *payService) Launch2ndPay(ctx *gin.Context, orderId int64) (cfg *wxpay.JSAPIParams, err error) {
+	payStatus, err := service.orderModel.FindOrderPayStatusByIdNUserId(orderId, ctx.GetInt64("userId"))
+	if err == sql.ErrNoRows {
+		return nil, resourceNotFound(ctx, "订单")
+	} else if err != nil {
 		util.Log.WithFields(logrus.Fields{"order_id": orderId}).
 			Errorf("failed to get order pay status, err: [%s]", err)
-		return nil, ecode.NothingFound
+		return nil, err
 	}
 	if payStatus.TimeExpire < time.Now().Unix() || payStatus.Status != 0 {
 
@@ -48,7 +57,7 @@ func (service *payService) Launch2ndPay(ctx *gin.Context, orderId int64) (cfg *w
 		_ = ctx.Error(err)
 		return nil, ecode.RequestErr
 	}
-	cfg, err = wcUtil.Launch2ndPay(payStatus.NonceStr, payStatus.PrepayId)
+	cfg, err = service.gateway.JSAPIParams(payStatus.PrepayId)
 	if err != nil {
 		util.Log.WithFields(logrus.Fields{"order_id": orderId}).
 			Errorf("failed to calc paySign, err: [%s]", err.Error())
@@ -59,12 +68,32 @@ func (service *payService) Launch2ndPay(ctx *gin.Context, orderId int64) (cfg *w
 
 }
 
+// CheckPayStatus 本地仍为待支付时向微信查询一次, 避免回调延迟或丢失时前端一直等待
 func (service *payService) CheckPayStatus(ctx *gin.Context, prepayId string) (status int8, err error) {
-	status, err = service.payModel.CheckPayStatusByPrepayId(prepayId)
-	if err != nil {
+	bill, err := service.payModel.CheckPayStatusByPrepayIdNUserId(prepayId, ctx.GetInt64("userId"))
+	if err == sql.ErrNoRows {
+		return 0, resourceNotFound(ctx, "支付单")
+	} else if err != nil {
 		util.Log.Errorf("查询订单付款状态出错, err: [%s]", err)
+		return 0, err
+	}
+	if bill.Status != consts.Pending {
+		return bill.Status, nil
+	}
+	// 前端会频繁轮询, 同一订单限制查询微信的频率
+	if ok, err := Rdb.ApiCache.SetNxEx(consts.CachePayQuery+"."+bill.OutTradeNo, 1, consts.PayQueryInterval); err != nil || !ok {
+		return bill.Status, nil
 	}
-	return
+	paid, err := service.SyncPayResult(bill.OutTradeNo)
+	if err != nil {
+		util.Log.WithFields(logrus.Fields{"out_trade_no": bill.OutTradeNo}).
+			Warningf("轮询支付状态时查询微信订单出错, err: [%s]", err.Error())
+		return bill.Status, nil
+	}
+	if paid {
+		return consts.Success, nil
+	}
+	return bill.Status, nil
 }
 
 func (service *payService) WechatPayCallBack(ctx *gin.Context) bool {
@@ -78,76 +107,170 @@ func (service *payService) WechatPayCallBack(ctx *gin.Context) bool {
 
 	util.Log.Debugf("wechat pay notify body: [%s]", string(body))
 
-	var result notify.PaidResult
-	err = xml.Unmarshal(body, &result)
+	// 验签并解析, 只有支付成功的通知才会返回
+	result, err := service.gateway.ParsePaidNotify(ctx.Request.Header, body)
 	if err != nil {
-		util.Log.Errorf("read http body xml failed! err: [%s]", err.Error())
+		util.Log.Warningf("解析微信支付通知出错, err: [%s]", err.Error())
 		return false
 	}
 
-	if *result.ReturnCode == "FAIL" {
-		util.Log.Errorf("notify result's return_code is FAIL, err: [%s]", *result.ReturnMsg)
+	err = service.confirmPaid(&dto.PaidTrade{
+		OutTradeNo:    result.OutTradeNo,
+		TransactionId: result.TransactionID,
+		TotalFee:      result.TotalFee,
+		TimeEnd:       result.TimeEnd,
+	}, "微信支付成功")
+	if err != nil {
+		util.Log.WithFields(logrus.Fields{"out_trade_no": result.OutTradeNo}).
+			Errorf("微信notify处理出错, err: [%s]", err.Error())
 		return false
 	}
+	return true
+}
 
-	// 这里加锁串行处理
-	var mutex sync.Mutex
-	mutex.Lock()
-	defer mutex.Lock()
-
-	bill, err := service.payModel.FindBillByOutTradeNo(&result)
+func (service *payService) SyncPayResult(outTradeNo string) (bool, error) {
+	ret, err := service.gateway.QueryOrder(outTradeNo)
+	if wxpay.IsErrCode(err, wxpay.ErrCodeOrderNotExist) {
+		return false, nil
+	}
 	if err != nil {
-		util.Log.WithFields(
-			logrus.Fields{"out_trade_no": result.OutTradeNo}).
-			Errorf("微信notify查询原订单出错, err: [%s]", err.Error())
-		return false
+		return false, err
 	}
-	// 已经处理过， 直接返回SUCCESS
-	if bill.Status == consts.Success && bill.TimeEnd != 0 && bill.TransactionId != "" {
-		util.Log.WithFields(
-			logrus.Fields{"out_trade_no": result.OutTradeNo}).
-			Debug("微信notify, 已处理过该notify")
-		return true
+	if !ret.Paid() {
+		return false, nil
 	}
+	err = service.confirmPaid(&dto.PaidTrade{
+		OutTradeNo:    outTradeNo,
+		TransactionId: ret.TransactionID,
+		TotalFee:      ret.TotalFee,
+		TimeEnd:       ret.TimeEnd,
+	}, "查询微信订单确认支付成功")
+	return err == nil, err
+}
 
-	// 回调的订单总价与数据库价格不符
-	if int(bill.TotalFee) != *result.TotalFee {
-		util.Log.Warning(" total fee of notify result is not equal to the one in db")
-		return false
+func (service *payService) SweepPendingPayments() {
+	var (
+		total   int
+		afterId int64
+	)
+	now := time.Now()
+	for {
+		bills, err := service.payModel.FindPendingBills(now.Add(-consts.PayReconcileAfter).Unix(), now.Unix(), afterId, consts.PayReconcileBatch)
+		if err != nil {
+			util.Log.Errorf("查询待支付流水出错, err: [%s]", err.Error())
+			return
+		}
+		for _, bill := range bills {
+			afterId = bill.Id
+			paid, err := service.SyncPayResult(bill.OutTradeNo)
+			if err != nil {
+				util.Log.WithFields(logrus.Fields{"out_trade_no": bill.OutTradeNo}).
+					Warningf("对账查询微信订单出错, err: [%s]", err.Error())
+				continue
+			}
+			if paid {
+				total++
+			}
+		}
+		if len(bills) < consts.PayReconcileBatch {
+			break
+		}
+	}
+	if total > 0 {
+		util.Log.Infof("reconcile pending payments done, %d orders confirmed paid.", total)
 	}
+}
 
-	// 进行签名校验
-	if !service.notify.PaidVerifySign(result) {
-		util.Log.Warning("notify result failed payVerifySign")
-		return false
+// GuardExpiredOrder 先关闭微信订单再关闭本地订单, 关闭之后用户无法再支付, 不会出现已付款但订单已关闭
+func (service *payService) GuardExpiredOrder(order *dto.ExpiredOrder) (bool, error) {
+	// 统一下单失败, 微信侧没有订单
+	if order.BillId == 0 {
+		return true, nil
 	}
+	paid, err := service.SyncPayResult(order.OutTradeNo)
+	if err != nil {
+		return false, err
+	}
+	if paid {
+		return false, nil
+	}
+	err = service.gateway.CloseOrder(order.OutTradeNo)
+	switch {
+	case wxpay.IsErrCode(err, wxpay.ErrCodeOrderPaid):
+		// 查询之后用户完成了支付
+		if _, err = service.SyncPayResult(order.OutTradeNo); err != nil {
+			return false, err
+		}
+		return false, nil
+	case wxpay.IsErrCode(err, wxpay.ErrCodeOrderClosed), wxpay.IsErrCode(err, wxpay.ErrCodeOrderNotExist):
+		return true, nil
+	case err != nil:
+		return false, err
+	}
+	return true, nil
+}
 
-	// 更新数据库
-	if err = service.payModel.SuccessPaidResult2Bill(&result); err != nil {
-		util.Log.Errorf("SuccessPaidResult2Bill failed, err: [%s]", err.Error())
-		return false
+// confirmPaid 支付成功的处理, 回调和主动查询共用。
+// 同一笔支付的回调和查询可能并发到达不同实例, 按 out_trade_no 加锁串行处理, 已处理过的直接返回
+func (service *payService) confirmPaid(trade *dto.PaidTrade, reason string) error {
+	logger := util.Log.WithFields(logrus.Fields{"out_trade_no": trade.OutTradeNo})
+	lock := Rdb.ApiCache.NewLock(consts.CacheLock+".PAY_RESULT."+trade.OutTradeNo, consts.PayNotifyLockTTL)
+	ok, err := lock.Acquire(consts.PayNotifyLockWait)
+	if err != nil {
+		return err
 	}
-	if err = service.orderModel.UpdateOrderStatus(*result.OutTradeNo, consts.Success); err != nil {
-		util.Log.Errorf("UpdateOrderStatus failed, err: [%s]", err.Error())
-		return false
+	if !ok {
+		return errors.New("该订单的支付结果正在处理中")
+	}
+	defer func() {
+		if held, err := lock.Release(); err != nil {
+			logger.Errorf("释放支付处理锁出错, err: [%s]", err.Error())
+		} else if !held {
+			logger.Warning("支付处理超过锁的持有时长, 锁已过期")
+		}
+	}()
+
+	bill, err := service.payModel.FindBillByOutTradeNo(trade.OutTradeNo)
+	if err != nil {
+		return err
+	}
+	// 已经处理过
+	if bill.Status == consts.Success && bill.TimeEnd != 0 && bill.TransactionId != "" {
+		logger.Debug("已处理过该笔支付")
+		return nil
+	}
+	// 支付金额与数据库价格不符
+	if bill.TotalFee != trade.TotalFee {
+		return fmt.Errorf("total fee [%d] is not equal to the one in db [%d]", trade.TotalFee, bill.TotalFee)
 	}
 
+	// 流水与订单状态在同一事务中更新
+	err = service.stateMachine.TransitByOutTradeNo(trade.OutTradeNo, &dto.OrderTransition{
+		To:        consts.Success,
+		ActorType: consts.ActorWechat,
+		Reason:    reason + ", transaction_id: " + trade.TransactionId,
+	}, service.payModel.SuccessPaid2Bill(trade))
+	if err != nil {
+		return err
+	}
+	logger.Info(reason)
+
 	go func() {
 		// 微信推送通知运营处理付款订单
-		wcUtil.OrderPaidNotifyStaff(conf.C.RecvOpenIds, *result.OutTradeNo, float64(bill.TotalFee)*0.01, time.Now().Unix())
+		wcUtil.OrderPaidNotifyStaff(conf.C.RecvOpenIds, trade.OutTradeNo, bill.TotalFee, time.Now().Unix())
 
 		// 微信推送给客户下单成功
-		o := service.orderModel.FindOrderInfo2NotifyClientByOutTradeNo(*result.OutTradeNo)
-		wcUtil.OrderPaidNotifyClient(o.OpenId, o.OutTradeNo, o.Amount*0.01, o.Id, time.Now().Unix())
+		o := service.orderModel.FindOrderInfo2NotifyClientByOutTradeNo(trade.OutTradeNo)
+		wcUtil.OrderPaidNotifyClient(o.OpenId, o.OutTradeNo, o.Amount, o.Id, time.Now().Unix())
 	}()
-
-	return true
+	return nil
 }
 
-func NewPayService(notify *notify.Notify, payModel model.PayModel, orderModel model.OrderModel) PayService {
+func NewPayService(payModel model.PayModel, orderModel model.OrderModel, stateMachine OrderStateMachine, gateway wxpay.Gateway) PayService {
 	return &payService{
-		payModel:   payModel,
-		orderModel: orderModel,
-		notify:     notify,
+		payModel:     payModel,
+		orderModel:   orderModel,
+		stateMachine: stateMachine,
+		gateway:      gateway,
 	}
 }
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	. "mk-api/server/dao"
+	"mk-api/server/dto"
+	"mk-api/server/model"
+	"mk-api/server/util"
+	"mk-api/server/util/consts"
+	"mk-api/server/util/reconcile"
+	"mk-api/server/util/wxpay"
+)
+
+// ReconcileService 下载微信支付对账单与本地流水核对, 生成对账报告供财务查看
+type ReconcileService interface {
+	// 核对 billDate(yyyyMMdd) 当天的交易, 重复核对时覆盖当天的报告
+	ReconcileDay(billDate string) (*dto.ReconcileReportDetail, error)
+	// 定时任务, 核对前一天的交易
+	ReconcileYesterday()
+	ListReports(ctx *gin.Context, input *dto.ListReconcileReportInput) (*dto.PaginateListOutput, error)
+	RetrieveReport(ctx *gin.Context, id int64) (*dto.ReconcileReportDetail, error)
+}
+
+type reconcileService struct {
+	reconcileModel model.ReconcileModel
+	gateway        wxpay.Gateway
+}
+
+func (service *reconcileService) ReconcileDay(billDate string) (*dto.ReconcileReportDetail, error) {
+	logger := util.Log.WithFields(logrus.Fields{"bill_date": billDate})
+	day, err := wxpay.ParseBillDate(billDate)
+	if err != nil {
+		return nil, err
+	}
+	start, end := day.Unix(), day.AddDate(0, 0, 1).Unix()
+	// 微信次日才生成对账单
+	if end > time.Now().Unix() {
+		return nil, errors.New("只能核对今天之前的对账单")
+	}
+
+	// 多个实例的定时任务同时触发时只核对一次
+	lock := Rdb.ApiCache.NewLock(consts.CacheLock+".BILL_RECONCILE."+billDate, consts.BillReconcileLockTTL)
+	ok, err := lock.Acquire(0)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("该日期正在对账")
+	}
+	defer func() { _, _ = lock.Release() }()
+
+	bill := &wxpay.Bill{}
+	raw, err := service.gateway.DownloadBill(billDate)
+	if err == wxpay.ErrNoBill {
+		logger.Info("微信当天没有交易, 没有对账单")
+	} else if err != nil {
+		return nil, err
+	} else if bill, err = wxpay.ParseBill(raw); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	outTradeNos := make([]string, 0, len(bill.Rows))
+	for _, row := range bill.Rows {
+		if !seen[row.OutTradeNo] {
+			seen[row.OutTradeNo] = true
+			outTradeNos = append(outTradeNos, row.OutTradeNo)
+		}
+	}
+	bills, err := service.reconcileModel.ListBillsForReconcile(start, end, outTradeNos)
+	if err != nil {
+		return nil, err
+	}
+	locals := make([]*reconcile.LocalBill, 0, len(bills))
+	for _, b := range bills {
+		locals = append(locals, &reconcile.LocalBill{
+			FeeType:       b.FeeType,
+			OutTradeNo:    b.OutTradeNo,
+			TransactionId: b.TransactionId,
+			OutRefundNo:   b.OutRefundNo,
+			RefundId:      b.RefundId,
+			TotalFee:      b.TotalFee,
+			Status:        b.Status,
+			TimeEnd:       b.TimeEnd,
+			CreateTime:    b.CreateTime,
+		})
+	}
+	result := reconcile.Match(bill.Rows, locals, start, end)
+
+	now := time.Now().Unix()
+	report := &dto.ReconcileReport{
+		BillDate:        billDate,
+		RemoteCount:     len(bill.Rows),
+		RemoteTotalFee:  bill.Summary.TotalFee,
+		RemoteRefundFee: bill.Summary.RefundFee,
+		LocalCount:      len(locals),
+		MatchedCount:    result.Matched,
+		DiffCount:       len(result.Diffs),
+		CreateTime:      now,
+		UpdateTime:      now,
+	}
+	diffs := make([]*dto.ReconcileDiff, 0, len(result.Diffs))
+	for _, d := range result.Diffs {
+		diffs = append(diffs, &dto.ReconcileDiff{
+			Kind:          d.Kind,
+			FeeType:       d.FeeType,
+			OutTradeNo:    d.OutTradeNo,
+			TransactionId: d.TransactionId,
+			OutRefundNo:   d.OutRefundNo,
+			LocalFee:      d.LocalFee,
+			RemoteFee:     d.RemoteFee,
+			LocalStatus:   d.LocalStatus,
+			RemoteStatus:  d.RemoteStatus,
+			CreateTime:    now,
+		})
+	}
+	if report.Id, err = service.reconcileModel.SaveReport(report, diffs); err != nil {
+		return nil, err
+	}
+	logger.Infof("对账完成, 对账单 %d 笔, 本地流水 %d 笔, 一致 %d 笔, 差异 %d 条",
+		report.RemoteCount, report.LocalCount, report.MatchedCount, report.DiffCount)
+	return &dto.ReconcileReportDetail{ReconcileReport: report, Diffs: diffs}, nil
+}
+
+func (service *reconcileService) ReconcileYesterday() {
+	billDate := time.Now().AddDate(0, 0, -1).Format("20060102")
+	if _, err := service.ReconcileDay(billDate); err != nil {
+		util.Log.WithFields(logrus.Fields{"bill_date": billDate}).Errorf("微信支付对账出错, err: [%s]", err.Error())
+	}
+}
+
+func (service *reconcileService) ListReports(ctx *gin.Context, input *dto.ListReconcileReportInput) (*dto.PaginateListOutput, error) {
+	var output dto.PaginateListOutput
+	list, err := service.reconcileModel.ListReports(input)
+	if err != nil {
+		util.Log.Errorf("查询对账报告出错, input: [%v], err: [%s]", input, err.Error())
+		return &output, err
+	}
+	if len(list) == int(input.PageSize)+1 {
+		output.HasNext = 1
+		list = list[:len(list)-1]
+	}
+	output.PageSize = int64(len(list))
+	output.PageNo = input.PageNo
+	output.List = list
+	return &output, nil
+}
+
+func (service *reconcileService) RetrieveReport(ctx *gin.Context, id int64) (*dto.ReconcileReportDetail, error) {
+	logger := util.Log.WithFields(logrus.Fields{"report_id": id})
+	report, err := service.reconcileModel.FindReportById(id)
+	if err == sql.ErrNoRows {
+		return nil, resourceNotFound(ctx, "对账报告")
+	} else if err != nil {
+		logger.Errorf("查询对账报告出错, err: [%s]", err.Error())
+		return nil, err
+	}
+	diffs, err := service.reconcileModel.ListDiffsByReportId(id)
+	if err != nil {
+		logger.Errorf("查询对账差异出错, err: [%s]", err.Error())
+		return nil, err
+	}
+	return &dto.ReconcileReportDetail{ReconcileReport: report, Diffs: diffs}, nil
+}
+
+func NewReconcileService(reconcileModel model.ReconcileModel, gateway wxpay.Gateway) ReconcileService {
+	return &reconcileService{
+		reconcileModel: reconcileModel,
+		gateway:        gateway,
+	}
+}
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"mk-api/library/ecode"
+	"mk-api/server/conf"
+	"mk-api/server/dto"
+	"mk-api/server/model"
+	"mk-api/server/util"
+	"mk-api/server/util/consts"
+	"mk-api/server/util/token"
+	wcUtil "mk-api/server/util/wechat"
+	"mk-api/server/util/wxpay"
+)
+
+type RefundService interface {
+	// 向微信发起退款, 退款结果以微信的异步通知为准。 只有微信明确拒绝时返回错误, 调用超时等结果未知时退款流水保持处理中并返回 nil
+	LaunchRefund(ctx *gin.Context, input *dto.LaunchRefundInput) error
+	WechatRefundCallBack(ctx *gin.Context) bool
+	SweepPendingRefunds()
+}
+
+type refundService struct {
+	payModel     model.PayModel
+	orderModel   model.OrderModel
+	stateMachine OrderStateMachine
+	gateway      wxpay.Gateway
+}
+
+func (service *refundService) LaunchRefund(ctx *gin.Context, input *dto.LaunchRefundInput) error {
+	logger := util.Log.WithFields(logrus.Fields{"order_id": input.OrderId})
+
+	paidBill, err := service.payModel.FindPaidBillByOrderId(input.OrderId)
+	if err == sql.ErrNoRows {
+		_ = ctx.Error(errors.New("订单尚未支付, 无法退款"))
+		return ecode.RequestErr
+	} else if err != nil {
+		logger.Errorf("查询订单支付流水出错, err: [%s]", err.Error())
+		return err
+	}
+
+	// 一个订单同时只能有一笔处理中或已成功的退款, 并发时由 SaveRefundBill 保证
+	if _, err = service.payModel.FindRefundBillByOrderId(input.OrderId); err == nil {
+		_ = ctx.Error(errors.New("该订单已经发起过退款"))
+		return ecode.RequestErr
+	} else if err != sql.ErrNoRows {
+		logger.Errorf("查询订单退款流水出错, err: [%s]", err.Error())
+		return err
+	}
+
+	refundFee := input.RefundFee
+	if refundFee == 0 {
+		refundFee = paidBill.TotalFee
+	}
+	if refundFee < 0 || refundFee > paidBill.TotalFee {
+		_ = ctx.Error(fmt.Errorf("退款金额必须在 0 到 %d 分之间", paidBill.TotalFee))
+		return ecode.RequestErr
+	}
+
+	outRefundNo, err := token.GenerateSnowflake()
+	if err != nil {
+		logger.Errorf("failed to generate snowflake, err: [%s]", err.Error())
+		return err
+	}
+	now := time.Now().Unix()
+	bill := &dto.TradeBill{
+		OrderId:       input.OrderId,
+		TransactionId: paidBill.TransactionId,
+		OutTradeNo:    paidBill.OutTradeNo,
+		OutRefundNo:   outRefundNo.String(),
+		TotalFee:      refundFee,
+		FeeType:       Outgo,
+		Status:        model.Processing,
+		TransType:     Refunded,
+		TimeStart:     now,
+		CreateTime:    now,
+		UpdateTime:    now,
+	}
+	billId, err := service.payModel.SaveRefundBill(bill)
+	if err == model.ErrRefundInFlight {
+		_ = ctx.Error(errors.New("该订单已经发起过退款"))
+		return ecode.RequestErr
+	} else if err != nil {
+		logger.Errorf("生成退款流水出错, err: [%s]", err.Error())
+		return err
+	}
+
+	bill.Id = billId
+	logger = logger.WithFields(logrus.Fields{
+		"bill_id":    billId,
+		"actor_type": input.ActorType,
+		"actor_id":   input.ActorId,
+	})
+	if err = service.requestRefund(paidBill, bill, input.Reason, logger); err != nil {
+		return err
+	}
+	logger.Infof("已向微信发起退款, out_refund_no: [%s], refund_fee: [%d]", bill.OutRefundNo, refundFee)
+	return nil
+}
+
+// SweepPendingRefunds 调用退款接口时超时等结果未知的退款流水仍为处理中, 用同一个退款单号重新发起, 微信不会重复退款
+func (service *refundService) SweepPendingRefunds() {
+	var afterId int64
+	startBefore := time.Now().Add(-consts.RefundRetryAfter).Unix()
+	for {
+		bills, err := service.payModel.FindPendingRefundBills(startBefore, afterId, consts.RefundRetryBatch)
+		if err != nil {
+			util.Log.Errorf("查询未受理的退款流水出错, err: [%s]", err.Error())
+			return
+		}
+		for _, bill := range bills {
+			afterId = bill.Id
+			logger := util.Log.WithFields(logrus.Fields{"order_id": bill.OrderId, "bill_id": bill.Id})
+			paidBill, err := service.payModel.FindPaidBillByOrderId(bill.OrderId)
+			if err != nil {
+				logger.Errorf("查询订单支付流水出错, err: [%s]", err.Error())
+				continue
+			}
+			if service.requestRefund(paidBill, bill, "", logger) == nil {
+				logger.Infof("已重新向微信发起退款, out_refund_no: [%s]", bill.OutRefundNo)
+			}
+		}
+		if len(bills) < consts.RefundRetryBatch {
+			break
+		}
+	}
+}
+
+// requestRefund 调用微信退款接口, 只有微信明确拒绝时才把退款流水更新为失败并返回错误。
+// 超时、网络错误等情况微信可能已经受理, 退款流水保持处理中, 等待退款通知或由 SweepPendingRefunds 重新发起
+func (service *refundService) requestRefund(paidBill *dto.TradeBill, bill *dto.TradeBill, reason string, logger *logrus.Entry) error {
+	ret, err := service.gateway.Refund(&wxpay.RefundParams{
+		TransactionID: paidBill.TransactionId,
+		OutTradeNo:    paidBill.OutTradeNo,
+		OutRefundNo:   bill.OutRefundNo,
+		TotalFee:      paidBill.TotalFee,
+		RefundFee:     bill.TotalFee,
+		RefundDesc:    reason,
+		NotifyURL:     conf.C.WeChat.PayRefundNotifyURL,
+	})
+	if wxpay.IsRejected(err) {
+		logger.Errorf("微信拒绝退款, out_refund_no: [%s], err: [%s]", bill.OutRefundNo, err.Error())
+		if e := service.payModel.FailRefundBill(bill.Id); e != nil {
+			logger.Errorf("更新退款流水为失败出错, err: [%s]", e.Error())
+		}
+		return err
+	}
+	if err != nil {
+		logger.Warningf("调用微信退款接口出错, 退款结果未知, out_refund_no: [%s], err: [%s]", bill.OutRefundNo, err.Error())
+		return nil
+	}
+	if err = service.payModel.UpdateRefundId(bill.Id, ret.RefundID); err != nil {
+		logger.Warningf("保存微信退款单号出错, err: [%s]", err.Error())
+	}
+	return nil
+}
+
+func (service *refundService) WechatRefundCallBack(ctx *gin.Context) bool {
+	body, err := ioutil.ReadAll(ctx.Request.Body)
+	if err != nil {
+		util.Log.Errorf("read http body failed！err: [%s]", err.Error())
+		return false
+	}
+
+	result, err := service.gateway.ParseRefundNotify(ctx.Request.Header, body)
+	if err != nil {
+		util.Log.Errorf("解析微信退款通知出错, body: [%s], err: [%s]", string(body), err.Error())
+		return false
+	}
+	logger := util.Log.WithFields(logrus.Fields{
+		"out_trade_no":  result.OutTradeNo,
+		"out_refund_no": result.OutRefundNo,
+	})
+
+	bill, err := service.payModel.FindRefundBillByOutRefundNo(result.OutRefundNo)
+	if err != nil {
+		logger.Errorf("微信退款通知查询退款流水出错, err: [%s]", err.Error())
+		return false
+	}
+	// 已经处理过， 直接返回SUCCESS
+	if bill.Status != model.Processing {
+		logger.Debug("微信退款通知, 已处理过该notify")
+		return true
+	}
+
+	if result.RefundStatus != wxpay.RefundStatusSuccess {
+		logger.Errorf("微信退款失败, refund_status: [%s]", result.RefundStatus)
+		if err = service.payModel.FailRefundBill(bill.Id); err != nil {
+			logger.Errorf("更新退款流水为失败出错, err: [%s]", err.Error())
+			return false
+		}
+		return true
+	}
+
+	if result.RefundFee != bill.TotalFee {
+		logger.Warningf("退款通知的金额 [%d] 与退款流水 [%d] 不符", result.RefundFee, bill.TotalFee)
+		return false
+	}
+
+	err = service.stateMachine.Transit(&dto.OrderTransition{
+		OrderId:   bill.OrderId,
+		To:        consts.Refunded,
+		ActorType: consts.ActorWechat,
+		Reason:    "微信退款成功, refund_id: " + result.RefundID,
+	}, service.payModel.SuccessRefund2Bill(result.OutRefundNo, result.RefundID, result.SuccessAt()))
+	if err != nil {
+		logger.Errorf("更新订单为已退款出错, err: [%s]", err.Error())
+		return false
+	}
+
+	// 微信推送给客户退款成功, 退款到账后才推送, 避免退款失败时客户已收到消息
+	go func() {
+		o := service.orderModel.FindOrderInfo2NotifyClientByOutTradeNo(result.OutTradeNo)
+		wcUtil.RefundAgreedNotifyClient(o.OpenId, o.OutTradeNo, result.RefundFee)
+	}()
+	return true
+}
+
+func NewRefundService(payModel model.PayModel, orderModel model.OrderModel, stateMachine OrderStateMachine, gateway wxpay.Gateway) RefundService {
+	return &refundService{
+		payModel:     payModel,
+		orderModel:   orderModel,
+		stateMachine: stateMachine,
+		gateway:      gateway,
+	}
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"mk-api/library/ecode"
+	"mk-api/server/conf"
+	"mk-api/server/dto"
+	"mk-api/server/model"
+	"mk-api/server/util/wxpay"
+)
+
+// 订单 1 已支付 399 元, 还没有退款
+type fakeRefundPayModel struct {
+	model.PayModel
+	pending  []*dto.TradeBill
+	failed   bool
+	refundId string
+	// 并发的另一次退款已经插入了退款流水
+	inFlight bool
+}
+
+func (m *fakeRefundPayModel) FindPaidBillByOrderId(orderId int64) (*dto.TradeBill, error) {
+	return &dto.TradeBill{
+		OrderId:       orderId,
+		TransactionId: "4200000512202005283917925386",
+		OutTradeNo:    "1267034018434977792",
+		TotalFee:      39900,
+		Status:        model.Success,
+	}, nil
+}
+
+func (m *fakeRefundPayModel) FindRefundBillByOrderId(orderId int64) (*dto.TradeBill, error) {
+	return nil, sql.ErrNoRows
+}
+
+func (m *fakeRefundPayModel) FindPendingRefundBills(startBefore int64, afterId int64, limit int) ([]*dto.TradeBill, error) {
+	output := make([]*dto.TradeBill, 0, len(m.pending))
+	for _, bill := range m.pending {
+		if bill.Id > afterId {
+			output = append(output, bill)
+		}
+	}
+	return output, nil
+}
+
+func (m *fakeRefundPayModel) SaveRefundBill(bill *dto.TradeBill) (int64, error) {
+	if m.inFlight {
+		return 0, model.ErrRefundInFlight
+	}
+	return 1, nil
+}
+
+func (m *fakeRefundPayModel) FailRefundBill(billId int64) error {
+	m.failed = true
+	return nil
+}
+
+func (m *fakeRefundPayModel) UpdateRefundId(billId int64, refundId string) error {
+	m.refundId = refundId
+	return nil
+}
+
+// 退款接口依次返回 errs 中的错误, 用完后退款成功
+type fakeRefundGateway struct {
+	wxpay.Gateway
+	errs        []error
+	outRefundNo []string
+}
+
+func (g *fakeRefundGateway) Refund(p *wxpay.RefundParams) (*wxpay.RefundResult, error) {
+	g.outRefundNo = append(g.outRefundNo, p.OutRefundNo)
+	if len(g.outRefundNo) <= len(g.errs) {
+		return nil, g.errs[len(g.outRefundNo)-1]
+	}
+	return &wxpay.RefundResult{RefundID: "50000000382019052709732678859", OutRefundNo: p.OutRefundNo, RefundFee: p.RefundFee}, nil
+}
+
+var errRefundTimeout = &url.Error{
+	Op:  "Post",
+	URL: "https://api.mch.weixin.qq.com/secapi/pay/refund",
+	Err: context.DeadlineExceeded,
+}
+
+func newRefundTestService(payModel model.PayModel, gateway wxpay.Gateway) *refundService {
+	if conf.C == nil {
+		conf.C = &conf.Config{}
+	}
+	return &refundService{payModel: payModel, gateway: gateway}
+}
+
+func refundContext() *gin.Context {
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	return ctx
+}
+
+func TestLaunchRefundTimeout(t *testing.T) {
+	payModel := &fakeRefundPayModel{}
+	gateway := &fakeRefundGateway{errs: []error{errRefundTimeout}}
+	service := newRefundTestService(payModel, gateway)
+
+	// 超时后微信可能已经受理, 退款流水必须保持处理中
+	if err := service.LaunchRefund(refundContext(), &dto.LaunchRefundInput{OrderId: 1}); err != nil {
+		t.Fatalf("LaunchRefund should not fail when the refund result is unknown, got %v", err)
+	}
+	if payModel.failed {
+		t.Fatal("refund bill was marked failed after a timeout")
+	}
+
+	// 重新发起时使用同一个退款单号
+	payModel.pending = []*dto.TradeBill{{Id: 1, OrderId: 1, OutRefundNo: gateway.outRefundNo[0], TotalFee: 39900}}
+	service.SweepPendingRefunds()
+	if len(gateway.outRefundNo) != 2 || gateway.outRefundNo[1] != gateway.outRefundNo[0] {
+		t.Fatalf("refund was not retried with the same out_refund_no: %v", gateway.outRefundNo)
+	}
+	if payModel.refundId == "" {
+		t.Error("refund_id was not saved after the retry was accepted")
+	}
+}
+
+func TestLaunchRefundRejected(t *testing.T) {
+	payModel := &fakeRefundPayModel{}
+	gateway := &fakeRefundGateway{errs: []error{&wxpay.ResultError{Code: "NOTENOUGH", Desc: "基本账户余额不足"}}}
+	service := newRefundTestService(payModel, gateway)
+
+	if err := service.LaunchRefund(refundContext(), &dto.LaunchRefundInput{OrderId: 1}); err == nil {
+		t.Fatal("LaunchRefund should fail when wechat rejects the refund")
+	}
+	if !payModel.failed {
+		t.Error("refund bill was not marked failed after wechat rejected it")
+	}
+}
+
+func TestLaunchRefundInFlight(t *testing.T) {
+	gateway := &fakeRefundGateway{}
+	service := newRefundTestService(&fakeRefundPayModel{inFlight: true}, gateway)
+
+	if err := service.LaunchRefund(refundContext(), &dto.LaunchRefundInput{OrderId: 1}); err != ecode.RequestErr {
+		t.Fatalf("LaunchRefund should be rejected when another refund is in flight, got %v", err)
+	}
+	if len(gateway.outRefundNo) != 0 {
+		t.Error("a second wechat refund was requested for the same order")
+	}
+}
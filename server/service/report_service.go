@@ -0,0 +1,310 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"mk-api/library/ecode"
+	"mk-api/library/util/cos"
+	"mk-api/server/dto"
+	"mk-api/server/model"
+	"mk-api/server/util"
+	"mk-api/server/util/consts"
+	"mk-api/server/util/exam"
+	"mk-api/server/util/token"
+	wcUtil "mk-api/server/util/wechat"
+)
+
+// 允许上传的报告格式及对应的扩展名
+var reportContentTypes = map[string]string{
+	"application/pdf": ".pdf",
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+}
+
+type ReportService interface {
+	// 运营人员为已付款的订单项上传报告, 上传后推送通知给用户
+	UploadReport(ctx *gin.Context, input *dto.PostReportInput, file *multipart.FileHeader) (int64, error)
+	// 用户查询时 input.UserId 取自 token, 运营后台必须指定订单项
+	ListReports(ctx *gin.Context, input *dto.ListReportInput) ([]*dto.Report, error)
+	// 用户获取自己报告的签名下载链接, 每次获取都记审计日志
+	GetReportUrl(ctx *gin.Context, id int64) (*dto.ReportUrlOutput, error)
+	// 运营人员删除传错的报告, 存储桶中的文件保留
+	DeleteReport(ctx *gin.Context, input *dto.DeleteReportInput) error
+	// 运营人员录入订单项的结构化结果, 项目名称必须是所购套餐中的项目
+	SaveExamResults(ctx *gin.Context, input *dto.PutExamResultInput) error
+	ListExamResults(ctx *gin.Context, input *dto.ListExamResultInput) ([]*dto.ExamResult, error)
+	// 用户查询自己的常用体检人的历次体检结果和指标趋势
+	ExamHistory(ctx *gin.Context, examineeId int64) (*dto.ExamHistoryOutput, error)
+}
+
+type reportService struct {
+	reportModel   model.ReportModel
+	examineeModel model.ExamineeModel
+	packageModel  model.PackageModel
+	auditService  AuditService
+}
+
+func (service *reportService) UploadReport(ctx *gin.Context, input *dto.PostReportInput, file *multipart.FileHeader) (int64, error) {
+	logger := util.Log.WithFields(logrus.Fields{"order_item_id": input.OrderItemId})
+	contentType := file.Header.Get("Content-Type")
+	ext, ok := reportContentTypes[contentType]
+	if !ok || file.Size > consts.ReportMaxSize {
+		_ = ctx.Error(errors.New("报告只能是不超过20M的pdf、jpg或png文件"))
+		return 0, ecode.RequestErr
+	}
+	target, err := service.reportTarget(ctx, input.OrderItemId)
+	if err != nil {
+		return 0, err
+	}
+	if target.OrderStatus != consts.Success && target.OrderStatus != consts.ToReview {
+		_ = ctx.Error(errors.New("只有已付款的订单项才能上传报告"))
+		return 0, consts.OrderStatusIllegal
+	}
+
+	key := fmt.Sprintf("reports/%d/%d/%s%s", target.OrderId, target.OrderItemId, token.GenerateUuid(), ext)
+	if err = cos.UploadPrivate(file, key); err != nil {
+		logger.Errorf("上传体检报告出错, err: [%s]", err.Error())
+		return 0, err
+	}
+	now := time.Now().Unix()
+	report := &dto.Report{
+		OrderId:     target.OrderId,
+		OrderItemId: target.OrderItemId,
+		UserId:      target.UserId,
+		FileName:    file.Filename,
+		ContentType: contentType,
+		Size:        file.Size,
+		CosKey:      key,
+		StaffId:     ctx.GetInt64("staffId"),
+		CreateTime:  now,
+		UpdateTime:  now,
+	}
+	id, err := service.reportModel.SaveReport(report)
+	if err != nil {
+		logger.Errorf("保存体检报告出错, key: [%s], err: [%s]", key, err.Error())
+		return 0, err
+	}
+	report.Id = id
+	service.auditService.Record(ctx, consts.AuditReportUpload, "mko_report", id, nil, report)
+
+	go wcUtil.ReportReadyNotifyClient(target.OpenId, target.ExamineeName, target.PackageName, target.OrderId)
+	return id, nil
+}
+
+func (service *reportService) ListReports(ctx *gin.Context, input *dto.ListReportInput) ([]*dto.Report, error) {
+	if input.UserId == 0 {
+		if input.OrderItemId == 0 {
+			_ = ctx.Error(errors.New("请指定订单项"))
+			return nil, ecode.RequestErr
+		}
+		if _, err := service.reportTarget(ctx, input.OrderItemId); err != nil {
+			return nil, err
+		}
+	}
+	output, err := service.reportModel.ListReports(input)
+	if err != nil {
+		util.Log.WithFields(logrus.Fields{"order_item_id": input.OrderItemId, "user_id": input.UserId}).
+			Errorf("查询体检报告出错, err: [%s]", err.Error())
+	}
+	return output, err
+}
+
+func (service *reportService) GetReportUrl(ctx *gin.Context, id int64) (*dto.ReportUrlOutput, error) {
+	userId := ctx.GetInt64("userId")
+	logger := util.Log.WithFields(logrus.Fields{"report_id": id, "user_id": userId})
+	report, err := service.reportModel.FindUserReport(id, userId)
+	if err == sql.ErrNoRows {
+		return nil, resourceNotFound(ctx, "报告")
+	} else if err != nil {
+		logger.Errorf("查询体检报告出错, err: [%s]", err.Error())
+		return nil, err
+	}
+	url, err := cos.PresignedURL(report.CosKey, consts.ReportUrlExpire)
+	if err != nil {
+		logger.Errorf("生成报告下载链接出错, err: [%s]", err.Error())
+		return nil, err
+	}
+	service.auditService.Record(ctx, consts.AuditReportDownload, "mko_report", id, nil, nil)
+	return &dto.ReportUrlOutput{Url: url, ExpireTime: time.Now().Add(consts.ReportUrlExpire).Unix()}, nil
+}
+
+func (service *reportService) DeleteReport(ctx *gin.Context, input *dto.DeleteReportInput) error {
+	logger := util.Log.WithFields(logrus.Fields{"report_id": input.ReportId})
+	report, err := service.reportModel.FindReportById(input.ReportId)
+	if err == sql.ErrNoRows {
+		_ = ctx.Error(errors.New("报告不存在"))
+		return ecode.RequestErr
+	} else if err != nil {
+		logger.Errorf("查询体检报告出错, err: [%s]", err.Error())
+		return err
+	}
+	if _, err = service.reportTarget(ctx, report.OrderItemId); err != nil {
+		return err
+	}
+	ok, err := service.reportModel.DeleteReport(input.ReportId, time.Now().Unix())
+	if err != nil {
+		logger.Errorf("删除体检报告出错, err: [%s]", err.Error())
+		return err
+	}
+	if !ok {
+		_ = ctx.Error(errors.New("报告不存在"))
+		return ecode.RequestErr
+	}
+	service.auditService.Record(ctx, consts.AuditReportDelete, "mko_report", input.ReportId, report, nil)
+	return nil
+}
+
+func (service *reportService) SaveExamResults(ctx *gin.Context, input *dto.PutExamResultInput) error {
+	logger := util.Log.WithFields(logrus.Fields{"order_item_id": input.OrderItemId})
+	target, err := service.reportTarget(ctx, input.OrderItemId)
+	if err != nil {
+		return err
+	}
+	if target.OrderStatus != consts.Success && target.OrderStatus != consts.ToReview {
+		_ = ctx.Error(errors.New("只有已付款的订单项才能录入体检结果"))
+		return consts.OrderStatusIllegal
+	}
+	// 以下单时的项目快照为准, 补齐快照之前的旧订单按套餐当前的项目
+	items := []*dto.PkgItemName(target.PkgItems)
+	if len(items) == 0 {
+		if items, err = service.packageModel.FindPkgItemNameByPkgId(target.PackageId); err != nil {
+			logger.Errorf("查询套餐项目出错, err: [%s]", err.Error())
+			return err
+		}
+	}
+	itemNames := make(map[string]bool, len(items))
+	for _, item := range items {
+		itemNames[item.Name] = true
+	}
+
+	now := time.Now().Unix()
+	staffId := ctx.GetInt64("staffId")
+	seen := make(map[string]bool, len(input.Results))
+	results := make([]*dto.ExamResult, 0, len(input.Results))
+	for _, r := range input.Results {
+		if !itemNames[r.ItemName] {
+			_ = ctx.Error(fmt.Errorf("套餐中没有项目: %s", r.ItemName))
+			return ecode.RequestErr
+		}
+		key := r.ItemName + "/" + r.Indicator
+		if seen[key] {
+			_ = ctx.Error(fmt.Errorf("指标重复: %s", key))
+			return ecode.RequestErr
+		}
+		seen[key] = true
+		results = append(results, &dto.ExamResult{
+			OrderId: target.OrderId,
+			UserId:  target.UserId,
+			Record: exam.Record{
+				OrderItemId: target.OrderItemId,
+				ExamineDate: target.ExamineDate,
+				ItemName:    r.ItemName,
+				Indicator:   r.Indicator,
+				Value:       r.Value,
+				Unit:        r.Unit,
+				RefRange:    r.RefRange,
+				Abnormal:    r.Abnormal,
+			},
+			StaffId:    staffId,
+			CreateTime: now,
+			UpdateTime: now,
+		})
+	}
+
+	before, err := service.reportModel.ListExamResults(input.OrderItemId)
+	if err != nil {
+		logger.Errorf("查询已录入的体检结果出错, err: [%s]", err.Error())
+		return err
+	}
+	if err = service.reportModel.ReplaceExamResults(input.OrderItemId, results); err != nil {
+		logger.Errorf("保存体检结果出错, err: [%s]", err.Error())
+		return err
+	}
+	service.auditService.Record(ctx, consts.AuditExamResultSave, "mko_exam_result", input.OrderItemId, before, results)
+	return nil
+}
+
+func (service *reportService) ListExamResults(ctx *gin.Context, input *dto.ListExamResultInput) ([]*dto.ExamResult, error) {
+	if _, err := service.reportTarget(ctx, input.OrderItemId); err != nil {
+		return nil, err
+	}
+	output, err := service.reportModel.ListExamResults(input.OrderItemId)
+	if err != nil {
+		util.Log.WithFields(logrus.Fields{"order_item_id": input.OrderItemId}).Errorf("查询体检结果出错, err: [%s]", err.Error())
+	}
+	return output, err
+}
+
+func (service *reportService) ExamHistory(ctx *gin.Context, examineeId int64) (*dto.ExamHistoryOutput, error) {
+	userId := ctx.GetInt64("userId")
+	logger := util.Log.WithFields(logrus.Fields{"examinee_id": examineeId, "user_id": userId})
+	examinee, err := service.examineeModel.FindExamineeByIdNUserId(examineeId, userId)
+	if err == sql.ErrNoRows {
+		return nil, resourceNotFound(ctx, "体检人")
+	} else if err != nil {
+		logger.Errorf("查询体检人出错, err: [%s]", err.Error())
+		return nil, err
+	}
+	rows, err := service.reportModel.FindExamHistory(userId, examinee.IdCardNo)
+	if err != nil {
+		logger.Errorf("查询体检人的历次体检结果出错, err: [%s]", err.Error())
+		return nil, err
+	}
+
+	output := &dto.ExamHistoryOutput{
+		ExamineeName: examinee.ExamineeName,
+		Visits:       make([]*dto.ExamVisit, 0, 4),
+	}
+	records := make([]*exam.Record, 0, len(rows))
+	var visit *dto.ExamVisit
+	for _, row := range rows {
+		record := row.Record
+		if visit == nil || visit.OrderItemId != row.OrderItemId {
+			visit = &dto.ExamVisit{
+				OrderId:      row.OrderId,
+				OrderItemId:  row.OrderItemId,
+				ExamineDate:  row.ExamineDate,
+				PackageName:  row.PackageName,
+				HospitalName: row.HospitalName,
+			}
+			output.Visits = append(output.Visits, visit)
+		}
+		visit.Results = append(visit.Results, &record)
+		records = append(records, &record)
+	}
+	output.Trends = exam.BuildSeries(records)
+	return output, nil
+}
+
+// 查询订单项, 机构对接人只能处理所对接机构的订单项
+func (service *reportService) reportTarget(ctx *gin.Context, orderItemId int64) (*dto.ReportTarget, error) {
+	target, err := service.reportModel.FindReportTarget(orderItemId)
+	if err == sql.ErrNoRows {
+		_ = ctx.Error(errors.New("订单项不存在"))
+		return nil, ecode.RequestErr
+	} else if err != nil {
+		util.Log.WithFields(logrus.Fields{"order_item_id": orderItemId}).Errorf("查询订单项出错, err: [%s]", err.Error())
+		return nil, err
+	}
+	if hospitalId := ctx.GetInt64("staffHospitalId"); hospitalId != 0 && hospitalId != target.HospitalId {
+		_ = ctx.Error(errors.New("只能处理所对接机构的订单"))
+		return nil, ecode.AccessDenied
+	}
+	return target, nil
+}
+
+func NewReportService(reportModel model.ReportModel, examineeModel model.ExamineeModel, packageModel model.PackageModel,
+	auditService AuditService) ReportService {
+	return &reportService{
+		reportModel:   reportModel,
+		examineeModel: examineeModel,
+		packageModel:  packageModel,
+		auditService:  auditService,
+	}
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"mk-api/server/conf"
+	"mk-api/server/dto"
+	"mk-api/server/model"
+	"mk-api/server/util/calendar"
+	"mk-api/server/util/consts"
+	"mk-api/server/util/xtime"
+)
+
+// 历史订单项, 体检日期是当天上午 10 点而不是零点
+type fakeRescheduleOrderModel struct {
+	model.OrderModel
+	examineDate int64
+	log         *dto.OrderItemRescheduleLog
+	extras      int
+}
+
+func (m *fakeRescheduleOrderModel) FindOrderItemScheduleByIdNUserId(orderItemId int64, userId int64) (*dto.OrderItemSchedule, error) {
+	return &dto.OrderItemSchedule{OrderItemId: orderItemId, OrderId: 1, PackageId: 1, ExamineDate: m.examineDate, OrderStatus: consts.Success}, nil
+}
+
+func (m *fakeRescheduleOrderModel) UpdateOrderItem(input *dto.PutOrderItemInput, schedule *dto.OrderItemSchedule,
+	log *dto.OrderItemRescheduleLog, extras ...model.TxFunc) (bool, error) {
+	m.log = log
+	m.extras = len(extras)
+	return true, nil
+}
+
+type fakeReschedulePackageModel struct {
+	model.PackageModel
+}
+
+func (m *fakeReschedulePackageModel) FindPackagePriceNTargetById(id int64) (*dto.PkgTargetNPrice, error) {
+	return &dto.PkgTargetNPrice{Target: int8(AnyGender)}, nil
+}
+
+type fakeRescheduleCapacityModel struct {
+	model.CapacityModel
+	released []*dto.CapacitySlot
+	reserved []*dto.CapacitySlot
+}
+
+func (m *fakeRescheduleCapacityModel) FindPackageCapacity(pkgId int64) (*dto.PackageCapacity, error) {
+	return &dto.PackageCapacity{HospitalId: 1, PackageId: pkgId}, nil
+}
+
+func (m *fakeRescheduleCapacityModel) Release(slots []*dto.CapacitySlot) model.TxFunc {
+	m.released = append(m.released, slots...)
+	return nil
+}
+
+func (m *fakeRescheduleCapacityModel) Reserve(slots []*dto.CapacitySlot) model.TxFunc {
+	m.reserved = append(m.reserved, slots...)
+	return nil
+}
+
+type fakeAuditService struct {
+	AuditService
+}
+
+func (s *fakeAuditService) Record(ctx *gin.Context, action string, table string, targetId int64, before, after interface{}) {
+}
+
+func TestRescheduleLegacyExamineDate(t *testing.T) {
+	if conf.C == nil {
+		conf.C = &conf.Config{}
+	}
+	day := xtime.DayStartAt(time.Now().Add(72 * time.Hour).Unix())
+	legacy := day + 10*3600
+
+	cases := []struct {
+		name       string
+		to         int64
+		reschedule bool
+	}{
+		// 同一天的其他时刻不算改期, 不释放也不占用名额
+		{"same day", day + 3600, false},
+		{"next day", day + 24*3600, true},
+	}
+	for _, c := range cases {
+		orderModel := &fakeRescheduleOrderModel{examineDate: legacy}
+		capacityModel := &fakeRescheduleCapacityModel{}
+		service := &orderService{
+			orderModel:    orderModel,
+			packageModel:  &fakeReschedulePackageModel{},
+			capacityModel: capacityModel,
+			calendar:      &calendar.Calendar{},
+			auditService:  &fakeAuditService{},
+		}
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ctx.Set("userId", owner)
+
+		input := &dto.PutOrderItemInput{Id: 1}
+		input.ExamineDate = c.to
+		if err := service.ModifyOrderItem(ctx, input); err != nil {
+			t.Fatalf("%s: ModifyOrderItem failed: %v", c.name, err)
+		}
+		if got := orderModel.log != nil; got != c.reschedule {
+			t.Errorf("%s: reschedule logged = %v, want %v", c.name, got, c.reschedule)
+		}
+		if !c.reschedule {
+			if len(capacityModel.released) != 0 || len(capacityModel.reserved) != 0 {
+				t.Errorf("%s: capacity changed when the day is the same", c.name)
+			}
+			continue
+		}
+		if len(capacityModel.released) != 1 || len(capacityModel.reserved) != 1 || capacityModel.reserved[0].ExamineDate != xtime.DayStartAt(c.to) {
+			t.Errorf("%s: released %v, reserved %v", c.name, capacityModel.released, capacityModel.reserved)
+		}
+	}
+}
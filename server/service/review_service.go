@@ -0,0 +1,208 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"mk-api/library/ecode"
+	"mk-api/library/util/cos"
+	"mk-api/server/dto"
+	"mk-api/server/model"
+	"mk-api/server/util"
+	"mk-api/server/util/consts"
+	"mk-api/server/util/xtime"
+)
+
+type ReviewService interface {
+	// 用户评价体检日期已到的订单项, 审核通过后才对外展示
+	SubmitReview(ctx *gin.Context, input *dto.PostReviewInput) (int64, error)
+	// 套餐详情页的评价, 只返回审核通过的, 昵称打码
+	ListPackageReviews(ctx *gin.Context, input *dto.ListReviewInput) (*dto.PaginateListOutput, error)
+	// 用户查询自己的评价时 input.UserId 取自 token, 运营后台查询全部
+	ListReviews(ctx *gin.Context, input *dto.ListReviewInput) (*dto.PaginateListOutput, error)
+	ApproveReview(ctx *gin.Context, input *dto.ApproveReviewInput) error
+	RejectReview(ctx *gin.Context, input *dto.RejectReviewInput) error
+	// 所有订单项的体检日期都已过去的订单变为待评价, 由定时任务调用
+	SweepReviewableOrders()
+}
+
+type reviewService struct {
+	reviewModel  model.ReviewModel
+	stateMachine OrderStateMachine
+	auditService AuditService
+}
+
+func (service *reviewService) SubmitReview(ctx *gin.Context, input *dto.PostReviewInput) (int64, error) {
+	userId := ctx.GetInt64("userId")
+	logger := util.Log.WithFields(logrus.Fields{"user_id": userId, "order_item_id": input.OrderItemId})
+	for _, image := range input.Images {
+		if !strings.HasPrefix(image, cos.CommonBucketUrl+"/") {
+			_ = ctx.Error(errors.New("晒图需先通过上传接口上传"))
+			return 0, ecode.RequestErr
+		}
+	}
+
+	item, err := service.reviewModel.FindReviewableItem(input.OrderItemId, userId)
+	if err == sql.ErrNoRows {
+		return 0, resourceNotFound(ctx, "订单项")
+	} else if err != nil {
+		logger.Errorf("查询订单项出错, err: [%s]", err.Error())
+		return 0, err
+	}
+	if item.OrderStatus != consts.Success && item.OrderStatus != consts.ToReview ||
+		item.RefundStatus == consts.RefundAuditing || item.RefundStatus == consts.RefundApproved {
+		_ = ctx.Error(errors.New("只有已付款且未退款的订单才能评价"))
+		return 0, ecode.RequestErr
+	}
+	if item.ExamineDate == 0 || item.ExamineDate > time.Now().Unix() {
+		_ = ctx.Error(errors.New("体检之后才能评价"))
+		return 0, ecode.RequestErr
+	}
+	if exists, err := service.reviewModel.ExistsReview(input.OrderItemId); err != nil {
+		logger.Errorf("查询订单项的评价出错, err: [%s]", err.Error())
+		return 0, err
+	} else if exists {
+		_ = ctx.Error(errors.New("您已经评价过了"))
+		return 0, ecode.RequestErr
+	}
+
+	review := &dto.Review{
+		OrderId:     item.OrderId,
+		OrderItemId: item.OrderItemId,
+		UserId:      userId,
+		PackageId:   item.PackageId,
+		Rating:      input.Rating,
+		Content:     input.Content,
+		Images:      input.Images,
+		Status:      consts.ReviewPending,
+		CreateTime:  time.Now().Unix(),
+	}
+	id, err := service.reviewModel.SaveReview(review)
+	if err != nil {
+		// 并发提交时由 uk_order_item_id 拦下重复的评价
+		if exists, e := service.reviewModel.ExistsReview(input.OrderItemId); e == nil && exists {
+			_ = ctx.Error(errors.New("您已经评价过了"))
+			return 0, ecode.RequestErr
+		}
+		logger.Errorf("保存评价出错, err: [%s]", err.Error())
+		return 0, err
+	}
+	review.Id = id
+	service.auditService.Record(ctx, consts.AuditReviewCreate, "mkp_review", id, nil, review)
+	return id, nil
+}
+
+func (service *reviewService) ListPackageReviews(ctx *gin.Context, input *dto.ListReviewInput) (*dto.PaginateListOutput, error) {
+	input.Status = consts.ReviewApproved
+	input.UserId = 0
+	output, err := service.ListReviews(ctx, input)
+	if err != nil {
+		return output, err
+	}
+	for _, review := range output.List.([]*dto.Review) {
+		review.UserId = 0
+		review.OrderId, review.OrderItemId = 0, 0
+		review.UserName = maskName(review.UserName)
+	}
+	return output, nil
+}
+
+func (service *reviewService) ListReviews(ctx *gin.Context, input *dto.ListReviewInput) (*dto.PaginateListOutput, error) {
+	var output dto.PaginateListOutput
+	list, err := service.reviewModel.ListReviews(input)
+	if err != nil {
+		util.Log.Errorf("查询评价出错, input: [%v], err: [%s]", input, err.Error())
+		return &output, err
+	}
+	if len(list) == int(input.PageSize)+1 {
+		output.HasNext = 1
+		list = list[:len(list)-1]
+	}
+	output.PageSize = int64(len(list))
+	output.PageNo = input.PageNo
+	output.List = list
+	return &output, nil
+}
+
+func (service *reviewService) ApproveReview(ctx *gin.Context, input *dto.ApproveReviewInput) error {
+	ok, err := service.reviewModel.ApproveReview(input.ReviewId, ctx.GetInt64("staffId"), time.Now().Unix())
+	if err != nil {
+		util.Log.WithFields(logrus.Fields{"review_id": input.ReviewId}).Errorf("审核通过评价出错, err: [%s]", err.Error())
+		return err
+	}
+	if !ok {
+		_ = ctx.Error(errors.New("评价不存在或已被审核"))
+		return ecode.RequestErr
+	}
+	service.auditService.Record(ctx, consts.AuditReviewApprove, "mkp_review", input.ReviewId,
+		gin.H{"status": consts.ReviewPending}, gin.H{"status": consts.ReviewApproved})
+	return nil
+}
+
+func (service *reviewService) RejectReview(ctx *gin.Context, input *dto.RejectReviewInput) error {
+	ok, err := service.reviewModel.RejectReview(input.ReviewId, input.Remark, ctx.GetInt64("staffId"), time.Now().Unix())
+	if err != nil {
+		util.Log.WithFields(logrus.Fields{"review_id": input.ReviewId}).Errorf("驳回评价出错, err: [%s]", err.Error())
+		return err
+	}
+	if !ok {
+		_ = ctx.Error(errors.New("评价不存在或已被审核"))
+		return ecode.RequestErr
+	}
+	service.auditService.Record(ctx, consts.AuditReviewReject, "mkp_review", input.ReviewId,
+		gin.H{"status": consts.ReviewPending, "remark": ""}, gin.H{"status": consts.ReviewRejected, "remark": input.Remark})
+	return nil
+}
+
+func (service *reviewService) SweepReviewableOrders() {
+	var total int
+	for {
+		orderIds, err := service.reviewModel.FindReviewableOrders(xtime.DayStartAt(time.Now().Unix()), consts.ReviewSweepBatch)
+		if err != nil {
+			util.Log.Errorf("查询待评价订单出错, err: [%s]", err.Error())
+			return
+		}
+		var done int
+		for _, orderId := range orderIds {
+			err = service.stateMachine.Transit(&dto.OrderTransition{
+				OrderId:   orderId,
+				To:        consts.ToReview,
+				ActorType: consts.ActorSystem,
+				Reason:    "体检日期已过",
+			}, service.reviewModel.RequireNoRefund(orderId))
+			if err == nil {
+				done++
+			} else if err == model.ErrRefundApplied {
+				util.Log.WithFields(logrus.Fields{"order_id": orderId}).Info("订单已申请退款, 不变为待评价")
+			} else if !ecode.EqualError(consts.OrderStatusIllegal, err) {
+				util.Log.WithFields(logrus.Fields{"order_id": orderId}).Errorf("订单变为待评价出错, err: [%s]", err.Error())
+			}
+		}
+		total += done
+		// 出错的订单会被再次查出, 整批都没有成功时结束, 避免死循环
+		if len(orderIds) < consts.ReviewSweepBatch || done == 0 {
+			break
+		}
+	}
+	if total > 0 {
+		util.Log.Infof("sweep reviewable orders done, %d orders to review.", total)
+	}
+}
+
+// 对外展示的昵称只保留第一个字
+func maskName(name string) string {
+	if name == "" {
+		return "匿名用户"
+	}
+	r, _ := utf8.DecodeRuneInString(name)
+	return string(r) + "***"
+}
+
+func NewReviewService(reviewModel model.ReviewModel, stateMachine OrderStateMachine, auditService AuditService) ReviewService {
+	return &reviewService{reviewModel: reviewModel, stateMachine: stateMachine, auditService: auditService}
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+	"mk-api/library/ecode"
+	. "mk-api/server/dao"
+	"mk-api/server/dto"
+	"mk-api/server/model"
+	"mk-api/server/util"
+	"mk-api/server/util/consts"
+	tokenUtil "mk-api/server/util/token"
+)
+
+type StaffService interface {
+	// 运营人员用户名密码登录, 返回运营后台使用的 token
+	Login(ctx *gin.Context, input *dto.StaffLoginInput) (*dto.StaffLoginOutput, error)
+}
+
+type staffService struct {
+	staffModel model.StaffModel
+}
+
+func (service *staffService) Login(ctx *gin.Context, input *dto.StaffLoginInput) (*dto.StaffLoginOutput, error) {
+	staff, err := service.staffModel.FindStaffByUsername(input.Username)
+	if err == sql.ErrNoRows {
+		_ = ctx.Error(errors.New("用户名或密码错误"))
+		return nil, ecode.RequestErr
+	} else if err != nil {
+		util.Log.WithFields(logrus.Fields{"username": input.Username}).Errorf("查询运营人员出错, err: [%s]", err.Error())
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(staff.Password), []byte(input.Password)) != nil {
+		_ = ctx.Error(errors.New("用户名或密码错误"))
+		return nil, ecode.RequestErr
+	}
+	if staff.Status != consts.StaffActive {
+		_ = ctx.Error(errors.New("该账号已停用"))
+		return nil, ecode.RequestErr
+	}
+	if !staff.Role.Valid() || (staff.Role.HospitalScoped() && staff.HospitalId == 0) {
+		_ = ctx.Error(errors.New("该账号角色配置有误, 请联系管理员"))
+		return nil, ecode.RequestErr
+	}
+
+	cli := Rdb.TokenRdbP.Get()
+	defer cli.Close()
+	token := tokenUtil.GenerateUuid()
+	expiresIn := int64(consts.StaffTokenExpire.Seconds())
+	if err = tokenUtil.SetStaffToken(token, staff, expiresIn, cli); err != nil {
+		util.Log.WithFields(logrus.Fields{"staff_id": staff.Id}).Errorf("保存运营人员token出错, err: [%s]", err.Error())
+		return nil, err
+	}
+	return &dto.StaffLoginOutput{Token: token, ExpiresIn: expiresIn, Staff: staff}, nil
+}
+
+func NewStaffService(staffModel model.StaffModel) StaffService {
+	return &staffService{staffModel: staffModel}
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"strconv"
@@ -21,14 +22,14 @@ import (
 type UserService interface {
 	Retrieve(id int64) (*dto.UserDetailOutput, error)
 	FindAllAddrs(userId int64) (addrs []model.UserAddr, err error)
-	SaveAddr(addr *model.UserAddr) (id int64, err error)
-	RetrieveAddr(id int64) (addr *dto.GetUserAddrOutput, err error)
-	DeleteAddr(id int64) (err error)
+	SaveAddr(ctx *gin.Context, addr *model.UserAddr) (id int64, err error)
+	RetrieveAddr(ctx *gin.Context, id int64) (addr *dto.GetUserAddrOutput, err error)
+	DeleteAddr(ctx *gin.Context, id int64) (err error)
 	UpdateUserAddr(ctx *gin.Context, id int64, addr *dto.UpdateUserAddrInput) (err error)
 
 	FindAllExaminees(userId int64) ([]*dto.ListExamineeOutputEle, error)
-	SaveExaminee(userId int64, input *dto.PostExamineeInput) (id int64, err error)
-	RemoveExaminee(id int64, userId int64) error
+	SaveExaminee(ctx *gin.Context, userId int64, input *dto.PostExamineeInput) (id int64, err error)
+	RemoveExaminee(ctx *gin.Context, id int64, userId int64) error
 	ModifyExaminee(ctx *gin.Context, id int64, input *dto.PostExamineeInput) error
 	ModifyProfile(ctx *gin.Context, input *dto.PutUserProfileInput) error
 	UploadAvatar(ctx *gin.Context, avatarUrl string) error
@@ -39,6 +40,7 @@ type userService struct {
 	addrModel     model.UserAddrModel
 	regionModel   model.RegionModel
 	examineeModel model.ExamineeModel
+	auditService  AuditService
 }
 
 func (service *userService) UploadAvatar(ctx *gin.Context, avatarUrl string) error {
@@ -48,14 +50,22 @@ func (service *userService) UploadAvatar(ctx *gin.Context, avatarUrl string) err
 	key := consts.CacheProfile + "." + strconv.FormatInt(userId, 10)
 	go Rdb.ApiCache.Delete(key)
 
-	return service.model.UpdateAvatUrl(avatarUrl, userId)
+	before, _ := service.model.FindUserByID(userId)
+	if err := service.model.UpdateAvatUrl(avatarUrl, userId); err != nil {
+		return err
+	}
+	service.auditService.Record(ctx, consts.AuditProfileAvatarUpdate, "mku_user_profile", userId, before, gin.H{"avatar_url": avatarUrl})
+	return nil
 }
 
 func (service *userService) ModifyProfile(ctx *gin.Context, input *dto.PutUserProfileInput) error {
 	input.UpdateTime = time.Now().Unix()
+	before, _ := service.model.FindUserByID(input.UserId)
 	err := service.model.UpdateProfile(input)
 	if err != nil {
 		util.Log.Errorf("修改用户信息失败, input: [%v], err: [%s]", input, err.Error())
+	} else {
+		service.auditService.Record(ctx, consts.AuditProfileUpdate, "mku_user_profile", input.UserId, before, input)
 	}
 
 	// delete api cache
@@ -82,27 +92,50 @@ func (service *userService) ModifyExaminee(ctx *gin.Context, id int64, input *dt
 		bean.Gender = Female
 	}
 
-	err := service.examineeModel.UpdateExaminee(bean)
+	before, err := service.examineeModel.FindExamineeByIdNUserId(id, userId)
+	if err == sql.ErrNoRows {
+		return resourceNotFound(ctx, "体检人")
+	} else if err != nil {
+		util.Log.WithFields(logrus.Fields{
+			"user_id":     userId,
+			"examinee_id": id,
+		}).Errorf("查询examinee出错, err: [%s]", err.Error())
+		return err
+	}
+	err = service.examineeModel.UpdateExaminee(bean)
 	if err != nil {
 		util.Log.WithFields(logrus.Fields{
 			"user_id":     userId,
 			"examinee_id": id,
 		}).Errorf("更新examinee出错, err: [%s]", err.Error())
+		return err
 	}
-	return err
+	service.auditService.Record(ctx, consts.AuditExamineeUpdate, "mku_examinee", id, before, bean)
+	return nil
 }
 
-func (service *userService) RemoveExaminee(id int64, userId int64) error {
-	err := service.examineeModel.DeleteExamineeByIdNUserId(id, userId)
+func (service *userService) RemoveExaminee(ctx *gin.Context, id int64, userId int64) error {
+	before, err := service.examineeModel.FindExamineeByIdNUserId(id, userId)
+	if err == sql.ErrNoRows {
+		return resourceNotFound(ctx, "体检人")
+	} else if err != nil {
+		util.Log.WithFields(
+			logrus.Fields{"user_id": userId, "examinee_id": id}).
+			Errorf("查询examinee出错, err: [%s]", err.Error())
+		return err
+	}
+	err = service.examineeModel.DeleteExamineeByIdNUserId(id, userId)
 	if err != nil {
 		util.Log.WithFields(
 			logrus.Fields{"user_id": userId, "examinee_id": id}).
 			Errorf("逻辑删除examinee失败， err: [%s]", err.Error())
+		return err
 	}
-	return err
+	service.auditService.Record(ctx, consts.AuditExamineeDelete, "mku_examinee", id, before, nil)
+	return nil
 }
 
-func (service *userService) SaveExaminee(userId int64, input *dto.PostExamineeInput) (id int64, err error) {
+func (service *userService) SaveExaminee(ctx *gin.Context, userId int64, input *dto.PostExamineeInput) (id int64, err error) {
 	var bean = &dto.ExamineeBean{
 		UserId:            userId,
 		Gender:            0,
@@ -121,7 +154,10 @@ func (service *userService) SaveExaminee(userId int64, input *dto.PostExamineeIn
 	id, err = service.examineeModel.SaveExaminee(bean)
 	if err != nil {
 		util.Log.WithFields(logrus.Fields{"user_id": userId}).Errorf("创建常用体检人失败, err: [%s]", err.Error())
+		return
 	}
+	bean.Id = id
+	service.auditService.Record(ctx, consts.AuditExamineeCreate, "mku_examinee", id, nil, bean)
 	return
 }
 
@@ -138,11 +174,16 @@ func (service *userService) FindAllExaminees(userId int64) ([]*dto.ListExamineeO
 	return output, err
 }
 
-func (service *userService) SaveAddr(addr *model.UserAddr) (id int64, err error) {
+func (service *userService) SaveAddr(ctx *gin.Context, addr *model.UserAddr) (id int64, err error) {
 	if addr.IsDefault == 1 {
 		_ = service.addrModel.CancelOriginDefaultAddr(addr.UserId)
 	}
-	return service.addrModel.Save(addr)
+	if id, err = service.addrModel.Save(addr); err != nil {
+		return
+	}
+	addr.Id = id
+	service.auditService.Record(ctx, consts.AuditAddressCreate, "mku_user_address", id, nil, addr)
+	return
 }
 
 func (service *userService) Retrieve(id int64) (*dto.UserDetailOutput, error) {
@@ -197,38 +238,56 @@ func (service *userService) FindAllAddrs(userId int64) (addrs []model.UserAddr,
 	return
 }
 
-func (service *userService) RetrieveAddr(id int64) (addr *dto.GetUserAddrOutput, err error) {
-	addr, err = service.addrModel.FindUserAddrByAddrId(id)
-	if err != nil {
+func (service *userService) RetrieveAddr(ctx *gin.Context, id int64) (addr *dto.GetUserAddrOutput, err error) {
+	addr, err = service.addrModel.FindUserAddrByIdNUserId(id, ctx.GetInt64("userId"))
+	if err == sql.ErrNoRows {
+		return nil, resourceNotFound(ctx, "收件地址")
+	} else if err != nil {
 		util.Log.Errorf("查询用户收件地址出错, err: [%s]", err.Error())
 	}
 	return
 }
 
-func (service *userService) DeleteAddr(id int64) (err error) {
-	err = service.addrModel.DeleteUserAddrByAddrId(id)
+func (service *userService) DeleteAddr(ctx *gin.Context, id int64) (err error) {
+	userId := ctx.GetInt64("userId")
+	before, err := service.RetrieveAddr(ctx, id)
+	if err != nil {
+		return
+	}
+	err = service.addrModel.DeleteUserAddrByIdNUserId(id, userId)
 	if err != nil {
 		util.Log.Errorf("删除用户收件地址出错, err: [%s]", err.Error())
+		return
 	}
+	service.auditService.Record(ctx, consts.AuditAddressDelete, "mku_user_address", id, before, nil)
 	return
 }
 
 func (service *userService) UpdateUserAddr(ctx *gin.Context, id int64, addr *dto.UpdateUserAddrInput) (err error) {
+	userId := ctx.GetInt64("userId")
+	// 先确认地址属于当前用户, 再取消原默认地址
+	before, err := service.RetrieveAddr(ctx, id)
+	if err != nil {
+		return
+	}
 	if addr.IsDefault == 1 {
-		_ = service.addrModel.CancelOriginDefaultAddr(ctx.GetInt64("userId"))
+		_ = service.addrModel.CancelOriginDefaultAddr(userId)
 	}
-	err = service.addrModel.UpdateUserAddr(id, addr)
+	err = service.addrModel.UpdateUserAddr(id, userId, addr)
 	if err != nil {
 		util.Log.Errorf("修改用户收件地址出错, err: [%s]", err.Error())
+		return
 	}
+	service.auditService.Record(ctx, consts.AuditAddressUpdate, "mku_user_address", id, before, addr)
 	return
 }
 
-func NewUserService(userModel model.UserModel, addrModel model.UserAddrModel, regionModel model.RegionModel, examineeModel model.ExamineeModel) UserService {
+func NewUserService(userModel model.UserModel, addrModel model.UserAddrModel, regionModel model.RegionModel, examineeModel model.ExamineeModel, auditService AuditService) UserService {
 	return &userService{
 		model:         userModel,
 		addrModel:     addrModel,
 		regionModel:   regionModel,
 		examineeModel: examineeModel,
+		auditService:  auditService,
 	}
 }
@@ -0,0 +1,61 @@
+// Package audit 审计日志的修改前后对比
+package audit
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// Diff 按 json 字段对比修改前后的记录, 只保留两边都有且有变化的字段, 返回 json 字符串,
+// 因此 before 和 after 可以是不同的结构体, 如数据库记录和请求参数.
+// 新建时 before 为 nil, 删除时 after 为 nil, 对应的返回值为空字符串, 另一边保留全部字段
+func Diff(before, after interface{}) (string, string, error) {
+	b, err := toMap(before)
+	if err != nil {
+		return "", "", err
+	}
+	a, err := toMap(after)
+	if err != nil {
+		return "", "", err
+	}
+	if b != nil && a != nil {
+		for k, v := range b {
+			if av, ok := a[k]; !ok || reflect.DeepEqual(v, av) {
+				delete(b, k)
+				delete(a, k)
+			}
+		}
+		for k := range a {
+			if _, ok := b[k]; !ok {
+				delete(a, k)
+			}
+		}
+	}
+	bs, err := marshal(b)
+	if err != nil {
+		return "", "", err
+	}
+	as, err := marshal(a)
+	return bs, as, err
+}
+
+func toMap(v interface{}) (map[string]interface{}, error) {
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]interface{})
+	err = json.Unmarshal(data, &m)
+	return m, err
+}
+
+func marshal(m map[string]interface{}) (string, error) {
+	if m == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(m)
+	return string(data), err
+}
@@ -0,0 +1,54 @@
+package audit
+
+import "testing"
+
+type examinee struct {
+	Name     string `json:"name"`
+	Mobile   string `json:"mobile"`
+	Relation int8   `json:"relation"`
+}
+
+func TestDiff(t *testing.T) {
+	cases := []struct {
+		name          string
+		before, after interface{}
+		b, a          string
+	}{
+		{
+			name:   "update",
+			before: &examinee{Name: "张三", Mobile: "13800000000", Relation: 1},
+			after:  &examinee{Name: "张三", Mobile: "13900000000", Relation: 1},
+			b:      `{"mobile":"13800000000"}`,
+			a:      `{"mobile":"13900000000"}`,
+		},
+		{
+			name:   "different struct",
+			before: &examinee{Name: "张三", Mobile: "13800000000", Relation: 1},
+			after:  map[string]interface{}{"name": "李四", "update_time": 1600000000},
+			b:      `{"name":"张三"}`,
+			a:      `{"name":"李四"}`,
+		},
+		{
+			name:  "create",
+			after: map[string]interface{}{"id": 1},
+			b:     "",
+			a:     `{"id":1}`,
+		},
+		{
+			name:   "delete",
+			before: &examinee{Name: "李四"},
+			after:  (*examinee)(nil),
+			b:      `{"mobile":"","name":"李四","relation":0}`,
+			a:      "",
+		},
+	}
+	for _, tc := range cases {
+		b, a, err := Diff(tc.before, tc.after)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if b != tc.b || a != tc.a {
+			t.Errorf("%s: expect (%s, %s), got (%s, %s)", tc.name, tc.b, tc.a, b, a)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// Package calendar 体检机构的营业日历: 法定节假日、调休上班日以及各机构每周固定的休息日
+package calendar
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// Calendar 全国统一的节假日安排, key 为 2006-01-02 格式的日期, value 为节日名称
+type Calendar struct {
+	// 法定节假日, 所有机构休息
+	Holidays map[string]string `json:"holidays"`
+	// 调休上班日, 即使是机构每周的休息日也照常营业
+	Workdays map[string]string `json:"workdays"`
+}
+
+// Weekdays 机构每周固定的休息日
+type Weekdays map[time.Weekday]bool
+
+// ParseWeekdays 解析以逗号分隔的星期, 0 为周日, 如 "0,6"
+func ParseWeekdays(s string) Weekdays {
+	weekdays := make(Weekdays)
+	for _, d := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(d))
+		if err != nil || n < 0 || n > 6 {
+			continue
+		}
+		weekdays[time.Weekday(n)] = true
+	}
+	return weekdays
+}
+
+func Parse(data []byte) (*Calendar, error) {
+	var c Calendar
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func Load(path string) (*Calendar, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// IsOpen ts 当天机构是否营业, 不营业时 remark 为原因
+func (c *Calendar) IsOpen(ts int64, closed Weekdays) (open bool, remark string) {
+	t := time.Unix(ts, 0)
+	date := t.Format(dateLayout)
+	if name, ok := c.Holidays[date]; ok {
+		return false, name
+	}
+	if _, ok := c.Workdays[date]; ok {
+		return true, ""
+	}
+	if closed[t.Weekday()] {
+		return false, "机构休息日"
+	}
+	return true, ""
+}
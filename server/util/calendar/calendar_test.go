@@ -0,0 +1,45 @@
+package calendar
+
+import (
+	"testing"
+	"time"
+)
+
+func date(s string) int64 {
+	t, _ := time.ParseInLocation(dateLayout, s, time.Local)
+	return t.Unix()
+}
+
+func TestIsOpen(t *testing.T) {
+	c, err := Load("../../static/calendar/holidays.json")
+	if err != nil {
+		t.Fatalf("load holidays.json failed: %v", err)
+	}
+	sunday := ParseWeekdays("0")
+
+	cases := []struct {
+		date string
+		open bool
+	}{
+		{"2020-10-01", false}, // 国庆节
+		{"2020-09-27", true},  // 周日, 国庆节调休上班
+		{"2020-09-20", false}, // 普通周日
+		{"2020-09-19", true},  // 普通周六
+		{"2020-09-21", true},  // 普通周一
+	}
+	for _, tc := range cases {
+		if open, remark := c.IsOpen(date(tc.date), sunday); open != tc.open {
+			t.Errorf("%s: expect open %v, got %v (%s)", tc.date, tc.open, open, remark)
+		}
+	}
+}
+
+func TestParseWeekdays(t *testing.T) {
+	w := ParseWeekdays("0, 6,x,9")
+	if len(w) != 2 || !w[time.Sunday] || !w[time.Saturday] {
+		t.Errorf("unexpected weekdays: %v", w)
+	}
+	if len(ParseWeekdays("")) != 0 {
+		t.Error("empty string should mean no closed weekdays")
+	}
+}
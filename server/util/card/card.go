@@ -0,0 +1,114 @@
+// Package card 体检卡的卡号生成和抵扣金额计算, 金额单位为分
+package card
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+
+	"mk-api/server/util/money"
+)
+
+// 体检卡类型
+const (
+	TypePackage int8 = 1 // 套餐卡, 抵扣一份指定套餐
+	TypeValue   int8 = 2 // 储值卡, 抵扣指定金额, 一次性使用, 余额不退
+)
+
+// 卡号去掉了容易混淆的 0 O 1 I, 16 位共 80 bit, 无法被猜出
+const (
+	alphabet   = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+	CodeLength = 16
+)
+
+var ErrNotApplicable = errors.New("订单中没有该体检卡对应的套餐")
+
+// GenerateCode 用 crypto/rand 生成随机卡号
+func GenerateCode() (string, error) {
+	max := big.NewInt(int64(len(alphabet)))
+	b := make([]byte, CodeLength)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = alphabet[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// Format 每 4 位用 - 分隔, 便于印刷和手工输入
+func Format(code string) string {
+	var sb strings.Builder
+	for i := 0; i < len(code); i++ {
+		if i > 0 && i%4 == 0 {
+			sb.WriteByte('-')
+		}
+		sb.WriteByte(code[i])
+	}
+	return sb.String()
+}
+
+// Normalize 去掉用户输入的分隔符和空格, 并转成大写
+func Normalize(input string) string {
+	r := strings.NewReplacer("-", "", " ", "")
+	return strings.ToUpper(r.Replace(input))
+}
+
+type Rule struct {
+	// 1-套餐卡 2-储值卡
+	Type int8 `json:"type" db:"type"`
+	// 套餐卡对应的套餐id
+	PackageId int64 `json:"pkg_id" db:"pkg_id"`
+	// 储值卡的面值
+	Value money.Fen `json:"value" db:"value"`
+}
+
+type Item struct {
+	PackageId int64
+	// 订单项使用优惠券后的应付金额
+	Payable money.Fen
+}
+
+// Offset 计算体检卡抵扣的金额, offsets 与 items 一一对应.
+// 套餐卡抵扣第一份对应套餐的应付金额, 储值卡按应付金额比例分摊面值, 可以抵扣到 0 元
+func (r *Rule) Offset(items []*Item) (total money.Fen, offsets []money.Fen, err error) {
+	offsets = make([]money.Fen, len(items))
+	switch r.Type {
+	case TypePackage:
+		for i, item := range items {
+			if item.PackageId == r.PackageId {
+				offsets[i] = item.Payable
+				return item.Payable, offsets, nil
+			}
+		}
+		return 0, nil, ErrNotApplicable
+	case TypeValue:
+		var payable money.Fen
+		for _, item := range items {
+			payable += item.Payable
+		}
+		total = r.Value
+		if total > payable {
+			total = payable
+		}
+		if total <= 0 {
+			return 0, offsets, nil
+		}
+		var allocated money.Fen
+		for i, item := range items {
+			offsets[i] = total * item.Payable / payable
+			allocated += offsets[i]
+		}
+		// 按比例取整后剩下的零头逐分补到还有余量的订单项上
+		for i := 0; allocated < total; i = (i + 1) % len(items) {
+			if offsets[i] < items[i].Payable {
+				offsets[i]++
+				allocated++
+			}
+		}
+		return total, offsets, nil
+	}
+	return 0, nil, errors.New("未知的体检卡类型")
+}
@@ -0,0 +1,69 @@
+package card
+
+import (
+	"testing"
+
+	"mk-api/server/util/money"
+)
+
+func TestGenerateCode(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		code, err := GenerateCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code) != CodeLength {
+			t.Fatalf("code %s has length %d", code, len(code))
+		}
+		if seen[code] {
+			t.Fatalf("duplicated code %s", code)
+		}
+		seen[code] = true
+		if Normalize(Format(code)) != code {
+			t.Fatalf("format then normalize %s failed", code)
+		}
+	}
+	if got := Normalize(" abcd-efgh 2345-6789 "); got != "ABCDEFGH23456789" {
+		t.Errorf("normalize got %s", got)
+	}
+}
+
+func TestOffset(t *testing.T) {
+	items := []*Item{
+		{PackageId: 1, Payable: 10000},
+		{PackageId: 2, Payable: 20000},
+		{PackageId: 2, Payable: 20000},
+	}
+	cases := []struct {
+		name  string
+		rule  Rule
+		total money.Fen
+		err   bool
+	}{
+		{"package", Rule{Type: TypePackage, PackageId: 2}, 20000, false},
+		{"package missing", Rule{Type: TypePackage, PackageId: 9}, 0, true},
+		{"value", Rule{Type: TypeValue, Value: 10001}, 10001, false},
+		{"value exceeds payable", Rule{Type: TypeValue, Value: 80000}, 50000, false},
+	}
+	for _, tc := range cases {
+		total, offsets, err := tc.rule.Offset(items)
+		if (err != nil) != tc.err {
+			t.Errorf("%s: unexpected err %v", tc.name, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		var s money.Fen
+		for i, v := range offsets {
+			if v > items[i].Payable {
+				t.Errorf("%s: offset %d exceeds payable %d", tc.name, v, items[i].Payable)
+			}
+			s += v
+		}
+		if total != tc.total || s != total {
+			t.Errorf("%s: total %d, sum %d, want %d", tc.name, total, s, tc.total)
+		}
+	}
+}
@@ -5,10 +5,100 @@ import "time"
 const ServiceName = "mk-server"
 const UrlPrefix = "https://www.mkhealth.club"
 
+// 订单状态, 状态之间的流转见 service/order_state_machine.go
 const (
 	OrderExpireIn      = time.Second * 2 * 3600
-	Closed        int8 = 4
-	Success       int8 = 2
+	Pending       int8 = 0 // 待付款
+	Success       int8 = 2 // 已付款(待预约)
+	Refunded      int8 = 3 // 已退款
+	Closed        int8 = 4 // 已关闭
+	ToReview      int8 = 5 // 待评价
+)
+
+// 订单状态变更的操作人类型
+const (
+	ActorUser   int8 = 1 // 用户
+	ActorStaff  int8 = 2 // 运营人员
+	ActorSystem int8 = 3 // 系统定时任务
+	ActorWechat int8 = 4 // 微信支付回调
+)
+
+// 退款申请的审核状态
+const (
+	RefundNotApplied int8 = 0 // 未申请
+	RefundAuditing   int8 = 1 // 待审核
+	RefundApproved   int8 = 2 // 已同意
+	RefundRejected   int8 = 3 // 已拒绝
+)
+
+// 订单项的预约状态, 由客服和体检机构确认
+const (
+	AppointmentPending   int8 = 0 // 待确认
+	AppointmentConfirmed int8 = 1 // 已确认
+)
+
+// 优惠券及用户领取的优惠券的状态
+const (
+	CouponActive   int8 = 1 // 启用
+	CouponDisabled int8 = 2 // 停用
+	CouponUnused   int8 = 0 // 未使用
+	CouponUsed     int8 = 1 // 已使用
+)
+
+// 体检卡批次及体检卡的状态
+const (
+	CardBatchActive int8 = 1 // 正常
+	CardBatchVoided int8 = 2 // 已作废
+	CardUnused      int8 = 0 // 未使用
+	CardRedeemed    int8 = 1 // 已使用
+	CardVoided      int8 = 2 // 已作废
+)
+
+// 发票抬头类型及发票申请的状态
+const (
+	InvoiceTitlePersonal int8 = 1 // 个人
+	InvoiceTitleCompany  int8 = 2 // 企业
+	InvoicePending       int8 = 0 // 待开具
+	InvoiceIssued        int8 = 1 // 已开具
+	InvoiceRejected      int8 = 2 // 已驳回
+)
+
+// 套餐评价的审核状态
+const (
+	ReviewPending  int8 = 0 // 待审核
+	ReviewApproved int8 = 1 // 已通过
+	ReviewRejected int8 = 2 // 已驳回
+)
+
+// 体检报告, 下载链接有效期短, 过期后用户需重新获取
+const (
+	ReportMaxSize   = 20 << 20
+	ReportUrlExpire = time.Minute * 10
+)
+
+// 幂等请求, 处理中的标记在请求异常退出时自动过期, 成功的响应在窗口期内原样返回
+const (
+	IdempotencyLockExpire = time.Minute
+	IdempotencyWindow     = time.Hour * 24
+)
+
+// 运营人员登录
+const (
+	StaffTokenExpire = time.Hour * 12
+	StaffActive      = 1 // 正常
+	StaffDisabled    = 2 // 已停用
+)
+
+// 超时订单扫描
+const (
+	OrderSweepInterval = time.Minute
+	OrderSweepBatch    = 100
+)
+
+// 待评价订单扫描, 体检日期次日起订单变为待评价
+const (
+	ReviewSweepInterval = time.Hour
+	ReviewSweepBatch    = 100
 )
 
 const (
@@ -17,6 +107,40 @@ const (
 	CachePackage  = "string.PACKAGE"
 	CacheOrder    = "string.ORDER"
 	CacheProfile  = "string.PROFILE"
+	// 幂等请求的响应, 后接 用户id.路由.幂等键
+	CacheIdempotency = "string.IDEMPOTENCY"
+	// 分布式锁, 后接 业务.资源标识
+	CacheLock = "string.LOCK"
+	// 前端轮询支付状态时向微信查询的限流标记, 后接 out_trade_no
+	CachePayQuery = "string.PAY_QUERY"
+)
+
+// 支付结果处理(回调和主动查询)按 out_trade_no 加锁, 持有时长需覆盖一次处理, 等待超时后回调让微信重试
+const (
+	PayNotifyLockTTL  = time.Second * 30
+	PayNotifyLockWait = time.Second * 3
+)
+
+// 微信支付对账, 支付超过 PayReconcileAfter 仍未收到回调的订单主动向微信查询
+const (
+	PayReconcileInterval = time.Minute * 5
+	PayReconcileAfter    = time.Minute * 5
+	PayReconcileBatch    = 100
+	// 同一订单轮询支付状态时向微信查询的最小间隔
+	PayQueryInterval = time.Second * 5
+)
+
+// 调用微信退款接口超时等结果未知的退款, 发起超过 RefundRetryAfter 仍未被受理的用同一个退款单号重新发起
+const (
+	RefundRetryInterval = time.Minute * 5
+	RefundRetryAfter    = time.Minute * 5
+	RefundRetryBatch    = 100
+)
+
+// 微信支付对账单次日 10 点后生成, 每天这个时候核对前一天的交易
+const (
+	BillReconcileHour    = 10
+	BillReconcileLockTTL = time.Minute * 10
 )
 
 // Api Cache Duration
@@ -28,3 +152,38 @@ const (
 	OrderListDuration    = time.Second * 2
 	ProfileOneDuration   = time.Hour * 24
 )
+
+// 审计日志记录的操作, 格式为 对象.动作
+const (
+	AuditOrderCreate         = "order.create"
+	AuditOrderDelete         = "order.delete"
+	AuditOrderCancel         = "order.cancel"
+	AuditOrderRefundApply    = "order.refund_apply"
+	AuditOrderItemUpdate     = "order_item.update"
+	AuditOrderNoteCreate     = "order_note.create"
+	AuditAppointmentConfirm  = "appointment.confirm"
+	AuditRefundApprove       = "refund.approve"
+	AuditRefundReject        = "refund.reject"
+	AuditExamineeCreate      = "examinee.create"
+	AuditExamineeUpdate      = "examinee.update"
+	AuditExamineeDelete      = "examinee.delete"
+	AuditAddressCreate       = "address.create"
+	AuditAddressUpdate       = "address.update"
+	AuditAddressDelete       = "address.delete"
+	AuditProfileUpdate       = "profile.update"
+	AuditProfileAvatarUpdate = "profile.avatar_update"
+	AuditCouponCreate        = "coupon.create"
+	AuditCardBatchCreate     = "card_batch.create"
+	AuditCardBatchVoid       = "card_batch.void"
+	AuditCardBatchExport     = "card_batch.export"
+	AuditInvoiceApply        = "invoice.apply"
+	AuditInvoiceIssue        = "invoice.issue"
+	AuditInvoiceReject       = "invoice.reject"
+	AuditReviewCreate        = "review.create"
+	AuditReviewApprove       = "review.approve"
+	AuditReviewReject        = "review.reject"
+	AuditReportUpload        = "report.upload"
+	AuditReportDelete        = "report.delete"
+	AuditReportDownload      = "report.download"
+	AuditExamResultSave      = "exam_result.save"
+)
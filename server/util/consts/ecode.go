@@ -0,0 +1,11 @@
+package consts
+
+import "mk-api/library/ecode"
+
+// 业务错误码, 通用错误码见 library/ecode/common_ecode.go
+var (
+	OrderStatusIllegal   = ecode.New(10001) // 订单当前状态不允许该操作
+	RescheduleChargeable = ecode.New(10002) // 免费改期次数已用完, 需用户确认收取服务费后再改期
+	CapacityFull         = ecode.New(10003) // 所选体检日期名额已满
+	ResourceNotFound     = ecode.New(10004) // 资源不存在或不属于当前用户
+)
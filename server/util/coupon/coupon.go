@@ -0,0 +1,111 @@
+// Package coupon 优惠券的适用范围和优惠金额计算, 金额单位为分
+package coupon
+
+import (
+	"errors"
+	"fmt"
+
+	"mk-api/server/util/money"
+)
+
+// 优惠券类型
+const (
+	TypeFixed   int8 = 1 // 满减券
+	TypePercent int8 = 2 // 折扣券
+)
+
+// 适用范围
+const (
+	ScopeAll      int8 = 0 // 全部套餐
+	ScopePackage  int8 = 1 // 指定套餐
+	ScopeHospital int8 = 2 // 指定体检机构的套餐
+)
+
+var ErrNotApplicable = errors.New("订单中没有适用该优惠券的套餐")
+
+type Rule struct {
+	// 1-满减券 2-折扣券
+	Type int8 `json:"type" db:"type"`
+	// 满减券的减免金额
+	Amount money.Fen `json:"amount" db:"amount"`
+	// 折扣券的减免比例, 如 15 表示减免 15%, 即 85 折
+	Rate int64 `json:"rate" db:"rate"`
+	// 适用套餐的金额满多少可用, 0 表示无门槛
+	Threshold money.Fen `json:"threshold" db:"threshold"`
+	// 折扣券最多减免的金额, 0 表示不限
+	MaxDiscount money.Fen `json:"max_discount" db:"max_discount"`
+	// 0-全部套餐 1-指定套餐 2-指定体检机构
+	ScopeType int8 `json:"scope_type" db:"scope_type"`
+	// 指定的套餐id或体检机构id
+	ScopeId int64 `json:"scope_id" db:"scope_id"`
+}
+
+type Item struct {
+	PackageId  int64
+	HospitalId int64
+	Price      money.Fen
+}
+
+func (r *Rule) Covers(item *Item) bool {
+	switch r.ScopeType {
+	case ScopePackage:
+		return item.PackageId == r.ScopeId
+	case ScopeHospital:
+		return item.HospitalId == r.ScopeId
+	}
+	return true
+}
+
+// Apply 计算订单的优惠金额, 并按价格比例分摊到适用的订单项上, discounts 与 items 一一对应.
+// 订单至少需支付 1 分钱, 优惠金额不会超过订单金额减 1 分
+func (r *Rule) Apply(items []*Item) (total money.Fen, discounts []money.Fen, err error) {
+	var eligible, amount money.Fen
+	for _, item := range items {
+		amount += item.Price
+		if r.Covers(item) {
+			eligible += item.Price
+		}
+	}
+	if eligible == 0 {
+		return 0, nil, ErrNotApplicable
+	}
+	if eligible < r.Threshold {
+		return 0, nil, fmt.Errorf("适用套餐满%s元才能使用该优惠券", r.Threshold.Yuan())
+	}
+
+	switch r.Type {
+	case TypeFixed:
+		total = r.Amount
+	case TypePercent:
+		total = eligible * money.Fen(r.Rate) / 100
+		if r.MaxDiscount > 0 && total > r.MaxDiscount {
+			total = r.MaxDiscount
+		}
+	}
+	if total > eligible {
+		total = eligible
+	}
+	if total > amount-1 {
+		total = amount - 1
+	}
+	if total <= 0 {
+		return 0, make([]money.Fen, len(items)), nil
+	}
+
+	discounts = make([]money.Fen, len(items))
+	var allocated money.Fen
+	for i, item := range items {
+		if r.Covers(item) {
+			discounts[i] = total * item.Price / eligible
+			allocated += discounts[i]
+		}
+	}
+	// 按比例取整后剩下的零头逐分补到还有余量的订单项上
+	for i := 0; allocated < total; i = (i + 1) % len(items) {
+		if r.Covers(items[i]) && discounts[i] < items[i].Price {
+			discounts[i]++
+			allocated++
+		}
+	}
+	return total, discounts, nil
+}
@@ -0,0 +1,54 @@
+package coupon
+
+import (
+	"testing"
+
+	"mk-api/server/util/money"
+)
+
+func sum(a []money.Fen) (s money.Fen) {
+	for _, v := range a {
+		s += v
+	}
+	return
+}
+
+func TestApply(t *testing.T) {
+	items := []*Item{
+		{PackageId: 1, HospitalId: 10, Price: 10000},
+		{PackageId: 2, HospitalId: 10, Price: 20000},
+		{PackageId: 3, HospitalId: 20, Price: 33300},
+	}
+	cases := []struct {
+		name  string
+		rule  Rule
+		total money.Fen
+		err   bool
+	}{
+		{"fixed", Rule{Type: TypeFixed, Amount: 5000}, 5000, false},
+		{"threshold not reached", Rule{Type: TypeFixed, Amount: 5000, Threshold: 70000}, 0, true},
+		{"percent", Rule{Type: TypePercent, Rate: 10}, 6330, false},
+		{"percent capped", Rule{Type: TypePercent, Rate: 10, MaxDiscount: 3000}, 3000, false},
+		{"hospital scope", Rule{Type: TypePercent, Rate: 10, ScopeType: ScopeHospital, ScopeId: 10}, 3000, false},
+		{"package scope missing", Rule{Type: TypeFixed, Amount: 100, ScopeType: ScopePackage, ScopeId: 9}, 0, true},
+		{"leave one fen", Rule{Type: TypeFixed, Amount: 100000}, 63299, false},
+	}
+	for _, tc := range cases {
+		total, discounts, err := tc.rule.Apply(items)
+		if (err != nil) != tc.err {
+			t.Errorf("%s: unexpected err %v", tc.name, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if total != tc.total || sum(discounts) != total {
+			t.Errorf("%s: expect total %d, got %d, discounts %v", tc.name, tc.total, total, discounts)
+		}
+		for i, d := range discounts {
+			if d > items[i].Price || (d > 0 && !tc.rule.Covers(items[i])) {
+				t.Errorf("%s: bad discount %d on item %d", tc.name, d, i)
+			}
+		}
+	}
+}
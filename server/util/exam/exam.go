@@ -0,0 +1,106 @@
+// Package exam 结构化体检结果的异常标记和多次体检的指标趋势
+package exam
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 指标的异常标记
+const (
+	FlagNormal   int8 = 0 // 正常
+	FlagHigh     int8 = 1 // 偏高
+	FlagLow      int8 = 2 // 偏低
+	FlagAbnormal int8 = 3 // 异常, 用于阴性/阳性等非数值结果
+)
+
+// 指标相比上一次体检的变化
+const (
+	TrendUnknown int8 = 0 // 无法比较, 结果不是数值或单位不一致
+	TrendUp      int8 = 1 // 上升
+	TrendDown    int8 = 2 // 下降
+	TrendFlat    int8 = 3 // 持平
+)
+
+// Record 一次体检中一个指标的结果
+type Record struct {
+	OrderItemId int64 `json:"order_item_id" db:"order_item_id"`
+	// 体检日期
+	ExamineDate int64 `json:"examine_date" db:"examine_date"`
+	// 体检项目名称, 对应套餐的项目 mkp_package_attribute.name
+	ItemName string `json:"item_name" db:"item_name"`
+	// 项目下的指标名称, 如血常规下的白细胞计数
+	Indicator string `json:"indicator" db:"indicator"`
+	// 结果值, 数值或阴性/阳性等文字
+	Value    string `json:"value" db:"value"`
+	Unit     string `json:"unit" db:"unit"`
+	RefRange string `json:"ref_range" db:"ref_range"`
+	// 0-正常 1-偏高 2-偏低 3-异常
+	Abnormal int8 `json:"abnormal" db:"abnormal"`
+}
+
+// Series 同一指标在多次体检中的结果, 按体检日期升序
+type Series struct {
+	ItemName  string `json:"item_name"`
+	Indicator string `json:"indicator"`
+	Unit      string `json:"unit"`
+	// 最近一次相比上一次的变化 0-无法比较 1-上升 2-下降 3-持平
+	Trend  int8      `json:"trend"`
+	Points []*Record `json:"points"`
+}
+
+// Numeric 结果值是否为数值
+func Numeric(value string) (float64, bool) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	return f, err == nil
+}
+
+// BuildSeries 把结果按项目和指标分组, 只返回在两次及以上体检中出现的指标, 按首次出现的顺序排列
+func BuildSeries(records []*Record) []*Series {
+	type key struct{ item, indicator string }
+	groups := make(map[key]*Series)
+	keys := make([]key, 0, len(records))
+	for _, r := range records {
+		k := key{r.ItemName, r.Indicator}
+		s, ok := groups[k]
+		if !ok {
+			s = &Series{ItemName: r.ItemName, Indicator: r.Indicator}
+			groups[k] = s
+			keys = append(keys, k)
+		}
+		s.Points = append(s.Points, r)
+	}
+
+	output := make([]*Series, 0, len(keys))
+	for _, k := range keys {
+		s := groups[k]
+		if len(s.Points) < 2 {
+			continue
+		}
+		sort.SliceStable(s.Points, func(i, j int) bool { return s.Points[i].ExamineDate < s.Points[j].ExamineDate })
+		last := s.Points[len(s.Points)-1]
+		s.Unit = last.Unit
+		s.Trend = compare(s.Points[len(s.Points)-2], last)
+		output = append(output, s)
+	}
+	return output
+}
+
+func compare(prev, cur *Record) int8 {
+	if prev.Unit != cur.Unit {
+		return TrendUnknown
+	}
+	p, ok1 := Numeric(prev.Value)
+	c, ok2 := Numeric(cur.Value)
+	if !ok1 || !ok2 {
+		return TrendUnknown
+	}
+	switch {
+	case c > p:
+		return TrendUp
+	case c < p:
+		return TrendDown
+	}
+	return TrendFlat
+}
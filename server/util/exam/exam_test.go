@@ -0,0 +1,53 @@
+package exam
+
+import "testing"
+
+func TestBuildSeries(t *testing.T) {
+	records := []*Record{
+		{OrderItemId: 2, ExamineDate: 200, ItemName: "血常规", Indicator: "白细胞计数", Value: "6.1", Unit: "10^9/L"},
+		{OrderItemId: 2, ExamineDate: 200, ItemName: "尿常规", Indicator: "尿蛋白", Value: "阴性"},
+		{OrderItemId: 2, ExamineDate: 200, ItemName: "肝功能", Indicator: "谷丙转氨酶", Value: "30", Unit: "U/L"},
+		{OrderItemId: 1, ExamineDate: 100, ItemName: "血常规", Indicator: "白细胞计数", Value: "5.2", Unit: "10^9/L"},
+		{OrderItemId: 1, ExamineDate: 100, ItemName: "尿常规", Indicator: "尿蛋白", Value: "阳性", Abnormal: FlagAbnormal},
+		{OrderItemId: 3, ExamineDate: 300, ItemName: "血常规", Indicator: "白细胞计数", Value: "6.1", Unit: "10^9/L"},
+		{OrderItemId: 3, ExamineDate: 300, ItemName: "血常规", Indicator: "血红蛋白", Value: "150", Unit: "g/L"},
+	}
+	series := BuildSeries(records)
+	if len(series) != 2 {
+		t.Fatalf("got %d series, want 2", len(series))
+	}
+
+	wbc := series[0]
+	if wbc.Indicator != "白细胞计数" || len(wbc.Points) != 3 {
+		t.Fatalf("unexpected first series %+v", wbc)
+	}
+	for i, date := range []int64{100, 200, 300} {
+		if wbc.Points[i].ExamineDate != date {
+			t.Errorf("point %d has date %d, want %d", i, wbc.Points[i].ExamineDate, date)
+		}
+	}
+	if wbc.Trend != TrendFlat {
+		t.Errorf("white blood cell trend got %d, want %d", wbc.Trend, TrendFlat)
+	}
+	if series[1].Indicator != "尿蛋白" || series[1].Trend != TrendUnknown {
+		t.Errorf("unexpected second series %+v", series[1])
+	}
+}
+
+func TestCompare(t *testing.T) {
+	cases := []struct {
+		prev, cur *Record
+		want      int8
+	}{
+		{&Record{Value: "5.2", Unit: "mmol/L"}, &Record{Value: "6.0", Unit: "mmol/L"}, TrendUp},
+		{&Record{Value: "6.0", Unit: "mmol/L"}, &Record{Value: " 5.2 ", Unit: "mmol/L"}, TrendDown},
+		{&Record{Value: "5.2", Unit: "mmol/L"}, &Record{Value: "5.2", Unit: "mmol/L"}, TrendFlat},
+		{&Record{Value: "5.2", Unit: "mmol/L"}, &Record{Value: "94", Unit: "mg/dL"}, TrendUnknown},
+		{&Record{Value: "阴性"}, &Record{Value: "阳性"}, TrendUnknown},
+	}
+	for _, c := range cases {
+		if got := compare(c.prev, c.cur); got != c.want {
+			t.Errorf("compare(%s, %s) got %d, want %d", c.prev.Value, c.cur.Value, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Package money 金额统一用以分为单位的整数表示, 数据库读写、JSON 和运算都不经过浮点数,
+// 只在推送消息等展示场景格式化为元
+package money
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Fen 金额, 单位分. JSON 中为整数分
+type Fen int64
+
+// Yuan 格式化为元, 保留两位小数, 如 12345 -> "123.45"
+func (f Fen) Yuan() string {
+	sign, v := "", int64(f)
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/100, v%100)
+}
+
+// Value 以整数分写入数据库
+func (f Fen) Value() (driver.Value, error) {
+	return int64(f), nil
+}
+
+// Scan 兼容整数列和历史的 decimal 列, decimal 列存的也是分, 小数部分必须为 0
+func (f *Fen) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*f = 0
+	case int64:
+		*f = Fen(v)
+	case float64:
+		*f = Fen(math.Round(v))
+	case []byte:
+		return f.parse(string(v))
+	case string:
+		return f.parse(v)
+	default:
+		return fmt.Errorf("money: cannot scan %T into Fen", src)
+	}
+	return nil
+}
+
+func (f *Fen) parse(s string) error {
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		if strings.Trim(s[i+1:], "0") != "" {
+			return fmt.Errorf("money: %q is not a whole number of fen", s)
+		}
+		s = s[:i]
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("money: %w", err)
+	}
+	*f = Fen(v)
+	return nil
+}
+
+// ParseYuan 解析以元为单位、最多两位小数的金额, 如 "123.45" -> 12345
+func ParseYuan(s string) (Fen, error) {
+	s = strings.TrimSpace(s)
+	sign := int64(1)
+	if strings.HasPrefix(s, "-") {
+		sign, s = -1, s[1:]
+	}
+	intPart, fracPart := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, fracPart = s[:i], s[i+1:]
+	}
+	if intPart == "" || len(fracPart) > 2 || strings.ContainsAny(intPart, "+-") {
+		return 0, fmt.Errorf("money: %q is not a valid yuan amount", s)
+	}
+	yuan, err := strconv.ParseInt(intPart, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("money: %w", err)
+	}
+	var fen int64
+	if fracPart != "" {
+		if fen, err = strconv.ParseInt(fracPart+strings.Repeat("0", 2-len(fracPart)), 10, 64); err != nil || fen < 0 {
+			return 0, fmt.Errorf("money: %q is not a valid yuan amount", s)
+		}
+	}
+	return Fen(sign * (yuan*100 + fen)), nil
+}
@@ -0,0 +1,84 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestYuan(t *testing.T) {
+	cases := map[Fen]string{
+		0:      "0.00",
+		5:      "0.05",
+		12345:  "123.45",
+		39900:  "399.00",
+		-1050:  "-10.50",
+		100001: "1000.01",
+	}
+	for f, want := range cases {
+		if got := f.Yuan(); got != want {
+			t.Errorf("Fen(%d).Yuan() = %q, want %q", f, got, want)
+		}
+	}
+}
+
+func TestParseYuan(t *testing.T) {
+	cases := map[string]Fen{
+		"0":       0,
+		"0.05":    5,
+		"0.5":     50,
+		"123.45":  12345,
+		"399.00":  39900,
+		"-10.50":  -1050,
+		"1000.01": 100001,
+	}
+	for s, want := range cases {
+		if got, err := ParseYuan(s); err != nil || got != want {
+			t.Errorf("ParseYuan(%q) = %d, %v, want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "1.234", "--1", "1.-5", "abc", ".5"} {
+		if _, err := ParseYuan(s); err == nil {
+			t.Errorf("ParseYuan(%q) should fail", s)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	cases := []struct {
+		src  interface{}
+		want Fen
+		err  bool
+	}{
+		{nil, 0, false},
+		{int64(39900), 39900, false},
+		{[]byte("39900.00"), 39900, false},
+		{[]byte("39900"), 39900, false},
+		{"-120.0", -120, false},
+		{[]byte("39900.50"), 0, true},
+		{[]byte("abc"), 0, true},
+		{true, 0, true},
+	}
+	for _, c := range cases {
+		var f Fen
+		err := f.Scan(c.src)
+		if (err != nil) != c.err || (err == nil && f != c.want) {
+			t.Errorf("Scan(%v) = %d, %v, want %d, err %v", c.src, f, err, c.want, c.err)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var v struct {
+		Amount Fen `json:"amount"`
+	}
+	if err := json.Unmarshal([]byte(`{"amount":39900}`), &v); err != nil || v.Amount != 39900 {
+		t.Fatalf("unmarshal: %d, %v", v.Amount, err)
+	}
+	if err := json.Unmarshal([]byte(`{"amount":399.5}`), &v); err == nil {
+		t.Error("fractional fen should be rejected")
+	}
+	b, _ := json.Marshal(v)
+	if string(b) != `{"amount":39900}` {
+		t.Errorf("marshal: %s", b)
+	}
+}
@@ -0,0 +1,55 @@
+// Package rbac 运营人员的角色和权限, 角色存在 mka_staff.role, 权限由角色决定
+package rbac
+
+type Role int8
+
+const (
+	RoleCustomerService Role = 1 // 客服
+	RoleFinance         Role = 2 // 财务
+	RoleHospitalLiaison Role = 3 // 机构对接人, 只能处理所对接机构的订单
+	RoleSuperAdmin      Role = 4 // 超级管理员, 拥有全部权限
+)
+
+type Permission string
+
+const (
+	OrderView          Permission = "order:view"          // 查看订单列表和详情
+	OrderNote          Permission = "order:note"          // 添加订单内部备注
+	AppointmentConfirm Permission = "appointment:confirm" // 确认体检预约
+	RefundAudit        Permission = "refund:audit"        // 审核退款申请
+	AuditView          Permission = "audit:view"          // 查看审计日志, 只有超级管理员拥有
+	CouponManage       Permission = "coupon:manage"       // 创建优惠券, 只有超级管理员拥有
+	CardManage         Permission = "card:manage"         // 创建、作废、导出体检卡, 只有超级管理员拥有
+	InvoiceIssue       Permission = "invoice:issue"       // 开具或驳回发票申请
+	ReviewModerate     Permission = "review:moderate"     // 审核套餐评价
+	ReportUpload       Permission = "report:upload"       // 上传和删除体检报告, 录入结构化体检结果
+	BillReconcile      Permission = "bill:reconcile"      // 查看微信支付对账报告
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleCustomerService: {OrderView, OrderNote, AppointmentConfirm, ReviewModerate, ReportUpload},
+	RoleFinance:         {OrderView, OrderNote, RefundAudit, InvoiceIssue, BillReconcile},
+	RoleHospitalLiaison: {OrderView, OrderNote, AppointmentConfirm, ReportUpload},
+}
+
+func (r Role) Valid() bool {
+	return r >= RoleCustomerService && r <= RoleSuperAdmin
+}
+
+// Can 角色是否拥有权限 p
+func (r Role) Can(p Permission) bool {
+	if r == RoleSuperAdmin {
+		return true
+	}
+	for _, perm := range rolePermissions[r] {
+		if perm == p {
+			return true
+		}
+	}
+	return false
+}
+
+// HospitalScoped 角色是否只能处理所属体检机构的订单
+func (r Role) HospitalScoped() bool {
+	return r == RoleHospitalLiaison
+}
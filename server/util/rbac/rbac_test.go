@@ -0,0 +1,32 @@
+package rbac
+
+import "testing"
+
+func TestCan(t *testing.T) {
+	cases := []struct {
+		role Role
+		perm Permission
+		can  bool
+	}{
+		{RoleFinance, RefundAudit, true},
+		{RoleCustomerService, RefundAudit, false},
+		{RoleHospitalLiaison, RefundAudit, false},
+		{RoleSuperAdmin, RefundAudit, true},
+		{RoleHospitalLiaison, AppointmentConfirm, true},
+		{RoleFinance, AppointmentConfirm, false},
+		{RoleFinance, InvoiceIssue, true},
+		{RoleCustomerService, InvoiceIssue, false},
+		{RoleCustomerService, ReviewModerate, true},
+		{RoleFinance, ReviewModerate, false},
+		{RoleHospitalLiaison, ReportUpload, true},
+		{RoleFinance, ReportUpload, false},
+		{RoleFinance, BillReconcile, true},
+		{RoleCustomerService, BillReconcile, false},
+		{Role(0), OrderView, false},
+	}
+	for _, tc := range cases {
+		if got := tc.role.Can(tc.perm); got != tc.can {
+			t.Errorf("role %d perm %s: expect %v, got %v", tc.role, tc.perm, tc.can, got)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+// Package reconcile 微信支付对账单与本地流水 mkb_trade_bill 的核对
+package reconcile
+
+import (
+	"mk-api/server/util/money"
+	"mk-api/server/util/wxpay"
+)
+
+// 差异类型
+const (
+	MissingLocal   int8 = 1 // 对账单中有, 本地没有
+	MissingRemote  int8 = 2 // 本地有, 对账单中没有
+	AmountMismatch int8 = 3 // 金额不一致
+	StatusMismatch int8 = 4 // 状态不一致
+)
+
+// 流水类型, 与 mkb_trade_bill.fee_type 一致
+const (
+	FeePay    int8 = 1 // 收款
+	FeeRefund int8 = 2 // 退款
+)
+
+// 流水状态, 与 mkb_trade_bill.status 一致
+const (
+	StatusProcessing int8 = 0
+	StatusSuccess    int8 = 2
+	StatusClosed     int8 = 4
+)
+
+// LocalBill 本地流水, 收款流水金额为订单金额, 退款流水金额为申请退款金额
+type LocalBill struct {
+	FeeType       int8
+	OutTradeNo    string
+	TransactionId string
+	OutRefundNo   string
+	RefundId      string
+	TotalFee      money.Fen
+	Status        int8
+	// 支付或退款完成时间
+	TimeEnd    int64
+	CreateTime int64
+}
+
+// Diff 一条差异, 只有一方时另一方的金额为 0、状态为空
+type Diff struct {
+	Kind          int8
+	FeeType       int8
+	OutTradeNo    string
+	TransactionId string
+	OutRefundNo   string
+	LocalFee      money.Fen
+	RemoteFee     money.Fen
+	LocalStatus   string
+	RemoteStatus  string
+}
+
+type Result struct {
+	// 两边一致的交易数
+	Matched int
+	Diffs   []*Diff
+}
+
+// Match 核对 [start, end) 这一天的对账单和本地流水。 locals 除当天完成的流水外, 还应包含对账单中出现的订单的流水,
+// 不在当天完成的流水只在对账单中有对应记录时参与核对。
+// 收款按商户订单号匹配, 其次按微信订单号; 退款按商户退款单号匹配, 其次按微信退款单号
+func Match(rows []*wxpay.BillRow, locals []*LocalBill, start, end int64) *Result {
+	var (
+		result  = &Result{}
+		matched = make(map[*LocalBill]bool)
+		pays    = newIndex()
+		refunds = newIndex()
+	)
+	for _, b := range locals {
+		if b.FeeType == FeeRefund {
+			refunds.add(b.OutRefundNo, b.RefundId, b)
+		} else {
+			pays.add(b.OutTradeNo, b.TransactionId, b)
+		}
+	}
+
+	for _, row := range rows {
+		var (
+			local  *LocalBill
+			diff   = &Diff{OutTradeNo: row.OutTradeNo, TransactionId: row.TransactionID}
+			status int8
+		)
+		if row.TradeState == wxpay.TradeStateRefund {
+			local = refunds.find(row.OutRefundNo, row.RefundID)
+			diff.FeeType, diff.OutRefundNo = FeeRefund, row.OutRefundNo
+			diff.RemoteFee, diff.RemoteStatus, status = row.RefundFee, row.RefundStatus, refundStatus(row.RefundStatus)
+		} else {
+			local = pays.find(row.OutTradeNo, row.TransactionID)
+			diff.FeeType = FeePay
+			diff.RemoteFee, diff.RemoteStatus, status = row.TotalFee, row.TradeState, tradeStatus(row.TradeState)
+		}
+		if local == nil || matched[local] {
+			diff.Kind = MissingLocal
+			result.Diffs = append(result.Diffs, diff)
+			continue
+		}
+		matched[local] = true
+		diff.LocalFee, diff.LocalStatus = local.TotalFee, statusName(local.Status)
+		switch {
+		case local.Status != status:
+			diff.Kind = StatusMismatch
+		case local.TotalFee != diff.RemoteFee:
+			diff.Kind = AmountMismatch
+		default:
+			result.Matched++
+			continue
+		}
+		result.Diffs = append(result.Diffs, diff)
+	}
+
+	for _, b := range locals {
+		if matched[b] || !expected(b, start, end) {
+			continue
+		}
+		result.Diffs = append(result.Diffs, &Diff{
+			Kind:          MissingRemote,
+			FeeType:       b.FeeType,
+			OutTradeNo:    b.OutTradeNo,
+			TransactionId: b.TransactionId,
+			OutRefundNo:   b.OutRefundNo,
+			LocalFee:      b.TotalFee,
+			LocalStatus:   statusName(b.Status),
+		})
+	}
+	return result
+}
+
+// expected 本地流水是否应出现在当天的对账单中: 当天支付成功的收款, 以及当天发起且未被微信拒绝的退款
+func expected(b *LocalBill, start, end int64) bool {
+	if b.FeeType == FeeRefund {
+		return b.Status != StatusClosed && b.CreateTime >= start && b.CreateTime < end
+	}
+	return b.Status == StatusSuccess && b.TimeEnd >= start && b.TimeEnd < end
+}
+
+// index 按商户单号和微信单号索引流水
+type index struct {
+	byNo map[string]*LocalBill
+	byId map[string]*LocalBill
+}
+
+func newIndex() *index {
+	return &index{byNo: make(map[string]*LocalBill), byId: make(map[string]*LocalBill)}
+}
+
+func (idx *index) add(no, id string, b *LocalBill) {
+	if no != "" {
+		idx.byNo[no] = b
+	}
+	if id != "" {
+		idx.byId[id] = b
+	}
+}
+
+func (idx *index) find(no, id string) *LocalBill {
+	if b, ok := idx.byNo[no]; ok && no != "" {
+		return b
+	}
+	if b, ok := idx.byId[id]; ok && id != "" {
+		return b
+	}
+	return nil
+}
+
+func tradeStatus(state string) int8 {
+	if state == wxpay.TradeStateSuccess {
+		return StatusSuccess
+	}
+	return StatusClosed
+}
+
+func refundStatus(state string) int8 {
+	switch state {
+	case wxpay.RefundStatusSuccess:
+		return StatusSuccess
+	case wxpay.RefundStatusProcessing:
+		return StatusProcessing
+	}
+	return StatusClosed
+}
+
+func statusName(status int8) string {
+	switch status {
+	case StatusProcessing:
+		return "PROCESSING"
+	case StatusSuccess:
+		return "SUCCESS"
+	case StatusClosed:
+		return "CLOSED"
+	}
+	return ""
+}
@@ -0,0 +1,82 @@
+package reconcile
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"mk-api/server/util/wxpay"
+)
+
+// 2020-06-01 00:00:00 +0800
+const (
+	dayStart int64 = 1590940800
+	dayEnd         = dayStart + 86400
+)
+
+func TestMatch(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/bill_20200601.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bill, err := wxpay.ParseBill(data)
+	if err != nil {
+		t.Fatalf("parse bill failed: %v", err)
+	}
+	locals := []*LocalBill{
+		{FeeType: FeePay, OutTradeNo: "1001", TotalFee: 39900, Status: StatusSuccess, TimeEnd: dayStart + 3600},
+		// 回调丢失, 本地仍为待支付
+		{FeeType: FeePay, OutTradeNo: "1002", TotalFee: 128800, Status: StatusProcessing},
+		{FeeType: FeePay, OutTradeNo: "1003", TotalFee: 19800, Status: StatusSuccess, TimeEnd: dayStart + 7200},
+		// 对账单中没有
+		{FeeType: FeePay, OutTradeNo: "1005", TotalFee: 59900, Status: StatusSuccess, TimeEnd: dayStart + 9000},
+		// 前一天支付, 不在当天的对账单中
+		{FeeType: FeePay, OutTradeNo: "1006", TotalFee: 59900, Status: StatusSuccess, TimeEnd: dayStart - 60},
+		// 商户退款单号缺失时按微信退款单号匹配
+		{FeeType: FeeRefund, OutTradeNo: "999", RefundId: "50000000382020060100000002001", TotalFee: 35910,
+			Status: StatusSuccess, CreateTime: dayStart + 70000},
+		{FeeType: FeeRefund, OutTradeNo: "998", OutRefundNo: "2002", TotalFee: 10000, Status: StatusProcessing, CreateTime: dayStart + 100},
+	}
+
+	result := Match(bill.Rows, locals, dayStart, dayEnd)
+	if result.Matched != 2 {
+		t.Errorf("matched = %d, want 2", result.Matched)
+	}
+	want := []struct {
+		kind    int8
+		feeType int8
+		no      string
+	}{
+		{StatusMismatch, FeePay, "1002"},
+		{AmountMismatch, FeePay, "1003"},
+		{MissingLocal, FeePay, "1004"},
+		{MissingRemote, FeePay, "1005"},
+		{MissingRemote, FeeRefund, "998"},
+	}
+	if len(result.Diffs) != len(want) {
+		t.Fatalf("got %d diffs, want %d", len(result.Diffs), len(want))
+	}
+	for i, w := range want {
+		d := result.Diffs[i]
+		if d.Kind != w.kind || d.FeeType != w.feeType || d.OutTradeNo != w.no {
+			t.Errorf("diff %d = %+v, want kind %d fee_type %d out_trade_no %s", i, d, w.kind, w.feeType, w.no)
+		}
+	}
+	if d := result.Diffs[1]; d.LocalFee != 19800 || d.RemoteFee != 19900 {
+		t.Errorf("amount mismatch fee = %d/%d", d.LocalFee, d.RemoteFee)
+	}
+	if d := result.Diffs[0]; d.LocalStatus != "PROCESSING" || d.RemoteStatus != wxpay.TradeStateSuccess {
+		t.Errorf("status mismatch status = %s/%s", d.LocalStatus, d.RemoteStatus)
+	}
+}
+
+func TestMatchEmptyBill(t *testing.T) {
+	locals := []*LocalBill{
+		{FeeType: FeePay, OutTradeNo: "1001", TotalFee: 39900, Status: StatusSuccess, TimeEnd: dayStart + 3600},
+		// 微信拒绝的退款不会出现在对账单中
+		{FeeType: FeeRefund, OutTradeNo: "999", OutRefundNo: "2001", TotalFee: 100, Status: StatusClosed, CreateTime: dayStart + 60},
+	}
+	result := Match(nil, locals, dayStart, dayEnd)
+	if result.Matched != 0 || len(result.Diffs) != 1 || result.Diffs[0].Kind != MissingRemote {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
@@ -0,0 +1,119 @@
+// Package refund 根据改退规则计算订单的可退金额和各项费用, 金额单位均为分
+package refund
+
+import (
+	"time"
+
+	"mk-api/server/util/money"
+	"mk-api/server/util/xtime"
+)
+
+// Policy 改退规则, 由运营在 zk 的 /superconf/business/refund_policy 中配置
+type Policy struct {
+	// 付款后多少小时内退款免服务费, 0 表示没有免费期
+	GracePeriodHours int64 `json:"grace_period_hours"`
+	// 预约成功后退款扣除的服务费, 实付金额的百分比
+	ServiceFeeRate int64 `json:"service_fee_rate"`
+	// 每位体检人免费改期的次数
+	FreeReschedules int64 `json:"free_reschedules"`
+	// 超出免费次数后每次改期扣除的服务费, 实付金额的百分比, 在退款时一并扣除
+	RescheduleFeeRate int64 `json:"reschedule_fee_rate"`
+}
+
+// DefaultPolicy 与公众号 "关于退款" 自动回复中公布的规则一致
+var DefaultPolicy = Policy{
+	GracePeriodHours:  0,
+	ServiceFeeRate:    10,
+	FreeReschedules:   3,
+	RescheduleFeeRate: 10,
+}
+
+type Item struct {
+	OrderItemId int64
+	// 实付金额
+	Price money.Fen
+	// 体检日期, 时间戳
+	ExamineDate int64
+	// 已经改期的次数
+	RescheduleCount int64
+	// 体检机构是否已确认预约, 未确认的不收退款服务费
+	AppointmentConfirmed bool
+}
+
+type ItemFee struct {
+	OrderItemId   int64     `json:"order_item_id"`
+	Price         money.Fen `json:"price"`
+	ServiceFee    money.Fen `json:"service_fee"`
+	RescheduleFee money.Fen `json:"reschedule_fee"`
+	RefundAmount  money.Fen `json:"refund_amount"`
+	// 是否可以退款, 体检日期已到的不可退
+	Refundable bool `json:"refundable"`
+	// 费用说明
+	Remark string `json:"remark"`
+}
+
+type Result struct {
+	PaidAmount    money.Fen  `json:"paid_amount"`
+	ServiceFee    money.Fen  `json:"service_fee"`
+	RescheduleFee money.Fen  `json:"reschedule_fee"`
+	RefundAmount  money.Fen  `json:"refund_amount"`
+	Items         []*ItemFee `json:"items"`
+}
+
+// ChargeableReschedules 超出免费次数的改期次数
+func (p *Policy) ChargeableReschedules(rescheduleCount int64) int64 {
+	if rescheduleCount <= p.FreeReschedules {
+		return 0
+	}
+	return rescheduleCount - p.FreeReschedules
+}
+
+// Calc 计算订单在 now 时刻退款的费用明细, paidAt 为付款时间
+func (p *Policy) Calc(items []*Item, paidAt int64, now int64) *Result {
+	result := &Result{Items: make([]*ItemFee, 0, len(items))}
+	inGracePeriod := p.GracePeriodHours > 0 && now-paidAt < p.GracePeriodHours*int64(time.Hour/time.Second)
+
+	for _, item := range items {
+		fee := &ItemFee{OrderItemId: item.OrderItemId, Price: item.Price}
+		result.PaidAmount += item.Price
+		result.Items = append(result.Items, fee)
+
+		if item.ExamineDate != 0 && xtime.DayStartAt(item.ExamineDate) <= now {
+			fee.Remark = "体检日期已到, 不予退款"
+			continue
+		}
+		fee.Refundable = true
+
+		if !inGracePeriod && item.AppointmentConfirmed {
+			fee.ServiceFee = percent(item.Price, p.ServiceFeeRate)
+		}
+		fee.RescheduleFee = percent(item.Price, p.RescheduleFeeRate) * money.Fen(p.ChargeableReschedules(item.RescheduleCount))
+		if fee.ServiceFee+fee.RescheduleFee > item.Price {
+			fee.RescheduleFee = item.Price - fee.ServiceFee
+		}
+		fee.RefundAmount = item.Price - fee.ServiceFee - fee.RescheduleFee
+		switch {
+		case fee.ServiceFee > 0 && fee.RescheduleFee > 0:
+			fee.Remark = "扣除退款服务费和超次改期服务费"
+		case fee.ServiceFee > 0:
+			fee.Remark = "扣除退款服务费"
+		case fee.RescheduleFee > 0:
+			fee.Remark = "扣除超次改期服务费"
+		default:
+			fee.Remark = "全额退款"
+		}
+
+		result.ServiceFee += fee.ServiceFee
+		result.RescheduleFee += fee.RescheduleFee
+		result.RefundAmount += fee.RefundAmount
+	}
+	return result
+}
+
+// 按百分比计算费用, 四舍五入到分
+func percent(amount money.Fen, rate int64) money.Fen {
+	if rate <= 0 {
+		return 0
+	}
+	return (amount*money.Fen(rate) + 50) / 100
+}
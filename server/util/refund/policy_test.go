@@ -0,0 +1,78 @@
+package refund
+
+import (
+	"testing"
+	"time"
+)
+
+const day = int64(24 * time.Hour / time.Second)
+
+func TestCalc(t *testing.T) {
+	now := time.Date(2020, 8, 1, 10, 0, 0, 0, time.Local).Unix()
+	paidAt := now - day
+	p := DefaultPolicy
+
+	result := p.Calc([]*Item{
+		{OrderItemId: 1, Price: 39900, ExamineDate: now + 3*day, RescheduleCount: 0, AppointmentConfirmed: true},
+		{OrderItemId: 2, Price: 39900, ExamineDate: now + 3*day, RescheduleCount: 5, AppointmentConfirmed: true},
+		{OrderItemId: 3, Price: 39900, ExamineDate: now - 3600, AppointmentConfirmed: true},
+	}, paidAt, now)
+
+	if result.PaidAmount != 119700 {
+		t.Errorf("paid amount: %d", result.PaidAmount)
+	}
+	// 10% 服务费
+	if fee := result.Items[0]; !fee.Refundable || fee.ServiceFee != 3990 || fee.RefundAmount != 35910 {
+		t.Errorf("item 1: %+v", fee)
+	}
+	// 超出免费次数 2 次, 每次 10%
+	if fee := result.Items[1]; fee.ServiceFee != 3990 || fee.RescheduleFee != 7980 || fee.RefundAmount != 27930 {
+		t.Errorf("item 2: %+v", fee)
+	}
+	// 体检当天不可退
+	if fee := result.Items[2]; fee.Refundable || fee.RefundAmount != 0 {
+		t.Errorf("item 3: %+v", fee)
+	}
+	if result.RefundAmount != 35910+27930 || result.ServiceFee != 7980 || result.RescheduleFee != 7980 {
+		t.Errorf("result: %+v", result)
+	}
+}
+
+func TestCalcGracePeriod(t *testing.T) {
+	now := time.Date(2020, 8, 1, 10, 0, 0, 0, time.Local).Unix()
+	p := Policy{GracePeriodHours: 2, ServiceFeeRate: 10, FreeReschedules: 3, RescheduleFeeRate: 10}
+	items := []*Item{{OrderItemId: 1, Price: 10001, ExamineDate: now + 2*day, AppointmentConfirmed: true}}
+
+	if r := p.Calc(items, now-3600, now); r.RefundAmount != 10001 {
+		t.Errorf("refund within grace period should be free, got %+v", r)
+	}
+	if r := p.Calc(items, now-3*3600, now); r.ServiceFee != 1000 || r.RefundAmount != 9001 {
+		t.Errorf("refund after grace period should charge service fee, got %+v", r)
+	}
+}
+
+func TestCalcFeeNotExceedPrice(t *testing.T) {
+	now := time.Now().Unix()
+	p := Policy{ServiceFeeRate: 50, FreeReschedules: 0, RescheduleFeeRate: 40}
+	r := p.Calc([]*Item{{Price: 100, ExamineDate: now + 2*day, RescheduleCount: 3, AppointmentConfirmed: true}}, now, now)
+	if r.RefundAmount != 0 || r.ServiceFee+r.RescheduleFee != 100 {
+		t.Errorf("fee should never exceed price, got %+v", r.Items[0])
+	}
+}
+
+func TestCalcUnconfirmedAppointment(t *testing.T) {
+	now := time.Date(2020, 8, 1, 10, 0, 0, 0, time.Local).Unix()
+	p := DefaultPolicy
+
+	// 体检机构还没有确认预约, 过了免费期也不收退款服务费, 超次改期服务费照收
+	r := p.Calc([]*Item{
+		{OrderItemId: 1, Price: 39900, ExamineDate: now + 3*day},
+		{OrderItemId: 2, Price: 39900, ExamineDate: now + 3*day, RescheduleCount: 4},
+	}, now-day, now)
+	if fee := r.Items[0]; fee.ServiceFee != 0 || fee.RefundAmount != 39900 || fee.Remark != "全额退款" {
+		t.Errorf("item 1: %+v", fee)
+	}
+	if fee := r.Items[1]; fee.ServiceFee != 0 || fee.RescheduleFee != 3990 || fee.RefundAmount != 35910 {
+		t.Errorf("item 2: %+v", fee)
+	}
+}
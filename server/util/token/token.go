@@ -6,6 +6,7 @@ import (
 	"github.com/bwmarrin/snowflake"
 	"github.com/gomodule/redigo/redis"
 	"github.com/google/uuid"
+	"mk-api/server/dto"
 )
 
 // 设置 user_id_token.1232: token
@@ -40,3 +41,15 @@ func GenerateSnowflake() (snowflake.ID, error) {
 	}
 	return node.Generate(), nil
 }
+
+// 设置 hash.staff_token.xxx: {staff_id: id, name: name, role: role, hospital_id: id}, 运营后台使用
+// 角色只是登录时的快照, 每次请求由 middleware.StaffRequired 按数据库中的账号重新校验
+func SetStaffToken(token string, staff *dto.Staff, expireIn int64, cli redis.Conn) error {
+	staffTokenKey := "hash.staff_token." + token
+	_ = cli.Send("HSET", staffTokenKey, "staff_id", staff.Id)
+	_ = cli.Send("HSET", staffTokenKey, "name", staff.Name)
+	_ = cli.Send("HSET", staffTokenKey, "role", int8(staff.Role))
+	_ = cli.Send("HSET", staffTokenKey, "hospital_id", staff.HospitalId)
+	_ = cli.Send("EXPIRE", staffTokenKey, expireIn)
+	return cli.Flush()
+}
@@ -2,45 +2,31 @@ package wechat
 
 import (
 	"mk-api/server/conf"
-	"strconv"
-	"strings"
-	"time"
-
-	"github.com/silenceper/wechat/v2/pay/order"
-	"github.com/silenceper/wechat/v2/util"
+	"mk-api/server/static"
+	"mk-api/server/util/wxpay"
 )
 
-func Launch2ndPay(nonceStr string, prepayId string) (cfg *order.Config, err error) {
-	cfg = &order.Config{}
-	var (
-		buffer    strings.Builder
-		timestamp = strconv.FormatInt(time.Now().Unix(), 10)
-	)
-	const signType = "MD5"
+const (
+	defaultCertFile = "cert/mai_kang_123z_apiclient_cert.pem"
+	defaultKeyFile  = "cert/mai_kang_129y_apiclient_key.pem"
+)
 
-	buffer.WriteString("appId=")
-	buffer.WriteString(conf.C.WeChat.AppID)
-	buffer.WriteString("&nonceStr=")
-	buffer.WriteString(nonceStr)
-	buffer.WriteString("&package=")
-	buffer.WriteString("prepay_id=" + prepayId)
-	buffer.WriteString("&signType=")
-	buffer.WriteString(signType)
-	buffer.WriteString("&timeStamp=")
-	buffer.WriteString(timestamp)
-	buffer.WriteString("&key=")
-	buffer.WriteString(conf.C.WeChat.PayKey)
-	sign, err := util.CalculateSign(buffer.String(), signType, conf.C.WeChat.PayKey)
-	if err != nil {
-		return
+// 微信支付商户平台接口, 使用 zk 中的微信支付配置, 按 pay_api_version 选择 v2、v3 或假支付
+func NewPayGateway() (wxpay.Gateway, error) {
+	certFile, keyFile := conf.C.WeChat.PayCertFile, conf.C.WeChat.PayKeyFile
+	if certFile == "" || keyFile == "" {
+		certFile, keyFile = static.Path(defaultCertFile), static.Path(defaultKeyFile)
 	}
-
-	// 签名
-	cfg.PaySign = sign
-	cfg.NonceStr = nonceStr
-	cfg.Timestamp = timestamp
-	cfg.PrePayID = prepayId
-	cfg.SignType = signType
-	cfg.Package = "prepay_id=" + prepayId
-	return
+	return wxpay.New(&wxpay.Config{
+		Version:     conf.C.WeChat.PayApiVersion,
+		AppID:       conf.C.WeChat.AppID,
+		MchID:       conf.C.WeChat.PayMchID,
+		Key:         conf.C.WeChat.PayKey,
+		Gateway:     conf.C.WeChat.PayGateway,
+		CertFile:    certFile,
+		KeyFile:     keyFile,
+		APIv3Key:    conf.C.WeChat.PayApiV3Key,
+		SerialNo:    conf.C.WeChat.PaySerialNo,
+		FakeOutcome: conf.C.WeChat.PayFakeOutcome,
+	})
 }
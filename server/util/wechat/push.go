@@ -2,12 +2,13 @@ package wechat
 
 import (
 	"fmt"
-	"strconv"
 	"time"
 
 	"github.com/silenceper/wechat/v2/officialaccount/message"
+	"mk-api/server/conf"
 	"mk-api/server/dao"
 	"mk-api/server/util"
+	"mk-api/server/util/money"
 )
 
 const (
@@ -50,7 +51,7 @@ func RefundLaunchedNotifyStaff(openIds []string, outTradeNo string) {
 }
 
 // 下单成功后微信推送给员工 use the 2nd
-func OrderPaidNotifyStaff(openIds []string, outTradeNo string, amount float64, paidTime int64) {
+func OrderPaidNotifyStaff(openIds []string, outTradeNo string, amount money.Fen, paidTime int64) {
 	tmpl := dao.AffAcc.GetTemplate()
 	const tmplId = "102fXlDTbJTx_RqhdLNh7KVZNJJOfbWo2AiwwtuA9A4"
 
@@ -71,7 +72,7 @@ func OrderPaidNotifyStaff(openIds []string, outTradeNo string, amount float64, p
 					Color: blue,
 				},
 				"keyword2": { // 实付金额
-					Value: strconv.FormatFloat(amount, 'f', -1, 64) + " 元",
+					Value: amount.Yuan() + " 元",
 					Color: orange,
 				},
 				"keyword3": { // 下单时间
@@ -91,7 +92,7 @@ func OrderPaidNotifyStaff(openIds []string, outTradeNo string, amount float64, p
 }
 
 // 付款成功后推送给客户 give up the 3rd , use the 6th
-func OrderPaidNotifyClient(openId, outTradeNo string, amount float64, orderId, paidTime int64) {
+func OrderPaidNotifyClient(openId, outTradeNo string, amount money.Fen, orderId, paidTime int64) {
 	tmpl := dao.AffAcc.GetTemplate()
 	const tmplId = "jIWVI8mZj7C_v_PscxgHB1MslRApfe_yE0q1ScXQgZ0"
 	orderTimeStr := time.Unix(paidTime, 0).Format("2006年01月02日 15:04:05")
@@ -119,7 +120,7 @@ func OrderPaidNotifyClient(openId, outTradeNo string, amount float64, orderId, p
 				Color: "",
 			},
 			"keyword4": { // 订单金额
-				Value: strconv.FormatFloat(amount, 'f', 2, 64) + " 元",
+				Value: amount.Yuan() + " 元",
 				Color: "",
 			},
 			"remark": {
@@ -177,8 +178,8 @@ func AppointmentMadeNotifyClient(openId string, examTime string, examCenterName
 	}
 }
 
-// 人工审核退款通过后， 推送给客户， admin用
-func RefundAgreedNotifyClient(openId, outTradeNo string, amount float64) {
+// 退款成功后， 推送给客户
+func RefundAgreedNotifyClient(openId, outTradeNo string, amount money.Fen) {
 	tmpl := dao.AffAcc.GetTemplate()
 	const tmplId = "De7WxIRy_ke0PiadqQjcUpIpHo1GQCa9gNVyr7zCp9A"
 	msg := &message.TemplateMessage{
@@ -200,7 +201,7 @@ func RefundAgreedNotifyClient(openId, outTradeNo string, amount float64) {
 				Color: "",
 			},
 			"keyword3": { // 退款金额
-				Value: "现金" + strconv.FormatFloat(amount, 'f', -1, 64) + "元",
+				Value: "现金" + amount.Yuan() + "元",
 				Color: blue,
 			},
 			"remark": { // 备注
@@ -213,3 +214,84 @@ func RefundAgreedNotifyClient(openId, outTradeNo string, amount float64) {
 		util.Log.Warningf("failed to send msg to %s, err: [%s]", openId, err.Error())
 	}
 }
+
+// 发票开具后推送给客户, 模板 id 取自配置, 未配置时不推送, admin用
+func InvoiceIssuedNotifyClient(openId, outTradeNo, title string, amount money.Fen, email string) {
+	tmplId := conf.C.WeChat.InvoiceIssuedTmplId
+	if tmplId == "" {
+		return
+	}
+	tmpl := dao.AffAcc.GetTemplate()
+	msg := &message.TemplateMessage{
+		ToUser:     openId,
+		TemplateID: tmplId,
+		URL:        "",
+		Color:      "",
+		Data: map[string]*message.TemplateDataItem{
+			"first": {
+				Value: "您申请的电子发票已开具",
+				Color: "",
+			},
+			"keyword1": { // 订单编号
+				Value: outTradeNo,
+				Color: blue,
+			},
+			"keyword2": { // 发票抬头
+				Value: title,
+				Color: "",
+			},
+			"keyword3": { // 开票金额
+				Value: amount.Yuan() + " 元",
+				Color: orange,
+			},
+			"remark": {
+				Value: fmt.Sprintf("电子发票将发送至 %s, 也可在“我的发票”中下载", email),
+				Color: "",
+			},
+		},
+	}
+	if _, err := tmpl.Send(msg); err != nil {
+		util.Log.Warningf("failed to send msg to %s, err: [%s]", openId, err.Error())
+	}
+}
+
+// 体检报告上传后推送给客户, 模板 id 取自配置, 未配置时不推送, admin用
+func ReportReadyNotifyClient(openId, examineeName, pkgName string, orderId int64) {
+	tmplId := conf.C.WeChat.ReportReadyTmplId
+	if tmplId == "" {
+		return
+	}
+	tmpl := dao.AffAcc.GetTemplate()
+	url := fmt.Sprintf("https://www.mkhealth.club/#/orderDetail?orderNum=%d&state=2", orderId)
+	msg := &message.TemplateMessage{
+		ToUser:     openId,
+		TemplateID: tmplId,
+		URL:        url,
+		Color:      "",
+		Data: map[string]*message.TemplateDataItem{
+			"first": {
+				Value: "您好，您的体检报告已出具",
+				Color: "",
+			},
+			"keyword1": { // 体检人
+				Value: examineeName,
+				Color: blue,
+			},
+			"keyword2": { // 体检套餐
+				Value: pkgName,
+				Color: "",
+			},
+			"keyword3": { // 出具时间
+				Value: time.Now().Format("2006年01月02日 15:04:05"),
+				Color: "",
+			},
+			"remark": {
+				Value: "请在订单详情中查看报告，如有疑问请拨打客服热线0668-2853837。祝您身体健康，幸福美满！",
+				Color: orange,
+			},
+		},
+	}
+	if _, err := tmpl.Send(msg); err != nil {
+		util.Log.Warningf("failed to send msg to %s, err: [%s]", openId, err.Error())
+	}
+}
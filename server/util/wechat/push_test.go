@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"mk-api/server/conf"
+	"mk-api/server/util/money"
 )
 
 func TestPush(t *testing.T) {
@@ -23,7 +24,7 @@ func TestPush(t *testing.T) {
 
 func testOrderPaidNotifyStaff(t *testing.T) {
 	openIds := []string{"oDvnPw4zKAmraE2eccSUHinSya5E"}
-	var amount float64 = 56.89
+	var amount money.Fen = 5689
 	OrderPaidNotifyStaff(openIds, "12345676", amount, time.Now().Unix())
 }
 
@@ -32,7 +33,7 @@ func testOrderPaidNotifyClient(t *testing.T) {
 	openId := "oDvnPw4zKAmraE2eccSUHinSya5E"
 	outTradeNo := "2112465451521"
 	// orderTime := "2020年07月28日 19:21:21"
-	var amount float64 = 99.99
+	var amount money.Fen = 9999
 	var orderId int64 = 25
 	OrderPaidNotifyClient(openId, outTradeNo, amount, orderId, time.Now().Unix())
 
@@ -54,5 +55,5 @@ func TestRefundLaunchedNotifyStaff(t *testing.T) {
 }
 
 func TestRefundAgreedNotifyClient(t *testing.T) {
-	RefundAgreedNotifyClient("oDvnPw4zKAmraE2eccSUHinSya5E", "7978789978", 98.65)
+	RefundAgreedNotifyClient("oDvnPw4zKAmraE2eccSUHinSya5E", "7978789978", 9865)
 }
@@ -0,0 +1,199 @@
+package wxpay
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"mk-api/server/util/money"
+)
+
+// ErrNoBill 当天没有交易, 微信不生成对账单
+var ErrNoBill = errors.New("no bill exist")
+
+// BillRow 对账单中的一笔交易, 退款在对账单中单独一行, TradeState 为 REFUND
+type BillRow struct {
+	// 交易时间, 格式 yyyy-MM-dd HH:mm:ss
+	TradeTime     string
+	TransactionID string
+	OutTradeNo    string
+	TradeState    string
+	// 订单金额, 含代金券
+	TotalFee     money.Fen
+	RefundID     string
+	OutRefundNo  string
+	RefundFee    money.Fen
+	RefundStatus string
+}
+
+// BillSummary 对账单末尾的汇总
+type BillSummary struct {
+	TradeCount int
+	TotalFee   money.Fen
+	RefundFee  money.Fen
+}
+
+// 金额列在新旧版本对账单中的名称, 优先取新版本中含代金券的订单金额和申请退款金额
+var (
+	colTotalFee        = []string{"订单金额", "应结订单金额", "总金额"}
+	colRefundFee       = []string{"申请退款金额", "退款金额"}
+	colSummaryTotalFee = []string{"订单总金额", "应结订单总金额", "总交易额"}
+	colSummaryRefund   = []string{"申请退款总金额", "退款总金额", "总退款金额"}
+)
+
+type Bill struct {
+	Rows    []*BillRow
+	Summary BillSummary
+}
+
+// DownloadBill 下载 date(yyyyMMdd) 当天的全部交易对账单, 返回原始 csv。 doc: https://pay.weixin.qq.com/wiki/doc/api/jsapi.php?chapter=9_6
+func (c *Client) DownloadBill(date string) ([]byte, error) {
+	raw, err := c.do(c.HTTPClient, "/pay/downloadbill", Params{"bill_date": date, "bill_type": "ALL"})
+	if err != nil {
+		return nil, err
+	}
+	// 成功时返回文本, 失败时返回 xml
+	if !bytes.HasPrefix(bytes.TrimSpace(raw), []byte("<xml>")) {
+		return raw, nil
+	}
+	ret, err := ParseParams(raw)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal response failed, raw: [%s], err: [%s]", raw, err.Error())
+	}
+	if ret["return_msg"] == "No Bill Exist" {
+		return nil, ErrNoBill
+	}
+	return nil, fmt.Errorf("return_code: [%s], return_msg: [%s]", ret["return_code"], ret["return_msg"])
+}
+
+// ParseBillDate 解析对账单日期 yyyyMMdd, 返回当天零点
+func ParseBillDate(date string) (time.Time, error) {
+	return time.ParseInLocation("20060102", date, beijing)
+}
+
+// ParseBill 解析全部交易对账单。 对账单第一行为表头, 之后每行一笔交易, 最后两行为汇总的表头和数据,
+// 每个字段前有一个 ` 字符。 按表头名称取列, 兼容新旧版本对账单的列名差异
+func ParseBill(data []byte) (*Bill, error) {
+	lines := strings.Split(strings.TrimPrefix(string(data), "\ufeff"), "\n")
+	var records [][]string
+	for _, line := range lines {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			records = append(records, strings.Split(line, ","))
+		}
+	}
+	if len(records) < 3 {
+		return nil, errors.New("bill is incomplete")
+	}
+
+	header := columns(records[0])
+	for _, names := range [][]string{{"交易时间"}, {"微信订单号"}, {"商户订单号"}, {"交易状态"}, colTotalFee} {
+		if !hasColumn(header, names) {
+			return nil, fmt.Errorf("bill column [%s] not found", strings.Join(names, "/"))
+		}
+	}
+	n := len(records) - 2
+	bill := &Bill{Rows: make([]*BillRow, 0, n)}
+	for i, record := range records[1:n] {
+		row, err := parseBillRow(header, record)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", i+2, err.Error())
+		}
+		bill.Rows = append(bill.Rows, row)
+	}
+
+	summary, err := parseBillSummary(columns(records[n]), records[n+1])
+	if err != nil {
+		return nil, fmt.Errorf("summary: %s", err.Error())
+	}
+	// 行数对不上说明对账单不完整
+	if summary.TradeCount != len(bill.Rows) {
+		return nil, fmt.Errorf("bill has %d rows, but summary says %d", len(bill.Rows), summary.TradeCount)
+	}
+	bill.Summary = *summary
+	return bill, nil
+}
+
+// columns 表头名称到列序号
+func columns(header []string) map[string]int {
+	m := make(map[string]int, len(header))
+	for i, name := range header {
+		m[strings.TrimSpace(name)] = i
+	}
+	return m
+}
+
+func hasColumn(header map[string]int, names []string) bool {
+	for _, name := range names {
+		if _, ok := header[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// field 取列的值并去掉前缀 `, 没有该列时返回空串
+func field(header map[string]int, record []string, names ...string) string {
+	for _, name := range names {
+		if i, ok := header[name]; ok && i < len(record) {
+			return strings.TrimPrefix(strings.TrimSpace(record[i]), "`")
+		}
+	}
+	return ""
+}
+
+// yuanField 取金额列, 空值为 0
+func yuanField(header map[string]int, record []string, names ...string) (money.Fen, error) {
+	v := field(header, record, names...)
+	if v == "" {
+		return 0, nil
+	}
+	return money.ParseYuan(v)
+}
+
+func parseBillRow(header map[string]int, record []string) (*BillRow, error) {
+	if len(record) < len(header) {
+		return nil, fmt.Errorf("expect %d columns, got %d", len(header), len(record))
+	}
+	row := &BillRow{
+		TradeTime:     field(header, record, "交易时间"),
+		TransactionID: field(header, record, "微信订单号"),
+		OutTradeNo:    field(header, record, "商户订单号"),
+		TradeState:    field(header, record, "交易状态"),
+		RefundID:      field(header, record, "微信退款单号"),
+		OutRefundNo:   field(header, record, "商户退款单号"),
+		RefundStatus:  field(header, record, "退款状态"),
+	}
+	// 没有退款的交易退款单号为 0
+	if row.RefundID == "0" {
+		row.RefundID = ""
+	}
+	if row.OutRefundNo == "0" {
+		row.OutRefundNo = ""
+	}
+	var err error
+	if row.TotalFee, err = yuanField(header, record, colTotalFee...); err != nil {
+		return nil, err
+	}
+	if row.RefundFee, err = yuanField(header, record, colRefundFee...); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+func parseBillSummary(header map[string]int, record []string) (*BillSummary, error) {
+	count, err := strconv.Atoi(field(header, record, "总交易单数"))
+	if err != nil {
+		return nil, err
+	}
+	summary := &BillSummary{TradeCount: count}
+	if summary.TotalFee, err = yuanField(header, record, colSummaryTotalFee...); err != nil {
+		return nil, err
+	}
+	if summary.RefundFee, err = yuanField(header, record, colSummaryRefund...); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
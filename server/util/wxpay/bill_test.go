@@ -0,0 +1,92 @@
+package wxpay
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func readBill(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParseBill(t *testing.T) {
+	bill, err := ParseBill(readBill(t, "bill_20200528.csv"))
+	if err != nil {
+		t.Fatalf("parse bill failed: %v", err)
+	}
+	if len(bill.Rows) != 3 || bill.Summary.TradeCount != 3 {
+		t.Fatalf("expect 3 rows, got %d, summary %+v", len(bill.Rows), bill.Summary)
+	}
+
+	paid := bill.Rows[1]
+	if paid.OutTradeNo != "1267101592630235136" || paid.TradeState != TradeStateSuccess ||
+		paid.TotalFee != 128800 || paid.OutRefundNo != "" || paid.RefundID != "" {
+		t.Errorf("unexpected paid row: %+v", paid)
+	}
+	refund := bill.Rows[2]
+	if refund.TradeState != TradeStateRefund || refund.OutRefundNo != "1267149231507705856" ||
+		refund.RefundFee != 35910 || refund.RefundStatus != RefundStatusSuccess {
+		t.Errorf("unexpected refund row: %+v", refund)
+	}
+	if bill.Summary.TotalFee != 168700 || bill.Summary.RefundFee != 35910 {
+		t.Errorf("unexpected summary: %+v", bill.Summary)
+	}
+}
+
+func TestParseLegacyBill(t *testing.T) {
+	bill, err := ParseBill(readBill(t, "bill_legacy.csv"))
+	if err != nil {
+		t.Fatalf("parse bill failed: %v", err)
+	}
+	if len(bill.Rows) != 1 || bill.Rows[0].TotalFee != 19900 || bill.Summary.TotalFee != 19900 {
+		t.Errorf("unexpected bill: %+v, %+v", bill.Rows[0], bill.Summary)
+	}
+}
+
+func TestParseBillIncomplete(t *testing.T) {
+	lines := bytes.SplitAfter(readBill(t, "bill_20200528.csv"), []byte("\n"))
+	// 下载中断, 缺少汇总或部分交易
+	for _, n := range []int{5, 4, 3} {
+		if _, err := ParseBill(bytes.Join(lines[:n], nil)); err == nil {
+			t.Errorf("parse a bill truncated to %d lines should fail", n)
+		}
+	}
+	if _, err := ParseBill([]byte("交易时间,商户订单号\n")); err == nil {
+		t.Error("parse a bill without rows should fail")
+	}
+}
+
+func TestDownloadBill(t *testing.T) {
+	data := readBill(t, "bill_20200528.csv")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		req, _ := ParseParams(body)
+		if r.URL.Path != "/pay/downloadbill" || !req.VerifySign(testKey) {
+			t.Errorf("unexpected request: %s %s", r.URL.Path, body)
+		}
+		if req["bill_date"] == "20200528" {
+			_, _ = w.Write(data)
+			return
+		}
+		_ = xml.NewEncoder(w).Encode(Params{"return_code": "FAIL", "return_msg": "No Bill Exist"})
+	}))
+	defer srv.Close()
+	cli := newTestClient(srv.URL)
+
+	raw, err := cli.DownloadBill("20200528")
+	if err != nil || len(raw) != len(data) {
+		t.Errorf("download bill: %d bytes, err: %v", len(raw), err)
+	}
+	if _, err = cli.DownloadBill("20200529"); err != ErrNoBill {
+		t.Errorf("expect ErrNoBill, got %v", err)
+	}
+}
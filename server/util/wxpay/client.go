@@ -0,0 +1,230 @@
+// Package wxpay 微信支付商户平台接口的客户端, Client 为 v2 接口, ClientV3 为 v3 接口, FakeGateway 为进程内的假支付, 业务代码通过 Gateway 使用。
+// 本包不依赖全局配置, 网关地址可以指向本地的假微信支付服务用于测试
+package wxpay
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/silenceper/wechat/v2/util"
+)
+
+const DefaultGateway = "https://api.mch.weixin.qq.com"
+
+const (
+	codeSuccess = "SUCCESS"
+	codeFail    = "FAIL"
+)
+
+type Config struct {
+	// 接口版本 V2、V3 或 Fake, 为空时使用 V2
+	Version string
+	AppID   string
+	MchID   string
+	// 商户后台设置的支付 key, v2 使用
+	Key string
+	// 为空时使用 DefaultGateway
+	Gateway string
+	// 商户证书, v2 退款等接口需要双向证书
+	CertFile string
+	// 商户证书私钥, v3 用来签名请求
+	KeyFile string
+	// APIv3 密钥, v3 用来解密通知和平台证书
+	APIv3Key string
+	// 商户证书序列号, v3 使用
+	SerialNo string
+	// 假支付下单后自动支付的结果, 为空时不自动支付
+	FakeOutcome string
+}
+
+type Client struct {
+	AppID   string
+	MchID   string
+	Key     string
+	Gateway string
+	// 普通接口
+	HTTPClient *http.Client
+	// 需要商户证书的接口
+	TLSClient *http.Client
+}
+
+// NewClient 证书文件为空时不加载证书, 此时需要证书的接口使用 HTTPClient
+func NewClient(cfg *Config) (*Client, error) {
+	cli := &Client{
+		AppID:      cfg.AppID,
+		MchID:      cfg.MchID,
+		Key:        cfg.Key,
+		Gateway:    cfg.Gateway,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+	if cli.Gateway == "" {
+		cli.Gateway = DefaultGateway
+	}
+	cli.TLSClient = cli.HTTPClient
+	if cfg.CertFile != "" && cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load merchant cert failed, err: [%s]", err.Error())
+		}
+		cli.TLSClient = &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+			},
+		}
+	}
+	return cli, nil
+}
+
+// Params 微信支付 v2 接口的 xml 参数
+type Params map[string]string
+
+func (p Params) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start = xml.StartElement{Name: xml.Name{Local: "xml"}}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, k := range p.sortedKeys() {
+		if err := e.EncodeElement(p[k], xml.StartElement{Name: xml.Name{Local: k}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+func (p *Params) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	*p = make(Params)
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			var v string
+			if err = d.DecodeElement(&v, &se); err != nil {
+				return err
+			}
+			(*p)[se.Name.Local] = v
+		}
+	}
+}
+
+func (p Params) sortedKeys() []string {
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Sign MD5 签名, 空值和 sign 字段不参与签名
+func (p Params) Sign(key string) string {
+	var buffer strings.Builder
+	for _, k := range p.sortedKeys() {
+		if p[k] == "" || k == "sign" {
+			continue
+		}
+		buffer.WriteString(k)
+		buffer.WriteString("=")
+		buffer.WriteString(p[k])
+		buffer.WriteString("&")
+	}
+	buffer.WriteString("key=")
+	buffer.WriteString(key)
+	sum := md5.Sum([]byte(buffer.String()))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func (p Params) VerifySign(key string) bool {
+	return p["sign"] != "" && p["sign"] == p.Sign(key)
+}
+
+// ParseParams 解析微信返回的 xml
+func ParseParams(body []byte) (Params, error) {
+	var p Params
+	if err := xml.Unmarshal(body, &p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// do 补全公共参数并签名后请求接口, 返回原始响应
+func (c *Client) do(cli *http.Client, path string, p Params) ([]byte, error) {
+	p["appid"] = c.AppID
+	p["mch_id"] = c.MchID
+	p["nonce_str"] = util.RandomStr(32)
+	p["sign_type"] = "MD5"
+	p["sign"] = p.Sign(c.Key)
+
+	body, err := xml.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := cli.Post(c.Gateway+path, "application/xml; charset=utf-8", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http status: [%d]", resp.StatusCode)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// post 请求返回 xml 的接口, 返回通过验签且业务结果为 SUCCESS 的响应
+func (c *Client) post(cli *http.Client, path string, p Params) (Params, error) {
+	raw, err := c.do(cli, path, p)
+	if err != nil {
+		return nil, err
+	}
+
+	ret, err := ParseParams(raw)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal response failed, raw: [%s], err: [%s]", raw, err.Error())
+	}
+	if ret["return_code"] != codeSuccess {
+		return ret, fmt.Errorf("return_code: [%s], return_msg: [%s]", ret["return_code"], ret["return_msg"])
+	}
+	if !ret.VerifySign(c.Key) {
+		return ret, errors.New("failed to verify response sign")
+	}
+	if ret["result_code"] != codeSuccess {
+		return ret, &ResultError{Code: ret["err_code"], Desc: ret["err_code_des"]}
+	}
+	return ret, nil
+}
+
+// ResultError 业务结果 result_code 为 FAIL 时返回
+type ResultError struct {
+	Code string
+	Desc string
+}
+
+func (e *ResultError) Error() string {
+	return fmt.Sprintf("err_code: [%s], err_code_des: [%s]", e.Code, e.Desc)
+}
+
+// Ack 回复微信通知
+func Ack(ok bool, msg string) string {
+	p := Params{"return_code": codeSuccess, "return_msg": "OK"}
+	if !ok {
+		p = Params{"return_code": codeFail, "return_msg": msg}
+	}
+	data, _ := xml.Marshal(p)
+	return string(data)
+}
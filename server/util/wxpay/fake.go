@@ -0,0 +1,309 @@
+package wxpay
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/silenceper/wechat/v2/util"
+	"mk-api/server/util/money"
+)
+
+// 假支付中用户支付的结果
+const (
+	FakeSuccess    = "success"     // 支付成功, 很快收到通知
+	FakeFail       = "fail"        // 支付失败, 不发通知
+	FakeLateNotify = "late_notify" // 支付成功, 通知很久之后才到, 期间只能靠主动查询
+	FakeNoNotify   = "no_notify"   // 支付成功, 通知丢失
+)
+
+// 通知未被确认时的重试次数, 微信会重试更多次, 本地开发不需要
+const fakeNotifyAttempts = 3
+
+// FakeGateway 进程内的假微信支付, 订单只保存在内存中, 用于本地开发和测试, 不需要商户号和证书。
+// 下单后由 Pay 或 AutoPay 模拟用户支付, 支付和退款通知使用 v2 的格式发到下单时的通知地址
+type FakeGateway struct {
+	// 不为空时下单后等待 PayDelay 自动按该结果支付, 为空时需要调用 Pay
+	AutoPay         string
+	PayDelay        time.Duration
+	NotifyDelay     time.Duration
+	LateNotifyDelay time.Duration
+	// 发送通知, 返回错误时重试。 默认 POST 到通知地址, 测试中可以替换成直接调用处理函数
+	Deliver func(url string, body []byte) error
+
+	// 通知的签名和解析与 v2 相同
+	v2 *Client
+
+	mu     sync.Mutex
+	orders map[string]*fakeOrder
+	// 生成微信支付订单号和退款单号
+	seq int
+}
+
+type fakeOrder struct {
+	PrepayParams
+	prepayID      string
+	tradeState    string
+	transactionID string
+	timeEnd       int64
+	refundFee     money.Fen
+}
+
+// payable 支付失败后用户可以重新支付
+func (o *fakeOrder) payable() bool {
+	return o.tradeState == TradeStateNotPay || o.tradeState == TradeStatePayError
+}
+
+// NewFakeGateway 商户号和 key 可以为空, 通知用同样的 key 签名和验签
+func NewFakeGateway(cfg *Config) *FakeGateway {
+	return &FakeGateway{
+		AutoPay:         cfg.FakeOutcome,
+		PayDelay:        3 * time.Second,
+		NotifyDelay:     time.Second,
+		LateNotifyDelay: 10 * time.Minute,
+		Deliver:         deliverXML,
+		v2:              &Client{AppID: cfg.AppID, MchID: cfg.MchID, Key: cfg.Key},
+		orders:          make(map[string]*fakeOrder),
+	}
+}
+
+func (f *FakeGateway) Prepay(p *PrepayParams) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// 与微信相同, 未支付的订单重复下单返回同一个 prepay_id
+	if o, ok := f.orders[p.OutTradeNo]; ok {
+		if !o.payable() {
+			return "", f.stateError(o)
+		}
+		return o.prepayID, nil
+	}
+	o := &fakeOrder{
+		PrepayParams: *p,
+		prepayID:     "wx_fake_" + util.RandomStr(24),
+		tradeState:   TradeStateNotPay,
+	}
+	f.orders[p.OutTradeNo] = o
+	if f.AutoPay != "" {
+		go func() {
+			time.Sleep(f.PayDelay)
+			_ = f.Pay(p.OutTradeNo, f.AutoPay)
+		}()
+	}
+	return o.prepayID, nil
+}
+
+func (f *FakeGateway) JSAPIParams(prepayID string) (*JSAPIParams, error) {
+	return f.v2.JSAPIParams(prepayID)
+}
+
+// Pay 模拟用户支付, outcome 为 FakeSuccess 等取值
+func (f *FakeGateway) Pay(outTradeNo string, outcome string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	o, ok := f.orders[outTradeNo]
+	if !ok {
+		return &ResultError{Code: ErrCodeOrderNotExist, Desc: "此交易订单号不存在"}
+	}
+	if !o.payable() {
+		return f.stateError(o)
+	}
+
+	var delay time.Duration
+	switch outcome {
+	case FakeFail:
+		o.tradeState = TradeStatePayError
+		return nil
+	case FakeSuccess:
+		delay = f.NotifyDelay
+	case FakeLateNotify:
+		delay = f.LateNotifyDelay
+	case FakeNoNotify:
+	default:
+		return fmt.Errorf("unknown fake pay outcome [%s]", outcome)
+	}
+	o.tradeState = TradeStateSuccess
+	o.transactionID = f.nextNo("4200")
+	o.timeEnd = time.Now().Unix()
+	if outcome == FakeNoNotify {
+		return nil
+	}
+
+	p := Params{
+		"return_code":    codeSuccess,
+		"result_code":    codeSuccess,
+		"appid":          f.v2.AppID,
+		"mch_id":         f.v2.MchID,
+		"nonce_str":      util.RandomStr(32),
+		"openid":         o.OpenID,
+		"trade_type":     "JSAPI",
+		"out_trade_no":   o.OutTradeNo,
+		"transaction_id": o.transactionID,
+		"total_fee":      strconv.FormatInt(int64(o.TotalFee), 10),
+		"time_end":       time.Unix(o.timeEnd, 0).In(beijing).Format("20060102150405"),
+		"attach":         o.Attach,
+	}
+	p["sign"] = p.Sign(f.v2.Key)
+	body, err := xml.Marshal(p)
+	if err != nil {
+		return err
+	}
+	go f.notify(o.NotifyURL, body, delay)
+	return nil
+}
+
+func (f *FakeGateway) ParsePaidNotify(header http.Header, body []byte) (*PaidNotify, error) {
+	return f.v2.ParsePaidNotify(header, body)
+}
+
+func (f *FakeGateway) QueryOrder(outTradeNo string) (*OrderQueryResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	o, ok := f.orders[outTradeNo]
+	if !ok {
+		return nil, &ResultError{Code: ErrCodeOrderNotExist, Desc: "此交易订单号不存在"}
+	}
+	return &OrderQueryResult{
+		TradeState:    o.tradeState,
+		TransactionID: o.transactionID,
+		OutTradeNo:    o.OutTradeNo,
+		TotalFee:      o.TotalFee,
+		TimeEnd:       o.timeEnd,
+	}, nil
+}
+
+func (f *FakeGateway) CloseOrder(outTradeNo string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	o, ok := f.orders[outTradeNo]
+	if !ok {
+		return &ResultError{Code: ErrCodeOrderNotExist, Desc: "此交易订单号不存在"}
+	}
+	if !o.payable() {
+		return f.stateError(o)
+	}
+	o.tradeState = TradeStateClosed
+	return nil
+}
+
+// Refund 退款总是成功, 等待 NotifyDelay 后发送退款通知
+func (f *FakeGateway) Refund(p *RefundParams) (*RefundResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	o, ok := f.orders[p.OutTradeNo]
+	if !ok {
+		return nil, &ResultError{Code: ErrCodeOrderNotExist, Desc: "此交易订单号不存在"}
+	}
+	if o.tradeState != TradeStateSuccess && o.tradeState != TradeStateRefund {
+		return nil, &ResultError{Code: "TRADE_STATE_ERROR", Desc: "订单状态错误"}
+	}
+	if p.RefundFee <= 0 || o.refundFee+p.RefundFee > o.TotalFee {
+		return nil, &ResultError{Code: "INVALID_REQUEST", Desc: "退款金额超过订单剩余可退金额"}
+	}
+	o.tradeState = TradeStateRefund
+	o.refundFee += p.RefundFee
+	refundID := f.nextNo("5000")
+
+	plain, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"root"`
+		RefundNotify
+	}{RefundNotify: RefundNotify{
+		TransactionID:       o.transactionID,
+		OutTradeNo:          o.OutTradeNo,
+		RefundID:            refundID,
+		OutRefundNo:         p.OutRefundNo,
+		TotalFee:            o.TotalFee,
+		RefundFee:           p.RefundFee,
+		SettlementRefundFee: p.RefundFee,
+		RefundStatus:        RefundStatusSuccess,
+		SuccessTime:         time.Now().In(beijing).Format("2006-01-02 15:04:05"),
+		RefundRecvAccout:    "支付用户零钱",
+	}})
+	if err != nil {
+		return nil, err
+	}
+	reqInfo, err := EncryptReqInfo(plain, f.v2.Key)
+	if err != nil {
+		return nil, err
+	}
+	body, err := xml.Marshal(Params{
+		"return_code": codeSuccess,
+		"appid":       f.v2.AppID,
+		"mch_id":      f.v2.MchID,
+		"nonce_str":   util.RandomStr(32),
+		"req_info":    reqInfo,
+	})
+	if err != nil {
+		return nil, err
+	}
+	go f.notify(p.NotifyURL, body, f.NotifyDelay)
+	return &RefundResult{RefundID: refundID, OutRefundNo: p.OutRefundNo, RefundFee: p.RefundFee}, nil
+}
+
+func (f *FakeGateway) ParseRefundNotify(header http.Header, body []byte) (*RefundNotify, error) {
+	return f.v2.ParseRefundNotify(header, body)
+}
+
+// DownloadBill 假支付不生成对账单
+func (f *FakeGateway) DownloadBill(date string) ([]byte, error) {
+	return nil, ErrNoBill
+}
+
+func (f *FakeGateway) WriteAck(w http.ResponseWriter, ok bool, msg string) {
+	f.v2.WriteAck(w, ok, msg)
+}
+
+func (f *FakeGateway) stateError(o *fakeOrder) error {
+	switch o.tradeState {
+	case TradeStateClosed:
+		return &ResultError{Code: ErrCodeOrderClosed, Desc: "订单已关闭"}
+	case TradeStateSuccess, TradeStateRefund:
+		return &ResultError{Code: ErrCodeOrderPaid, Desc: "订单已支付"}
+	}
+	return &ResultError{Code: "TRADE_STATE_ERROR", Desc: "订单状态错误: " + o.tradeState}
+}
+
+// nextNo 与微信的订单号一样为纯数字
+func (f *FakeGateway) nextNo(prefix string) string {
+	f.seq++
+	return fmt.Sprintf("%s%s%08d", prefix, time.Now().In(beijing).Format("20060102150405"), f.seq)
+}
+
+// notify 等待 delay 后发送通知, 未被确认时按 delay 间隔重试
+func (f *FakeGateway) notify(url string, body []byte, delay time.Duration) {
+	for i := 0; i < fakeNotifyAttempts; i++ {
+		time.Sleep(delay)
+		if f.Deliver(url, body) == nil {
+			return
+		}
+	}
+}
+
+// deliverXML POST 通知到通知地址, 应答的 return_code 为 SUCCESS 时视为已确认
+func deliverXML(url string, body []byte) error {
+	if url == "" {
+		return errors.New("notify url is empty")
+	}
+	resp, err := http.Post(url, "application/xml; charset=utf-8", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	ret, err := ParseParams(data)
+	if err != nil {
+		return err
+	}
+	if ret["return_code"] != codeSuccess {
+		return fmt.Errorf("notify not acknowledged, return_msg: [%s]", ret["return_msg"])
+	}
+	return nil
+}
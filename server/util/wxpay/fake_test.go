@@ -0,0 +1,155 @@
+package wxpay
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// 接收通知的服务, 解析后把结果发到 channel
+func newNotifyServer(t *testing.T, f *FakeGateway, paid chan<- *PaidNotify, refunded chan<- *RefundNotify) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/pay/wechat_callback":
+			n, err := f.ParsePaidNotify(r.Header, body)
+			if err != nil {
+				t.Errorf("parse paid notify failed: %v", err)
+			} else {
+				paid <- n
+			}
+			f.WriteAck(w, err == nil, "FAIL")
+		case "/pay/refund_callback":
+			n, err := f.ParseRefundNotify(r.Header, body)
+			if err != nil {
+				t.Errorf("parse refund notify failed: %v", err)
+			} else {
+				refunded <- n
+			}
+			f.WriteAck(w, err == nil, "FAIL")
+		}
+	}))
+}
+
+func newTestFake() *FakeGateway {
+	f := NewFakeGateway(&Config{AppID: "wx0001", MchID: "1600000001", Key: testKey})
+	f.NotifyDelay = 10 * time.Millisecond
+	f.LateNotifyDelay = 200 * time.Millisecond
+	return f
+}
+
+func TestFakePayNRefund(t *testing.T) {
+	f := newTestFake()
+	paid, refunded := make(chan *PaidNotify, 1), make(chan *RefundNotify, 1)
+	srv := newNotifyServer(t, f, paid, refunded)
+	defer srv.Close()
+
+	prepayID, err := f.Prepay(&PrepayParams{OutTradeNo: "1267034018434977792", TotalFee: 39900, NotifyURL: srv.URL + "/pay/wechat_callback"})
+	if err != nil || prepayID == "" {
+		t.Fatalf("prepay failed: %q, %v", prepayID, err)
+	}
+	if err = f.Pay("1267034018434977792", FakeSuccess); err != nil {
+		t.Fatalf("pay failed: %v", err)
+	}
+	select {
+	case n := <-paid:
+		if n.OutTradeNo != "1267034018434977792" || n.TotalFee != 39900 || n.TransactionID == "" {
+			t.Errorf("unexpected paid notify: %+v", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("paid notify not received")
+	}
+	if err = f.CloseOrder("1267034018434977792"); !IsErrCode(err, ErrCodeOrderPaid) {
+		t.Errorf("expect ORDERPAID, got %v", err)
+	}
+
+	_, err = f.Refund(&RefundParams{OutTradeNo: "1267034018434977792", OutRefundNo: "1267034018434977793",
+		TotalFee: 39900, RefundFee: 35910, NotifyURL: srv.URL + "/pay/refund_callback"})
+	if err != nil {
+		t.Fatalf("refund failed: %v", err)
+	}
+	select {
+	case n := <-refunded:
+		if n.RefundStatus != RefundStatusSuccess || n.RefundFee != 35910 || n.SuccessAt() == 0 {
+			t.Errorf("unexpected refund notify: %+v", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("refund notify not received")
+	}
+	if _, err = f.Refund(&RefundParams{OutTradeNo: "1267034018434977792", OutRefundNo: "1267034018434977794", RefundFee: 39900}); err == nil {
+		t.Error("refund more than the remaining amount should fail")
+	}
+}
+
+func TestFakePayFail(t *testing.T) {
+	f := newTestFake()
+	f.Deliver = func(url string, body []byte) error {
+		t.Error("failed payment should not notify")
+		return nil
+	}
+	_, _ = f.Prepay(&PrepayParams{OutTradeNo: "1267034018434977792", TotalFee: 39900})
+	if err := f.Pay("1267034018434977792", FakeFail); err != nil {
+		t.Fatal(err)
+	}
+	ret, err := f.QueryOrder("1267034018434977792")
+	if err != nil || ret.Paid() {
+		t.Fatalf("order should not be paid: %+v, %v", ret, err)
+	}
+	// 支付失败后可以关闭, 关闭后不能再支付
+	if err = f.CloseOrder("1267034018434977792"); err != nil {
+		t.Fatalf("close order failed: %v", err)
+	}
+	if err = f.Pay("1267034018434977792", FakeSuccess); !IsErrCode(err, ErrCodeOrderClosed) {
+		t.Errorf("expect ORDERCLOSED, got %v", err)
+	}
+	if _, err = f.QueryOrder("1267034018434977799"); !IsErrCode(err, ErrCodeOrderNotExist) {
+		t.Errorf("expect ORDERNOTEXIST, got %v", err)
+	}
+}
+
+func TestFakeLateNotify(t *testing.T) {
+	f := newTestFake()
+	delivered := make(chan time.Time, 1)
+	f.Deliver = func(url string, body []byte) error {
+		delivered <- time.Now()
+		return nil
+	}
+	_, _ = f.Prepay(&PrepayParams{OutTradeNo: "1267034018434977792", TotalFee: 39900})
+	start := time.Now()
+	if err := f.Pay("1267034018434977792", FakeLateNotify); err != nil {
+		t.Fatal(err)
+	}
+	// 通知到达前主动查询已是支付成功
+	if ret, err := f.QueryOrder("1267034018434977792"); err != nil || !ret.Paid() || ret.TimeEnd == 0 {
+		t.Errorf("order should be paid before the notify arrives: %+v, %v", ret, err)
+	}
+	select {
+	case at := <-delivered:
+		if at.Sub(start) < f.LateNotifyDelay {
+			t.Errorf("notify arrived too early: %s", at.Sub(start))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("late notify not delivered")
+	}
+}
+
+func TestFakeAutoPay(t *testing.T) {
+	f := newTestFake()
+	f.AutoPay = FakeNoNotify
+	f.PayDelay = 10 * time.Millisecond
+	f.Deliver = func(url string, body []byte) error {
+		t.Error("lost notify should not be delivered")
+		return nil
+	}
+	_, _ = f.Prepay(&PrepayParams{OutTradeNo: "1267034018434977792", TotalFee: 39900})
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if ret, _ := f.QueryOrder("1267034018434977792"); ret.Paid() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("order not paid automatically")
+}
@@ -0,0 +1,90 @@
+package wxpay
+
+import (
+	"fmt"
+	"net/http"
+
+	"mk-api/server/util/money"
+)
+
+// 商户平台接口版本
+const (
+	V2 = "v2"
+	V3 = "v3"
+	// 进程内的假微信支付, 见 FakeGateway
+	Fake = "fake"
+)
+
+// Gateway 业务用到的微信支付接口, v2(xml + MD5) 和 v3(json + RSA) 各有一个实现, 由配置选择, 本地开发和测试使用 FakeGateway。
+// 两个版本的差异都在实现内部处理, 返回的交易状态、错误码等统一为 v2 的取值
+type Gateway interface {
+	// Prepay JSAPI 下单, 返回 prepay_id
+	Prepay(p *PrepayParams) (string, error)
+	// JSAPIParams 前端调起支付需要的参数, 每次调用生成新的随机串和签名
+	JSAPIParams(prepayID string) (*JSAPIParams, error)
+	// ParsePaidNotify 验签并解析支付结果通知, 只接受支付成功的通知
+	ParsePaidNotify(header http.Header, body []byte) (*PaidNotify, error)
+	QueryOrder(outTradeNo string) (*OrderQueryResult, error)
+	CloseOrder(outTradeNo string) error
+	Refund(p *RefundParams) (*RefundResult, error)
+	// ParseRefundNotify 验签并解析退款结果通知
+	ParseRefundNotify(header http.Header, body []byte) (*RefundNotify, error)
+	// DownloadBill 下载 date(yyyyMMdd) 当天的全部交易对账单, 两个版本的对账单格式相同
+	DownloadBill(date string) ([]byte, error)
+	// WriteAck 回复微信的支付和退款通知
+	WriteAck(w http.ResponseWriter, ok bool, msg string)
+}
+
+// New 按 cfg.Version 创建对应版本的客户端, 为空时使用 v2
+func New(cfg *Config) (Gateway, error) {
+	switch cfg.Version {
+	case "", V2:
+		cli, err := NewClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return cli, nil
+	case V3:
+		cli, err := NewClientV3(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return cli, nil
+	case Fake:
+		return NewFakeGateway(cfg), nil
+	}
+	return nil, fmt.Errorf("unsupported pay api version [%s]", cfg.Version)
+}
+
+type PrepayParams struct {
+	OutTradeNo string
+	// 单位分
+	TotalFee money.Fen
+	// 商品描述, 会出现在用户的支付凭证中
+	Description string
+	OpenID      string
+	ClientIP    string
+	// 支付结果通知地址
+	NotifyURL string
+	// 附加数据, 在查询和通知中原样返回
+	Attach string
+}
+
+// JSAPIParams 前端 WeixinJSBridge getBrandWCPayRequest 的参数, json 字段与之前返回给前端的保持一致
+type JSAPIParams struct {
+	Timestamp string `json:"timestamp"`
+	NonceStr  string `json:"nonceStr"`
+	PrePayID  string `json:"prePayId"`
+	SignType  string `json:"signType"`
+	Package   string `json:"package"`
+	PaySign   string `json:"paySign"`
+}
+
+// PaidNotify 支付成功通知中的交易信息
+type PaidNotify struct {
+	OutTradeNo    string
+	TransactionID string
+	TotalFee      money.Fen
+	// 支付完成时间戳
+	TimeEnd int64
+}
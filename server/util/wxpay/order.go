@@ -0,0 +1,94 @@
+package wxpay
+
+import (
+	"strconv"
+	"time"
+
+	"mk-api/server/util/money"
+)
+
+// 交易状态
+const (
+	TradeStateSuccess    = "SUCCESS"    // 支付成功
+	TradeStateRefund     = "REFUND"     // 转入退款
+	TradeStateNotPay     = "NOTPAY"     // 未支付
+	TradeStateClosed     = "CLOSED"     // 已关闭
+	TradeStateRevoked    = "REVOKED"    // 已撤销(付款码支付)
+	TradeStateUserPaying = "USERPAYING" // 用户支付中(付款码支付)
+	TradeStatePayError   = "PAYERROR"   // 支付失败
+)
+
+// 业务错误码
+const (
+	ErrCodeOrderNotExist = "ORDERNOTEXIST" // 此交易订单号不存在
+	ErrCodeOrderPaid     = "ORDERPAID"     // 订单已支付, 不能关闭
+	ErrCodeOrderClosed   = "ORDERCLOSED"   // 订单已关闭
+)
+
+// 微信支付接口中的时间均为北京时间
+var beijing = time.FixedZone("CST", 8*3600)
+
+type OrderQueryResult struct {
+	TradeState    string
+	TransactionID string
+	OutTradeNo    string
+	TotalFee      money.Fen
+	// 支付完成时间戳, 未支付时为0
+	TimeEnd int64
+}
+
+// Paid 是否已支付, 转入退款的订单也是支付过的
+func (r *OrderQueryResult) Paid() bool {
+	return r.TradeState == TradeStateSuccess || r.TradeState == TradeStateRefund
+}
+
+// QueryOrder 查询订单。 doc: https://pay.weixin.qq.com/wiki/doc/api/jsapi.php?chapter=9_2
+func (c *Client) QueryOrder(outTradeNo string) (*OrderQueryResult, error) {
+	ret, err := c.post(c.HTTPClient, "/pay/orderquery", Params{"out_trade_no": outTradeNo})
+	if err != nil {
+		return nil, err
+	}
+	totalFee, _ := strconv.ParseInt(ret["total_fee"], 10, 64)
+	return &OrderQueryResult{
+		TradeState:    ret["trade_state"],
+		TransactionID: ret["transaction_id"],
+		OutTradeNo:    ret["out_trade_no"],
+		TotalFee:      money.Fen(totalFee),
+		TimeEnd:       ParseTime(ret["time_end"]),
+	}, nil
+}
+
+// CloseOrder 关闭订单, 关闭后用户不能再支付。 doc: https://pay.weixin.qq.com/wiki/doc/api/jsapi.php?chapter=9_3
+func (c *Client) CloseOrder(outTradeNo string) error {
+	_, err := c.post(c.HTTPClient, "/pay/closeorder", Params{"out_trade_no": outTradeNo})
+	return err
+}
+
+// IsErrCode 判断 err 是否为指定错误码的业务错误
+func IsErrCode(err error, code string) bool {
+	e, ok := err.(*ResultError)
+	return ok && e.Code == code
+}
+
+// 系统繁忙一类的错误码, 微信不一定没有受理, 需要用同样的参数重试, 不能当作请求被拒绝
+var retryErrCodes = map[string]bool{
+	"SYSTEMERROR":       true,
+	"SYSTEM_ERROR":      true,
+	"BIZERR_NEED_RETRY": true,
+	"FREQUENCY_LIMITED": true,
+}
+
+// IsRejected 判断 err 是否为微信明确拒绝的业务错误。 超时、网络错误和系统繁忙时请求可能已被受理, 返回 false
+func IsRejected(err error) bool {
+	e, ok := err.(*ResultError)
+	return ok && !retryErrCodes[e.Code]
+}
+
+// ParseTime 解析 yyyyMMddHHmmss 格式的北京时间, 返回时间戳, 格式错误时为0
+func ParseTime(s string) int64 {
+	t, err := time.ParseInLocation("20060102150405", s, beijing)
+	if err != nil {
+		return 0
+	}
+	return t.Unix()
+}
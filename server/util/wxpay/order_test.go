@@ -0,0 +1,103 @@
+package wxpay
+
+import (
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// 本地的假微信支付订单接口, paid 中的订单为已支付, 其余为未支付
+func newFakeOrderServer(t *testing.T, paid map[string]bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		req, err := ParseParams(body)
+		if err != nil {
+			t.Fatalf("parse request failed: %v", err)
+		}
+		if !req.VerifySign(testKey) {
+			t.Errorf("request sign mismatched: %s", body)
+		}
+		resp := Params{"return_code": "SUCCESS", "result_code": "SUCCESS"}
+		switch r.URL.Path {
+		case "/pay/orderquery":
+			resp["out_trade_no"] = req["out_trade_no"]
+			resp["trade_state"] = TradeStateNotPay
+			if paid[req["out_trade_no"]] {
+				resp["trade_state"] = TradeStateSuccess
+				resp["transaction_id"] = "4200000512202005283917925386"
+				resp["total_fee"] = "39900"
+				resp["time_end"] = "20200528101530"
+			}
+		case "/pay/closeorder":
+			if paid[req["out_trade_no"]] {
+				resp["result_code"] = "FAIL"
+				resp["err_code"] = ErrCodeOrderPaid
+				resp["err_code_des"] = "订单已支付"
+			}
+		default:
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		resp["sign"] = resp.Sign(testKey)
+		_ = xml.NewEncoder(w).Encode(resp)
+	}))
+}
+
+func TestQueryOrder(t *testing.T) {
+	srv := newFakeOrderServer(t, map[string]bool{"1267034018434977792": true})
+	defer srv.Close()
+	cli := newTestClient(srv.URL)
+
+	ret, err := cli.QueryOrder("1267034018434977792")
+	if err != nil {
+		t.Fatalf("query order failed: %v", err)
+	}
+	if !ret.Paid() || ret.TotalFee != 39900 || ret.TransactionID == "" {
+		t.Errorf("unexpected query result: %+v", ret)
+	}
+	// 2020-05-28 10:15:30 +0800
+	if ret.TimeEnd != 1590632130 {
+		t.Errorf("time_end parsed as %d", ret.TimeEnd)
+	}
+
+	ret, err = cli.QueryOrder("1267034018434977799")
+	if err != nil {
+		t.Fatalf("query order failed: %v", err)
+	}
+	if ret.Paid() {
+		t.Errorf("order should not be paid: %+v", ret)
+	}
+}
+
+func TestCloseOrder(t *testing.T) {
+	srv := newFakeOrderServer(t, map[string]bool{"1267034018434977792": true})
+	defer srv.Close()
+	cli := newTestClient(srv.URL)
+
+	if err := cli.CloseOrder("1267034018434977799"); err != nil {
+		t.Errorf("close unpaid order failed: %v", err)
+	}
+	if err := cli.CloseOrder("1267034018434977792"); !IsErrCode(err, ErrCodeOrderPaid) {
+		t.Errorf("expect ORDERPAID when closing a paid order, got %v", err)
+	}
+}
+
+func TestIsRejected(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{&ResultError{Code: "NOTENOUGH", Desc: "基本账户余额不足"}, true},
+		{&ResultError{Code: "SYSTEMERROR", Desc: "接口返回错误"}, false},
+		{&ResultError{Code: "SYSTEM_ERROR", Desc: "系统错误"}, false},
+		{errors.New("net/http: request canceled (Client.Timeout exceeded while awaiting headers)"), false},
+		{nil, false},
+	}
+	for _, c := range cases {
+		if got := IsRejected(c.err); got != c.want {
+			t.Errorf("IsRejected(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package wxpay
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/silenceper/wechat/v2/util"
+	"mk-api/server/util/money"
+)
+
+// Prepay 统一下单。 doc: https://pay.weixin.qq.com/wiki/doc/api/jsapi.php?chapter=9_1
+func (c *Client) Prepay(p *PrepayParams) (string, error) {
+	ret, err := c.post(c.HTTPClient, "/pay/unifiedorder", Params{
+		"body":             p.Description,
+		"out_trade_no":     p.OutTradeNo,
+		"total_fee":        strconv.FormatInt(int64(p.TotalFee), 10),
+		"spbill_create_ip": p.ClientIP,
+		"notify_url":       p.NotifyURL,
+		"trade_type":       "JSAPI",
+		"openid":           p.OpenID,
+		"attach":           p.Attach,
+	})
+	if err != nil {
+		return "", err
+	}
+	return ret["prepay_id"], nil
+}
+
+// JSAPIParams 参与签名的字段名按 ASCII 排序后与 Params.Sign 的规则相同。 doc: https://pay.weixin.qq.com/wiki/doc/api/jsapi.php?chapter=7_7
+func (c *Client) JSAPIParams(prepayID string) (*JSAPIParams, error) {
+	p := Params{
+		"appId":     c.AppID,
+		"timeStamp": strconv.FormatInt(time.Now().Unix(), 10),
+		"nonceStr":  util.RandomStr(32),
+		"package":   "prepay_id=" + prepayID,
+		"signType":  "MD5",
+	}
+	return &JSAPIParams{
+		Timestamp: p["timeStamp"],
+		NonceStr:  p["nonceStr"],
+		PrePayID:  prepayID,
+		SignType:  p["signType"],
+		Package:   p["package"],
+		PaySign:   p.Sign(c.Key),
+	}, nil
+}
+
+// ParsePaidNotify 解析支付结果通知。 doc: https://pay.weixin.qq.com/wiki/doc/api/jsapi.php?chapter=9_7
+func (c *Client) ParsePaidNotify(header http.Header, body []byte) (*PaidNotify, error) {
+	p, err := ParseParams(body)
+	if err != nil {
+		return nil, err
+	}
+	if p["return_code"] != codeSuccess {
+		return nil, fmt.Errorf("return_code: [%s], return_msg: [%s]", p["return_code"], p["return_msg"])
+	}
+	if !p.VerifySign(c.Key) {
+		return nil, errors.New("failed to verify notify sign")
+	}
+	if p["mch_id"] != c.MchID {
+		return nil, fmt.Errorf("mch_id [%s] mismatched", p["mch_id"])
+	}
+	if p["result_code"] != codeSuccess {
+		return nil, &ResultError{Code: p["err_code"], Desc: p["err_code_des"]}
+	}
+	totalFee, err := strconv.ParseInt(p["total_fee"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid total_fee [%s]", p["total_fee"])
+	}
+	return &PaidNotify{
+		OutTradeNo:    p["out_trade_no"],
+		TransactionID: p["transaction_id"],
+		TotalFee:      money.Fen(totalFee),
+		TimeEnd:       ParseTime(p["time_end"]),
+	}, nil
+}
+
+func (c *Client) WriteAck(w http.ResponseWriter, ok bool, msg string) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	_, _ = io.WriteString(w, Ack(ok, msg))
+}
@@ -0,0 +1,52 @@
+package wxpay
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func TestJSAPIParams(t *testing.T) {
+	params, err := newTestClient("").JSAPIParams("wx28101530123456789abcdef0123456700")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := Params{
+		"appId":     "wx0001",
+		"timeStamp": params.Timestamp,
+		"nonceStr":  params.NonceStr,
+		"package":   "prepay_id=wx28101530123456789abcdef0123456700",
+		"signType":  "MD5",
+	}
+	if params.Package != p["package"] || params.PaySign != p.Sign(testKey) {
+		t.Errorf("unexpected jsapi params: %+v", params)
+	}
+}
+
+func TestParsePaidNotify(t *testing.T) {
+	p := Params{
+		"return_code":    "SUCCESS",
+		"result_code":    "SUCCESS",
+		"appid":          "wx0001",
+		"mch_id":         "1600000001",
+		"out_trade_no":   "1267034018434977792",
+		"transaction_id": "4200000512202005283917925386",
+		"total_fee":      "39900",
+		"time_end":       "20200528101530",
+	}
+	p["sign"] = p.Sign(testKey)
+	body, _ := xml.Marshal(p)
+
+	n, err := newTestClient("").ParsePaidNotify(nil, body)
+	if err != nil {
+		t.Fatalf("parse paid notify failed: %v", err)
+	}
+	if n.OutTradeNo != "1267034018434977792" || n.TotalFee != 39900 || n.TimeEnd != 1590632130 {
+		t.Errorf("unexpected paid notify: %+v", n)
+	}
+
+	p["total_fee"] = "1"
+	body, _ = xml.Marshal(p)
+	if _, err = newTestClient("").ParsePaidNotify(nil, body); err == nil {
+		t.Error("tampered notify should be rejected")
+	}
+}
@@ -0,0 +1,158 @@
+package wxpay
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"mk-api/server/util/money"
+)
+
+// 退款状态
+const (
+	RefundStatusSuccess    = "SUCCESS"     // 退款成功
+	RefundStatusProcessing = "PROCESSING"  // 退款处理中
+	RefundStatusChange     = "CHANGE"      // 退款异常
+	RefundStatusClosed     = "REFUNDCLOSE" // 退款关闭
+)
+
+type RefundParams struct {
+	TransactionID string
+	OutTradeNo    string
+	OutRefundNo   string
+	// 单位分
+	TotalFee  money.Fen
+	RefundFee money.Fen
+	// 退款原因, 会出现在用户收到的退款消息中
+	RefundDesc string
+	// 退款结果通知地址
+	NotifyURL string
+}
+
+type RefundResult struct {
+	RefundID    string
+	OutRefundNo string
+	RefundFee   money.Fen
+}
+
+// Refund 申请退款, 需要商户证书。 doc: https://pay.weixin.qq.com/wiki/doc/api/jsapi.php?chapter=9_4
+func (c *Client) Refund(p *RefundParams) (*RefundResult, error) {
+	params := Params{
+		"transaction_id": p.TransactionID,
+		"out_trade_no":   p.OutTradeNo,
+		"out_refund_no":  p.OutRefundNo,
+		"total_fee":      strconv.FormatInt(int64(p.TotalFee), 10),
+		"refund_fee":     strconv.FormatInt(int64(p.RefundFee), 10),
+		"refund_desc":    p.RefundDesc,
+		"notify_url":     p.NotifyURL,
+	}
+	ret, err := c.post(c.TLSClient, "/secapi/pay/refund", params)
+	if err != nil {
+		return nil, err
+	}
+	refundFee, _ := strconv.ParseInt(ret["refund_fee"], 10, 64)
+	return &RefundResult{
+		RefundID:    ret["refund_id"],
+		OutRefundNo: ret["out_refund_no"],
+		RefundFee:   money.Fen(refundFee),
+	}, nil
+}
+
+// RefundNotify 退款结果通知中 req_info 解密后的内容, v3 的通知转换为同样的字段和取值
+type RefundNotify struct {
+	TransactionID       string    `xml:"transaction_id"`
+	OutTradeNo          string    `xml:"out_trade_no"`
+	RefundID            string    `xml:"refund_id"`
+	OutRefundNo         string    `xml:"out_refund_no"`
+	TotalFee            money.Fen `xml:"total_fee"`
+	RefundFee           money.Fen `xml:"refund_fee"`
+	SettlementRefundFee money.Fen `xml:"settlement_refund_fee"`
+	RefundStatus        string    `xml:"refund_status"`
+	SuccessTime         string    `xml:"success_time"`
+	RefundRecvAccout    string    `xml:"refund_recv_accout"`
+}
+
+// SuccessAt 退款成功时间戳, 未成功时为0
+func (n *RefundNotify) SuccessAt() int64 {
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", n.SuccessTime, beijing)
+	if err != nil {
+		return 0
+	}
+	return t.Unix()
+}
+
+// ParseRefundNotify 解析退款结果通知并解密 req_info。 doc: https://pay.weixin.qq.com/wiki/doc/api/jsapi.php?chapter=9_16
+func (c *Client) ParseRefundNotify(header http.Header, body []byte) (*RefundNotify, error) {
+	p, err := ParseParams(body)
+	if err != nil {
+		return nil, err
+	}
+	if p["return_code"] != codeSuccess {
+		return nil, fmt.Errorf("return_code: [%s], return_msg: [%s]", p["return_code"], p["return_msg"])
+	}
+	if p["mch_id"] != c.MchID {
+		return nil, fmt.Errorf("mch_id [%s] mismatched", p["mch_id"])
+	}
+	plain, err := DecryptReqInfo(p["req_info"], c.Key)
+	if err != nil {
+		return nil, err
+	}
+	var n RefundNotify
+	if err = xml.Unmarshal(plain, &n); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// DecryptReqInfo 对 req_info 做 base64 解码后, 以商户 key 的 md5 小写值为密钥做 AES-256-ECB 解密
+func DecryptReqInfo(reqInfo string, key string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(reqInfo)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(reqInfoKey(key))
+	if err != nil {
+		return nil, err
+	}
+	size := block.BlockSize()
+	if len(data) == 0 || len(data)%size != 0 {
+		return nil, errors.New("req_info is not a multiple of the block size")
+	}
+	plain := make([]byte, len(data))
+	for i := 0; i < len(data); i += size {
+		block.Decrypt(plain[i:i+size], data[i:i+size])
+	}
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > size || !bytes.Equal(plain[len(plain)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, errors.New("invalid req_info padding")
+	}
+	return plain[:len(plain)-pad], nil
+}
+
+// EncryptReqInfo 与 DecryptReqInfo 相反, 供假微信支付服务使用
+func EncryptReqInfo(plain []byte, key string) (string, error) {
+	block, err := aes.NewCipher(reqInfoKey(key))
+	if err != nil {
+		return "", err
+	}
+	size := block.BlockSize()
+	pad := size - len(plain)%size
+	data := append(append([]byte{}, plain...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	for i := 0; i < len(data); i += size {
+		block.Encrypt(data[i:i+size], data[i:i+size])
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+func reqInfoKey(key string) []byte {
+	sum := md5.Sum([]byte(key))
+	return []byte(hex.EncodeToString(sum[:]))
+}
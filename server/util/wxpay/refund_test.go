@@ -0,0 +1,111 @@
+package wxpay
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testKey = "192006250b4c09247ec02edce69f6a2d"
+
+// 本地的假微信支付退款接口
+func newFakeRefundServer(t *testing.T, resultCode string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/secapi/pay/refund" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		req, err := ParseParams(body)
+		if err != nil {
+			t.Fatalf("parse request failed: %v", err)
+		}
+		if !req.VerifySign(testKey) {
+			t.Errorf("request sign mismatched: %s", body)
+		}
+		resp := Params{
+			"return_code":   "SUCCESS",
+			"result_code":   resultCode,
+			"out_refund_no": req["out_refund_no"],
+			"refund_id":     "50000000382019052709732678859",
+			"refund_fee":    req["refund_fee"],
+		}
+		if resultCode != "SUCCESS" {
+			resp["err_code"] = "NOTENOUGH"
+			resp["err_code_des"] = "基本账户余额不足"
+		}
+		resp["sign"] = resp.Sign(testKey)
+		_ = xml.NewEncoder(w).Encode(resp)
+	}))
+}
+
+func newTestClient(gateway string) *Client {
+	cli, _ := NewClient(&Config{AppID: "wx0001", MchID: "1600000001", Key: testKey, Gateway: gateway})
+	return cli
+}
+
+func TestRefund(t *testing.T) {
+	srv := newFakeRefundServer(t, "SUCCESS")
+	defer srv.Close()
+
+	ret, err := newTestClient(srv.URL).Refund(&RefundParams{
+		TransactionID: "4200000512202005283917925386",
+		OutTradeNo:    "1267034018434977792",
+		OutRefundNo:   "1267034018434977793",
+		TotalFee:      39900,
+		RefundFee:     35910,
+	})
+	if err != nil {
+		t.Fatalf("refund failed: %v", err)
+	}
+	if ret.RefundFee != 35910 || ret.OutRefundNo != "1267034018434977793" || ret.RefundID == "" {
+		t.Errorf("unexpected refund result: %+v", ret)
+	}
+}
+
+func TestRefundResultFail(t *testing.T) {
+	srv := newFakeRefundServer(t, "FAIL")
+	defer srv.Close()
+
+	_, err := newTestClient(srv.URL).Refund(&RefundParams{OutTradeNo: "1", OutRefundNo: "2", TotalFee: 1, RefundFee: 1})
+	if e, ok := err.(*ResultError); !ok || e.Code != "NOTENOUGH" {
+		t.Errorf("expect ResultError NOTENOUGH, got %v", err)
+	}
+}
+
+func TestParseRefundNotify(t *testing.T) {
+	plain := `<root><out_refund_no><![CDATA[1267034018434977793]]></out_refund_no>` +
+		`<out_trade_no><![CDATA[1267034018434977792]]></out_trade_no>` +
+		`<refund_id><![CDATA[50000000382019052709732678859]]></refund_id>` +
+		`<refund_fee><![CDATA[35910]]></refund_fee>` +
+		`<refund_status><![CDATA[SUCCESS]]></refund_status>` +
+		`<success_time><![CDATA[2020-06-01 10:20:30]]></success_time>` +
+		`<total_fee><![CDATA[39900]]></total_fee></root>`
+	reqInfo, err := EncryptReqInfo([]byte(plain), testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := xml.Marshal(Params{
+		"return_code": "SUCCESS",
+		"appid":       "wx0001",
+		"mch_id":      "1600000001",
+		"nonce_str":   "TeqClE3i0mvn3DrK",
+		"req_info":    reqInfo,
+	})
+
+	n, err := newTestClient("").ParseRefundNotify(nil, body)
+	if err != nil {
+		t.Fatalf("parse refund notify failed: %v", err)
+	}
+	if n.RefundStatus != RefundStatusSuccess || n.RefundFee != 35910 || n.OutRefundNo != "1267034018434977793" {
+		t.Errorf("unexpected refund notify: %+v", n)
+	}
+	if n.SuccessAt() == 0 {
+		t.Errorf("success_time not parsed: %s", n.SuccessTime)
+	}
+
+	if _, err = DecryptReqInfo(reqInfo, "another key"); err == nil {
+		t.Error("decrypt with a wrong key should fail")
+	}
+}
@@ -0,0 +1,350 @@
+package wxpay
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/silenceper/wechat/v2/util"
+)
+
+const (
+	// 应答和通知签名中的时间与本地相差超过该值时拒绝, 防止重放
+	v3SignMaxAge = 5 * time.Minute
+	// 遇到本地没有的平台证书序列号时重新下载证书的最小间隔, 防止伪造的序列号导致频繁下载
+	v3CertMinInterval = time.Minute
+)
+
+// ClientV3 微信支付 v3 接口的客户端。 请求为 json, 用商户私钥 SHA256-RSA 签名, 应答和通知用微信支付平台证书验签,
+// 平台证书和通知内容用 APIv3 密钥 AES-256-GCM 加密。 doc: https://pay.weixin.qq.com/wiki/doc/apiv3/wechatpay/wechatpay-1.shtml
+type ClientV3 struct {
+	AppID    string
+	MchID    string
+	APIv3Key string
+	// 商户证书序列号
+	SerialNo   string
+	PrivateKey *rsa.PrivateKey
+	Gateway    string
+	HTTPClient *http.Client
+
+	mu sync.RWMutex
+	// 平台证书, 按序列号索引。 微信更换证书期间新旧证书同时有效
+	certs map[string]*x509.Certificate
+	// 上次下载平台证书的时间
+	certsAt time.Time
+}
+
+// NewClientV3 平台证书在第一次验签时下载, 创建客户端不访问微信
+func NewClientV3(cfg *Config) (*ClientV3, error) {
+	if len(cfg.APIv3Key) != 32 {
+		return nil, errors.New("APIv3 key must be 32 bytes")
+	}
+	if cfg.SerialNo == "" {
+		return nil, errors.New("merchant cert serial no is required")
+	}
+	pemData, err := ioutil.ReadFile(cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("read merchant private key failed, err: [%s]", err.Error())
+	}
+	key, err := ParsePrivateKey(pemData)
+	if err != nil {
+		return nil, err
+	}
+	cli := &ClientV3{
+		AppID:      cfg.AppID,
+		MchID:      cfg.MchID,
+		APIv3Key:   cfg.APIv3Key,
+		SerialNo:   cfg.SerialNo,
+		PrivateKey: key,
+		Gateway:    cfg.Gateway,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+	if cli.Gateway == "" {
+		cli.Gateway = DefaultGateway
+	}
+	return cli, nil
+}
+
+// ParsePrivateKey 解析 PEM 格式的商户私钥, 商户平台下载的 apiclient_key.pem 为 PKCS#8, 兼容 PKCS#1
+func ParsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("merchant private key is not PEM encoded")
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("merchant private key is not RSA")
+	}
+	return rsaKey, nil
+}
+
+// v3Error v3 接口失败时返回的 json
+type v3Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// v3 中含义相同但名称不同的错误码, 统一成 v2 的错误码, 业务代码只需判断一种
+var v3ErrCodes = map[string]string{
+	"ORDER_NOT_EXIST": ErrCodeOrderNotExist,
+	"ORDER_CLOSED":    ErrCodeOrderClosed,
+}
+
+// call 签名并发送请求, 验证应答签名后把 json 应答解析到 out, out 为 nil 时忽略应答内容
+func (c *ClientV3) call(method, path string, in, out interface{}) error {
+	header, data, err := c.send(method, c.Gateway+path, in)
+	if err != nil {
+		return err
+	}
+	if err = c.verify(header, data); err != nil {
+		return err
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// send 签名并发送请求, 返回未验签的应答。 非 2xx 的应答转换为 ResultError
+func (c *ClientV3) send(method, rawURL string, in interface{}) (http.Header, []byte, error) {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return nil, nil, err
+		}
+	}
+	req, err := http.NewRequest(method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	auth, err := c.authorization(method, req.URL.RequestURI(), body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var e v3Error
+		if json.Unmarshal(data, &e) != nil || e.Code == "" {
+			return nil, nil, fmt.Errorf("http status: [%d], body: [%s]", resp.StatusCode, data)
+		}
+		if code, ok := v3ErrCodes[e.Code]; ok {
+			e.Code = code
+		}
+		return nil, nil, &ResultError{Code: e.Code, Desc: e.Message}
+	}
+	return resp.Header, data, nil
+}
+
+// authorization 请求签名串为 方法\nURL\n时间戳\n随机串\n请求体\n。 doc: https://pay.weixin.qq.com/wiki/doc/apiv3/wechatpay/wechatpay4_0.shtml
+func (c *ClientV3) authorization(method, uri string, body []byte) (string, error) {
+	nonce := util.RandomStr(32)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature, err := c.sign(method + "\n" + uri + "\n" + timestamp + "\n" + nonce + "\n" + string(body) + "\n")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`WECHATPAY2-SHA256-RSA2048 mchid="%s",nonce_str="%s",signature="%s",timestamp="%s",serial_no="%s"`,
+		c.MchID, nonce, signature, timestamp, c.SerialNo), nil
+}
+
+// sign 商户私钥 SHA256 with RSA 签名, 返回 base64
+func (c *ClientV3) sign(message string) (string, error) {
+	sum := sha256.Sum256([]byte(message))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, c.PrivateKey, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// verify 用 Wechatpay-Serial 指定的平台证书验证应答或通知的签名
+func (c *ClientV3) verify(header http.Header, body []byte) error {
+	cert, err := c.platformCert(header.Get("Wechatpay-Serial"))
+	if err != nil {
+		return err
+	}
+	return verifySign(cert, header, body)
+}
+
+// verifySign 应答签名串为 时间戳\n随机串\n应答体\n。 doc: https://pay.weixin.qq.com/wiki/doc/apiv3/wechatpay/wechatpay4_1.shtml
+func verifySign(cert *x509.Certificate, header http.Header, body []byte) error {
+	timestamp := header.Get("Wechatpay-Timestamp")
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid Wechatpay-Timestamp [%s]", timestamp)
+	}
+	if age := time.Since(time.Unix(ts, 0)); age > v3SignMaxAge || age < -v3SignMaxAge {
+		return fmt.Errorf("Wechatpay-Timestamp [%s] expired", timestamp)
+	}
+	signature, err := base64.StdEncoding.DecodeString(header.Get("Wechatpay-Signature"))
+	if err != nil {
+		return fmt.Errorf("invalid Wechatpay-Signature, err: [%s]", err.Error())
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("platform cert is not RSA")
+	}
+	sum := sha256.Sum256([]byte(timestamp + "\n" + header.Get("Wechatpay-Nonce") + "\n" + string(body) + "\n"))
+	if err = rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], signature); err != nil {
+		return errors.New("failed to verify wechatpay signature")
+	}
+	return nil
+}
+
+// platformCert 按序列号取平台证书, 本地没有或已过期时重新下载
+func (c *ClientV3) platformCert(serialNo string) (*x509.Certificate, error) {
+	c.mu.RLock()
+	cert := c.certs[serialNo]
+	c.mu.RUnlock()
+	if cert != nil && time.Now().Before(cert.NotAfter) {
+		return cert, nil
+	}
+	if err := c.downloadCerts(); err != nil {
+		return nil, err
+	}
+	c.mu.RLock()
+	cert = c.certs[serialNo]
+	c.mu.RUnlock()
+	if cert == nil || !time.Now().Before(cert.NotAfter) {
+		return nil, fmt.Errorf("platform cert [%s] not found or expired", serialNo)
+	}
+	return cert, nil
+}
+
+// downloadCerts 下载平台证书。 证书列表的应答也需要验签, 签名用的证书必须在列表中。
+// doc: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/wechatpay5_1.shtml
+func (c *ClientV3) downloadCerts() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.certsAt) < v3CertMinInterval {
+		return nil
+	}
+	c.certsAt = time.Now()
+
+	header, data, err := c.send(http.MethodGet, c.Gateway+"/v3/certificates", nil)
+	if err != nil {
+		return fmt.Errorf("download platform certs failed, err: [%s]", err.Error())
+	}
+	var ret struct {
+		Data []struct {
+			SerialNo           string            `json:"serial_no"`
+			EncryptCertificate encryptedResource `json:"encrypt_certificate"`
+		} `json:"data"`
+	}
+	if err = json.Unmarshal(data, &ret); err != nil {
+		return err
+	}
+	certs := make(map[string]*x509.Certificate, len(ret.Data))
+	for _, d := range ret.Data {
+		plain, err := d.EncryptCertificate.decrypt(c.APIv3Key)
+		if err != nil {
+			return fmt.Errorf("decrypt platform cert [%s] failed, err: [%s]", d.SerialNo, err.Error())
+		}
+		block, _ := pem.Decode(plain)
+		if block == nil {
+			return fmt.Errorf("platform cert [%s] is not PEM encoded", d.SerialNo)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return err
+		}
+		certs[d.SerialNo] = cert
+	}
+	cert := certs[header.Get("Wechatpay-Serial")]
+	if cert == nil {
+		return fmt.Errorf("platform cert list is signed by unknown cert [%s]", header.Get("Wechatpay-Serial"))
+	}
+	if err = verifySign(cert, header, data); err != nil {
+		return err
+	}
+	c.certs = certs
+	return nil
+}
+
+// encryptedResource 用 APIv3 密钥 AEAD_AES_256_GCM 加密的平台证书或通知内容
+type encryptedResource struct {
+	Algorithm      string `json:"algorithm"`
+	Ciphertext     string `json:"ciphertext"`
+	AssociatedData string `json:"associated_data"`
+	Nonce          string `json:"nonce"`
+}
+
+func (r *encryptedResource) decrypt(key string) ([]byte, error) {
+	if r.Algorithm != "AEAD_AES_256_GCM" {
+		return nil, fmt.Errorf("unsupported algorithm [%s]", r.Algorithm)
+	}
+	return DecryptAESGCM(key, r.Nonce, r.AssociatedData, r.Ciphertext)
+}
+
+// DecryptAESGCM 对 base64 的密文做 AES-256-GCM 解密, 密文末尾16字节为认证标签
+func DecryptAESGCM(key, nonce, associatedData, ciphertext string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("nonce must be %d bytes", gcm.NonceSize())
+	}
+	return gcm.Open(nil, []byte(nonce), data, []byte(associatedData))
+}
+
+// EncryptAESGCM 与 DecryptAESGCM 相反, 供假微信支付服务使用
+func EncryptAESGCM(key, nonce, associatedData string, plain []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return "", fmt.Errorf("nonce must be %d bytes", gcm.NonceSize())
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nil, []byte(nonce), plain, []byte(associatedData))), nil
+}
+
+func newGCM(key string) (cipher.AEAD, error) {
+	block, err := aes.NewCipher([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,282 @@
+package wxpay
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/silenceper/wechat/v2/util"
+	"mk-api/server/util/money"
+)
+
+// v3 通知的事件类型
+const (
+	eventTransactionSuccess = "TRANSACTION.SUCCESS"
+	eventRefundPrefix       = "REFUND."
+)
+
+// v3 退款状态与 v2 名称不同的取值
+var v3RefundStatus = map[string]string{
+	"CLOSED":   RefundStatusClosed,
+	"ABNORMAL": RefundStatusChange,
+}
+
+type v3Amount struct {
+	Total    money.Fen `json:"total,omitempty"`
+	Refund   money.Fen `json:"refund,omitempty"`
+	Currency string    `json:"currency,omitempty"`
+}
+
+type v3Transaction struct {
+	MchID         string   `json:"mchid"`
+	OutTradeNo    string   `json:"out_trade_no"`
+	TransactionID string   `json:"transaction_id"`
+	TradeState    string   `json:"trade_state"`
+	SuccessTime   string   `json:"success_time"`
+	Amount        v3Amount `json:"amount"`
+}
+
+type v3Refund struct {
+	MchID               string   `json:"mchid"`
+	OutTradeNo          string   `json:"out_trade_no"`
+	TransactionID       string   `json:"transaction_id"`
+	OutRefundNo         string   `json:"out_refund_no"`
+	RefundID            string   `json:"refund_id"`
+	RefundStatus        string   `json:"refund_status"`
+	SuccessTime         string   `json:"success_time"`
+	UserReceivedAccount string   `json:"user_received_account"`
+	Amount              v3Amount `json:"amount"`
+}
+
+// Prepay JSAPI 下单。 doc: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_1.shtml
+func (c *ClientV3) Prepay(p *PrepayParams) (string, error) {
+	in := map[string]interface{}{
+		"appid":        c.AppID,
+		"mchid":        c.MchID,
+		"description":  p.Description,
+		"out_trade_no": p.OutTradeNo,
+		"notify_url":   p.NotifyURL,
+		"amount":       v3Amount{Total: p.TotalFee, Currency: "CNY"},
+		"payer":        map[string]string{"openid": p.OpenID},
+		"scene_info":   map[string]string{"payer_client_ip": p.ClientIP},
+	}
+	if p.Attach != "" {
+		in["attach"] = p.Attach
+	}
+	var ret struct {
+		PrepayID string `json:"prepay_id"`
+	}
+	if err := c.call(http.MethodPost, "/v3/pay/transactions/jsapi", in, &ret); err != nil {
+		return "", err
+	}
+	return ret.PrepayID, nil
+}
+
+// JSAPIParams 签名串为 appId\n时间戳\n随机串\npackage\n, 用商户私钥签名。 doc: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_4.shtml
+func (c *ClientV3) JSAPIParams(prepayID string) (*JSAPIParams, error) {
+	params := &JSAPIParams{
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		NonceStr:  util.RandomStr(32),
+		PrePayID:  prepayID,
+		SignType:  "RSA",
+		Package:   "prepay_id=" + prepayID,
+	}
+	sign, err := c.sign(c.AppID + "\n" + params.Timestamp + "\n" + params.NonceStr + "\n" + params.Package + "\n")
+	if err != nil {
+		return nil, err
+	}
+	params.PaySign = sign
+	return params, nil
+}
+
+// ParsePaidNotify doc: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_5.shtml
+func (c *ClientV3) ParsePaidNotify(header http.Header, body []byte) (*PaidNotify, error) {
+	var t v3Transaction
+	eventType, err := c.parseNotify(header, body, &t)
+	if err != nil {
+		return nil, err
+	}
+	if eventType != eventTransactionSuccess || t.TradeState != TradeStateSuccess {
+		return nil, fmt.Errorf("event_type: [%s], trade_state: [%s]", eventType, t.TradeState)
+	}
+	if t.MchID != c.MchID {
+		return nil, fmt.Errorf("mchid [%s] mismatched", t.MchID)
+	}
+	return &PaidNotify{
+		OutTradeNo:    t.OutTradeNo,
+		TransactionID: t.TransactionID,
+		TotalFee:      t.Amount.Total,
+		TimeEnd:       parseRFC3339(t.SuccessTime),
+	}, nil
+}
+
+// QueryOrder doc: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_2.shtml
+func (c *ClientV3) QueryOrder(outTradeNo string) (*OrderQueryResult, error) {
+	var t v3Transaction
+	path := "/v3/pay/transactions/out-trade-no/" + url.PathEscape(outTradeNo) + "?mchid=" + url.QueryEscape(c.MchID)
+	if err := c.call(http.MethodGet, path, nil, &t); err != nil {
+		return nil, err
+	}
+	return &OrderQueryResult{
+		TradeState:    t.TradeState,
+		TransactionID: t.TransactionID,
+		OutTradeNo:    t.OutTradeNo,
+		TotalFee:      t.Amount.Total,
+		TimeEnd:       parseRFC3339(t.SuccessTime),
+	}, nil
+}
+
+// CloseOrder 成功时应答为 204 无内容。 doc: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_3.shtml
+func (c *ClientV3) CloseOrder(outTradeNo string) error {
+	path := "/v3/pay/transactions/out-trade-no/" + url.PathEscape(outTradeNo) + "/close"
+	return c.call(http.MethodPost, path, map[string]string{"mchid": c.MchID}, nil)
+}
+
+// Refund v3 退款不需要双向证书。 doc: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_9.shtml
+func (c *ClientV3) Refund(p *RefundParams) (*RefundResult, error) {
+	in := map[string]interface{}{
+		"out_refund_no": p.OutRefundNo,
+		"amount":        v3Amount{Total: p.TotalFee, Refund: p.RefundFee, Currency: "CNY"},
+	}
+	// 两者都有时以 transaction_id 为准
+	if p.TransactionID != "" {
+		in["transaction_id"] = p.TransactionID
+	} else {
+		in["out_trade_no"] = p.OutTradeNo
+	}
+	if p.RefundDesc != "" {
+		in["reason"] = p.RefundDesc
+	}
+	if p.NotifyURL != "" {
+		in["notify_url"] = p.NotifyURL
+	}
+	var ret struct {
+		RefundID    string   `json:"refund_id"`
+		OutRefundNo string   `json:"out_refund_no"`
+		Amount      v3Amount `json:"amount"`
+	}
+	if err := c.call(http.MethodPost, "/v3/refund/domestic/refunds", in, &ret); err != nil {
+		return nil, err
+	}
+	return &RefundResult{
+		RefundID:    ret.RefundID,
+		OutRefundNo: ret.OutRefundNo,
+		RefundFee:   ret.Amount.Refund,
+	}, nil
+}
+
+// ParseRefundNotify 转换为 v2 的退款通知字段和取值。 doc: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_11.shtml
+func (c *ClientV3) ParseRefundNotify(header http.Header, body []byte) (*RefundNotify, error) {
+	var r v3Refund
+	eventType, err := c.parseNotify(header, body, &r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(eventType, eventRefundPrefix) {
+		return nil, fmt.Errorf("unexpected event_type [%s]", eventType)
+	}
+	if r.MchID != c.MchID {
+		return nil, fmt.Errorf("mchid [%s] mismatched", r.MchID)
+	}
+	status := r.RefundStatus
+	if s, ok := v3RefundStatus[status]; ok {
+		status = s
+	}
+	n := &RefundNotify{
+		TransactionID:    r.TransactionID,
+		OutTradeNo:       r.OutTradeNo,
+		RefundID:         r.RefundID,
+		OutRefundNo:      r.OutRefundNo,
+		TotalFee:         r.Amount.Total,
+		RefundFee:        r.Amount.Refund,
+		RefundStatus:     status,
+		RefundRecvAccout: r.UserReceivedAccount,
+	}
+	if at := parseRFC3339(r.SuccessTime); at != 0 {
+		n.SuccessTime = time.Unix(at, 0).In(beijing).Format("2006-01-02 15:04:05")
+	}
+	return n, nil
+}
+
+// DownloadBill 先申请交易账单得到下载地址, 再下载账单文件。 账单文件的应答没有签名, 用申请时返回的哈希值校验。
+// doc: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_6.shtml
+func (c *ClientV3) DownloadBill(date string) ([]byte, error) {
+	day, err := ParseBillDate(date)
+	if err != nil {
+		return nil, err
+	}
+	var ret struct {
+		HashType    string `json:"hash_type"`
+		HashValue   string `json:"hash_value"`
+		DownloadURL string `json:"download_url"`
+	}
+	err = c.call(http.MethodGet, "/v3/bill/tradebill?bill_date="+day.Format("2006-01-02")+"&bill_type=ALL", nil, &ret)
+	if IsErrCode(err, "NO_STATEMENT_EXIST") {
+		return nil, ErrNoBill
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ret.HashType != "SHA1" {
+		return nil, fmt.Errorf("unsupported hash_type [%s]", ret.HashType)
+	}
+	_, data, err := c.send(http.MethodGet, ret.DownloadURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum(data)
+	if !strings.EqualFold(hex.EncodeToString(sum[:]), ret.HashValue) {
+		return nil, errors.New("bill hash mismatched")
+	}
+	return data, nil
+}
+
+// WriteAck 成功时应答 204, 失败时应答 5xx 和错误信息, 微信会重发通知。 doc: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_5.shtml
+func (c *ClientV3) WriteAck(w http.ResponseWriter, ok bool, msg string) {
+	if ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	_ = json.NewEncoder(w).Encode(v3Error{Code: codeFail, Message: msg})
+}
+
+// parseNotify 验签并解密通知的 resource 到 out, 返回事件类型
+func (c *ClientV3) parseNotify(header http.Header, body []byte, out interface{}) (string, error) {
+	if err := c.verify(header, body); err != nil {
+		return "", err
+	}
+	var n struct {
+		ID        string            `json:"id"`
+		EventType string            `json:"event_type"`
+		Resource  encryptedResource `json:"resource"`
+	}
+	if err := json.Unmarshal(body, &n); err != nil {
+		return "", err
+	}
+	plain, err := n.Resource.decrypt(c.APIv3Key)
+	if err != nil {
+		return "", fmt.Errorf("decrypt notify [%s] failed, err: [%s]", n.ID, err.Error())
+	}
+	if err = json.Unmarshal(plain, out); err != nil {
+		return "", err
+	}
+	return n.EventType, nil
+}
+
+// parseRFC3339 v3 的时间格式, 返回时间戳, 格式错误或为空时为0
+func parseRFC3339(s string) int64 {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0
+	}
+	return t.Unix()
+}
@@ -0,0 +1,368 @@
+package wxpay
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testAPIv3Key       = "0c9f3e1a7b2d4c6e8f0a1b3c5d7e9f1a"
+	testMerchantSerial = "3775B6A45ACD588826D15E583A95F5DD5C4B1D2E"
+	testPlatformSerial = "5157F09EFDC096DE15EBE81A47057A7232F1B8E1"
+)
+
+var (
+	testKeysOnce    sync.Once
+	testMerchantKey *rsa.PrivateKey
+	testPlatformKey *rsa.PrivateKey
+	testPlatformPEM []byte
+)
+
+// 商户私钥和自签名的平台证书, 所有用例共用, 避免重复生成 RSA 密钥
+func testKeys(t *testing.T) {
+	testKeysOnce.Do(func() {
+		var err error
+		if testMerchantKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			t.Fatal(err)
+		}
+		if testPlatformKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			t.Fatal(err)
+		}
+		serial, _ := new(big.Int).SetString(testPlatformSerial, 16)
+		tmpl := &x509.Certificate{
+			SerialNumber: serial,
+			Subject:      pkix.Name{CommonName: "Tenpay.com Root CA"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(24 * time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &testPlatformKey.PublicKey, testPlatformKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testPlatformPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	})
+}
+
+// fakeV3 本地的假微信支付 v3 接口, paid 中的订单为已支付, 其余为未支付
+type fakeV3 struct {
+	t         *testing.T
+	srv       *httptest.Server
+	paid      map[string]bool
+	bill      []byte
+	certCalls int
+}
+
+var authRegexp = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+func newFakeV3(t *testing.T, paid map[string]bool) (*fakeV3, *ClientV3) {
+	testKeys(t)
+	f := &fakeV3{t: t, paid: paid, bill: readBill(t, "bill_20200528.csv")}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.handle))
+	cli := &ClientV3{
+		AppID:      "wx0001",
+		MchID:      "1600000001",
+		APIv3Key:   testAPIv3Key,
+		SerialNo:   testMerchantSerial,
+		PrivateKey: testMerchantKey,
+		Gateway:    f.srv.URL,
+		HTTPClient: http.DefaultClient,
+	}
+	return f, cli
+}
+
+func (f *fakeV3) handle(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	f.verifyRequest(r, body)
+
+	path := r.URL.Path
+	switch {
+	case path == "/v3/certificates":
+		f.certCalls++
+		nonce := "4de73afd28b6"
+		ciphertext, err := EncryptAESGCM(testAPIv3Key, nonce, "certificate", testPlatformPEM)
+		if err != nil {
+			f.t.Fatal(err)
+		}
+		f.reply(w, http.StatusOK, map[string]interface{}{"data": []interface{}{map[string]interface{}{
+			"serial_no": testPlatformSerial,
+			"encrypt_certificate": encryptedResource{
+				Algorithm: "AEAD_AES_256_GCM", Nonce: nonce, AssociatedData: "certificate", Ciphertext: ciphertext,
+			},
+		}}})
+	case path == "/v3/pay/transactions/jsapi":
+		var in struct {
+			MchID  string   `json:"mchid"`
+			Amount v3Amount `json:"amount"`
+			Payer  struct {
+				OpenID string `json:"openid"`
+			} `json:"payer"`
+		}
+		_ = json.Unmarshal(body, &in)
+		if in.MchID != "1600000001" || in.Amount.Total != 39900 || in.Payer.OpenID == "" {
+			f.t.Errorf("unexpected prepay request: %s", body)
+		}
+		f.reply(w, http.StatusOK, map[string]string{"prepay_id": "wx28101530123456789abcdef0123456700"})
+	case strings.HasSuffix(path, "/close"):
+		if f.paid[strings.TrimSuffix(strings.TrimPrefix(path, "/v3/pay/transactions/out-trade-no/"), "/close")] {
+			f.reply(w, http.StatusBadRequest, v3Error{Code: ErrCodeOrderPaid, Message: "订单已支付"})
+			return
+		}
+		f.reply(w, http.StatusNoContent, nil)
+	case strings.HasPrefix(path, "/v3/pay/transactions/out-trade-no/"):
+		outTradeNo := strings.TrimPrefix(path, "/v3/pay/transactions/out-trade-no/")
+		if r.URL.Query().Get("mchid") != "1600000001" {
+			f.t.Errorf("mchid missing in query: %s", r.URL)
+		}
+		if outTradeNo == "1267034018434977700" {
+			f.reply(w, http.StatusNotFound, v3Error{Code: "ORDER_NOT_EXIST", Message: "订单不存在"})
+			return
+		}
+		t := v3Transaction{MchID: "1600000001", OutTradeNo: outTradeNo, TradeState: TradeStateNotPay}
+		if f.paid[outTradeNo] {
+			t.TradeState = TradeStateSuccess
+			t.TransactionID = "4200000512202005283917925386"
+			t.SuccessTime = "2020-05-28T10:15:30+08:00"
+			t.Amount.Total = 39900
+		}
+		f.reply(w, http.StatusOK, t)
+	case path == "/v3/bill/tradebill":
+		if r.URL.Query().Get("bill_date") != "2020-05-28" {
+			f.reply(w, http.StatusBadRequest, v3Error{Code: "NO_STATEMENT_EXIST", Message: "账单不存在"})
+			return
+		}
+		sum := sha1.Sum(f.bill)
+		f.reply(w, http.StatusOK, map[string]string{
+			"hash_type":    "SHA1",
+			"hash_value":   hex.EncodeToString(sum[:]),
+			"download_url": f.srv.URL + "/v3/billdownload/file?token=6XIv5TUPto7pByrTQKhd6kwvyKLG2uY2wMMR8cNXqaA_Cv_isgaUtBzp4QtiozLO",
+		})
+	case path == "/v3/billdownload/file":
+		// 账单文件不签名
+		_, _ = w.Write(f.bill)
+	default:
+		f.t.Errorf("unexpected path: %s", path)
+	}
+}
+
+// verifyRequest 用商户公钥验证请求签名
+func (f *fakeV3) verifyRequest(r *http.Request, body []byte) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "WECHATPAY2-SHA256-RSA2048 ") {
+		f.t.Errorf("unexpected authorization: %s", auth)
+		return
+	}
+	fields := make(map[string]string)
+	for _, m := range authRegexp.FindAllStringSubmatch(auth, -1) {
+		fields[m[1]] = m[2]
+	}
+	if fields["mchid"] != "1600000001" || fields["serial_no"] != testMerchantSerial {
+		f.t.Errorf("unexpected authorization: %s", auth)
+	}
+	message := r.Method + "\n" + r.URL.RequestURI() + "\n" + fields["timestamp"] + "\n" + fields["nonce_str"] + "\n" + string(body) + "\n"
+	signature, _ := base64.StdEncoding.DecodeString(fields["signature"])
+	sum := sha256.Sum256([]byte(message))
+	if err := rsa.VerifyPKCS1v15(&testMerchantKey.PublicKey, crypto.SHA256, sum[:], signature); err != nil {
+		f.t.Errorf("request signature mismatched, %s %s", r.Method, r.URL)
+	}
+}
+
+func (f *fakeV3) reply(w http.ResponseWriter, status int, v interface{}) {
+	var body []byte
+	if v != nil {
+		body, _ = json.Marshal(v)
+	}
+	for k, vs := range signedHeader(f.t, body) {
+		w.Header()[k] = vs
+	}
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+// signedHeader 用平台私钥对应答或通知签名
+func signedHeader(t *testing.T, body []byte) http.Header {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := "fdasflkja484w"
+	sum := sha256.Sum256([]byte(timestamp + "\n" + nonce + "\n" + string(body) + "\n"))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, testPlatformKey, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := make(http.Header)
+	h.Set("Wechatpay-Serial", testPlatformSerial)
+	h.Set("Wechatpay-Timestamp", timestamp)
+	h.Set("Wechatpay-Nonce", nonce)
+	h.Set("Wechatpay-Signature", base64.StdEncoding.EncodeToString(signature))
+	return h
+}
+
+// testNotify 构造加密并签名的回调通知
+func testNotify(t *testing.T, eventType string, resource interface{}) (http.Header, []byte) {
+	plain, _ := json.Marshal(resource)
+	nonce := "fdasfjihihih"
+	ciphertext, err := EncryptAESGCM(testAPIv3Key, nonce, "transaction", plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"id":            "EV-2018022511223320873",
+		"event_type":    eventType,
+		"resource_type": "encrypt-resource",
+		"resource": encryptedResource{
+			Algorithm: "AEAD_AES_256_GCM", Nonce: nonce, AssociatedData: "transaction", Ciphertext: ciphertext,
+		},
+	})
+	return signedHeader(t, body), body
+}
+
+func TestV3Prepay(t *testing.T) {
+	f, cli := newFakeV3(t, nil)
+	defer f.srv.Close()
+
+	prepayID, err := cli.Prepay(&PrepayParams{
+		OutTradeNo:  "1267034018434977792",
+		TotalFee:    39900,
+		Description: "迈康-体检套餐",
+		OpenID:      "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o",
+		ClientIP:    "127.0.0.1",
+		NotifyURL:   "https://api.example.com/pay/wechat_callback",
+	})
+	if err != nil {
+		t.Fatalf("prepay failed: %v", err)
+	}
+	if prepayID == "" {
+		t.Fatal("prepay_id is empty")
+	}
+
+	params, err := cli.JSAPIParams(prepayID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.SignType != "RSA" || params.Package != "prepay_id="+prepayID {
+		t.Errorf("unexpected jsapi params: %+v", params)
+	}
+	signature, _ := base64.StdEncoding.DecodeString(params.PaySign)
+	sum := sha256.Sum256([]byte("wx0001\n" + params.Timestamp + "\n" + params.NonceStr + "\n" + params.Package + "\n"))
+	if err = rsa.VerifyPKCS1v15(&testMerchantKey.PublicKey, crypto.SHA256, sum[:], signature); err != nil {
+		t.Errorf("paySign mismatched: %v", err)
+	}
+	if f.certCalls != 1 {
+		t.Errorf("platform certs downloaded %d times", f.certCalls)
+	}
+}
+
+func TestV3QueryNCloseOrder(t *testing.T) {
+	f, cli := newFakeV3(t, map[string]bool{"1267034018434977792": true})
+	defer f.srv.Close()
+
+	ret, err := cli.QueryOrder("1267034018434977792")
+	if err != nil {
+		t.Fatalf("query order failed: %v", err)
+	}
+	// 2020-05-28 10:15:30 +0800
+	if !ret.Paid() || ret.TotalFee != 39900 || ret.TimeEnd != 1590632130 {
+		t.Errorf("unexpected query result: %+v", ret)
+	}
+	if _, err = cli.QueryOrder("1267034018434977700"); !IsErrCode(err, ErrCodeOrderNotExist) {
+		t.Errorf("expect ORDERNOTEXIST, got %v", err)
+	}
+
+	if err = cli.CloseOrder("1267034018434977799"); err != nil {
+		t.Errorf("close unpaid order failed: %v", err)
+	}
+	if err = cli.CloseOrder("1267034018434977792"); !IsErrCode(err, ErrCodeOrderPaid) {
+		t.Errorf("expect ORDERPAID, got %v", err)
+	}
+}
+
+func TestV3ParsePaidNotify(t *testing.T) {
+	f, cli := newFakeV3(t, nil)
+	defer f.srv.Close()
+
+	header, body := testNotify(t, "TRANSACTION.SUCCESS", v3Transaction{
+		MchID:         "1600000001",
+		OutTradeNo:    "1267034018434977792",
+		TransactionID: "4200000512202005283917925386",
+		TradeState:    TradeStateSuccess,
+		SuccessTime:   "2020-05-28T10:15:30+08:00",
+		Amount:        v3Amount{Total: 39900},
+	})
+	n, err := cli.ParsePaidNotify(header, body)
+	if err != nil {
+		t.Fatalf("parse paid notify failed: %v", err)
+	}
+	if n.OutTradeNo != "1267034018434977792" || n.TotalFee != 39900 || n.TimeEnd != 1590632130 {
+		t.Errorf("unexpected paid notify: %+v", n)
+	}
+
+	// 篡改后验签失败
+	tampered := bytes.Replace(body, []byte("TRANSACTION.SUCCESS"), []byte("TRANSACTION.SUCCES_"), 1)
+	if _, err = cli.ParsePaidNotify(header, tampered); err == nil {
+		t.Error("tampered notify should be rejected")
+	}
+	if _, err = DecryptAESGCM("another key of exactly 32 bytes!", "fdasfjihihih", "transaction", "AAAAAAAAAAAAAAAAAAAAAA=="); err == nil {
+		t.Error("decrypt with a wrong key should fail")
+	}
+}
+
+func TestV3ParseRefundNotify(t *testing.T) {
+	f, cli := newFakeV3(t, nil)
+	defer f.srv.Close()
+
+	header, body := testNotify(t, "REFUND.SUCCESS", v3Refund{
+		MchID:        "1600000001",
+		OutTradeNo:   "1267034018434977792",
+		OutRefundNo:  "1267034018434977793",
+		RefundID:     "50000000382019052709732678859",
+		RefundStatus: "SUCCESS",
+		SuccessTime:  "2020-06-01T10:20:30+08:00",
+		Amount:       v3Amount{Total: 39900, Refund: 35910},
+	})
+	n, err := cli.ParseRefundNotify(header, body)
+	if err != nil {
+		t.Fatalf("parse refund notify failed: %v", err)
+	}
+	if n.RefundStatus != RefundStatusSuccess || n.RefundFee != 35910 || n.SuccessAt() != 1590978030 {
+		t.Errorf("unexpected refund notify: %+v", n)
+	}
+
+	header, body = testNotify(t, "REFUND.ABNORMAL", v3Refund{MchID: "1600000001", RefundStatus: "ABNORMAL"})
+	if n, err = cli.ParseRefundNotify(header, body); err != nil || n.RefundStatus != RefundStatusChange {
+		t.Errorf("ABNORMAL should be converted to CHANGE, got %+v, %v", n, err)
+	}
+}
+
+func TestV3DownloadBill(t *testing.T) {
+	f, cli := newFakeV3(t, nil)
+	defer f.srv.Close()
+
+	data, err := cli.DownloadBill("20200528")
+	if err != nil {
+		t.Fatalf("download bill failed: %v", err)
+	}
+	if _, err = ParseBill(data); err != nil {
+		t.Errorf("parse downloaded bill failed: %v", err)
+	}
+	if _, err = cli.DownloadBill("20200529"); err != ErrNoBill {
+		t.Errorf("expect ErrNoBill, got %v", err)
+	}
+}
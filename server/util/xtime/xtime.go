@@ -21,3 +21,9 @@ func TomorrowStartAt() int64 {
 	t, _ := time.ParseInLocation("2006-01-02 15:04:05", timeStr+" 23:59:59", time.Local)
 	return t.Unix() + 1
 }
+
+// DayStartAt ts 当天零点的时间戳
+func DayStartAt(ts int64) int64 {
+	t := time.Unix(ts, 0)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local).Unix()
+}
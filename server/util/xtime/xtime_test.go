@@ -5,3 +5,10 @@ import "testing"
 func TestTomorrowStartAt(t *testing.T) {
 	t.Logf("明天凌晨的时间戳是: %d", TomorrowStartAt())
 }
+
+func TestDayStartAt(t *testing.T) {
+	tomorrow := TomorrowStartAt()
+	if DayStartAt(tomorrow+3600) != tomorrow || DayStartAt(tomorrow) != tomorrow {
+		t.Errorf("DayStartAt(%d) != %d", tomorrow+3600, tomorrow)
+	}
+}
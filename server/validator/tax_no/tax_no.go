@@ -0,0 +1,39 @@
+package tax_no
+
+import (
+	"regexp"
+	"strings"
+)
+
+// 统一社会信用代码 GB 32100-2015 的字符集和各位的权重, 第 18 位为校验码
+const creditCodeChars = "0123456789ABCDEFGHJKLMNPQRTUWXY"
+
+var creditCodeWeight = [17]int{1, 3, 9, 27, 19, 26, 16, 17, 20, 29, 25, 13, 8, 24, 10, 30, 28}
+
+// 三证合一前的税务登记证号, 15、17 或 20 位
+var legacyTaxNo = regexp.MustCompile(`^[0-9A-Z]{15}$|^[0-9A-Z]{17}$|^[0-9A-Z]{20}$`)
+
+// IsValidCreditCode 校验 18 位统一社会信用代码
+func IsValidCreditCode(code string) bool {
+	if len(code) != 18 {
+		return false
+	}
+	sum := 0
+	for i := 0; i < 17; i++ {
+		n := strings.IndexByte(creditCodeChars, code[i])
+		if n < 0 {
+			return false
+		}
+		sum += n * creditCodeWeight[i]
+	}
+	check := (31 - sum%31) % 31
+	return code[17] == creditCodeChars[check]
+}
+
+// IsValidTaxNo 纳税人识别号, 统一社会信用代码或旧的税务登记证号
+func IsValidTaxNo(no string) bool {
+	if len(no) == 18 {
+		return IsValidCreditCode(no)
+	}
+	return legacyTaxNo.MatchString(no)
+}
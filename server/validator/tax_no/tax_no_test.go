@@ -0,0 +1,20 @@
+package tax_no
+
+import "testing"
+
+func TestIsValidTaxNo(t *testing.T) {
+	cases := map[string]bool{
+		"91350100M000100Y43":   true,
+		"91350100M000100Y44":   false,
+		"91350100M00010OY43":   false,
+		"440300123456789":      true,
+		"44030012345678901234": true,
+		"4403001234":           false,
+		"":                     false,
+	}
+	for no, want := range cases {
+		if got := IsValidTaxNo(no); got != want {
+			t.Errorf("IsValidTaxNo(%q) = %v, want %v", no, got, want)
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/go-playground/validator/v10"
 	zhTranslations "github.com/go-playground/validator/v10/translations/zh"
 	"mk-api/server/validator/id_card"
+	"mk-api/server/validator/tax_no"
 )
 
 var (
@@ -29,6 +30,10 @@ func checkIdCardNo(fl validator.FieldLevel) bool {
 	return id_card.IsValidCitizenNo(&idCardNo)
 }
 
+func checkTaxNo(fl validator.FieldLevel) bool {
+	return tax_no.IsValidTaxNo(fl.Field().String())
+}
+
 func Init() {
 	// 中文翻译
 	zh := zhongwen.New()
@@ -63,6 +68,18 @@ func Init() {
 				return t
 			})
 		}
+
+		// 验证纳税人识别号
+		{
+			_ = V.RegisterValidation("checkTaxNo", checkTaxNo)
+
+			_ = V.RegisterTranslation("checkTaxNo", Trans, func(ut ut.Translator) error {
+				return ut.Add("checkTaxNo", "纳税人识别号有误", true)
+			}, func(ut ut.Translator, fe validator.FieldError) string {
+				t, _ := ut.T("checkTaxNo", fe.Field(), fe.Field())
+				return t
+			})
+		}
 	}
 }
 